@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
+	"encoding/base64"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
 	"strconv"
+	"time"
 
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
@@ -13,6 +16,12 @@ import (
 	"github.com/joho/godotenv"
 	"github.com/redis/go-redis/v9"
 
+	"github.com/cxcnxl/go-crud/internal/app_service"
+	"github.com/cxcnxl/go-crud/internal/blobstore"
+	"github.com/cxcnxl/go-crud/internal/encryption"
+	"github.com/cxcnxl/go-crud/internal/mailer"
+	"github.com/cxcnxl/go-crud/internal/models"
+	"github.com/cxcnxl/go-crud/internal/realtime"
 	"github.com/cxcnxl/go-crud/internal/routes"
 	redisw "github.com/cxcnxl/go-crud/internal/redis"
 )
@@ -20,8 +29,22 @@ import (
 func main() {
     parseDotEnv();
     db := connectToDb();
+    migrateDb(db);
     rdb := connectToRedis();
-    startServer(db, rdb);
+    blobs := connectToBlobStore();
+    service := appservice.NewAppService(
+        db,
+        rdb,
+        blobs,
+        newUrlSigner(),
+        newMessageSealer(),
+        newMailer(),
+        postRetention(),
+    );
+    hub := realtime.NewHub();
+    go hub.Run(context.Background(), rdb);
+    startJobs(service);
+    startServer(service, hub);
 }
 
 // prepares .env file so it can be read via os.Getenv or panics
@@ -43,7 +66,8 @@ func connectToDb() *gorm.DB {
 
     db, err := gorm.Open(
         mysql.Open(mysqlConnString),
-        &gorm.Config{},
+        // unique index violations come back as gorm.ErrDuplicatedKey
+        &gorm.Config{ TranslateError: true },
     );
     if err != nil {
         slog.Error("Error opening connection to the database: " + err.Error());
@@ -53,6 +77,49 @@ func connectToDb() *gorm.DB {
     return db;
 }
 
+// creates or updates tables of the models or panics
+func migrateDb(db *gorm.DB) {
+    err := db.AutoMigrate(
+        &models.User{},
+        &models.UsernameChange{},
+        &models.EmailChange{},
+        &models.Post{},
+        &models.PostRevision{},
+        &models.Attachment{},
+        &models.Follow{},
+        &models.Block{},
+        &models.Mute{},
+        &models.Reaction{},
+        &models.ReactionCount{},
+        &models.Tag{},
+        &models.PostTag{},
+        &models.PostMention{},
+        &models.Notification{},
+        &models.NotificationPreference{},
+        &models.Conversation{},
+        &models.Participant{},
+        &models.Message{},
+        &models.Report{},
+        &models.ModerationFilter{},
+        &models.ModerationAction{},
+    );
+    if err != nil {
+        slog.Error("Error migrating the database: " + err.Error());
+        panic(err);
+    }
+
+    // posts created before statuses existed are published ones
+    err = db.
+        Model(&models.Post{}).
+        Where("published_at IS NULL AND status = ?", models.PostStatusPublished).
+        Update("published_at", gorm.Expr("created_at")).
+        Error;
+    if err != nil {
+        slog.Error("Error backfilling posts publication time: " + err.Error());
+        panic(err);
+    }
+}
+
 func connectToRedis() *redisw.RedisWrapper {
     redisDb, err := strconv.Atoi(os.Getenv("REDIS_DB"));
     if err != nil {
@@ -68,16 +135,172 @@ func connectToRedis() *redisw.RedisWrapper {
     return redisw.NewRedisWrapper(rdb);
 }
 
+// opens blob store selected by BLOB_STORE ("local" or "s3") or panics
+func connectToBlobStore() blobstore.BlobStore {
+    switch os.Getenv("BLOB_STORE") {
+    case "", "local":
+        root := os.Getenv("BLOB_LOCAL_PATH");
+        if root == "" {
+            root = "./uploads";
+        }
+
+        store, err := blobstore.NewLocalStore(root);
+        if err != nil {
+            slog.Error("Error opening local blob store: " + err.Error());
+            panic(err);
+        }
+
+        return store;
+    case "s3":
+        region := os.Getenv("S3_REGION");
+        if region == "" {
+            region = "us-east-1";
+        }
+
+        return blobstore.NewS3Store(
+            os.Getenv("S3_ENDPOINT"),
+            os.Getenv("S3_BUCKET"),
+            region,
+            os.Getenv("S3_ACCESS_KEY"),
+            os.Getenv("S3_SECRET_KEY"),
+        );
+    default:
+        slog.Error("Unknown BLOB_STORE: " + os.Getenv("BLOB_STORE"));
+        panic("Unknown BLOB_STORE");
+    }
+}
+
+// creates signer of private download links or panics
+func newUrlSigner() *blobstore.UrlSigner {
+    key := os.Getenv("BLOB_SIGNING_KEY");
+    if key == "" {
+        slog.Error("BLOB_SIGNING_KEY variable not found");
+        panic("BLOB_SIGNING_KEY variable not found");
+    }
+
+    return blobstore.NewUrlSigner(key);
+}
+
+// creates encrypter of direct messages from base64 encoded 32 byte
+// MESSAGES_ENCRYPTION_KEY or panics
+func newMessageSealer() *encryption.Sealer {
+    key, err := base64.StdEncoding.DecodeString(os.Getenv("MESSAGES_ENCRYPTION_KEY"));
+    if err != nil {
+        slog.Error("Error decoding MESSAGES_ENCRYPTION_KEY: " + err.Error());
+        panic(err);
+    }
+
+    sealer, err := encryption.NewSealer(key);
+    if err != nil {
+        slog.Error("Invalid MESSAGES_ENCRYPTION_KEY: " + err.Error());
+        panic(err);
+    }
+
+    return sealer;
+}
+
+// creates mailer selected by MAILER ("log", the default, or "smtp") or
+// panics
+func newMailer() mailer.Mailer {
+    switch os.Getenv("MAILER") {
+    case "", "log":
+        return mailer.LogMailer{};
+    case "smtp":
+        port, err := strconv.Atoi(os.Getenv("SMTP_PORT"));
+        if err != nil {
+            slog.Error("Invalid SMTP_PORT: " + os.Getenv("SMTP_PORT"));
+            panic(err);
+        }
+
+        from := os.Getenv("MAIL_FROM");
+        if from == "" {
+            slog.Error("MAIL_FROM variable not found");
+            panic("MAIL_FROM variable not found");
+        }
+
+        return mailer.NewSmtpMailer(
+            os.Getenv("SMTP_HOST"),
+            port,
+            os.Getenv("SMTP_USERNAME"),
+            os.Getenv("SMTP_PASSWORD"),
+            from,
+        );
+    default:
+        slog.Error("Unknown MAILER: " + os.Getenv("MAILER"));
+        panic("Unknown MAILER");
+    }
+}
+
+// runs periodic background jobs
+func startJobs(service *appservice.AppService) {
+    go runPeriodically(
+        "reaction reconciler",
+        30 * time.Second,
+        service.ReconcileReactionCounts,
+    );
+
+    go runPeriodically(
+        "scheduled posts publisher",
+        15 * time.Second,
+        service.PublishDuePosts,
+    );
+
+    go runPeriodically(
+        "deleted posts purge",
+        time.Hour,
+        service.PurgeDeletedPosts,
+    );
+}
+
+// how long deleted posts stay in the trash, POST_RETENTION_DAYS or 30 days
+func postRetention() time.Duration {
+    days := 30;
+
+    if val := os.Getenv("POST_RETENTION_DAYS"); val != "" {
+        parsed, err := strconv.Atoi(val);
+        if err != nil || parsed < 0 {
+            slog.Error("Invalid POST_RETENTION_DAYS: " + val);
+            panic("Invalid POST_RETENTION_DAYS");
+        }
+        days = parsed;
+    }
+
+    return time.Duration(days) * 24 * time.Hour;
+}
+
+func runPeriodically(name string, interval time.Duration, job func() error) {
+    ticker := time.NewTicker(interval);
+    defer ticker.Stop();
+
+    for range ticker.C {
+        err := job();
+        if err != nil {
+            slog.Error(fmt.Sprintf("Error running %s: %s", name, err.Error()));
+        }
+    }
+}
+
 // starts http server or panics
-func startServer(db *gorm.DB, rdb *redisw.RedisWrapper) {
-    router := routes.NewRouter(db, rdb);
+func startServer(service *appservice.AppService, hub *realtime.Hub) {
+    router := routes.NewRouter(service, hub);
 
     const port int = 8080;
     addr := fmt.Sprintf(":%d", port);
 
     slog.Info(fmt.Sprintf("server is running on localhost:%d", port));
 
-    err := http.ListenAndServe(addr, router.Mux);
+    // gRPC clients speak HTTP/2 without TLS to this listener
+    protocols := new(http.Protocols);
+    protocols.SetHTTP1(true);
+    protocols.SetUnencryptedHTTP2(true);
+
+    server := &http.Server{
+        Addr: addr,
+        Handler: router.Mux,
+        Protocols: protocols,
+    };
+
+    err := server.ListenAndServe();
     if err != nil {
         slog.Error("Error starting server: " + err.Error());
         panic(err);
@@ -0,0 +1,308 @@
+package appservice
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/cxcnxl/go-crud/internal/auth_helpers"
+	"github.com/cxcnxl/go-crud/internal/dto"
+	"github.com/cxcnxl/go-crud/internal/mailer"
+	"github.com/cxcnxl/go-crud/internal/models"
+	"github.com/cxcnxl/go-crud/internal/validation"
+)
+
+// how long a released username stays reserved for its previous owner
+const UsernameCooldown = 30 * 24 * time.Hour;
+
+// how long email change links stay valid
+const emailChangeTTL = 24 * time.Hour;
+
+// renames the user, keeping the old username reserved for UsernameCooldown
+func (self *AppService) ChangeUsername(userId uint, username string) (dto.UserViewDto, error) {
+    username = strings.TrimSpace(username);
+    if !validation.Username(username) {
+        return dto.UserViewDto{}, InvalidUsernameError{};
+    }
+
+    err := self.db.Transaction(func(tx *gorm.DB) error {
+        var user models.User;
+        result := tx.
+            Clauses(clause.Locking{Strength: "UPDATE"}).
+            First(&user, userId);
+        if result.Error != nil {
+            return result.Error;
+        }
+        if user.Username == username {
+            return nil;
+        }
+
+        // locking reads hold the index range, so a concurrent claim of the
+        // same name waits for this transaction
+        var taken int64;
+        result = tx.
+            Model(&models.User{}).
+            Clauses(clause.Locking{Strength: "UPDATE"}).
+            Where("username = ? AND id <> ?", username, userId).
+            Count(&taken);
+        if result.Error != nil {
+            return result.Error;
+        }
+        if taken > 0 {
+            return DuplicateUserUsernameError{};
+        }
+
+        reserved, err := usernameReserved(tx, username, userId);
+        if err != nil {
+            return err;
+        }
+        if reserved {
+            return UsernameReservedError{};
+        }
+
+        err = tx.Create(&models.UsernameChange{
+            UserID: userId,
+            OldUsername: user.Username,
+            NewUsername: username,
+            ReservedUntil: time.Now().Add(UsernameCooldown),
+        }).Error;
+        if err != nil {
+            return err;
+        }
+
+        return tx.Model(&user).Update("username", username).Error;
+    });
+    if errors.Is(err, gorm.ErrDuplicatedKey) {
+        return dto.UserViewDto{}, DuplicateUserUsernameError{};
+    }
+    if err != nil {
+        return dto.UserViewDto{}, err;
+    }
+
+    return self.GetMe(userId);
+}
+
+// reports whether username was released by someone other than userId
+// within the cooldown
+func usernameReserved(tx *gorm.DB, username string, userId uint) (bool, error) {
+    var reserved int64;
+    result := tx.
+        Model(&models.UsernameChange{}).
+        Clauses(clause.Locking{Strength: "UPDATE"}).
+        Where("old_username = ? AND user_id <> ?", username, userId).
+        Where("reserved_until > ?", time.Now()).
+        Count(&reserved);
+
+    return reserved > 0, result.Error;
+}
+
+// lists previous usernames of the user, newest first
+func (self *AppService) GetUsernameHistory(userId uint) ([]models.UsernameChange, error) {
+    changes := []models.UsernameChange{};
+    result := self.db.
+        Where(models.UsernameChange{UserID: userId}).
+        Order("id DESC").
+        Find(&changes);
+
+    return changes, result.Error;
+}
+
+// returns current username of the user who gave up username within the
+// cooldown, so links to the old profile keep working
+func (self *AppService) ResolveOldUsername(username string) (string, error) {
+    var change models.UsernameChange;
+    result := self.db.
+        Where("old_username = ?", username).
+        Where("reserved_until > ?", time.Now()).
+        Order("id DESC").
+        First(&change);
+    if result.Error != nil {
+        return "", result.Error;
+    }
+
+    user, err := self.GetUserById(change.UserID);
+    if err != nil {
+        return "", err;
+    }
+
+    return user.Username, nil;
+}
+
+// starts email change after checking the password. The change is applied
+// once the link mailed to the new address is opened, the old address is
+// told about the request. confirmUrl is the page the link points to
+func (self *AppService) RequestEmailChange(
+    userId uint,
+    data dto.ChangeEmailDto,
+    confirmUrl string,
+) error {
+    email, err := parseEmail(data.Email);
+    if err != nil {
+        return err;
+    }
+
+    user, err := self.GetUserById(userId);
+    if err != nil {
+        return err;
+    }
+
+    valid, err := auth_helpers.VerifyPass(data.Password, user.PasswordHashed);
+    if err != nil {
+        return err;
+    }
+    if !valid {
+        return InvalidPasswordError{};
+    }
+
+    if email == user.Email {
+        return nil;
+    }
+    if duplicate, _ := self.GetUserByEmail(email); duplicate.ID != 0 {
+        return DuplicateUserEmailError{};
+    }
+
+    token := make([]byte, 32);
+    if _, err := rand.Read(token); err != nil {
+        return err;
+    }
+    tokenHex := hex.EncodeToString(token);
+
+    change := models.EmailChange{
+        UserID: userId,
+        NewEmail: email,
+        TokenHash: hashToken(tokenHex),
+        ExpiresAt: time.Now().Add(emailChangeTTL),
+    };
+    if err := self.db.Create(&change).Error; err != nil {
+        return err;
+    }
+
+    return self.afterCommit(func(service *AppService) error {
+        return service.mailEmailChange(user, email, confirmUrl, tokenHex);
+    });
+}
+
+// mails the confirmation link to the new address and an alert to the old
+func (self *AppService) mailEmailChange(user models.User, email string, confirmUrl string, tokenHex string) error {
+    err := self.mailer.Send(self.ctx, mailer.Message{
+        To: email,
+        Subject: "Confirm your new email address",
+        Body: fmt.Sprintf(
+            "Hi %s,\n\nopen the link below to use this address for your account:\n\n%s?token=%s\n\nThe link expires in 24 hours.\n",
+            user.Username,
+            confirmUrl,
+            url.QueryEscape(tokenHex),
+        ),
+    });
+    if err != nil {
+        return err;
+    }
+
+    err = self.mailer.Send(self.ctx, mailer.Message{
+        To: user.Email,
+        Subject: "Your email address is being changed",
+        Body: fmt.Sprintf(
+            "Hi %s,\n\nsomeone asked to change the email address of your account to %s.\nIf it was not you, change your password right away.\n",
+            user.Username,
+            email,
+        ),
+    });
+    if err != nil {
+        // the change still needs confirmation, a lost alert does not block it
+        slog.Error("Error sending email change alert: " + err.Error());
+    }
+
+    return nil;
+}
+
+// applies the email change the token was issued for
+func (self *AppService) ConfirmEmailChange(token string) (dto.UserViewDto, error) {
+    var userId uint;
+
+    err := self.db.Transaction(func(tx *gorm.DB) error {
+        var change models.EmailChange;
+        result := tx.
+            Clauses(clause.Locking{Strength: "UPDATE"}).
+            Where(models.EmailChange{TokenHash: hashToken(token)}).
+            First(&change);
+        if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+            return InvalidEmailChangeTokenError{};
+        }
+        if result.Error != nil {
+            return result.Error;
+        }
+        if change.ConfirmedAt != nil || time.Now().After(change.ExpiresAt) {
+            return InvalidEmailChangeTokenError{};
+        }
+        userId = change.UserID;
+
+        var taken int64;
+        result = tx.
+            Model(&models.User{}).
+            Clauses(clause.Locking{Strength: "UPDATE"}).
+            Where("email = ? AND id <> ?", change.NewEmail, change.UserID).
+            Count(&taken);
+        if result.Error != nil {
+            return result.Error;
+        }
+        if taken > 0 {
+            return DuplicateUserEmailError{};
+        }
+
+        err := tx.
+            Model(&models.User{}).
+            Where("id = ?", change.UserID).
+            Update("email", change.NewEmail).
+            Error;
+        if err != nil {
+            return err;
+        }
+
+        now := time.Now();
+        err = tx.Model(&change).Update("confirmed_at", now).Error;
+        if err != nil {
+            return err;
+        }
+
+        // other pending changes of the user are void now
+        return tx.
+            Model(&models.EmailChange{}).
+            Where("user_id = ? AND confirmed_at IS NULL", change.UserID).
+            Update("expires_at", now).
+            Error;
+    });
+    if errors.Is(err, gorm.ErrDuplicatedKey) {
+        return dto.UserViewDto{}, DuplicateUserEmailError{};
+    }
+    if err != nil {
+        return dto.UserViewDto{}, err;
+    }
+
+    return self.GetMe(userId);
+}
+
+func parseEmail(value string) (string, error) {
+    value = strings.TrimSpace(value);
+
+    address, err := mail.ParseAddress(value);
+    if err != nil || address.Address != value || len(value) > 255 {
+        return "", InvalidEmailError{};
+    }
+
+    return value, nil;
+}
+
+func hashToken(token string) string {
+    sum := sha256.Sum256([]byte(token));
+    return hex.EncodeToString(sum[:]);
+}
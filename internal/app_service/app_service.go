@@ -2,11 +2,18 @@ package appservice
 
 import (
 	"context"
+	"errors"
+	"log/slog"
+	"time"
 
 	"gorm.io/gorm"
 
 	"github.com/cxcnxl/go-crud/internal/auth_helpers"
+	"github.com/cxcnxl/go-crud/internal/blobstore"
 	"github.com/cxcnxl/go-crud/internal/dto"
+	"github.com/cxcnxl/go-crud/internal/encryption"
+	"github.com/cxcnxl/go-crud/internal/fieldset"
+	"github.com/cxcnxl/go-crud/internal/mailer"
 	"github.com/cxcnxl/go-crud/internal/models"
 	"github.com/cxcnxl/go-crud/internal/redis"
 )
@@ -14,20 +21,136 @@ import (
 type AppService struct {
 	db *gorm.DB
     redis *redis.RedisWrapper
+    blobs blobstore.BlobStore
+    urlSigner *blobstore.UrlSigner
+    // encrypts direct message bodies
+    messages *encryption.Sealer
+    mailer mailer.Mailer
+    // how long deleted posts stay in the trash before they are purged
+    postRetention time.Duration
     ctx context.Context
+    // members and relations reads load, see Reading
+    reads fieldset.Spec
+    // side effects held back until the transaction of the service commits,
+    // nil outside transactions
+    pending *pendingEffects
+}
+
+// redis writes, events and mails of a transaction, see afterCommit
+type pendingEffects struct {
+    effects []func(service *AppService) error
 }
 
 func NewAppService(
     db *gorm.DB,
     redis *redis.RedisWrapper,
+    blobs blobstore.BlobStore,
+    urlSigner *blobstore.UrlSigner,
+    messages *encryption.Sealer,
+    mailer mailer.Mailer,
+    postRetention time.Duration,
 ) *AppService {
     return &AppService{
         db,
         redis,
+        blobs,
+        urlSigner,
+        messages,
+        mailer,
+        postRetention,
         context.Background(),
+        fieldset.Spec{},
+        nil,
     };
 }
 
+// runs fn with a copy of the service whose database work goes through one
+// transaction, committed when fn returns nil and rolled back otherwise.
+// Transactions the copy opens itself become savepoints. Redis writes,
+// events and mails of the copy wait for the commit and are dropped on
+// rollback, caches are not filled with rows that may still roll back.
+// Blobs are not part of it
+func (self *AppService) Transaction(fn func(service *AppService) error) error {
+    pending := &pendingEffects{};
+    err := self.db.Transaction(func(tx *gorm.DB) error {
+        service := *self;
+        service.db = tx;
+        service.pending = pending;
+
+        return fn(&service);
+    });
+    if err != nil {
+        return err;
+    }
+
+    // effects of savepoints wait for the outer transaction
+    self.afterCommit(func(service *AppService) error {
+        for _, effect := range pending.effects {
+            if err := effect(service); err != nil {
+                slog.Error("Error running effect of committed transaction: " + err.Error());
+            }
+        }
+        return nil;
+    });
+
+    return nil;
+}
+
+// runs effect now, or once the transaction of the service commits. Effects
+// get a service outside the transaction, which is done by then. Errors of
+// held back effects are logged, as the transaction can not fail anymore
+func (self *AppService) afterCommit(effect func(service *AppService) error) error {
+    if self.pending == nil {
+        return effect(self);
+    }
+
+    self.pending.effects = append(self.pending.effects, effect);
+    return nil;
+}
+
+// reports whether the service works in a transaction. Read-through caches
+// are neither read nor filled then, rows it reads may still roll back and
+// cached ones miss its own writes
+func (self *AppService) inTransaction() bool {
+    return self.pending != nil;
+}
+
+type serviceKey struct {};
+
+// context of a request served by service, e.g. an operation of an atomic
+// batch bound to its transaction
+func ContextWithService(ctx context.Context, service *AppService) context.Context {
+    return context.WithValue(ctx, serviceKey{}, service);
+}
+
+// service ctx carries, see ContextWithService, self for other contexts
+func (self *AppService) For(ctx context.Context) *AppService {
+    if service, ok := ctx.Value(serviceKey{}).(*AppService); ok {
+        return service;
+    }
+
+    return self;
+}
+
+// copy of the service whose post reads select the members of spec and
+// preload the relations it includes, see fieldset.Spec.Scope
+func (self *AppService) Reading(spec fieldset.Spec) *AppService {
+    service := *self;
+    service.reads = spec;
+
+    return &service;
+}
+
+// narrows a post query to the members and relations of the reads, without
+// any it preloads the author as always
+func (self *AppService) postReads(db *gorm.DB) *gorm.DB {
+    if self.reads.IsZero() {
+        return db.Preload("Author");
+    }
+
+    return self.reads.Scope(db);
+}
+
 func (self *AppService) CreateUser(data dto.CreateUserDto) (models.User, error) {
     user := models.User{
         Email: data.Email,
@@ -44,8 +167,20 @@ func (self *AppService) CreateUser(data dto.CreateUserDto) (models.User, error)
     if duplicate, _ := self.GetUserByUsername(data.Username); duplicate.ID != 0 {
         return duplicate, DuplicateUserUsernameError{};
     }
+    if reserved, err := usernameReserved(self.db, data.Username, 0); err != nil {
+        return user, err;
+    } else if reserved {
+        return user, UsernameReservedError{};
+    }
 
     result := self.db.Create(&user);
+    if errors.Is(result.Error, gorm.ErrDuplicatedKey) {
+        // lost a race to a concurrent registration
+        if duplicate, _ := self.GetUserByUsername(data.Username); duplicate.ID != 0 {
+            return duplicate, DuplicateUserUsernameError{};
+        }
+        return user, DuplicateUserEmailError{};
+    }
     if result.Error != nil {
         return user, result.Error;
     }
@@ -65,6 +200,11 @@ func (self *AppService) LoginUser(data dto.PostLoginDto) (models.User, error) {
         return user, LoginBlockedError{};
     }
 
+    // unknown users look like wrong passwords, so logins do not tell
+    // which accounts exist
+    if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+        return user, InvalidPasswordError{};
+    }
     if result.Error != nil {
         return user, result.Error;
     }
@@ -82,6 +222,10 @@ func (self *AppService) LoginUser(data dto.PostLoginDto) (models.User, error) {
         return user, InvalidPasswordError{};
     }
 
+    if user.SuspendedAt != nil {
+        return user, SuspendedUserError{};
+    }
+
     return user, nil;
 }
 
@@ -127,6 +271,9 @@ func (self *AppService) GetUserById(id uint) (models.User, error) {
 // TODO: move redis operations into redis service
 //
 // TODO: create const keys for redis
+//
+// Attempts count right away, also in transactions, or atomic batches that
+// roll back would allow unlimited guesses
 func (self *AppService) handleInvalidPasswordAttempt(
     user models.User,
 ) {
@@ -186,3 +333,161 @@ type LoginBlockedError struct {}
 func (self LoginBlockedError) Error() string {
     return "login_blocked";
 }
+
+type InvalidReactionError struct {}
+func (self InvalidReactionError) Error() string {
+    return "invalid_reaction";
+}
+
+type InvalidPostBodyError struct {}
+func (self InvalidPostBodyError) Error() string {
+    return "invalid_post_body";
+}
+
+type SelfFollowError struct {}
+func (self SelfFollowError) Error() string {
+    return "self_follow";
+}
+
+type NotPostAuthorError struct {}
+func (self NotPostAuthorError) Error() string {
+    return "not_post_author";
+}
+
+type InvalidPostStatusError struct {}
+func (self InvalidPostStatusError) Error() string {
+    return "invalid_post_status";
+}
+
+type InvalidPublishAtError struct {}
+func (self InvalidPublishAtError) Error() string {
+    return "invalid_publish_at";
+}
+
+type PostAlreadyPublishedError struct {}
+func (self PostAlreadyPublishedError) Error() string {
+    return "post_already_published";
+}
+
+type AttachmentTooLargeError struct {}
+func (self AttachmentTooLargeError) Error() string {
+    return "attachment_too_large";
+}
+
+type UnsupportedAttachmentTypeError struct {}
+func (self UnsupportedAttachmentTypeError) Error() string {
+    return "unsupported_attachment_type";
+}
+
+type TooManyAttachmentsError struct {}
+func (self TooManyAttachmentsError) Error() string {
+    return "too_many_attachments";
+}
+
+type InvalidNotificationTypeError struct {}
+func (self InvalidNotificationTypeError) Error() string {
+    return "invalid_notification_type";
+}
+
+type InvalidBodyFormatError struct {}
+func (self InvalidBodyFormatError) Error() string {
+    return "invalid_body_format";
+}
+
+type SelfBlockError struct {}
+func (self SelfBlockError) Error() string {
+    return "self_block";
+}
+
+type SelfMuteError struct {}
+func (self SelfMuteError) Error() string {
+    return "self_mute";
+}
+
+type UserBlockedError struct {}
+func (self UserBlockedError) Error() string {
+    return "user_blocked";
+}
+
+// profile field failed validation
+type InvalidProfileError struct {
+    Field string
+}
+func (self InvalidProfileError) Error() string {
+    return "invalid_" + self.Field;
+}
+
+type InvalidUsernameError struct {}
+func (self InvalidUsernameError) Error() string {
+    return "invalid_username";
+}
+
+type UsernameReservedError struct {}
+func (self UsernameReservedError) Error() string {
+    return "username_reserved";
+}
+
+type InvalidEmailError struct {}
+func (self InvalidEmailError) Error() string {
+    return "invalid_email";
+}
+
+type InvalidEmailChangeTokenError struct {}
+func (self InvalidEmailChangeTokenError) Error() string {
+    return "invalid_email_change_token";
+}
+
+type InvalidVisibilityError struct {}
+func (self InvalidVisibilityError) Error() string {
+    return "invalid_visibility";
+}
+
+type InvalidParticipantsError struct {}
+func (self InvalidParticipantsError) Error() string {
+    return "invalid_participants";
+}
+
+type InvalidMessageBodyError struct {}
+func (self InvalidMessageBodyError) Error() string {
+    return "invalid_message_body";
+}
+
+type NotAdminError struct {}
+func (self NotAdminError) Error() string {
+    return "not_admin";
+}
+
+type SuspendedUserError struct {}
+func (self SuspendedUserError) Error() string {
+    return "user_suspended";
+}
+
+type InvalidReportError struct {}
+func (self InvalidReportError) Error() string {
+    return "invalid_report";
+}
+
+type ReportClosedError struct {}
+func (self ReportClosedError) Error() string {
+    return "report_closed";
+}
+
+type InvalidModerationActionError struct {}
+func (self InvalidModerationActionError) Error() string {
+    return "invalid_moderation_action";
+}
+
+type InvalidFilterError struct {}
+func (self InvalidFilterError) Error() string {
+    return "invalid_filter";
+}
+
+type PostUnderReviewError struct {}
+func (self PostUnderReviewError) Error() string {
+    return "post_under_review";
+}
+
+type PostChangedError struct {}
+func (self PostChangedError) Error() string {
+    return "post_changed";
+}
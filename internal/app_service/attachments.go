@@ -0,0 +1,245 @@
+package appservice
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/cxcnxl/go-crud/internal/dto"
+	"github.com/cxcnxl/go-crud/internal/media"
+	"github.com/cxcnxl/go-crud/internal/models"
+)
+
+const MaxAttachmentSize int64 = 10 << 20;
+const maxAttachmentsPerPost int64 = 10;
+const thumbnailSize int = 320;
+const signedUrlTTL = 15 * time.Minute;
+
+// stores uploaded file and links it to the post of the user
+func (self *AppService) AddAttachment(
+    postId uint,
+    userId uint,
+    fileName string,
+    data []byte,
+    private bool,
+) (models.Attachment, error) {
+    post, err := self.GetPostById(postId);
+    if err != nil {
+        return models.Attachment{}, err;
+    }
+    if post.AuthorID != userId {
+        return models.Attachment{}, NotPostAuthorError{};
+    }
+
+    if int64(len(data)) > MaxAttachmentSize {
+        return models.Attachment{}, AttachmentTooLargeError{};
+    }
+
+    contentType, ok := media.DetectType(data);
+    if !ok {
+        return models.Attachment{}, UnsupportedAttachmentTypeError{};
+    }
+
+    var count int64;
+    result := self.db.
+        Model(&models.Attachment{}).
+        Where(models.Attachment{PostID: post.ID}).
+        Count(&count);
+    if result.Error != nil {
+        return models.Attachment{}, result.Error;
+    }
+    if count >= maxAttachmentsPerPost {
+        return models.Attachment{}, TooManyAttachmentsError{};
+    }
+
+    var thumbnail []byte;
+    if media.IsImage(contentType) {
+        thumbnail, err = media.Thumbnail(data, thumbnailSize);
+        if errors.Is(err, media.ImageTooLargeError{}) {
+            return models.Attachment{}, AttachmentTooLargeError{};
+        }
+        if err != nil {
+            // sniffed as image, but cannot be decoded
+            return models.Attachment{}, UnsupportedAttachmentTypeError{};
+        }
+    }
+
+    attachment := models.Attachment{
+        PostID: post.ID,
+        FileName: filepath.Base(fileName),
+        ContentType: contentType,
+        Size: int64(len(data)),
+        Private: private,
+        BlobKey: fmt.Sprintf("attachments/%d/%s", post.ID, randomBlobName()),
+    };
+
+    err = self.blobs.Put(self.ctx, attachment.BlobKey, bytes.NewReader(data), contentType);
+    if err != nil {
+        return attachment, err;
+    }
+
+    if thumbnail != nil {
+        attachment.ThumbnailKey = attachment.BlobKey + "_thumb";
+        err = self.blobs.Put(
+            self.ctx,
+            attachment.ThumbnailKey,
+            bytes.NewReader(thumbnail),
+            "image/jpeg",
+        );
+        if err != nil {
+            self.deleteAttachmentBlobs(attachment);
+            return attachment, err;
+        }
+    }
+
+    result = self.db.Create(&attachment);
+    if result.Error != nil {
+        self.deleteAttachmentBlobs(attachment);
+        return attachment, result.Error;
+    }
+
+    return attachment, nil;
+}
+
+func (self *AppService) DeleteAttachment(id uint, userId uint) error {
+    attachment, post, err := self.getAttachment(id);
+    if err != nil {
+        return err;
+    }
+    if post.AuthorID != userId {
+        return NotPostAuthorError{};
+    }
+
+    result := self.db.Delete(&attachment);
+    if result.Error != nil {
+        return result.Error;
+    }
+
+    self.deleteAttachmentBlobs(attachment);
+
+    return nil;
+}
+
+// returns attachments of the post with download links for the viewer
+func (self *AppService) GetAttachmentViews(post models.Post) ([]dto.AttachmentDto, error) {
+    var attachments []models.Attachment;
+    result := self.db.
+        Where(models.Attachment{PostID: post.ID}).
+        Order("id").
+        Find(&attachments);
+    if result.Error != nil {
+        return nil, result.Error;
+    }
+
+    views := make([]dto.AttachmentDto, 0, len(attachments));
+    for _, attachment := range attachments {
+        view := dto.AttachmentDto{
+            Attachment: attachment,
+            Url: self.attachmentUrl(post, attachment, false),
+        };
+        if attachment.ThumbnailKey != "" {
+            view.ThumbnailUrl = self.attachmentUrl(post, attachment, true);
+        }
+
+        views = append(views, view);
+    }
+
+    return views, nil;
+}
+
+// opens attachment blob or its thumbnail for download. Public attachments
+// of published public posts are open to everyone, the rest require a valid
+// signature in query
+func (self *AppService) OpenAttachment(
+    id uint,
+    thumbnail bool,
+    query url.Values,
+) (models.Attachment, io.ReadCloser, error) {
+    attachment, post, err := self.getAttachment(id);
+    if err != nil {
+        return attachment, nil, err;
+    }
+
+    path := attachmentPath(attachment, thumbnail);
+    open := !attachment.Private && isListed(post);
+    if !open && !self.urlSigner.Verify(path, query) {
+        // do not reveal existence of private attachments
+        return attachment, nil, gorm.ErrRecordNotFound;
+    }
+
+    key := attachment.BlobKey;
+    if thumbnail {
+        if attachment.ThumbnailKey == "" {
+            return attachment, nil, gorm.ErrRecordNotFound;
+        }
+        key = attachment.ThumbnailKey;
+    }
+
+    blob, err := self.blobs.Get(self.ctx, key);
+    if err != nil {
+        return attachment, nil, err;
+    }
+
+    return attachment, blob, nil;
+}
+
+func (self *AppService) getAttachment(id uint) (models.Attachment, models.Post, error) {
+    attachment := models.Attachment{ ID: id };
+
+    result := self.db.Where(&attachment).First(&attachment);
+    if result.Error != nil {
+        return attachment, models.Post{}, result.Error;
+    }
+
+    post, err := self.GetPostById(attachment.PostID);
+    if err != nil {
+        return attachment, post, err;
+    }
+
+    return attachment, post, nil;
+}
+
+func (self *AppService) attachmentUrl(
+    post models.Post,
+    attachment models.Attachment,
+    thumbnail bool,
+) string {
+    path := attachmentPath(attachment, thumbnail);
+
+    if attachment.Private || !isListed(post) {
+        return self.urlSigner.Sign(path, signedUrlTTL);
+    }
+
+    return path;
+}
+
+// removes blobs of the attachment, failures only leave garbage behind
+func (self *AppService) deleteAttachmentBlobs(attachment models.Attachment) {
+    self.blobs.Delete(self.ctx, attachment.BlobKey);
+    if attachment.ThumbnailKey != "" {
+        self.blobs.Delete(self.ctx, attachment.ThumbnailKey);
+    }
+}
+
+func attachmentPath(attachment models.Attachment, thumbnail bool) string {
+    if thumbnail {
+        return fmt.Sprintf("/attachments/%d/thumbnail", attachment.ID);
+    }
+
+    return fmt.Sprintf("/attachments/%d", attachment.ID);
+}
+
+func randomBlobName() string {
+    name := make([]byte, 16);
+    rand.Read(name);
+
+    return hex.EncodeToString(name);
+}
@@ -0,0 +1,239 @@
+package appservice
+
+import (
+	"log/slog"
+	"slices"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/cxcnxl/go-crud/internal/models"
+)
+
+// blocks the user and removes follows between the two of them
+func (self *AppService) BlockUser(blockerId uint, blockedId uint) error {
+    if blockerId == blockedId {
+        return SelfBlockError{};
+    }
+
+    if _, err := self.GetUserById(blockedId); err != nil {
+        return err;
+    }
+
+    err := self.db.Transaction(func(tx *gorm.DB) error {
+        block := models.Block{
+            BlockerID: blockerId,
+            BlockedID: blockedId,
+        };
+
+        result := tx.
+            Clauses(clause.OnConflict{DoNothing: true}).
+            Create(&block);
+        if result.Error != nil {
+            return result.Error;
+        }
+
+        for _, pair := range [][2]uint{{blockerId, blockedId}, {blockedId, blockerId}} {
+            result := tx.
+                Where(models.Follow{FollowerID: pair[0], FolloweeID: pair[1]}).
+                Delete(&models.Follow{});
+            if result.Error != nil {
+                return result.Error;
+            }
+            if result.RowsAffected == 0 {
+                continue;
+            }
+
+            err := updateFollowCounters(tx, pair[0], pair[1], -1);
+            if err != nil {
+                return err;
+            }
+        }
+
+        return nil;
+    });
+    if err != nil {
+        return err;
+    }
+
+    return self.afterRelationChange(blockerId, blockedId);
+}
+
+func (self *AppService) UnblockUser(blockerId uint, blockedId uint) error {
+    result := self.db.
+        Where(models.Block{BlockerID: blockerId, BlockedID: blockedId}).
+        Delete(&models.Block{});
+    if result.Error != nil {
+        return result.Error;
+    }
+
+    return self.afterRelationChange(blockerId, blockedId);
+}
+
+// hides posts of the user from the muter, nothing else changes
+func (self *AppService) MuteUser(muterId uint, mutedId uint) error {
+    if muterId == mutedId {
+        return SelfMuteError{};
+    }
+
+    if _, err := self.GetUserById(mutedId); err != nil {
+        return err;
+    }
+
+    result := self.db.
+        Clauses(clause.OnConflict{DoNothing: true}).
+        Create(&models.Mute{MuterID: muterId, MutedID: mutedId});
+    if result.Error != nil {
+        return result.Error;
+    }
+
+    return self.afterCommit(func(service *AppService) error {
+        return service.redis.DeleteUserRelations(muterId);
+    });
+}
+
+func (self *AppService) UnmuteUser(muterId uint, mutedId uint) error {
+    result := self.db.
+        Where(models.Mute{MuterID: muterId, MutedID: mutedId}).
+        Delete(&models.Mute{});
+    if result.Error != nil {
+        return result.Error;
+    }
+
+    return self.afterCommit(func(service *AppService) error {
+        return service.redis.DeleteUserRelations(muterId);
+    });
+}
+
+// drops cached relations and timelines of both users, the timelines lost
+// or regained follows
+func (self *AppService) afterRelationChange(userId uint, otherId uint) error {
+    return self.afterCommit(func(service *AppService) error {
+        if err := service.redis.DeleteUserRelations(userId, otherId); err != nil {
+            return err;
+        }
+        if err := service.redis.DeleteTimeline(userId); err != nil {
+            return err;
+        }
+
+        return service.redis.DeleteTimeline(otherId);
+    });
+}
+
+// returns ids of users blocked by or blocking the user
+func (self *AppService) getBlockedIds(userId uint) ([]uint, error) {
+    var version int64;
+    if !self.inTransaction() {
+        ids, cachedVersion, ok, err := self.redis.GetBlockedUsers(userId);
+        if err != nil {
+            return nil, err;
+        }
+        if ok {
+            return ids, nil;
+        }
+        version = cachedVersion;
+    }
+
+    var blocked []uint;
+    result := self.db.
+        Model(&models.Block{}).
+        Where(models.Block{BlockerID: userId}).
+        Pluck("blocked_id", &blocked);
+    if result.Error != nil {
+        return nil, result.Error;
+    }
+
+    var blocking []uint;
+    result = self.db.
+        Model(&models.Block{}).
+        Where(models.Block{BlockedID: userId}).
+        Pluck("blocker_id", &blocking);
+    if result.Error != nil {
+        return nil, result.Error;
+    }
+
+    ids := append(blocked, blocking...);
+    if self.inTransaction() {
+        return ids, nil;
+    }
+    if err := self.redis.SetBlockedUsers(userId, ids, version); err != nil {
+        slog.Error("Error caching blocked users: " + err.Error());
+    }
+
+    return ids, nil;
+}
+
+func (self *AppService) getMutedIds(userId uint) ([]uint, error) {
+    var version int64;
+    if !self.inTransaction() {
+        ids, cachedVersion, ok, err := self.redis.GetMutedUsers(userId);
+        if err != nil {
+            return nil, err;
+        }
+        if ok {
+            return ids, nil;
+        }
+        version = cachedVersion;
+    }
+
+    ids := []uint{};
+    result := self.db.
+        Model(&models.Mute{}).
+        Where(models.Mute{MuterID: userId}).
+        Pluck("muted_id", &ids);
+    if result.Error != nil {
+        return nil, result.Error;
+    }
+
+    if self.inTransaction() {
+        return ids, nil;
+    }
+    if err := self.redis.SetMutedUsers(userId, ids, version); err != nil {
+        slog.Error("Error caching muted users: " + err.Error());
+    }
+
+    return ids, nil;
+}
+
+// returns ids of users whose content the viewer must not see. Anonymous
+// viewers see everything
+func (self *AppService) getHiddenAuthorIds(viewerId uint) ([]uint, error) {
+    if viewerId == 0 {
+        return []uint{}, nil;
+    }
+
+    blocked, err := self.getBlockedIds(viewerId);
+    if err != nil {
+        return nil, err;
+    }
+
+    muted, err := self.getMutedIds(viewerId);
+    if err != nil {
+        return nil, err;
+    }
+
+    return append(blocked, muted...), nil;
+}
+
+// reports whether either of the users blocked the other
+func (self *AppService) isBlocked(userId uint, otherId uint) (bool, error) {
+    if userId == 0 || otherId == 0 {
+        return false, nil;
+    }
+
+    blocked, err := self.getBlockedIds(userId);
+    if err != nil {
+        return false, err;
+    }
+
+    return slices.Contains(blocked, otherId), nil;
+}
+
+// narrows query to rows whose column is not one of hidden user ids
+func excludeUsers(query *gorm.DB, column string, ids []uint) *gorm.DB {
+    if len(ids) == 0 {
+        return query;
+    }
+
+    return query.Where(column + " NOT IN ?", ids);
+}
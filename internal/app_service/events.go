@@ -0,0 +1,48 @@
+package appservice
+
+import (
+	"encoding/json"
+	"log/slog"
+
+	"github.com/cxcnxl/go-crud/internal/redis"
+)
+
+const (
+    EventPost         string = "post";
+    EventNotification string = "notification";
+    EventReaction     string = "reaction";
+    EventMessage      string = "message";
+    // read receipt of a conversation participant
+    EventMessageRead  string = "message_read";
+)
+
+// max number of missed events replayed to a resuming client
+const maxReplayedEvents int = 500;
+
+// returns events of the user published after lastId, oldest first
+func (self *AppService) GetEventsAfter(userId uint, lastId string) ([]redis.StreamEvent, error) {
+    return self.redis.GetEventsAfter(userId, lastId, maxReplayedEvents);
+}
+
+// sends realtime event to the users. Failures are logged, live delivery is
+// best effort
+func (self *AppService) publishEvent(userIds []uint, eventType string, data any) {
+    if len(userIds) == 0 {
+        return;
+    }
+
+    payload, err := json.Marshal(data);
+    if err != nil {
+        slog.Error("Error encoding realtime event: " + err.Error());
+        return;
+    }
+
+    // events of rolled back transactions never happened
+    self.afterCommit(func(service *AppService) error {
+        err := service.redis.PublishEvent(userIds, eventType, payload);
+        if err != nil {
+            slog.Error("Error publishing realtime event: " + err.Error());
+        }
+        return nil;
+    });
+}
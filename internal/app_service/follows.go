@@ -0,0 +1,207 @@
+package appservice
+
+import (
+	"slices"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/cxcnxl/go-crud/internal/dto"
+	"github.com/cxcnxl/go-crud/internal/models"
+)
+
+func (self *AppService) FollowUser(followerId uint, followeeId uint) error {
+    if followerId == followeeId {
+        return SelfFollowError{};
+    }
+
+    if _, err := self.GetUserById(followeeId); err != nil {
+        return err;
+    }
+
+    if blocked, err := self.isBlocked(followerId, followeeId); err != nil {
+        return err;
+    } else if blocked {
+        return UserBlockedError{};
+    }
+
+    created := false;
+    err := self.db.Transaction(func(tx *gorm.DB) error {
+        follow := models.Follow{
+            FollowerID: followerId,
+            FolloweeID: followeeId,
+        };
+
+        result := tx.
+            Clauses(clause.OnConflict{DoNothing: true}).
+            Create(&follow);
+        if result.Error != nil {
+            return result.Error;
+        }
+        if result.RowsAffected == 0 {
+            return nil;
+        }
+
+        created = true;
+        return updateFollowCounters(tx, followerId, followeeId, 1);
+    });
+    if err != nil {
+        return err;
+    }
+
+    if created {
+        self.notify(followeeId, models.NotificationFollow, followerId, nil);
+    }
+
+    // followee posts are missing from cached timeline, rebuild on next read
+    return self.afterCommit(func(service *AppService) error {
+        return service.redis.DeleteTimeline(followerId);
+    });
+}
+
+func (self *AppService) UnfollowUser(followerId uint, followeeId uint) error {
+    err := self.db.Transaction(func(tx *gorm.DB) error {
+        result := tx.
+            Where(models.Follow{FollowerID: followerId, FolloweeID: followeeId}).
+            Delete(&models.Follow{});
+        if result.Error != nil {
+            return result.Error;
+        }
+        if result.RowsAffected == 0 {
+            return nil;
+        }
+
+        return updateFollowCounters(tx, followerId, followeeId, -1);
+    });
+    if err != nil {
+        return err;
+    }
+
+    return self.afterCommit(func(service *AppService) error {
+        return service.redis.DeleteTimeline(followerId);
+    });
+}
+
+// lists users following userId, newest follows first
+func (self *AppService) GetFollowers(
+    userId uint,
+    viewerId uint,
+    before uint,
+    limit int,
+) (dto.PageDto[models.User], error) {
+    return self.listFollowEdges("followee_id", "follower_id", userId, viewerId, before, limit);
+}
+
+// lists users followed by userId, newest follows first
+func (self *AppService) GetFollowing(
+    userId uint,
+    viewerId uint,
+    before uint,
+    limit int,
+) (dto.PageDto[models.User], error) {
+    return self.listFollowEdges("follower_id", "followee_id", userId, viewerId, before, limit);
+}
+
+// lists one side of the follows of the user. Like profiles, lists of users
+// blocked either way by the viewer do not exist for them, and users blocked
+// either way are left out of the lists of others
+func (self *AppService) listFollowEdges(
+    filterColumn string,
+    userColumn string,
+    userId uint,
+    viewerId uint,
+    before uint,
+    limit int,
+) (dto.PageDto[models.User], error) {
+    page := dto.PageDto[models.User]{ Items: []models.User{} };
+
+    if _, err := self.GetUserById(userId); err != nil {
+        return page, err;
+    }
+
+    blockedIds := []uint{};
+    if viewerId != 0 {
+        var err error;
+        blockedIds, err = self.getBlockedIds(viewerId);
+        if err != nil {
+            return page, err;
+        }
+    }
+    if slices.Contains(blockedIds, userId) {
+        return page, gorm.ErrRecordNotFound;
+    }
+
+    var rows []struct {
+        FollowID uint
+        models.User
+    };
+
+    query := self.db.
+        Table("follows").
+        Select("follows.id AS follow_id, users.*").
+        Joins("JOIN users ON users.id = follows." + userColumn).
+        Where("follows." + filterColumn + " = ?", userId);
+    if before != 0 {
+        query = query.Where("follows.id < ?", before);
+    }
+    query = excludeUsers(query, "users.id", blockedIds);
+
+    result := query.
+        Order("follows.id DESC").
+        Limit(limit + 1).
+        Scan(&rows);
+    if result.Error != nil {
+        return page, result.Error;
+    }
+
+    for i, row := range rows {
+        if i == limit {
+            page.NextCursor = rows[i - 1].FollowID;
+            break;
+        }
+        page.Items = append(page.Items, row.User);
+    }
+
+    return page, nil;
+}
+
+// returns ids of all followers of the user
+func (self *AppService) getFollowerIds(userId uint) ([]uint, error) {
+    ids := []uint{};
+
+    result := self.db.
+        Model(&models.Follow{}).
+        Where(models.Follow{FolloweeID: userId}).
+        Pluck("follower_id", &ids);
+
+    return ids, result.Error;
+}
+
+// returns ids of all users followed by the user
+func (self *AppService) getFolloweeIds(userId uint) ([]uint, error) {
+    ids := []uint{};
+
+    result := self.db.
+        Model(&models.Follow{}).
+        Where(models.Follow{FollowerID: userId}).
+        Pluck("followee_id", &ids);
+
+    return ids, result.Error;
+}
+
+func updateFollowCounters(tx *gorm.DB, followerId uint, followeeId uint, delta int) error {
+    err := tx.
+        Model(&models.User{}).
+        Where("id = ?", followerId).
+        Update("following_count", gorm.Expr("following_count + ?", delta)).
+        Error;
+    if err != nil {
+        return err;
+    }
+
+    return tx.
+        Model(&models.User{}).
+        Where("id = ?", followeeId).
+        Update("followers_count", gorm.Expr("followers_count + ?", delta)).
+        Error;
+}
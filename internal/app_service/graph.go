@@ -0,0 +1,121 @@
+package appservice
+
+import (
+	"github.com/cxcnxl/go-crud/internal/dto"
+	"github.com/cxcnxl/go-crud/internal/models"
+)
+
+// batched reads backing the GraphQL loaders. Each one answers every key of
+// a batch with a single query and leaves out rows the viewer must not see,
+// so missing keys resolve to null
+
+// loads users by id as the viewer sees them. Users blocked either way are
+// left out
+func (self *AppService) GetUsersByIds(ids []uint, viewerId uint) (map[uint]dto.UserViewDto, error) {
+    views := make(map[uint]dto.UserViewDto, len(ids));
+
+    blockedIds := []uint{};
+    if viewerId != 0 {
+        var err error;
+        blockedIds, err = self.getBlockedIds(viewerId);
+        if err != nil {
+            return nil, err;
+        }
+    }
+
+    var users []models.User;
+    query := self.db.Where("id IN ?", ids);
+    result := excludeUsers(query, "id", blockedIds).Find(&users);
+    if result.Error != nil {
+        return nil, result.Error;
+    }
+
+    for _, user := range users {
+        views[user.ID] = userView(user, viewerId);
+    }
+
+    return views, nil;
+}
+
+// loads posts by id, without authors, keeping the ones the viewer can see
+func (self *AppService) GetPostsByIds(ids []uint, viewerId uint) (map[uint]models.Post, error) {
+    var posts []models.Post;
+    result := self.db.Where("id IN ?", ids).Find(&posts);
+    if result.Error != nil {
+        return nil, result.Error;
+    }
+
+    audience, err := self.audienceOf(viewerId);
+    if err != nil {
+        return nil, err;
+    }
+    posts, err = audience.filter(posts);
+    if err != nil {
+        return nil, err;
+    }
+
+    found := make(map[uint]models.Post, len(posts));
+    for _, post := range posts {
+        found[post.ID] = post;
+    }
+
+    return found, nil;
+}
+
+// loads up to limit latest posts of every author, newest first. The window
+// function ranks posts per author, so all authors share one query.
+// Followers-only posts of authors the viewer does not follow are dropped
+// after the limit, which may leave such pages short
+func (self *AppService) GetAuthorsPosts(
+    authorIds []uint,
+    viewerId uint,
+    limit int,
+) (map[uint][]models.Post, error) {
+    ranked := self.db.
+        Model(&models.Post{}).
+        Select("posts.*, ROW_NUMBER() OVER (PARTITION BY author_id ORDER BY id DESC) AS row_rank").
+        Where("author_id IN ?", authorIds).
+        Where(
+            self.db.
+                Where("status = ? AND visibility <> ?", models.PostStatusPublished, models.VisibilityPrivate).
+                Or("author_id = ?", viewerId),
+        );
+
+    var posts []models.Post;
+    // the inner query already leaves out soft deleted posts
+    result := self.db.
+        Unscoped().
+        Table("(?) AS ranked", ranked).
+        Where("row_rank <= ?", limit).
+        Order("author_id, id DESC").
+        Find(&posts);
+    if result.Error != nil {
+        return nil, result.Error;
+    }
+
+    audience, err := self.audienceOf(viewerId);
+    if err != nil {
+        return nil, err;
+    }
+    posts, err = audience.filter(posts);
+    if err != nil {
+        return nil, err;
+    }
+
+    byAuthor := make(map[uint][]models.Post, len(authorIds));
+    for _, post := range posts {
+        byAuthor[post.AuthorID] = append(byAuthor[post.AuthorID], post);
+    }
+
+    return byAuthor, nil;
+}
+
+// returns the GraphQL query persisted under its sha256 hash, ok is false
+// for unknown hashes
+func (self *AppService) GetPersistedQuery(hash string) (string, bool, error) {
+    return self.redis.GetPersistedQuery(hash);
+}
+
+func (self *AppService) PersistQuery(hash string, query string) error {
+    return self.redis.SetPersistedQuery(hash, query);
+}
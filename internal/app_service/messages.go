@@ -0,0 +1,488 @@
+package appservice
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+
+	"github.com/cxcnxl/go-crud/internal/dto"
+	"github.com/cxcnxl/go-crud/internal/models"
+)
+
+// max number of users in a conversation, the creator included
+const MaxConversationSize int = 10;
+
+const maxMessageLength int = 2000;
+
+// starts a conversation of the user with participantIds. One-to-one
+// conversations are reused, so two users always share a single one
+func (self *AppService) CreateConversation(
+    userId uint,
+    participantIds []uint,
+) (dto.ConversationViewDto, error) {
+    ids := append([]uint{userId}, participantIds...);
+    slices.Sort(ids);
+    ids = slices.Compact(ids);
+    if len(ids) < 2 || len(ids) > MaxConversationSize || slices.Contains(ids, 0) {
+        return dto.ConversationViewDto{}, InvalidParticipantsError{};
+    }
+
+    var found int64;
+    result := self.db.Model(&models.User{}).Where("id IN ?", ids).Count(&found);
+    if result.Error != nil {
+        return dto.ConversationViewDto{}, result.Error;
+    }
+    if int(found) != len(ids) {
+        return dto.ConversationViewDto{}, InvalidParticipantsError{};
+    }
+
+    for i, id := range ids {
+        for _, other := range ids[i + 1:] {
+            blocked, err := self.isBlocked(id, other);
+            if err != nil {
+                return dto.ConversationViewDto{}, err;
+            }
+            if blocked {
+                return dto.ConversationViewDto{}, UserBlockedError{};
+            }
+        }
+    }
+
+    if len(ids) == 2 {
+        existingId, err := self.findDirectConversation(ids[0], ids[1]);
+        if err != nil {
+            return dto.ConversationViewDto{}, err;
+        }
+        if existingId != 0 {
+            return self.GetConversation(userId, existingId);
+        }
+    }
+
+    now := time.Now();
+    conversation := models.Conversation{
+        CreatedByID: userId,
+        LastMessageAt: &now,
+    };
+    if len(ids) == 2 {
+        key := fmt.Sprintf("%d:%d", ids[0], ids[1]);
+        conversation.DirectKey = &key;
+    }
+    err := self.db.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Create(&conversation).Error; err != nil {
+            return err;
+        }
+
+        participants := make([]models.Participant, 0, len(ids));
+        for _, id := range ids {
+            participants = append(participants, models.Participant{
+                ConversationID: conversation.ID,
+                UserID: id,
+            });
+        }
+
+        return tx.Omit("User").Create(&participants).Error;
+    });
+    if errors.Is(err, gorm.ErrDuplicatedKey) && conversation.DirectKey != nil {
+        // a concurrent request created it first
+        existingId, err := self.findDirectConversation(ids[0], ids[1]);
+        if err != nil {
+            return dto.ConversationViewDto{}, err;
+        }
+        return self.GetConversation(userId, existingId);
+    }
+    if err != nil {
+        return dto.ConversationViewDto{}, err;
+    }
+
+    return self.GetConversation(userId, conversation.ID);
+}
+
+// returns id of the conversation having exactly the two users, 0 if none.
+// Conversations started before DirectKey existed are found by participants
+func (self *AppService) findDirectConversation(userId uint, otherId uint) (uint, error) {
+    ids := []uint{};
+
+    result := self.db.
+        Model(&models.Conversation{}).
+        Where("direct_key = ?", fmt.Sprintf("%d:%d", min(userId, otherId), max(userId, otherId))).
+        Pluck("id", &ids);
+    if result.Error != nil || len(ids) > 0 {
+        return firstId(ids), result.Error;
+    }
+
+    result = self.db.
+        Model(&models.Participant{}).
+        Where("conversation_id IN (?)", self.db.
+            Model(&models.Participant{}).
+            Select("conversation_id").
+            Group("conversation_id").
+            Having("COUNT(*) = 2")).
+        Where("user_id IN ?", []uint{userId, otherId}).
+        Group("conversation_id").
+        Having("COUNT(*) = 2").
+        Limit(1).
+        Pluck("conversation_id", &ids);
+
+    return firstId(ids), result.Error;
+}
+
+func firstId(ids []uint) uint {
+    if len(ids) == 0 {
+        return 0;
+    }
+
+    return ids[0];
+}
+
+// lists conversations of the user, most recently active first. Cursor is
+// the time of the last message of the last conversation and its id
+func (self *AppService) GetConversations(
+    userId uint,
+    before dto.Keyset,
+    limit int,
+) (dto.KeysetPageDto[dto.ConversationViewDto], error) {
+    page := dto.KeysetPageDto[dto.ConversationViewDto]{ Items: []dto.ConversationViewDto{} };
+
+    query := self.db.
+        Preload("Participants.User").
+        Joins("JOIN participants ON participants.conversation_id = conversations.id").
+        Where("participants.user_id = ?", userId);
+    if !before.IsZero() {
+        at := time.UnixMilli(before.At);
+        query = query.Where(
+            "(conversations.last_message_at < ? OR (conversations.last_message_at = ? AND conversations.id < ?))",
+            at, at, before.ID,
+        );
+    }
+
+    var conversations []models.Conversation;
+    result := query.
+        Order("conversations.last_message_at DESC, conversations.id DESC").
+        Limit(limit + 1).
+        Find(&conversations);
+    if result.Error != nil {
+        return page, result.Error;
+    }
+
+    if len(conversations) > limit {
+        conversations = conversations[:limit];
+        last := conversations[limit - 1];
+        page.NextCursor = &dto.Keyset{ At: last.LastMessageAt.UnixMilli(), ID: last.ID };
+    }
+
+    ids := make([]uint, 0, len(conversations));
+    for _, conversation := range conversations {
+        ids = append(ids, conversation.ID);
+    }
+
+    unread, err := self.countUnreadMessages(userId, ids);
+    if err != nil {
+        return page, err;
+    }
+
+    for _, conversation := range conversations {
+        page.Items = append(page.Items, dto.ConversationViewDto{
+            Conversation: conversation,
+            Unread: unread[conversation.ID],
+        });
+    }
+
+    return page, nil;
+}
+
+// loads conversation with participants and their read receipts. Users
+// outside the conversation get gorm.ErrRecordNotFound
+func (self *AppService) GetConversation(
+    userId uint,
+    conversationId uint,
+) (dto.ConversationViewDto, error) {
+    if _, err := self.getParticipantIds(userId, conversationId); err != nil {
+        return dto.ConversationViewDto{}, err;
+    }
+
+    var conversation models.Conversation;
+    result := self.db.
+        Preload("Participants.User").
+        First(&conversation, conversationId);
+    if result.Error != nil {
+        return dto.ConversationViewDto{}, result.Error;
+    }
+
+    unread, err := self.countUnreadMessages(userId, []uint{conversationId});
+    if err != nil {
+        return dto.ConversationViewDto{}, err;
+    }
+
+    return dto.ConversationViewDto{
+        Conversation: conversation,
+        Unread: unread[conversationId],
+    }, nil;
+}
+
+// lists messages of the conversation, newest first
+func (self *AppService) GetMessages(
+    userId uint,
+    conversationId uint,
+    before uint,
+    limit int,
+) (dto.PageDto[models.Message], error) {
+    page := dto.PageDto[models.Message]{ Items: []models.Message{} };
+
+    if _, err := self.getParticipantIds(userId, conversationId); err != nil {
+        return page, err;
+    }
+
+    query := self.db.Where(models.Message{ConversationID: conversationId});
+    if before != 0 {
+        query = query.Where("id < ?", before);
+    }
+
+    var messages []models.Message;
+    result := query.Order("id DESC").Limit(limit + 1).Find(&messages);
+    if result.Error != nil {
+        return page, result.Error;
+    }
+
+    if len(messages) > limit {
+        messages = messages[:limit];
+        page.NextCursor = messages[limit - 1].ID;
+    }
+
+    for i := range messages {
+        if err := self.openMessage(&messages[i]); err != nil {
+            return page, err;
+        }
+    }
+    page.Items = messages;
+
+    return page, nil;
+}
+
+// stores encrypted message and delivers it live to every participant
+func (self *AppService) SendMessage(
+    userId uint,
+    conversationId uint,
+    body string,
+) (models.Message, error) {
+    body = strings.TrimSpace(body);
+    if body == "" || utf8.RuneCountInString(body) > maxMessageLength {
+        return models.Message{}, InvalidMessageBodyError{};
+    }
+
+    participantIds, err := self.getParticipantIds(userId, conversationId);
+    if err != nil {
+        return models.Message{}, err;
+    }
+
+    for _, id := range participantIds {
+        blocked, err := self.isBlocked(userId, id);
+        if err != nil {
+            return models.Message{}, err;
+        }
+        if blocked {
+            return models.Message{}, UserBlockedError{};
+        }
+    }
+
+    sealed, err := self.messages.Seal([]byte(body), messageAdditionalData(conversationId));
+    if err != nil {
+        return models.Message{}, err;
+    }
+
+    message := models.Message{
+        ConversationID: conversationId,
+        SenderID: userId,
+        SealedBody: sealed,
+    };
+    err = self.db.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Create(&message).Error; err != nil {
+            return err;
+        }
+
+        err := tx.
+            Model(&models.Conversation{}).
+            Where("id = ?", conversationId).
+            Update("last_message_at", message.CreatedAt).
+            Error;
+        if err != nil {
+            return err;
+        }
+
+        // senders have read everything up to their own message
+        return tx.
+            Model(&models.Participant{}).
+            Where(models.Participant{ConversationID: conversationId, UserID: userId}).
+            Update("last_read_message_id", message.ID).
+            Error;
+    });
+    if err != nil {
+        return message, err;
+    }
+    message.Body = body;
+
+    // other devices of the sender get it too. Events are kept in the replay
+    // streams in Redis, so they carry ids only and clients fetch the text
+    // with GetMessages, keeping bodies encrypted at rest
+    self.publishEvent(participantIds, EventMessage, map[string]uint{
+        "conversation_id": conversationId,
+        "message_id": message.ID,
+        "sender_id": userId,
+    });
+
+    return message, nil;
+}
+
+// moves read receipt of the user up to messageId, 0 means the latest
+// message. Receipts never move back
+func (self *AppService) MarkConversationRead(
+    userId uint,
+    conversationId uint,
+    messageId uint,
+) error {
+    participantIds, err := self.getParticipantIds(userId, conversationId);
+    if err != nil {
+        return err;
+    }
+
+    if messageId == 0 {
+        ids := []uint{};
+        result := self.db.
+            Model(&models.Message{}).
+            Where(models.Message{ConversationID: conversationId}).
+            Order("id DESC").
+            Limit(1).
+            Pluck("id", &ids);
+        if result.Error != nil || len(ids) == 0 {
+            return result.Error;
+        }
+        messageId = ids[0];
+    } else {
+        var count int64;
+        result := self.db.
+            Model(&models.Message{}).
+            Where(models.Message{ID: messageId, ConversationID: conversationId}).
+            Count(&count);
+        if result.Error != nil {
+            return result.Error;
+        }
+        if count == 0 {
+            return gorm.ErrRecordNotFound;
+        }
+    }
+
+    result := self.db.
+        Model(&models.Participant{}).
+        Where(models.Participant{ConversationID: conversationId, UserID: userId}).
+        Where("last_read_message_id < ?", messageId).
+        Update("last_read_message_id", messageId);
+    if result.Error != nil {
+        return result.Error;
+    }
+
+    if result.RowsAffected > 0 {
+        self.publishEvent(participantIds, EventMessageRead, map[string]uint{
+            "conversation_id": conversationId,
+            "user_id": userId,
+            "last_read_message_id": messageId,
+        });
+    }
+
+    return nil;
+}
+
+// returns number of unread messages in all conversations of the user
+func (self *AppService) GetUnreadMessagesCount(userId uint) (int, error) {
+    unread, err := self.countUnreadMessages(userId, nil);
+    if err != nil {
+        return 0, err;
+    }
+
+    total := 0;
+    for _, count := range unread {
+        total += count;
+    }
+
+    return total, nil;
+}
+
+// counts messages of others after the read receipt of the user, by
+// conversation. nil conversationIds counts all conversations of the user
+func (self *AppService) countUnreadMessages(
+    userId uint,
+    conversationIds []uint,
+) (map[uint]int, error) {
+    unread := map[uint]int{};
+    if conversationIds != nil && len(conversationIds) == 0 {
+        return unread, nil;
+    }
+
+    query := self.db.
+        Table("participants").
+        Select("participants.conversation_id, COUNT(messages.id) AS count").
+        Joins(
+            "JOIN messages ON messages.conversation_id = participants.conversation_id" +
+            " AND messages.id > participants.last_read_message_id" +
+            " AND messages.sender_id <> participants.user_id",
+        ).
+        Where("participants.user_id = ?", userId);
+    if conversationIds != nil {
+        query = query.Where("participants.conversation_id IN ?", conversationIds);
+    }
+
+    var rows []struct {
+        ConversationID uint
+        Count          int
+    };
+    result := query.Group("participants.conversation_id").Scan(&rows);
+    if result.Error != nil {
+        return nil, result.Error;
+    }
+
+    for _, row := range rows {
+        unread[row.ConversationID] = row.Count;
+    }
+
+    return unread, nil;
+}
+
+// returns ids of all participants of the conversation, or
+// gorm.ErrRecordNotFound when the user is not one of them
+func (self *AppService) getParticipantIds(userId uint, conversationId uint) ([]uint, error) {
+    ids := []uint{};
+
+    result := self.db.
+        Model(&models.Participant{}).
+        Where(models.Participant{ConversationID: conversationId}).
+        Pluck("user_id", &ids);
+    if result.Error != nil {
+        return nil, result.Error;
+    }
+    if !slices.Contains(ids, userId) {
+        return nil, gorm.ErrRecordNotFound;
+    }
+
+    return ids, nil;
+}
+
+func (self *AppService) openMessage(message *models.Message) error {
+    body, err := self.messages.Open(
+        message.SealedBody,
+        messageAdditionalData(message.ConversationID),
+    );
+    if err != nil {
+        return err;
+    }
+    message.Body = string(body);
+
+    return nil;
+}
+
+// binds sealed bodies to their conversation
+func messageAdditionalData(conversationId uint) []byte {
+    return []byte(fmt.Sprintf("conversation:%d", conversationId));
+}
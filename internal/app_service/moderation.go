@@ -0,0 +1,519 @@
+package appservice
+
+import (
+	"context"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/cxcnxl/go-crud/internal/dto"
+	"github.com/cxcnxl/go-crud/internal/models"
+)
+
+// actions moderators take on reports
+const (
+    ModerationDismiss       string = "dismiss";
+    // publishes held or hidden post
+    ModerationApprove       string = "approve";
+    ModerationHidePost      string = "hide_post";
+    ModerationSuspendAuthor string = "suspend_author";
+    ModerationDeletePost    string = "delete_post";
+)
+
+// actions recorded in the audit trail besides the ones above
+const (
+    moderationSuspendUser   string = "suspend_user";
+    moderationUnsuspendUser string = "unsuspend_user";
+    moderationCreateFilter  string = "create_filter";
+    moderationDeleteFilter  string = "delete_filter";
+)
+
+const maxReportReasonLength int = 1000;
+
+func (self *AppService) ReportPost(
+    reporterId uint,
+    postId uint,
+    reason string,
+) (models.Report, error) {
+    post, err := self.GetVisiblePost(postId, reporterId);
+    if err != nil {
+        return models.Report{}, err;
+    }
+
+    return self.fileReport(reporterId, post.AuthorID, &post.ID, reason);
+}
+
+func (self *AppService) ReportUser(
+    reporterId uint,
+    userId uint,
+    reason string,
+) (models.Report, error) {
+    if _, err := self.GetUserById(userId); err != nil {
+        return models.Report{}, err;
+    }
+
+    return self.fileReport(reporterId, userId, nil, reason);
+}
+
+func (self *AppService) fileReport(
+    reporterId uint,
+    userId uint,
+    postId *uint,
+    reason string,
+) (models.Report, error) {
+    reason = strings.TrimSpace(reason);
+    if reason == "" || utf8.RuneCountInString(reason) > maxReportReasonLength {
+        return models.Report{}, InvalidReportError{};
+    }
+    if reporterId == userId {
+        return models.Report{}, InvalidReportError{};
+    }
+
+    report := models.Report{
+        ReporterID: &reporterId,
+        PostID: postId,
+        UserID: userId,
+        Reason: reason,
+        Status: models.ReportOpen,
+    };
+    result := self.db.Create(&report);
+
+    return report, result.Error;
+}
+
+// lists reports with the given status, newest first
+func (self *AppService) GetReports(
+    moderatorId uint,
+    status models.ReportStatus,
+    before uint,
+    limit int,
+) (dto.PageDto[models.Report], error) {
+    page := dto.PageDto[models.Report]{ Items: []models.Report{} };
+
+    if err := self.RequireAdmin(moderatorId); err != nil {
+        return page, err;
+    }
+
+    query := self.db.Where(models.Report{Status: status});
+    if before != 0 {
+        query = query.Where("id < ?", before);
+    }
+
+    var reports []models.Report;
+    result := query.Order("id DESC").Limit(limit + 1).Find(&reports);
+    if result.Error != nil {
+        return page, result.Error;
+    }
+
+    if len(reports) > limit {
+        reports = reports[:limit];
+        page.NextCursor = reports[limit - 1].ID;
+    }
+    page.Items = reports;
+
+    return page, nil;
+}
+
+// applies the action to the target of the report and resolves every open
+// report on the same target, all in one transaction. The report is locked,
+// so moderators resolving it at once do not both act on it
+func (self *AppService) ResolveReport(
+    moderatorId uint,
+    reportId uint,
+    data dto.ModerationActionDto,
+) (models.Report, error) {
+    if err := self.RequireAdmin(moderatorId); err != nil {
+        return models.Report{}, err;
+    }
+
+    var report models.Report;
+    now := time.Now();
+    err := self.Transaction(func(tx *AppService) error {
+        result := tx.db.
+            Clauses(clause.Locking{Strength: "UPDATE"}).
+            First(&report, reportId);
+        if result.Error != nil {
+            return result.Error;
+        }
+        if report.Status != models.ReportOpen {
+            return ReportClosedError{};
+        }
+
+        switch data.Action {
+        case ModerationDismiss:
+        case ModerationSuspendAuthor:
+            if err := tx.checkSuspendable(moderatorId, report.UserID); err != nil {
+                return err;
+            }
+            if err := tx.setSuspended(report.UserID, true); err != nil {
+                return err;
+            }
+        case ModerationApprove, ModerationHidePost, ModerationDeletePost:
+            if report.PostID == nil {
+                return InvalidModerationActionError{};
+            }
+            if err := tx.moderatePost(*report.PostID, data.Action); err != nil {
+                return err;
+            }
+        default:
+            return InvalidModerationActionError{};
+        }
+
+        query := tx.db.
+            Model(&models.Report{}).
+            Where(models.Report{Status: models.ReportOpen});
+        if report.PostID != nil {
+            query = query.Where("post_id = ?", *report.PostID);
+        } else {
+            query = query.Where("post_id IS NULL AND user_id = ?", report.UserID);
+        }
+
+        result = query.Updates(map[string]any{
+            "status": models.ReportResolved,
+            "resolution": data.Action,
+            "resolved_by_id": moderatorId,
+            "resolved_at": now,
+        });
+        if result.Error != nil {
+            return result.Error;
+        }
+
+        return tx.db.Create(&models.ModerationAction{
+            ModeratorID: moderatorId,
+            Action: data.Action,
+            ReportID: &report.ID,
+            PostID: report.PostID,
+            UserID: &report.UserID,
+            Note: data.Note,
+        }).Error;
+    });
+    if err != nil {
+        return report, err;
+    }
+
+    report.Status = models.ReportResolved;
+    report.Resolution = data.Action;
+    report.ResolvedByID = &moderatorId;
+    report.ResolvedAt = &now;
+
+    return report, nil;
+}
+
+func (self *AppService) moderatePost(postId uint, action string) error {
+    post, err := self.GetPostById(postId);
+    if err != nil {
+        return err;
+    }
+
+    switch action {
+    case ModerationApprove:
+        if post.Status != models.PostStatusHeld && post.Status != models.PostStatusHidden {
+            return nil;
+        }
+
+        claimed, err := self.claimPublication(post.ID, post.Status);
+        if err != nil {
+            return err;
+        }
+        // the author changed the post since it was read
+        if !claimed {
+            return PostChangedError{};
+        }
+
+        post, err = self.GetPostById(post.ID);
+        if err != nil {
+            return err;
+        }
+        self.afterPublish(post);
+    case ModerationHidePost:
+        return self.db.
+            Model(&post).
+            Update("status", models.PostStatusHidden).
+            Error;
+    case ModerationDeletePost:
+        // hidden first, so restoring it from the trash does not bring it back
+        // to readers
+        result := self.db.Model(&post).Update("status", models.PostStatusHidden);
+        if result.Error != nil {
+            return result.Error;
+        }
+        return self.db.Delete(&post).Error;
+    }
+
+    return nil;
+}
+
+func (self *AppService) SuspendUser(moderatorId uint, userId uint, note string) error {
+    return self.moderateUser(moderatorId, userId, true, note);
+}
+
+func (self *AppService) UnsuspendUser(moderatorId uint, userId uint, note string) error {
+    return self.moderateUser(moderatorId, userId, false, note);
+}
+
+func (self *AppService) moderateUser(
+    moderatorId uint,
+    userId uint,
+    suspended bool,
+    note string,
+) error {
+    if err := self.RequireAdmin(moderatorId); err != nil {
+        return err;
+    }
+    if err := self.checkSuspendable(moderatorId, userId); err != nil {
+        return err;
+    }
+
+    if err := self.setSuspended(userId, suspended); err != nil {
+        return err;
+    }
+
+    action := moderationSuspendUser;
+    if !suspended {
+        action = moderationUnsuspendUser;
+    }
+
+    return self.db.Create(&models.ModerationAction{
+        ModeratorID: moderatorId,
+        Action: action,
+        UserID: &userId,
+        Note: note,
+    }).Error;
+}
+
+// moderators neither suspend themselves nor other moderators
+func (self *AppService) checkSuspendable(moderatorId uint, userId uint) error {
+    if moderatorId == userId {
+        return InvalidModerationActionError{};
+    }
+
+    user, err := self.GetUserById(userId);
+    if err != nil {
+        return err;
+    }
+    if user.Role == models.RoleAdmin {
+        return InvalidModerationActionError{};
+    }
+
+    return nil;
+}
+
+func (self *AppService) setSuspended(userId uint, suspended bool) error {
+    var suspendedAt *time.Time;
+    if suspended {
+        now := time.Now();
+        suspendedAt = &now;
+    }
+
+    result := self.db.
+        Model(&models.User{ID: userId}).
+        Update("suspended_at", suspendedAt);
+    if result.Error != nil {
+        return result.Error;
+    }
+
+    // tokens of the user are checked against this flag on every request
+    return self.afterCommit(func(service *AppService) error {
+        return service.redis.SetUserSuspended(userId, suspended);
+    });
+}
+
+// reports whether the account is suspended. Called on every authenticated
+// request, so the answer is cached in redis
+func (self *AppService) IsUserSuspended(userId uint) (bool, error) {
+    suspended, ok, err := self.redis.GetUserSuspended(userId);
+    if err != nil {
+        return false, err;
+    }
+    if ok {
+        return suspended, nil;
+    }
+
+    user, err := self.GetUserById(userId);
+    if err != nil {
+        return false, err;
+    }
+    suspended = user.SuspendedAt != nil;
+    if self.inTransaction() {
+        return suspended, nil;
+    }
+
+    err = self.redis.SetUserSuspended(userId, suspended);
+    if err != nil {
+        slog.Error("Error caching user suspension: " + err.Error());
+    }
+
+    return suspended, nil;
+}
+
+// fails with SuspendedUserError while the account is suspended. Tokens are
+// checked with it under the context of their request, so operations of an
+// atomic batch read through its transaction
+func (self *AppService) CheckActiveUser(ctx context.Context, userId uint) error {
+    suspended, err := self.For(ctx).IsUserSuspended(userId);
+    if err != nil {
+        return err;
+    }
+    if suspended {
+        return SuspendedUserError{};
+    }
+
+    return nil;
+}
+
+// builds moderation filter from the request of the moderator. Filters
+// are served through routes.Resource, which stores them
+func NewModerationFilter(
+    moderatorId uint,
+    data dto.ModerationFilterDto,
+) (models.ModerationFilter, error) {
+    filter := models.ModerationFilter{
+        Pattern: strings.TrimSpace(data.Pattern),
+        IsRegex: data.IsRegex,
+        CreatedByID: moderatorId,
+    };
+    if filter.Pattern == "" || len(filter.Pattern) > 255 {
+        return filter, InvalidFilterError{};
+    }
+    if _, err := compileFilter(filter); err != nil {
+        return filter, InvalidFilterError{};
+    }
+
+    return filter, nil;
+}
+
+// records creation or removal of the filter in the audit trail, within tx
+// of the change
+func AuditFilterChange(
+    tx *gorm.DB,
+    moderatorId uint,
+    filter *models.ModerationFilter,
+    created bool,
+) error {
+    action := moderationDeleteFilter;
+    if created {
+        action = moderationCreateFilter;
+    }
+
+    return tx.Create(&models.ModerationAction{
+        ModeratorID: moderatorId,
+        Action: action,
+        FilterID: &filter.ID,
+        Note: filter.Pattern,
+    }).Error;
+}
+
+// lists moderation actions, newest first
+func (self *AppService) GetModerationAudit(
+    moderatorId uint,
+    before uint,
+    limit int,
+) (dto.PageDto[models.ModerationAction], error) {
+    page := dto.PageDto[models.ModerationAction]{ Items: []models.ModerationAction{} };
+
+    if err := self.RequireAdmin(moderatorId); err != nil {
+        return page, err;
+    }
+
+    query := self.db.Model(&models.ModerationAction{});
+    if before != 0 {
+        query = query.Where("id < ?", before);
+    }
+
+    var actions []models.ModerationAction;
+    result := query.Order("id DESC").Limit(limit + 1).Find(&actions);
+    if result.Error != nil {
+        return page, result.Error;
+    }
+
+    if len(actions) > limit {
+        actions = actions[:limit];
+        page.NextCursor = actions[limit - 1].ID;
+    }
+    page.Items = actions;
+
+    return page, nil;
+}
+
+// returns NotAdminError unless the user is a moderator
+func (self *AppService) RequireAdmin(userId uint) error {
+    user, err := self.GetUserById(userId);
+    if err != nil {
+        return err;
+    }
+    if user.Role != models.RoleAdmin {
+        return NotAdminError{};
+    }
+
+    return nil;
+}
+
+// returns the first filter matching body, nil when none does
+func (self *AppService) matchFilters(body string) (*models.ModerationFilter, error) {
+    var filters []models.ModerationFilter;
+    result := self.db.Order("id").Find(&filters);
+    if result.Error != nil {
+        return nil, result.Error;
+    }
+
+    for _, filter := range filters {
+        pattern, err := compileFilter(filter);
+        if err != nil {
+            slog.Error("Invalid moderation filter: " + err.Error());
+            continue;
+        }
+        if pattern.MatchString(body) {
+            return &filter, nil;
+        }
+    }
+
+    return nil, nil;
+}
+
+// holds post for review when its new body matches a filter. Only posts on
+// their way to readers are checked, drafts are checked once they get published
+func (self *AppService) holdIfFiltered(
+    post *models.Post,
+    body string,
+) (*models.ModerationFilter, error) {
+    if post.Status != models.PostStatusPublished && post.Status != models.PostStatusScheduled {
+        return nil, nil;
+    }
+
+    filter, err := self.matchFilters(body);
+    if err != nil || filter == nil {
+        return nil, err;
+    }
+
+    post.Status = models.PostStatusHeld;
+    post.PublishedAt = nil;
+
+    return filter, nil;
+}
+
+// puts held post into the review queue
+func (self *AppService) reportHeldPost(post models.Post, filter *models.ModerationFilter) {
+    result := self.db.Create(&models.Report{
+        PostID: &post.ID,
+        UserID: post.AuthorID,
+        Reason: "matched filter: " + filter.Pattern,
+        Status: models.ReportOpen,
+    });
+    if result.Error != nil {
+        slog.Error("Error reporting held post: " + result.Error.Error());
+    }
+}
+
+// keywords match whole words regardless of case
+func compileFilter(filter models.ModerationFilter) (*regexp.Regexp, error) {
+    if filter.IsRegex {
+        return regexp.Compile(filter.Pattern);
+    }
+
+    return regexp.Compile(`(?i)(^|\W)` + regexp.QuoteMeta(filter.Pattern) + `($|\W)`);
+}
@@ -0,0 +1,238 @@
+package appservice
+
+import (
+	"log/slog"
+	"slices"
+	"time"
+
+	"gorm.io/gorm/clause"
+
+	"github.com/cxcnxl/go-crud/internal/dto"
+	"github.com/cxcnxl/go-crud/internal/models"
+)
+
+// lists notifications of the user, newest first
+func (self *AppService) GetNotifications(
+    userId uint,
+    unreadOnly bool,
+    before uint,
+    limit int,
+) (dto.PageDto[models.Notification], error) {
+    page := dto.PageDto[models.Notification]{ Items: []models.Notification{} };
+
+    hiddenIds, err := self.getHiddenAuthorIds(userId);
+    if err != nil {
+        return page, err;
+    }
+
+    query := self.db.
+        Preload("Actor").
+        Where("user_id = ?", userId);
+    query = excludeUsers(query, "actor_id", hiddenIds);
+    if unreadOnly {
+        query = query.Where("read_at IS NULL");
+    }
+    if before != 0 {
+        query = query.Where("id < ?", before);
+    }
+
+    var notifications []models.Notification;
+    result := query.Order("id DESC").Limit(limit + 1).Find(&notifications);
+    if result.Error != nil {
+        return page, result.Error;
+    }
+
+    if len(notifications) > limit {
+        notifications = notifications[:limit];
+        page.NextCursor = notifications[limit - 1].ID;
+    }
+    page.Items = notifications;
+
+    return page, nil;
+}
+
+// returns number of unread notifications GetNotifications lists, cached in
+// redis
+func (self *AppService) GetUnreadNotificationsCount(userId uint) (int, error) {
+    if !self.inTransaction() {
+        count, ok, err := self.redis.GetUnreadNotifications(userId);
+        if err != nil {
+            return 0, err;
+        }
+        if ok {
+            return count, nil;
+        }
+    }
+
+    hiddenIds, err := self.getHiddenAuthorIds(userId);
+    if err != nil {
+        return 0, err;
+    }
+
+    var dbCount int64;
+    query := self.db.
+        Model(&models.Notification{}).
+        Where("user_id = ? AND read_at IS NULL", userId);
+    result := excludeUsers(query, "actor_id", hiddenIds).Count(&dbCount);
+    if result.Error != nil {
+        return 0, result.Error;
+    }
+
+    if self.inTransaction() {
+        return int(dbCount), nil;
+    }
+
+    err = self.redis.SetUnreadNotifications(userId, int(dbCount));
+    if err != nil {
+        return 0, err;
+    }
+
+    return int(dbCount), nil;
+}
+
+func (self *AppService) MarkNotificationRead(userId uint, notificationId uint) error {
+    result := self.db.
+        Model(&models.Notification{}).
+        Where("id = ? AND user_id = ? AND read_at IS NULL", notificationId, userId).
+        Update("read_at", time.Now());
+    if result.Error != nil {
+        return result.Error;
+    }
+    if result.RowsAffected == 0 {
+        return nil;
+    }
+
+    return self.afterCommit(func(service *AppService) error {
+        return service.redis.IncrUnreadNotifications(userId, -1);
+    });
+}
+
+func (self *AppService) MarkAllNotificationsRead(userId uint) error {
+    result := self.db.
+        Model(&models.Notification{}).
+        Where("user_id = ? AND read_at IS NULL", userId).
+        Update("read_at", time.Now());
+    if result.Error != nil {
+        return result.Error;
+    }
+
+    return self.afterCommit(func(service *AppService) error {
+        return service.redis.SetUnreadNotifications(userId, 0);
+    });
+}
+
+// returns enabled flag of every notification type for the user
+func (self *AppService) GetNotificationPreferences(userId uint) (map[models.NotificationType]bool, error) {
+    var rows []models.NotificationPreference;
+    result := self.db.Where("user_id = ?", userId).Find(&rows);
+    if result.Error != nil {
+        return nil, result.Error;
+    }
+
+    preferences := make(map[models.NotificationType]bool, len(models.NotificationTypes));
+    for _, notificationType := range models.NotificationTypes {
+        preferences[notificationType] = true;
+    }
+    for _, row := range rows {
+        preferences[row.Type] = row.Enabled;
+    }
+
+    return preferences, nil;
+}
+
+// updates given notification types, others stay as they are
+func (self *AppService) SetNotificationPreferences(
+    userId uint,
+    data dto.NotificationPreferencesDto,
+) (map[models.NotificationType]bool, error) {
+    for notificationType, enabled := range data {
+        if !slices.Contains(models.NotificationTypes, notificationType) {
+            return nil, InvalidNotificationTypeError{};
+        }
+
+        row := models.NotificationPreference{
+            UserID: userId,
+            Type: notificationType,
+            Enabled: enabled,
+        };
+
+        result := self.db.
+            Clauses(clause.OnConflict{UpdateAll: true}).
+            Create(&row);
+        if result.Error != nil {
+            return nil, result.Error;
+        }
+    }
+
+    return self.GetNotificationPreferences(userId);
+}
+
+// creates notification unless the user turned its type off. Notifications
+// of muted actors are kept for when they are unmuted, but neither counted
+// nor pushed. Failures are logged, notifications never break the action
+// that caused them
+func (self *AppService) notify(
+    userId uint,
+    notificationType models.NotificationType,
+    actorId uint,
+    postId *uint,
+) {
+    if userId == actorId {
+        return;
+    }
+
+    blocked, err := self.isBlocked(userId, actorId);
+    if err != nil {
+        slog.Error("Error loading blocked users: " + err.Error());
+        return;
+    }
+    if blocked {
+        return;
+    }
+
+    preferences, err := self.GetNotificationPreferences(userId);
+    if err != nil {
+        slog.Error("Error loading notification preferences: " + err.Error());
+        return;
+    }
+    if !preferences[notificationType] {
+        return;
+    }
+
+    notification := models.Notification{
+        UserID: userId,
+        Type: notificationType,
+        ActorID: actorId,
+        PostID: postId,
+    };
+
+    result := self.db.Omit("Actor").Create(&notification);
+    if result.Error != nil {
+        slog.Error("Error creating notification: " + result.Error.Error());
+        return;
+    }
+
+    muted, err := self.getMutedIds(userId);
+    if err != nil {
+        slog.Error("Error loading muted users: " + err.Error());
+        return;
+    }
+    if slices.Contains(muted, actorId) {
+        return;
+    }
+
+    self.afterCommit(func(service *AppService) error {
+        err := service.redis.IncrUnreadNotifications(userId, 1);
+        if err != nil {
+            slog.Error("Error updating unread notifications: " + err.Error());
+        }
+        return nil;
+    });
+
+    err = self.db.Preload("Actor").First(&notification, notification.ID).Error;
+    if err != nil {
+        slog.Error("Error loading notification: " + err.Error());
+        return;
+    }
+    self.publishEvent([]uint{ userId }, EventNotification, notification);
+}
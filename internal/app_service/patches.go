@@ -0,0 +1,70 @@
+package appservice
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"gorm.io/gorm/clause"
+
+	"github.com/cxcnxl/go-crud/internal/jsonpatch"
+	"github.com/cxcnxl/go-crud/internal/mergepatch"
+	"github.com/cxcnxl/go-crud/internal/validation"
+)
+
+// patch of a content type applyPatch does not know
+type UnsupportedPatchError struct {}
+func (self UnsupportedPatchError) Error() string {
+    return "unsupported_patch";
+}
+
+// content types applyPatch takes, plain JSON bodies are merge patches
+var PatchContentTypes = []string{ mergepatch.ContentType, jsonpatch.ContentType };
+
+// applies patch of contentType to the JSON form of projection, the part of
+// a model clients may edit, and decodes the result into target. Results
+// are checked like request bodies, so unknown members, wrong types and
+// failed validate tags come back as validation.Errors
+func applyPatch(projection any, contentType string, patch []byte, target any) error {
+    current, err := json.Marshal(projection);
+    if err != nil {
+        return err;
+    }
+
+    var patched []byte;
+    switch contentType {
+    case mergepatch.ContentType, "application/json":
+        // patches replacing the whole document make no sense for a model
+        if trimmed := bytes.TrimSpace(patch); len(trimmed) == 0 || trimmed[0] != '{' {
+            return mergepatch.InvalidPatchError{};
+        }
+        patched, err = mergepatch.Apply(current, patch);
+    case jsonpatch.ContentType:
+        patched, err = jsonpatch.Apply(current, patch);
+    default:
+        return UnsupportedPatchError{};
+    }
+    if err != nil {
+        return err;
+    }
+    if trimmed := bytes.TrimSpace(patched); len(trimmed) == 0 || trimmed[0] != '{' {
+        return jsonpatch.InvalidPatchError{};
+    }
+
+    if err := validation.Decode(patched, target); err != nil {
+        return err;
+    }
+
+    return validation.Struct(target);
+}
+
+// loads the row with primary key id and locks it until the transaction of
+// the service ends, for reads a write of the same transaction depends on.
+// gorm.ErrRecordNotFound when scopes leave it out
+func (self *AppService) lockRow(row any, id uint, scopes ...Scope) error {
+    query := self.db;
+    for _, scope := range scopes {
+        query = query.Scopes(scope);
+    }
+
+    return query.Clauses(clause.Locking{ Strength: "UPDATE" }).First(row, id).Error;
+}
@@ -0,0 +1,394 @@
+package appservice
+
+import (
+	"log/slog"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+
+	"github.com/cxcnxl/go-crud/internal/dto"
+	"github.com/cxcnxl/go-crud/internal/models"
+)
+
+func (self *AppService) GetPostById(id uint) (models.Post, error) {
+    post := models.Post{
+        ID: id,
+    };
+
+    result := self.db.
+        Scopes(self.postReads).
+        Limit(1).
+        Where(&post).
+        First(&post);
+    if result.Error != nil {
+        return post, result.Error;
+    }
+
+    return post, nil;
+}
+
+// loads post if the viewer is allowed to see it, see postAudience.CanView.
+// For viewers who are not, the post does not exist.
+// viewerId of 0 means anonymous viewer
+func (self *AppService) GetVisiblePost(id uint, viewerId uint) (models.Post, error) {
+    post, err := self.GetPostById(id);
+    if err != nil {
+        return post, err;
+    }
+
+    visible, err := self.CanViewPost(post, viewerId);
+    if err != nil {
+        return models.Post{}, err;
+    }
+    if !visible {
+        return models.Post{}, gorm.ErrRecordNotFound;
+    }
+
+    return post, nil;
+}
+
+// loads post together with its reaction counters and reactions of the
+// viewer. viewerId of 0 means anonymous viewer
+func (self *AppService) GetPostView(id uint, viewerId uint) (dto.PostViewDto, error) {
+    post, err := self.GetVisiblePost(id, viewerId);
+    if err != nil {
+        return dto.PostViewDto{}, err;
+    }
+
+    // members the reads leave out are not worked out, the projection of
+    // the response drops them
+    var html string;
+    if self.reads.Wants("body_html") {
+        html, err = self.postHtml(post);
+        if err != nil {
+            return dto.PostViewDto{}, err;
+        }
+    }
+
+    var attachments []dto.AttachmentDto;
+    if self.reads.Wants("attachments") {
+        attachments, err = self.GetAttachmentViews(post);
+        if err != nil {
+            return dto.PostViewDto{}, err;
+        }
+    }
+
+    var counts map[string]int;
+    if self.reads.Wants("reactions") {
+        counts, err = self.GetReactionCounts(post.ID);
+        if err != nil {
+            return dto.PostViewDto{}, err;
+        }
+    }
+
+    mine := []string{};
+    if viewerId != 0 && self.reads.Wants("my_reactions") {
+        mine, err = self.GetUserReactions(post.ID, viewerId);
+        if err != nil {
+            return dto.PostViewDto{}, err;
+        }
+    }
+
+    return dto.PostViewDto{
+        Post: post,
+        Body: &post.Body,
+        BodyHtml: &html,
+        Attachments: attachments,
+        Reactions: counts,
+        MyReactions: mine,
+    }, nil;
+}
+
+// creates published post, draft or post scheduled for data.PublishAt
+func (self *AppService) CreatePost(authorId uint, data dto.CreatePostDto) (models.Post, error) {
+    body := strings.TrimSpace(data.Body);
+    if body == "" || utf8.RuneCountInString(body) > maxPostLength {
+        return models.Post{}, InvalidPostBodyError{};
+    }
+
+    status := models.PostStatus(data.Status);
+    if status == "" {
+        status = models.PostStatusPublished;
+        if data.PublishAt != nil {
+            status = models.PostStatusScheduled;
+        }
+    }
+
+    format, err := parseBodyFormat(data.BodyFormat, models.BodyFormatPlain);
+    if err != nil {
+        return models.Post{}, err;
+    }
+
+    visibility, err := parseVisibility(data.Visibility, models.VisibilityPublic);
+    if err != nil {
+        return models.Post{}, err;
+    }
+
+    html, err := renderBody(body, format);
+    if err != nil {
+        return models.Post{}, err;
+    }
+
+    post := models.Post{
+        Body: body,
+        BodyFormat: format,
+        BodyHtml: html,
+        Status: status,
+        Visibility: visibility,
+        AuthorID: authorId,
+    };
+
+    now := time.Now();
+    switch status {
+    case models.PostStatusPublished:
+        post.PublishedAt = &now;
+    case models.PostStatusScheduled:
+        if data.PublishAt == nil || !data.PublishAt.After(now) {
+            return models.Post{}, InvalidPublishAtError{};
+        }
+        post.PublishAt = data.PublishAt;
+    case models.PostStatusDraft:
+    default:
+        return models.Post{}, InvalidPostStatusError{};
+    }
+
+    author, err := self.GetUserById(authorId);
+    if err != nil {
+        return models.Post{}, err;
+    }
+
+    filter, err := self.holdIfFiltered(&post, post.Body);
+    if err != nil {
+        return models.Post{}, err;
+    }
+
+    // tags and mentions are stored with the post, or neither is
+    err = self.Transaction(func(service *AppService) error {
+        if err := service.db.Create(&post).Error; err != nil {
+            return err;
+        }
+
+        return service.syncPostEntities(post);
+    });
+    if err != nil {
+        return post, err;
+    }
+    post.Author = author;
+
+    if filter != nil {
+        self.reportHeldPost(post, filter);
+    }
+
+    self.afterPublish(post);
+
+    return post, nil;
+}
+
+// publishes draft or scheduled post now, or reschedules it when publishAt
+// is in the future. Posts matching a filter are held for review instead
+func (self *AppService) PublishPost(
+    postId uint,
+    userId uint,
+    publishAt *time.Time,
+) (models.Post, error) {
+    post, err := self.GetPostById(postId);
+    if err != nil {
+        return post, err;
+    }
+    if post.AuthorID != userId {
+        return post, NotPostAuthorError{};
+    }
+    if post.Status == models.PostStatusPublished {
+        return post, PostAlreadyPublishedError{};
+    }
+    if post.Status == models.PostStatusHeld || post.Status == models.PostStatusHidden {
+        return post, PostUnderReviewError{};
+    }
+
+    if filter, err := self.matchFilters(post.Body); err != nil {
+        return post, err;
+    } else if filter != nil {
+        result := self.db.
+            Model(&post).
+            Where("status = ?", post.Status).
+            Update("status", models.PostStatusHeld);
+        if result.Error != nil {
+            return post, result.Error;
+        }
+        if result.RowsAffected == 0 {
+            return post, PostAlreadyPublishedError{};
+        }
+        self.reportHeldPost(post, filter);
+
+        return self.GetPostById(post.ID);
+    }
+
+    if publishAt != nil && publishAt.After(time.Now()) {
+        result := self.db.
+            Model(&post).
+            Where("status <> ?", models.PostStatusPublished).
+            Updates(map[string]any{
+                "status": models.PostStatusScheduled,
+                "publish_at": publishAt,
+            });
+        if result.Error != nil {
+            return post, result.Error;
+        }
+        if result.RowsAffected == 0 {
+            return post, PostAlreadyPublishedError{};
+        }
+
+        return self.GetPostById(post.ID);
+    }
+
+    claimed, err := self.claimPublication(post.ID, post.Status);
+    if err != nil {
+        return post, err;
+    }
+    if !claimed {
+        return post, PostAlreadyPublishedError{};
+    }
+
+    post, err = self.GetPostById(post.ID);
+    if err != nil {
+        return post, err;
+    }
+
+    self.afterPublish(post);
+
+    return post, nil;
+}
+
+// publishes scheduled posts whose time has come. Several instances may run
+// it at once, every post is claimed by exactly one of them
+func (self *AppService) PublishDuePosts() error {
+    for {
+        var ids []uint;
+        result := self.db.
+            Model(&models.Post{}).
+            Where(models.Post{Status: models.PostStatusScheduled}).
+            Where("publish_at <= ?", time.Now()).
+            Order("publish_at").
+            Limit(publishBatchSize).
+            Pluck("id", &ids);
+        if result.Error != nil {
+            return result.Error;
+        }
+        if len(ids) == 0 {
+            return nil;
+        }
+
+        for _, id := range ids {
+            claimed, err := self.claimPublication(id, models.PostStatusScheduled);
+            if err != nil {
+                return err;
+            }
+            if !claimed {
+                // another instance got it first
+                continue;
+            }
+
+            post, err := self.GetPostById(id);
+            if err != nil {
+                return err;
+            }
+
+            self.afterPublish(post);
+        }
+    }
+}
+
+// lists drafts and scheduled posts of the user
+func (self *AppService) GetDrafts(
+    userId uint,
+    before uint,
+    limit int,
+) (dto.PageDto[models.Post], error) {
+    page := dto.PageDto[models.Post]{ Items: []models.Post{} };
+
+    query := self.db.
+        Scopes(self.postReads).
+        Where("author_id = ?", userId).
+        Where("status <> ?", models.PostStatusPublished);
+    if before != 0 {
+        query = query.Where("id < ?", before);
+    }
+
+    var posts []models.Post;
+    result := query.Order("id DESC").Limit(limit + 1).Find(&posts);
+    if result.Error != nil {
+        return page, result.Error;
+    }
+
+    if len(posts) > limit {
+        posts = posts[:limit];
+        page.NextCursor = posts[limit - 1].ID;
+    }
+    page.Items = posts;
+
+    return page, nil;
+}
+
+// atomically moves post from status to published. Only the caller whose
+// update went through gets true, so concurrent publishers never publish
+// the same post twice
+func (self *AppService) claimPublication(postId uint, from models.PostStatus) (bool, error) {
+    result := self.db.
+        Model(&models.Post{}).
+        Where("id = ? AND status = ?", postId, from).
+        Updates(map[string]any{
+            "status": models.PostStatusPublished,
+            "published_at": time.Now(),
+        });
+    if result.Error != nil {
+        return false, result.Error;
+    }
+
+    return result.RowsAffected == 1, nil;
+}
+
+// distributes post that has just become published
+func (self *AppService) afterPublish(post models.Post) {
+    if post.Status != models.PostStatusPublished {
+        return;
+    }
+
+    err := self.fanOutPost(post);
+    if err != nil {
+        // timelines are rebuilt from the database, the post is not lost
+        slog.Error("Error fanning out post: " + err.Error());
+    }
+
+    self.notifyPendingMentions(post);
+}
+
+const maxPostLength int = 5000;
+const publishBatchSize int = 100;
+
+// returns user with their latest published posts for syndication feeds
+func (self *AppService) GetUserFeed(username string) (models.User, []models.Post, error) {
+    user, err := self.GetUserByUsername(username);
+    if err != nil {
+        return user, nil, err;
+    }
+
+    posts := []models.Post{};
+    result := self.db.
+        Where("author_id = ?", user.ID).
+        Where(models.Post{
+            Status: models.PostStatusPublished,
+            Visibility: models.VisibilityPublic,
+        }).
+        Order("published_at DESC").
+        Limit(feedSize).
+        Find(&posts);
+    if result.Error != nil {
+        return user, nil, result.Error;
+    }
+
+    return user, posts, nil;
+}
+
+const feedSize int = 50;
@@ -0,0 +1,154 @@
+package appservice
+
+import (
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/language"
+	"gorm.io/gorm"
+
+	"github.com/cxcnxl/go-crud/internal/dto"
+	"github.com/cxcnxl/go-crud/internal/models"
+)
+
+const maxDisplayNameLength int = 64;
+const maxBioLength int = 500;
+const maxProfileUrlLength int = 255;
+
+// returns the user as its owner sees it
+func (self *AppService) GetMe(userId uint) (dto.UserViewDto, error) {
+    user, err := self.GetUserById(userId);
+    if err != nil {
+        return dto.UserViewDto{}, err;
+    }
+
+    return userView(user, userId), nil;
+}
+
+// returns public profile of the user. Email is shown only when the owner
+// allows it, users blocked either way do not see each other at all.
+// viewerId of 0 means anonymous viewer
+func (self *AppService) GetProfile(username string, viewerId uint) (dto.UserViewDto, error) {
+    user, err := self.GetUserByUsername(username);
+    if err != nil {
+        return dto.UserViewDto{}, err;
+    }
+
+    blocked, err := self.isBlocked(viewerId, user.ID);
+    if err != nil {
+        return dto.UserViewDto{}, err;
+    }
+    if blocked {
+        return dto.UserViewDto{}, gorm.ErrRecordNotFound;
+    }
+
+    return userView(user, viewerId), nil;
+}
+
+// applies a merge or JSON patch of contentType to the profile of the user,
+// see applyPatch. The profile is read and written in one transaction, so
+// it can not change between test operations and the update
+func (self *AppService) UpdateProfile(userId uint, contentType string, patch []byte) (dto.UserViewDto, error) {
+    err := self.Transaction(func(service *AppService) error {
+        var user models.User;
+        if err := service.lockRow(&user, userId); err != nil {
+            return err;
+        }
+
+        var profile dto.ProfileDto;
+        if err := applyPatch(profileOf(user), contentType, patch, &profile); err != nil {
+            return err;
+        }
+
+        profile, err := normalizeProfile(profile);
+        if err != nil {
+            return err;
+        }
+
+        return service.db.Model(&user).Updates(map[string]any{
+            "display_name": profile.DisplayName,
+            "bio": profile.Bio,
+            "avatar_url": profile.AvatarUrl,
+            "website": profile.Website,
+            "locale": profile.Locale,
+            "show_email": profile.ShowEmail,
+        }).Error;
+    });
+    if err != nil {
+        return dto.UserViewDto{}, err;
+    }
+
+    return self.GetMe(userId);
+}
+
+func profileOf(user models.User) dto.ProfileDto {
+    return dto.ProfileDto{
+        DisplayName: user.DisplayName,
+        Bio: user.Bio,
+        AvatarUrl: user.AvatarUrl,
+        Website: user.Website,
+        Locale: user.Locale,
+        ShowEmail: user.ShowEmail,
+    };
+}
+
+// trims and validates profile fields, locales are canonicalized
+func normalizeProfile(profile dto.ProfileDto) (dto.ProfileDto, error) {
+    profile.DisplayName = strings.TrimSpace(profile.DisplayName);
+    if utf8.RuneCountInString(profile.DisplayName) > maxDisplayNameLength {
+        return profile, InvalidProfileError{ Field: "display_name" };
+    }
+
+    profile.Bio = strings.TrimSpace(profile.Bio);
+    if utf8.RuneCountInString(profile.Bio) > maxBioLength {
+        return profile, InvalidProfileError{ Field: "bio" };
+    }
+
+    profile.AvatarUrl = strings.TrimSpace(profile.AvatarUrl);
+    if !isProfileUrl(profile.AvatarUrl) {
+        return profile, InvalidProfileError{ Field: "avatar_url" };
+    }
+
+    profile.Website = strings.TrimSpace(profile.Website);
+    if !isProfileUrl(profile.Website) {
+        return profile, InvalidProfileError{ Field: "website" };
+    }
+
+    profile.Locale = strings.TrimSpace(profile.Locale);
+    if profile.Locale != "" {
+        tag, err := language.Parse(profile.Locale);
+        if err != nil {
+            return profile, InvalidProfileError{ Field: "locale" };
+        }
+        profile.Locale = tag.String();
+    }
+
+    return profile, nil;
+}
+
+// accepts empty values and absolute http(s) urls
+func isProfileUrl(value string) bool {
+    if value == "" {
+        return true;
+    }
+    if len(value) > maxProfileUrlLength {
+        return false;
+    }
+
+    parsed, err := url.Parse(value);
+    if err != nil {
+        return false;
+    }
+
+    return (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != "";
+}
+
+func userView(user models.User, viewerId uint) dto.UserViewDto {
+    view := dto.UserViewDto{ User: user };
+    if user.ShowEmail || (viewerId != 0 && user.ID == viewerId) {
+        view.Email = user.Email;
+    }
+
+    return view;
+}
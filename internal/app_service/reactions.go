@@ -0,0 +1,264 @@
+package appservice
+
+import (
+	"log/slog"
+	"slices"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/cxcnxl/go-crud/internal/models"
+)
+
+var ReactionKinds = []string{
+    "like",
+    "love",
+    "laugh",
+    "wow",
+    "sad",
+    "angry",
+};
+
+func (self *AppService) AddReaction(postId uint, userId uint, emoji string) error {
+    if !slices.Contains(ReactionKinds, emoji) {
+        return InvalidReactionError{};
+    }
+
+    post, err := self.GetVisiblePost(postId, userId);
+    if err != nil {
+        return err;
+    }
+
+    reaction := models.Reaction{
+        PostID: postId,
+        UserID: userId,
+        Emoji: emoji,
+    };
+
+    result := self.db.
+        Clauses(clause.OnConflict{DoNothing: true}).
+        Create(&reaction);
+    if result.Error != nil {
+        return result.Error;
+    }
+
+    // already reacted, PUT is idempotent
+    if result.RowsAffected == 0 {
+        return nil;
+    }
+
+    err = self.bumpReactionCount(postId, emoji, 1);
+    if err != nil {
+        return err;
+    }
+
+    self.notify(post.AuthorID, models.NotificationReaction, userId, &post.ID);
+    self.publishReactionEvent(post);
+
+    return nil;
+}
+
+func (self *AppService) RemoveReaction(postId uint, userId uint, emoji string) error {
+    if !slices.Contains(ReactionKinds, emoji) {
+        return InvalidReactionError{};
+    }
+
+    result := self.db.
+        Where(models.Reaction{PostID: postId, UserID: userId, Emoji: emoji}).
+        Delete(&models.Reaction{});
+    if result.Error != nil {
+        return result.Error;
+    }
+
+    if result.RowsAffected == 0 {
+        return nil;
+    }
+
+    err := self.bumpReactionCount(postId, emoji, -1);
+    if err != nil {
+        return err;
+    }
+
+    post, err := self.GetPostById(postId);
+    if err != nil {
+        return err;
+    }
+    self.publishReactionEvent(post);
+
+    return nil;
+}
+
+// returns reaction counters of the post, served from redis and loaded
+// from reactions table on cache miss
+func (self *AppService) GetReactionCounts(postId uint) (map[string]int, error) {
+    if !self.inTransaction() {
+        counts, ok, err := self.redis.GetReactionCounts(postId);
+        if err != nil {
+            return nil, err;
+        }
+        if ok {
+            return counts, nil;
+        }
+    }
+
+    counts, err := self.countReactions(postId);
+    if err != nil {
+        return nil, err;
+    }
+    if self.inTransaction() {
+        return counts, nil;
+    }
+
+    err = self.redis.SetReactionCounts(postId, counts);
+    if err != nil {
+        return nil, err;
+    }
+
+    return counts, nil;
+}
+
+func (self *AppService) GetUserReactions(postId uint, userId uint) ([]string, error) {
+    emojis := []string{};
+
+    result := self.db.
+        Model(&models.Reaction{}).
+        Where(models.Reaction{PostID: postId, UserID: userId}).
+        Order("emoji").
+        Pluck("emoji", &emojis);
+    if result.Error != nil {
+        return nil, result.Error;
+    }
+
+    return emojis, nil;
+}
+
+// writes counters of posts changed since the last run back to mysql.
+// Meant to be called periodically
+func (self *AppService) ReconcileReactionCounts() error {
+    for {
+        postIds, err := self.redis.PopDirtyReactionPosts(reconcileBatchSize);
+        if err != nil {
+            return err;
+        }
+        if len(postIds) == 0 {
+            return nil;
+        }
+
+        for _, postId := range postIds {
+            err := self.reconcilePostReactions(postId);
+            if err != nil {
+                // put it back so the next run retries
+                self.redis.MarkReactionsDirty(postId);
+                return err;
+            }
+        }
+    }
+}
+
+func (self *AppService) reconcilePostReactions(postId uint) error {
+    counts, ok, err := self.redis.GetReactionCounts(postId);
+    if err != nil {
+        return err;
+    }
+    if !ok {
+        // counters expired before reconciliation, recount from source
+        counts, err = self.countReactions(postId);
+        if err != nil {
+            return err;
+        }
+    }
+
+    return self.db.Transaction(func(tx *gorm.DB) error {
+        err := tx.
+            Where(models.ReactionCount{PostID: postId}).
+            Delete(&models.ReactionCount{}).
+            Error;
+        if err != nil {
+            return err;
+        }
+
+        rows := []models.ReactionCount{};
+        for emoji, count := range counts {
+            rows = append(rows, models.ReactionCount{
+                PostID: postId,
+                Emoji: emoji,
+                Count: count,
+            });
+        }
+        if len(rows) == 0 {
+            return nil;
+        }
+
+        return tx.Create(&rows).Error;
+    });
+}
+
+// tells post author about changed reaction counters, once they are
+// committed
+func (self *AppService) publishReactionEvent(post models.Post) {
+    self.afterCommit(func(service *AppService) error {
+        counts, err := service.GetReactionCounts(post.ID);
+        if err != nil {
+            slog.Error("Error loading reaction counts: " + err.Error());
+            return nil;
+        }
+
+        service.publishEvent([]uint{ post.AuthorID }, EventReaction, map[string]any{
+            "post_id": post.ID,
+            "reactions": counts,
+        });
+        return nil;
+    });
+}
+
+func (self *AppService) bumpReactionCount(postId uint, emoji string, delta int) error {
+    return self.afterCommit(func(service *AppService) error {
+        cached, err := service.redis.IncrReactionCount(postId, emoji, delta);
+        if err != nil {
+            return err;
+        }
+        if cached {
+            return nil;
+        }
+
+        // counters are not in redis yet. Recount from the database, which
+        // already contains this change
+        counts, err := service.countReactions(postId);
+        if err != nil {
+            return err;
+        }
+
+        err = service.redis.SetReactionCounts(postId, counts);
+        if err != nil {
+            return err;
+        }
+
+        return service.redis.MarkReactionsDirty(postId);
+    });
+}
+
+func (self *AppService) countReactions(postId uint) (map[string]int, error) {
+    var rows []struct {
+        Emoji string
+        Count int
+    };
+
+    result := self.db.
+        Model(&models.Reaction{}).
+        Select("emoji, count(*) as count").
+        Where(models.Reaction{PostID: postId}).
+        Group("emoji").
+        Scan(&rows);
+    if result.Error != nil {
+        return nil, result.Error;
+    }
+
+    counts := make(map[string]int, len(rows));
+    for _, row := range rows {
+        counts[row.Emoji] = row.Count;
+    }
+
+    return counts, nil;
+}
+
+const reconcileBatchSize int = 100;
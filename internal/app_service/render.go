@@ -0,0 +1,53 @@
+package appservice
+
+import (
+	"log/slog"
+
+	"github.com/cxcnxl/go-crud/internal/markdown"
+	"github.com/cxcnxl/go-crud/internal/models"
+)
+
+// validates format name, empty one falls back to fallback
+func parseBodyFormat(format string, fallback models.BodyFormat) (models.BodyFormat, error) {
+    switch models.BodyFormat(format) {
+    case "":
+        return fallback, nil;
+    case models.BodyFormatPlain, models.BodyFormatMarkdown:
+        return models.BodyFormat(format), nil;
+    default:
+        return "", InvalidBodyFormatError{};
+    }
+}
+
+// renders post body into sanitized html according to its format
+func renderBody(body string, format models.BodyFormat) (string, error) {
+    if format == models.BodyFormatMarkdown {
+        return markdown.Render(body);
+    }
+
+    return markdown.RenderPlain(body), nil;
+}
+
+// returns cached html of the post, rendering and storing it for posts
+// written before html was cached
+func (self *AppService) postHtml(post models.Post) (string, error) {
+    if post.BodyHtml != "" {
+        return post.BodyHtml, nil;
+    }
+
+    rendered, err := renderBody(post.Body, post.BodyFormat);
+    if err != nil {
+        return "", err;
+    }
+
+    result := self.db.
+        Model(&models.Post{}).
+        Where("id = ?", post.ID).
+        UpdateColumn("body_html", rendered);
+    if result.Error != nil {
+        // serve it anyway, next read retries
+        slog.Error("Error caching post html: " + result.Error.Error());
+    }
+
+    return rendered, nil;
+}
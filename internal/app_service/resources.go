@@ -0,0 +1,146 @@
+package appservice
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/cxcnxl/go-crud/internal/dto"
+)
+
+// narrows resource queries, e.g. to rows the caller owns
+type Scope func(query *gorm.DB) *gorm.DB;
+
+// runs in the transaction of a resource write, right after the write, so
+// side effects such as audit rows commit or roll back with it
+type WriteHook[T any] func(tx *gorm.DB, item *T) error;
+
+// generic persistence of models exposed through routes.Resource. Items
+// are paginated by their primary key, newest first, so T needs an ID field
+type Store[T any] struct {
+    service *AppService
+}
+
+func NewStore[T any](service *AppService) Store[T] {
+    return Store[T]{ service };
+}
+
+func (self Store[T]) List(scope Scope, before uint, limit int) (dto.PageDto[T], error) {
+    page := dto.PageDto[T]{ Items: []T{} };
+
+    primaryKey := clause.Column{ Table: clause.CurrentTable, Name: clause.PrimaryKey };
+    query := scoped(self.service.db, scope);
+    if before != 0 {
+        query = query.Where(clause.Lt{ Column: primaryKey, Value: before });
+    }
+
+    var items []T;
+    result := query.
+        Order(clause.OrderByColumn{ Column: primaryKey, Desc: true }).
+        Limit(limit + 1).
+        Find(&items);
+    if result.Error != nil {
+        return page, result.Error;
+    }
+
+    if len(items) > limit {
+        items = items[:limit];
+        page.NextCursor = idOf(items[limit - 1]);
+    }
+    page.Items = items;
+
+    return page, nil;
+}
+
+// loads item by id, gorm.ErrRecordNotFound when scope leaves it out
+func (self Store[T]) Get(scope Scope, id uint) (T, error) {
+    var item T;
+    result := scoped(self.service.db, scope).First(&item, id);
+
+    return item, result.Error;
+}
+
+func (self Store[T]) Create(item *T, after WriteHook[T]) error {
+    return self.service.db.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Omit(clause.Associations).Create(item).Error; err != nil {
+            return err;
+        }
+
+        return runHook(tx, after, item);
+    });
+}
+
+// loads the item by id, locked until the write commits, lets change modify
+// it and saves every field. gorm.ErrRecordNotFound when scope leaves it out
+func (self Store[T]) Update(
+    scope Scope,
+    id uint,
+    change func(item *T) error,
+    after WriteHook[T],
+) (T, error) {
+    var item T;
+    err := self.service.Transaction(func(service *AppService) error {
+        if err := service.lockRow(&item, id, scope); err != nil {
+            return err;
+        }
+        if err := change(&item); err != nil {
+            return err;
+        }
+
+        if err := service.db.Omit(clause.Associations).Save(&item).Error; err != nil {
+            return err;
+        }
+
+        return runHook(service.db, after, &item);
+    });
+
+    return item, err;
+}
+
+// sets the field of column, e.g. "owner_id", on item to value
+func (self Store[T]) SetColumn(item *T, column string, value any) error {
+    statement := &gorm.Statement{ DB: self.service.db };
+    if err := statement.Parse(item); err != nil {
+        return err;
+    }
+
+    field := statement.Schema.LookUpField(column);
+    if field == nil {
+        return fmt.Errorf("%s has no column %s", statement.Schema.Name, column);
+    }
+
+    return field.Set(context.Background(), reflect.ValueOf(item).Elem(), value);
+}
+
+func (self Store[T]) Delete(item *T, after WriteHook[T]) error {
+    return self.service.db.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Delete(item).Error; err != nil {
+            return err;
+        }
+
+        return runHook(tx, after, item);
+    });
+}
+
+func scoped(db *gorm.DB, scope Scope) *gorm.DB {
+    if scope == nil {
+        return db;
+    }
+
+    return db.Scopes(scope);
+}
+
+func runHook[T any](tx *gorm.DB, hook WriteHook[T], item *T) error {
+    if hook == nil {
+        return nil;
+    }
+
+    return hook(tx, item);
+}
+
+func idOf[T any](item T) uint {
+    return uint(reflect.ValueOf(item).FieldByName("ID").Uint());
+}
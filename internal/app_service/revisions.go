@@ -0,0 +1,358 @@
+package appservice
+
+import (
+	"log/slog"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+
+	"github.com/cxcnxl/go-crud/internal/diff"
+	"github.com/cxcnxl/go-crud/internal/dto"
+	"github.com/cxcnxl/go-crud/internal/models"
+)
+
+// applies a merge or JSON patch of contentType to the editable part of the
+// post, see applyPatch, and saves it like UpdatePost. Reading, patching and
+// saving run in one transaction, so the post can not change between test
+// operations and the update
+func (self *AppService) PatchPost(
+    postId uint,
+    userId uint,
+    contentType string,
+    patch []byte,
+) (models.Post, error) {
+    var updated models.Post;
+    err := self.Transaction(func(service *AppService) error {
+        var post models.Post;
+        if err := service.lockRow(&post, postId); err != nil {
+            return err;
+        }
+        if post.AuthorID != userId {
+            return NotPostAuthorError{};
+        }
+
+        var data dto.UpdatePostDto;
+        err := applyPatch(dto.UpdatePostDto{
+            Body: post.Body,
+            BodyFormat: string(post.BodyFormat),
+            Visibility: string(post.Visibility),
+        }, contentType, patch, &data);
+        if err != nil {
+            return err;
+        }
+
+        updated, err = service.UpdatePost(postId, userId, data);
+        return err;
+    });
+
+    return updated, err;
+}
+
+// changes body of the post keeping the previous one as a revision
+func (self *AppService) UpdatePost(
+    postId uint,
+    userId uint,
+    data dto.UpdatePostDto,
+) (models.Post, error) {
+    body := strings.TrimSpace(data.Body);
+    if body == "" || utf8.RuneCountInString(body) > maxPostLength {
+        return models.Post{}, InvalidPostBodyError{};
+    }
+
+    post, err := self.GetPostById(postId);
+    if err != nil {
+        return post, err;
+    }
+    if post.AuthorID != userId {
+        return post, NotPostAuthorError{};
+    }
+
+    format, err := parseBodyFormat(data.BodyFormat, post.BodyFormat);
+    if err != nil {
+        return post, err;
+    }
+
+    visibility, err := parseVisibility(data.Visibility, post.Visibility);
+    if err != nil {
+        return post, err;
+    }
+
+    if post.Body == body && post.BodyFormat == format {
+        if post.Visibility != visibility {
+            // audience change alone is not a new revision
+            post.Visibility = visibility;
+            result := self.db.Model(&post).Update("visibility", visibility);
+            if result.Error != nil {
+                return post, result.Error;
+            }
+
+            // mentioned users may have just joined the audience
+            self.notifyPendingMentions(post);
+            return post, nil;
+        }
+
+        return post, nil;
+    }
+
+    html, err := renderBody(body, format);
+    if err != nil {
+        return post, err;
+    }
+
+    filter, err := self.holdIfFiltered(&post, body);
+    if err != nil {
+        return post, err;
+    }
+
+    err = self.Transaction(func(service *AppService) error {
+        revision := models.PostRevision{
+            PostID: post.ID,
+            Body: post.Body,
+            BodyFormat: post.BodyFormat,
+        };
+        if err := service.db.Create(&revision).Error; err != nil {
+            return err;
+        }
+
+        post.Body = body;
+        post.BodyFormat = format;
+        post.BodyHtml = html;
+        post.Visibility = visibility;
+        if err := service.db.Omit("Author").Save(&post).Error; err != nil {
+            return err;
+        }
+
+        return service.syncPostEntities(post);
+    });
+    if err != nil {
+        return post, err;
+    }
+
+    if filter != nil {
+        self.reportHeldPost(post, filter);
+    }
+    self.notifyPendingMentions(post);
+
+    return post, nil;
+}
+
+// lists previous bodies of the post, newest first
+func (self *AppService) GetPostRevisions(
+    postId uint,
+    viewerId uint,
+) ([]models.PostRevision, error) {
+    if _, err := self.GetVisiblePost(postId, viewerId); err != nil {
+        return nil, err;
+    }
+
+    revisions := []models.PostRevision{};
+    result := self.db.
+        Where(models.PostRevision{PostID: postId}).
+        Order("id DESC").
+        Find(&revisions);
+
+    return revisions, result.Error;
+}
+
+// diffs two revisions of the post. Revision id 0 stands for the current
+// body of the post
+func (self *AppService) DiffPostRevisions(
+    postId uint,
+    viewerId uint,
+    fromId uint,
+    toId uint,
+) ([]diff.Op, error) {
+    post, err := self.GetVisiblePost(postId, viewerId);
+    if err != nil {
+        return nil, err;
+    }
+
+    from, err := self.getRevisionBody(post, fromId);
+    if err != nil {
+        return nil, err;
+    }
+
+    to, err := self.getRevisionBody(post, toId);
+    if err != nil {
+        return nil, err;
+    }
+
+    return diff.Words(from, to), nil;
+}
+
+func (self *AppService) getRevisionBody(post models.Post, revisionId uint) (string, error) {
+    if revisionId == 0 {
+        return post.Body, nil;
+    }
+
+    revision := models.PostRevision{
+        ID: revisionId,
+        PostID: post.ID,
+    };
+
+    result := self.db.Where(&revision).First(&revision);
+    if result.Error != nil {
+        return "", result.Error;
+    }
+
+    return revision.Body, nil;
+}
+
+// moves post into the trash of its author
+func (self *AppService) DeletePost(postId uint, userId uint) error {
+    post, err := self.GetPostById(postId);
+    if err != nil {
+        return err;
+    }
+    if post.AuthorID != userId {
+        return NotPostAuthorError{};
+    }
+
+    return self.db.Delete(&post).Error;
+}
+
+// takes post of the user out of the trash. Posts past the retention are
+// gone, even when the purge has not removed them yet
+func (self *AppService) RestorePost(postId uint, userId uint) (models.Post, error) {
+    var post models.Post;
+
+    result := self.db.
+        Unscoped().
+        Where("id = ?", postId).
+        Where("deleted_at >= ?", self.retentionCutoff()).
+        First(&post);
+    if result.Error != nil {
+        return post, result.Error;
+    }
+    if post.AuthorID != userId {
+        return post, NotPostAuthorError{};
+    }
+
+    result = self.db.
+        Unscoped().
+        Model(&post).
+        Update("deleted_at", nil);
+    if result.Error != nil {
+        return post, result.Error;
+    }
+
+    return self.GetPostById(post.ID);
+}
+
+// lists soft deleted posts of the user still inside the retention, most
+// recently deleted first
+func (self *AppService) GetTrash(
+    userId uint,
+    before uint,
+    limit int,
+) (dto.PageDto[models.Post], error) {
+    page := dto.PageDto[models.Post]{ Items: []models.Post{} };
+
+    query := self.db.
+        Unscoped().
+        Scopes(self.postReads).
+        Where("author_id = ?", userId).
+        Where("deleted_at >= ?", self.retentionCutoff());
+    if before != 0 {
+        query = query.Where("id < ?", before);
+    }
+
+    var posts []models.Post;
+    result := query.Order("id DESC").Limit(limit + 1).Find(&posts);
+    if result.Error != nil {
+        return page, result.Error;
+    }
+
+    if len(posts) > limit {
+        posts = posts[:limit];
+        page.NextCursor = posts[limit - 1].ID;
+    }
+    page.Items = posts;
+
+    return page, nil;
+}
+
+// permanently deletes posts that stayed in the trash longer than the
+// retention, together with their revisions, reactions, attachments, tags,
+// mentions and notifications
+func (self *AppService) PurgeDeletedPosts() error {
+    cutoff := self.retentionCutoff();
+
+    for {
+        var ids []uint;
+        result := self.db.
+            Unscoped().
+            Model(&models.Post{}).
+            Where("deleted_at < ?", cutoff).
+            Limit(purgeBatchSize).
+            Pluck("id", &ids);
+        if result.Error != nil {
+            return result.Error;
+        }
+        if len(ids) == 0 {
+            return nil;
+        }
+
+        var attachments []models.Attachment;
+        result = self.db.Where("post_id IN ?", ids).Find(&attachments);
+        if result.Error != nil {
+            return result.Error;
+        }
+
+        // unread notifications about the posts leave the cached counters
+        var unread []struct {
+            UserID uint
+            Count  int
+        };
+        err := self.db.Transaction(func(tx *gorm.DB) error {
+            result := tx.
+                Model(&models.Notification{}).
+                Select("user_id, COUNT(*) AS count").
+                Where("post_id IN ? AND read_at IS NULL", ids).
+                Group("user_id").
+                Scan(&unread);
+            if result.Error != nil {
+                return result.Error;
+            }
+
+            for _, model := range []any{
+                &models.Attachment{},
+                &models.PostRevision{},
+                &models.Reaction{},
+                &models.ReactionCount{},
+                &models.PostTag{},
+                &models.PostMention{},
+                &models.Notification{},
+            } {
+                err := tx.Where("post_id IN ?", ids).Delete(model).Error;
+                if err != nil {
+                    return err;
+                }
+            }
+
+            return tx.Unscoped().Where("id IN ?", ids).Delete(&models.Post{}).Error;
+        });
+        if err != nil {
+            return err;
+        }
+
+        for _, row := range unread {
+            err := self.redis.IncrUnreadNotifications(row.UserID, -row.Count);
+            if err != nil {
+                slog.Error("Error updating unread notifications: " + err.Error());
+            }
+        }
+        for _, attachment := range attachments {
+            self.deleteAttachmentBlobs(attachment);
+        }
+    }
+}
+
+const purgeBatchSize int = 100;
+
+// posts deleted before it are past the retention
+func (self *AppService) retentionCutoff() time.Time {
+    return time.Now().Add(-self.postRetention);
+}
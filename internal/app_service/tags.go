@@ -0,0 +1,179 @@
+package appservice
+
+import (
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/cxcnxl/go-crud/internal/dto"
+	"github.com/cxcnxl/go-crud/internal/entities"
+	"github.com/cxcnxl/go-crud/internal/models"
+)
+
+// lists published posts tagged with tag, newest first. viewerId of 0 means
+// anonymous viewer
+func (self *AppService) GetTagPosts(
+    tag string,
+    viewerId uint,
+    before uint,
+    limit int,
+) (dto.PageDto[models.Post], error) {
+    page := dto.PageDto[models.Post]{ Items: []models.Post{} };
+
+    hiddenIds, err := self.getHiddenAuthorIds(viewerId);
+    if err != nil {
+        return page, err;
+    }
+
+    query := self.db.
+        Scopes(self.postReads).
+        Joins("JOIN post_tags ON post_tags.post_id = posts.id").
+        Joins("JOIN tags ON tags.id = post_tags.tag_id").
+        Where("tags.name = ?", entities.NormalizeTag(tag)).
+        Where("posts.status = ?", models.PostStatusPublished).
+        Where("posts.visibility = ?", models.VisibilityPublic);
+    query = excludeUsers(query, "posts.author_id", hiddenIds);
+    if before != 0 {
+        query = query.Where("posts.id < ?", before);
+    }
+
+    var posts []models.Post;
+    result := query.Order("posts.id DESC").Limit(limit + 1).Find(&posts);
+    if result.Error != nil {
+        return page, result.Error;
+    }
+
+    if len(posts) > limit {
+        posts = posts[:limit];
+        page.NextCursor = posts[limit - 1].ID;
+    }
+
+    audience, err := self.audienceOf(viewerId);
+    if err != nil {
+        return page, err;
+    }
+    page.Items, err = audience.filter(posts);
+    if err != nil {
+        return page, err;
+    }
+
+    return page, nil;
+}
+
+// stores tags and mentions found in the post body, replacing the ones of
+// its previous version
+func (self *AppService) syncPostEntities(post models.Post) error {
+    parsed := entities.Parse(post.Body);
+
+    var users []models.User;
+    if len(parsed.Mentions) > 0 {
+        result := self.db.
+            Where("username IN ?", parsed.Mentions).
+            Where("id <> ?", post.AuthorID).
+            Find(&users);
+        if result.Error != nil {
+            return result.Error;
+        }
+    }
+
+    return self.db.Transaction(func(tx *gorm.DB) error {
+        tagIds := []uint{};
+        for _, name := range parsed.Tags {
+            // posts created at once may bring the same new tag, the unique
+            // name lets one insert win and the rest read its row
+            tag := models.Tag{ Name: name };
+            err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&tag).Error;
+            if err != nil {
+                return err;
+            }
+            err = tx.Where("name = ?", name).First(&tag).Error;
+            if err != nil {
+                return err;
+            }
+            tagIds = append(tagIds, tag.ID);
+        }
+
+        err := tx.Where("post_id = ?", post.ID).Delete(&models.PostTag{}).Error;
+        if err != nil {
+            return err;
+        }
+        for _, tagId := range tagIds {
+            err := tx.Create(&models.PostTag{ PostID: post.ID, TagID: tagId }).Error;
+            if err != nil {
+                return err;
+            }
+        }
+
+        userIds := []uint{};
+        for _, user := range users {
+            userIds = append(userIds, user.ID);
+        }
+
+        // keep rows of still mentioned users, they remember notification state
+        query := tx.Where("post_id = ?", post.ID);
+        if len(userIds) > 0 {
+            query = query.Where("user_id NOT IN ?", userIds);
+        }
+        err = query.Delete(&models.PostMention{}).Error;
+        if err != nil {
+            return err;
+        }
+        for _, userId := range userIds {
+            err := tx.
+                Clauses(clause.OnConflict{DoNothing: true}).
+                Create(&models.PostMention{ PostID: post.ID, UserID: userId }).
+                Error;
+            if err != nil {
+                return err;
+            }
+        }
+
+        return nil;
+    });
+}
+
+// notifies users mentioned in a published post who were not notified yet
+func (self *AppService) notifyPendingMentions(post models.Post) {
+    if post.Status != models.PostStatusPublished {
+        return;
+    }
+
+    var mentions []models.PostMention;
+    result := self.db.
+        Where("post_id = ? AND notified_at IS NULL", post.ID).
+        Find(&mentions);
+    if result.Error != nil {
+        slog.Error("Error loading post mentions: " + result.Error.Error());
+        return;
+    }
+
+    for _, mention := range mentions {
+        // mentioned users outside the audience stay pending, they are
+        // notified if the post becomes visible to them
+        visible, err := self.CanViewPost(post, mention.UserID);
+        if err != nil {
+            slog.Error("Error checking post audience: " + err.Error());
+            continue;
+        }
+        if !visible {
+            continue;
+        }
+
+        // claim the mention so concurrent edits notify once
+        result := self.db.
+            Model(&models.PostMention{}).
+            Where("post_id = ? AND user_id = ? AND notified_at IS NULL", post.ID, mention.UserID).
+            Update("notified_at", time.Now());
+        if result.Error != nil {
+            slog.Error("Error claiming post mention: " + result.Error.Error());
+            continue;
+        }
+        if result.RowsAffected == 0 {
+            continue;
+        }
+
+        self.notify(mention.UserID, models.NotificationMention, post.AuthorID, &post.ID);
+    }
+}
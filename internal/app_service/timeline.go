@@ -0,0 +1,315 @@
+package appservice
+
+import (
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/cxcnxl/go-crud/internal/dto"
+	"github.com/cxcnxl/go-crud/internal/models"
+	"github.com/cxcnxl/go-crud/internal/redis"
+)
+
+// accounts with at least this many followers are not fanned out on write,
+// their posts are merged into timelines on read instead
+const CelebrityFollowersThreshold int = 10000;
+
+// returns published posts of followed accounts and of the user itself,
+// newest first. Cursor is publication time of the last post and its id
+func (self *AppService) GetTimeline(
+    userId uint,
+    before dto.Keyset,
+    limit int,
+) (dto.KeysetPageDto[models.Post], error) {
+    page := dto.KeysetPageDto[models.Post]{ Items: []models.Post{} };
+
+    cursor := redis.TimelineEntry{ PostID: before.ID, Score: before.At };
+    entries, err := self.getFannedOutTimeline(userId, cursor, limit);
+    if err != nil {
+        return page, err;
+    }
+
+    celebrityIds, err := self.getFollowedCelebrityIds(userId);
+    if err != nil {
+        return page, err;
+    }
+
+    if len(celebrityIds) > 0 {
+        fannedIn, err := self.queryTimelineEntries(
+            self.db.Where("author_id IN ?", celebrityIds),
+            cursor,
+            limit,
+        );
+        if err != nil {
+            return page, err;
+        }
+
+        entries = redis.MergeTimelineEntries(entries, fannedIn);
+    }
+
+    if len(entries) > limit {
+        entries = entries[:limit];
+    }
+    if len(entries) == 0 {
+        return page, nil;
+    }
+
+    ids := make([]uint, 0, len(entries));
+    for _, entry := range entries {
+        ids = append(ids, entry.PostID);
+    }
+
+    hiddenIds, err := self.getHiddenAuthorIds(userId);
+    if err != nil {
+        return page, err;
+    }
+
+    var posts []models.Post;
+    query := self.db.
+        Scopes(self.postReads).
+        Where("id IN ?", ids).
+        Where(models.Post{Status: models.PostStatusPublished});
+    result := excludeUsers(query, "author_id", hiddenIds).Find(&posts);
+    if result.Error != nil {
+        return page, result.Error;
+    }
+
+    audience, err := self.audienceOf(userId);
+    if err != nil {
+        return page, err;
+    }
+    posts, err = audience.filter(posts);
+    if err != nil {
+        return page, err;
+    }
+
+    // keep timeline order, posts deleted since fan-out, posts of hidden
+    // authors and posts the viewer may no longer see are skipped
+    byId := make(map[uint]models.Post, len(posts));
+    for _, post := range posts {
+        byId[post.ID] = post;
+    }
+    for _, id := range ids {
+        if post, ok := byId[id]; ok {
+            page.Items = append(page.Items, post);
+        }
+    }
+
+    if len(entries) == limit {
+        last := entries[len(entries) - 1];
+        page.NextCursor = &dto.Keyset{ At: last.Score, ID: last.PostID };
+    }
+
+    return page, nil;
+}
+
+// reads fan-out part of the timeline from redis, rebuilding it from the
+// database when it is not cached. Cached timelines keep the newest
+// redis.TimelineSize posts only, pages past them come from the database
+func (self *AppService) getFannedOutTimeline(
+    userId uint,
+    before redis.TimelineEntry,
+    limit int,
+) ([]redis.TimelineEntry, error) {
+    if !self.inTransaction() {
+        entries, trimmed, ok, err := self.redis.GetTimeline(userId, before, limit);
+        if err != nil {
+            return nil, err;
+        }
+        if ok && !trimmed {
+            return entries, nil;
+        }
+        if ok {
+            return self.fillTimeline(userId, entries, before, limit);
+        }
+    }
+
+    authorIds, err := self.getFannedOutAuthorIds(userId);
+    if err != nil {
+        return nil, err;
+    }
+
+    all, err := self.queryTimelineEntries(
+        self.db.Where("author_id IN ?", authorIds),
+        redis.TimelineEntry{},
+        redis.TimelineSize,
+    );
+    if err != nil {
+        return nil, err;
+    }
+
+    if !self.inTransaction() {
+        err = self.redis.SetTimeline(userId, all);
+        if err != nil {
+            return nil, err;
+        }
+    }
+
+    entries := []redis.TimelineEntry{};
+    for _, entry := range all {
+        if before != (redis.TimelineEntry{}) && !entry.OlderThan(before) {
+            continue;
+        }
+        if len(entries) == limit {
+            break;
+        }
+        entries = append(entries, entry);
+    }
+    if len(all) < redis.TimelineSize {
+        // nothing older than the window
+        return entries, nil;
+    }
+
+    return self.fillTimeline(userId, entries, before, limit);
+}
+
+// tops up a page cut short by the end of the cached window with older
+// posts from the database
+func (self *AppService) fillTimeline(
+    userId uint,
+    entries []redis.TimelineEntry,
+    before redis.TimelineEntry,
+    limit int,
+) ([]redis.TimelineEntry, error) {
+    if len(entries) >= limit {
+        return entries, nil;
+    }
+    if len(entries) > 0 {
+        before = entries[len(entries) - 1];
+    }
+
+    authorIds, err := self.getFannedOutAuthorIds(userId);
+    if err != nil {
+        return nil, err;
+    }
+
+    older, err := self.queryTimelineEntries(
+        self.db.Where("author_id IN ?", authorIds),
+        before,
+        limit - len(entries),
+    );
+    if err != nil {
+        return nil, err;
+    }
+
+    return append(entries, older...), nil;
+}
+
+// authors whose posts are fanned out to the timeline of the user, the user
+// included and celebrities left out
+func (self *AppService) getFannedOutAuthorIds(userId uint) ([]uint, error) {
+    authorIds, err := self.getFolloweeIds(userId);
+    if err != nil {
+        return nil, err;
+    }
+    authorIds = append(authorIds, userId);
+
+    celebrityIds, err := self.getFollowedCelebrityIds(userId);
+    if err != nil {
+        return nil, err;
+    }
+
+    return slices.DeleteFunc(authorIds, func(id uint) bool {
+        return slices.Contains(celebrityIds, id);
+    }), nil;
+}
+
+// loads published posts matching query as timeline entries older than
+// before, newest first
+func (self *AppService) queryTimelineEntries(
+    query *gorm.DB,
+    before redis.TimelineEntry,
+    limit int,
+) ([]redis.TimelineEntry, error) {
+    query = query.
+        Model(&models.Post{}).
+        Where(models.Post{Status: models.PostStatusPublished});
+    if before != (redis.TimelineEntry{}) {
+        at := time.UnixMilli(before.Score);
+        query = query.Where(
+            "(published_at < ? OR (published_at = ? AND id < ?))",
+            at, at, before.PostID,
+        );
+    }
+
+    var rows []struct {
+        ID          uint
+        PublishedAt time.Time
+    };
+    result := query.
+        Select("id, published_at").
+        Order("published_at DESC, id DESC").
+        Limit(limit).
+        Scan(&rows);
+    if result.Error != nil {
+        return nil, result.Error;
+    }
+
+    entries := make([]redis.TimelineEntry, 0, len(rows));
+    for _, row := range rows {
+        entries = append(entries, redis.TimelineEntry{
+            PostID: row.ID,
+            Score: row.PublishedAt.UnixMilli(),
+        });
+    }
+
+    return entries, nil;
+}
+
+func (self *AppService) getFollowedCelebrityIds(userId uint) ([]uint, error) {
+    ids := []uint{};
+
+    result := self.db.
+        Table("follows").
+        Joins("JOIN users ON users.id = follows.followee_id").
+        Where("follows.follower_id = ?", userId).
+        Where("users.followers_count >= ?", CelebrityFollowersThreshold).
+        Pluck("users.id", &ids);
+
+    return ids, result.Error;
+}
+
+// pushes just published post into cached timelines of author followers,
+// unless the author has too many of them. Waits for the transaction of the
+// service to commit
+func (self *AppService) fanOutPost(post models.Post) error {
+    return self.afterCommit(func(service *AppService) error {
+        return service.fanOut(post);
+    });
+}
+
+func (self *AppService) fanOut(post models.Post) error {
+    if post.PublishedAt == nil {
+        return nil;
+    }
+
+    entry := redis.TimelineEntry{
+        PostID: post.ID,
+        Score: post.PublishedAt.UnixMilli(),
+    };
+
+    if post.Visibility == models.VisibilityPrivate {
+        return self.redis.AddToTimelines([]uint{ post.AuthorID }, entry);
+    }
+
+    if post.Author.FollowersCount >= CelebrityFollowersThreshold {
+        // still show it to the author. Followers see it on the next
+        // timeline read, live events are not fanned out either
+        return self.redis.AddToTimelines([]uint{ post.AuthorID }, entry);
+    }
+
+    followerIds, err := self.getFollowerIds(post.AuthorID);
+    if err != nil {
+        return err;
+    }
+
+    err = self.redis.AddToTimelines(append(followerIds, post.AuthorID), entry);
+    if err != nil {
+        return err;
+    }
+
+    self.publishEvent(followerIds, EventPost, post);
+
+    return nil;
+}
@@ -0,0 +1,162 @@
+package appservice
+
+import (
+	"slices"
+
+	"github.com/cxcnxl/go-crud/internal/models"
+)
+
+// validates visibility name, empty one falls back to fallback
+func parseVisibility(
+    visibility string,
+    fallback models.PostVisibility,
+) (models.PostVisibility, error) {
+    switch models.PostVisibility(visibility) {
+    case "":
+        return fallback, nil;
+    case models.VisibilityPublic,
+        models.VisibilityFollowers,
+        models.VisibilityUnlisted,
+        models.VisibilityPrivate:
+        return models.PostVisibility(visibility), nil;
+    default:
+        return "", InvalidVisibilityError{};
+    }
+}
+
+// relations of a viewer needed to decide which posts they may see. It is
+// loaded once per request, so list read paths check every post of a page
+// without extra queries
+type postAudience struct {
+    service    *AppService
+    viewerId   uint
+    blockedIds []uint
+    // whether the viewer follows the author, by author. Loaded for the
+    // authors of followers-only posts only
+    follows    map[uint]bool
+}
+
+func (self *AppService) audienceOf(viewerId uint) (*postAudience, error) {
+    audience := &postAudience{
+        service: self,
+        viewerId: viewerId,
+        follows: map[uint]bool{},
+    };
+
+    if viewerId != 0 {
+        blockedIds, err := self.getBlockedIds(viewerId);
+        if err != nil {
+            return nil, err;
+        }
+        audience.blockedIds = blockedIds;
+    }
+
+    return audience, nil;
+}
+
+// decides whether the viewer can see the post. Every read path goes through
+// it. Authors always see their own posts, others only published ones whose
+// visibility lets them in and whose author is not blocked either way
+func (self *postAudience) CanView(post models.Post) (bool, error) {
+    if self.viewerId != 0 && post.AuthorID == self.viewerId {
+        return true, nil;
+    }
+    if post.Status != models.PostStatusPublished {
+        return false, nil;
+    }
+    if slices.Contains(self.blockedIds, post.AuthorID) {
+        return false, nil;
+    }
+
+    switch post.Visibility {
+    case models.VisibilityPublic, models.VisibilityUnlisted, "":
+        return true, nil;
+    case models.VisibilityFollowers:
+        if self.viewerId == 0 {
+            return false, nil;
+        }
+
+        if err := self.loadFollows([]uint{ post.AuthorID }); err != nil {
+            return false, err;
+        }
+
+        return self.follows[post.AuthorID], nil;
+    default:
+        return false, nil;
+    }
+}
+
+// looks up which of the authors the viewer follows, skipping the ones it
+// already knows about
+func (self *postAudience) loadFollows(authorIds []uint) error {
+    missing := []uint{};
+    for _, id := range authorIds {
+        if _, ok := self.follows[id]; !ok && !slices.Contains(missing, id) {
+            missing = append(missing, id);
+        }
+    }
+    if len(missing) == 0 {
+        return nil;
+    }
+
+    followeeIds := []uint{};
+    result := self.service.db.
+        Model(&models.Follow{}).
+        Where("follower_id = ? AND followee_id IN ?", self.viewerId, missing).
+        Pluck("followee_id", &followeeIds);
+    if result.Error != nil {
+        return result.Error;
+    }
+
+    for _, id := range missing {
+        self.follows[id] = slices.Contains(followeeIds, id);
+    }
+
+    return nil;
+}
+
+// keeps posts the viewer can see, in order
+func (self *postAudience) filter(posts []models.Post) ([]models.Post, error) {
+    // follows of the whole page in one query
+    if self.viewerId != 0 {
+        authorIds := []uint{};
+        for _, post := range posts {
+            if post.Visibility == models.VisibilityFollowers {
+                authorIds = append(authorIds, post.AuthorID);
+            }
+        }
+        if err := self.loadFollows(authorIds); err != nil {
+            return nil, err;
+        }
+    }
+
+    visible := make([]models.Post, 0, len(posts));
+    for _, post := range posts {
+        ok, err := self.CanView(post);
+        if err != nil {
+            return nil, err;
+        }
+        if ok {
+            visible = append(visible, post);
+        }
+    }
+
+    return visible, nil;
+}
+
+// reports whether the viewer can see the post
+func (self *AppService) CanViewPost(post models.Post, viewerId uint) (bool, error) {
+    audience, err := self.audienceOf(viewerId);
+    if err != nil {
+        return false, err;
+    }
+
+    return audience.CanView(post);
+}
+
+// reports whether the post shows up on pages anyone can browse, such as tag
+// pages and syndication feeds
+func isListed(post models.Post) bool {
+    return post.Status == models.PostStatusPublished &&
+        (post.Visibility == models.VisibilityPublic || post.Visibility == "");
+}
@@ -0,0 +1,24 @@
+package blobstore
+
+import (
+	"context"
+	"io"
+)
+
+// storage for uploaded files. Keys are slash separated paths
+type BlobStore interface {
+    Put(ctx context.Context, key string, data io.Reader, contentType string) error
+    // returns NotFoundError when there is no blob under the key
+    Get(ctx context.Context, key string) (io.ReadCloser, error)
+    Delete(ctx context.Context, key string) error
+}
+
+type NotFoundError struct {}
+func (self NotFoundError) Error() string {
+    return "blob_not_found";
+}
+
+type InvalidKeyError struct {}
+func (self InvalidKeyError) Error() string {
+    return "invalid_blob_key";
+}
@@ -0,0 +1,99 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// keeps blobs as files under root directory
+type LocalStore struct {
+    root string
+}
+
+func NewLocalStore(root string) (*LocalStore, error) {
+    err := os.MkdirAll(root, 0o755);
+    if err != nil {
+        return nil, err;
+    }
+
+    return &LocalStore{ root }, nil;
+}
+
+func (self *LocalStore) Put(
+    _ context.Context,
+    key string,
+    data io.Reader,
+    _ string,
+) error {
+    path, err := self.path(key);
+    if err != nil {
+        return err;
+    }
+
+    err = os.MkdirAll(filepath.Dir(path), 0o755);
+    if err != nil {
+        return err;
+    }
+
+    // write to temp file first so readers never see partial blobs
+    tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*");
+    if err != nil {
+        return err;
+    }
+    defer os.Remove(tmp.Name());
+
+    _, err = io.Copy(tmp, data);
+    if closeErr := tmp.Close(); err == nil {
+        err = closeErr;
+    }
+    if err != nil {
+        return err;
+    }
+
+    return os.Rename(tmp.Name(), path);
+}
+
+func (self *LocalStore) Get(_ context.Context, key string) (io.ReadCloser, error) {
+    path, err := self.path(key);
+    if err != nil {
+        return nil, err;
+    }
+
+    file, err := os.Open(path);
+    if err != nil {
+        if errors.Is(err, fs.ErrNotExist) {
+            return nil, NotFoundError{};
+        }
+        return nil, err;
+    }
+
+    return file, nil;
+}
+
+func (self *LocalStore) Delete(_ context.Context, key string) error {
+    path, err := self.path(key);
+    if err != nil {
+        return err;
+    }
+
+    err = os.Remove(path);
+    if err != nil && !errors.Is(err, fs.ErrNotExist) {
+        return err;
+    }
+
+    return nil;
+}
+
+// maps key to a file path, rejecting keys escaping the root
+func (self *LocalStore) path(key string) (string, error) {
+    if key == "" || strings.HasPrefix(key, "/") || !filepath.IsLocal(key) {
+        return "", InvalidKeyError{};
+    }
+
+    return filepath.Join(self.root, filepath.FromSlash(key)), nil;
+}
@@ -0,0 +1,238 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// keeps blobs in a bucket of S3 compatible storage (AWS, MinIO, ...).
+// Uses path style urls and signature v4
+type S3Store struct {
+    endpoint  string
+    bucket    string
+    region    string
+    accessKey string
+    secretKey string
+    client    *http.Client
+}
+
+func NewS3Store(
+    endpoint string,
+    bucket string,
+    region string,
+    accessKey string,
+    secretKey string,
+) *S3Store {
+    return &S3Store{
+        strings.TrimRight(endpoint, "/"),
+        bucket,
+        region,
+        accessKey,
+        secretKey,
+        &http.Client{ Timeout: 60 * time.Second },
+    };
+}
+
+func (self *S3Store) Put(
+    ctx context.Context,
+    key string,
+    data io.Reader,
+    contentType string,
+) error {
+    body, err := io.ReadAll(data);
+    if err != nil {
+        return err;
+    }
+
+    req, err := self.newRequest(ctx, "PUT", key, body);
+    if err != nil {
+        return err;
+    }
+    if contentType != "" {
+        req.Header.Set("Content-Type", contentType);
+    }
+    self.sign(req, body, time.Now());
+
+    res, err := self.client.Do(req);
+    if err != nil {
+        return err;
+    }
+    defer res.Body.Close();
+
+    return checkS3Response(res);
+}
+
+func (self *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+    req, err := self.newRequest(ctx, "GET", key, nil);
+    if err != nil {
+        return nil, err;
+    }
+    self.sign(req, nil, time.Now());
+
+    res, err := self.client.Do(req);
+    if err != nil {
+        return nil, err;
+    }
+
+    err = checkS3Response(res);
+    if err != nil {
+        res.Body.Close();
+        return nil, err;
+    }
+
+    return res.Body, nil;
+}
+
+func (self *S3Store) Delete(ctx context.Context, key string) error {
+    req, err := self.newRequest(ctx, "DELETE", key, nil);
+    if err != nil {
+        return err;
+    }
+    self.sign(req, nil, time.Now());
+
+    res, err := self.client.Do(req);
+    if err != nil {
+        return err;
+    }
+    defer res.Body.Close();
+
+    err = checkS3Response(res);
+    if _, ok := err.(NotFoundError); ok {
+        return nil;
+    }
+
+    return err;
+}
+
+func (self *S3Store) newRequest(
+    ctx context.Context,
+    method string,
+    key string,
+    body []byte,
+) (*http.Request, error) {
+    if key == "" || strings.HasPrefix(key, "/") {
+        return nil, InvalidKeyError{};
+    }
+
+    rawUrl := fmt.Sprintf("%s/%s/%s", self.endpoint, self.bucket, key);
+    parsed, err := url.Parse(rawUrl);
+    if err != nil {
+        return nil, err;
+    }
+    // keep exactly the encoding that gets signed
+    parsed.RawPath = s3EscapePath(parsed.Path);
+
+    return http.NewRequestWithContext(ctx, method, parsed.String(), bytes.NewReader(body));
+}
+
+// signs request with AWS signature version 4
+func (self *S3Store) sign(req *http.Request, body []byte, now time.Time) {
+    now = now.UTC();
+    amzDate := now.Format("20060102T150405Z");
+    date := now.Format("20060102");
+
+    payloadHash := sha256.Sum256(body);
+    payloadHashHex := hex.EncodeToString(payloadHash[:]);
+
+    req.Header.Set("X-Amz-Date", amzDate);
+    req.Header.Set("X-Amz-Content-Sha256", payloadHashHex);
+
+    headers := map[string]string{
+        "host": req.URL.Host,
+        "x-amz-date": amzDate,
+        "x-amz-content-sha256": payloadHashHex,
+    };
+    if contentType := req.Header.Get("Content-Type"); contentType != "" {
+        headers["content-type"] = contentType;
+    }
+
+    names := make([]string, 0, len(headers));
+    for name := range headers {
+        names = append(names, name);
+    }
+    sort.Strings(names);
+
+    var canonicalHeaders strings.Builder;
+    for _, name := range names {
+        canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n");
+    }
+    signedHeaders := strings.Join(names, ";");
+
+    canonicalRequest := strings.Join([]string{
+        req.Method,
+        req.URL.EscapedPath(),
+        req.URL.Query().Encode(),
+        canonicalHeaders.String(),
+        signedHeaders,
+        payloadHashHex,
+    }, "\n");
+
+    scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, self.region);
+    canonicalHash := sha256.Sum256([]byte(canonicalRequest));
+    stringToSign := strings.Join([]string{
+        "AWS4-HMAC-SHA256",
+        amzDate,
+        scope,
+        hex.EncodeToString(canonicalHash[:]),
+    }, "\n");
+
+    key := hmacSha256([]byte("AWS4" + self.secretKey), date);
+    key = hmacSha256(key, self.region);
+    key = hmacSha256(key, "s3");
+    key = hmacSha256(key, "aws4_request");
+    signature := hex.EncodeToString(hmacSha256(key, stringToSign));
+
+    req.Header.Set("Authorization", fmt.Sprintf(
+        "AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+        self.accessKey,
+        scope,
+        signedHeaders,
+        signature,
+    ));
+}
+
+func hmacSha256(key []byte, data string) []byte {
+    mac := hmac.New(sha256.New, key);
+    mac.Write([]byte(data));
+    return mac.Sum(nil);
+}
+
+// escapes every path segment the way signature v4 expects
+func s3EscapePath(path string) string {
+    segments := strings.Split(path, "/");
+    for i, segment := range segments {
+        segments[i] = strings.ReplaceAll(url.PathEscape(segment), "+", "%2B");
+    }
+
+    return strings.Join(segments, "/");
+}
+
+func checkS3Response(res *http.Response) error {
+    if res.StatusCode == http.StatusNotFound {
+        return NotFoundError{};
+    }
+    if res.StatusCode >= 300 {
+        body, _ := io.ReadAll(io.LimitReader(res.Body, 1024));
+        return S3Error{ res.StatusCode, string(body) };
+    }
+
+    return nil;
+}
+
+type S3Error struct {
+    Status int
+    Body   string
+}
+func (self S3Error) Error() string {
+    return fmt.Sprintf("s3 request failed with status %d: %s", self.Status, self.Body);
+}
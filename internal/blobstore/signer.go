@@ -0,0 +1,49 @@
+package blobstore
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// issues and checks expiring download links for private blobs
+type UrlSigner struct {
+    key []byte
+}
+
+func NewUrlSigner(key string) *UrlSigner {
+    return &UrlSigner{ []byte(key) };
+}
+
+// returns path with expires and sig query params appended
+func (self *UrlSigner) Sign(path string, ttl time.Duration) string {
+    expires := time.Now().Add(ttl).Unix();
+
+    query := url.Values{};
+    query.Set("expires", strconv.FormatInt(expires, 10));
+    query.Set("sig", self.signature(path, expires));
+
+    return path + "?" + query.Encode();
+}
+
+// checks expires and sig query params of a signed path
+func (self *UrlSigner) Verify(path string, query url.Values) bool {
+    expires, err := strconv.ParseInt(query.Get("expires"), 10, 64);
+    if err != nil || time.Now().Unix() > expires {
+        return false;
+    }
+
+    expected := self.signature(path, expires);
+    return hmac.Equal([]byte(expected), []byte(query.Get("sig")));
+}
+
+func (self *UrlSigner) signature(path string, expires int64) string {
+    mac := hmac.New(sha256.New, self.key);
+    mac.Write([]byte(fmt.Sprintf("%s\n%d", path, expires)));
+
+    return base64.RawURLEncoding.EncodeToString(mac.Sum(nil));
+}
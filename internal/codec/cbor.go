@@ -0,0 +1,45 @@
+package codec
+
+import (
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// CBOR, see RFC 8949. Time tags decode to RFC 3339 text, other tags are
+// dropped and their content is kept
+var CBOR = Codec{
+    ContentType: "application/cbor",
+    Encode: encodeCBOR,
+    Decode: decodeCBOR,
+};
+
+var cborEncoding = mustCBOR(cbor.EncOptions{ Sort: cbor.SortCoreDeterministic }.EncMode());
+
+var cborDecoding = mustCBOR(cbor.DecOptions{
+    DefaultMapType: reflect.TypeOf(map[string]any{}),
+    MaxNestedLevels: maxDepth,
+    UnrecognizedTagToAny: cbor.UnrecognizedTagContentToAny,
+    TimeTagToAny: cbor.TimeTagToRFC3339Nano,
+}.DecMode());
+
+func encodeCBOR(value any) ([]byte, error) {
+    return cborEncoding.Marshal(nativeNumbers(value));
+}
+
+func decodeCBOR(data []byte) (any, error) {
+    var value any;
+    if err := cborDecoding.Unmarshal(data, &value); err != nil {
+        return nil, InvalidDataError{};
+    }
+
+    return genericValue(value);
+}
+
+func mustCBOR[T any](mode T, err error) T {
+    if err != nil {
+        panic(err);
+    }
+
+    return mode;
+}
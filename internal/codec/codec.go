@@ -0,0 +1,255 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"mime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// body format of requests and responses. Values go through encoding/json
+// first, so json tags and marshalers shape every format alike. Encode and
+// Decode work on the generic values json decodes into: nil, bool,
+// json.Number, string, []any and map[string]any. Decode may return []byte
+// for binary strings, which reach handlers base64 encoded like JSON does
+type Codec struct {
+    ContentType string
+    // other media types clients use for the format
+    Aliases     []string
+    // nil for JSON itself
+    Encode      func(value any) ([]byte, error)
+    Decode      func(data []byte) (any, error)
+}
+
+var JSON = Codec{ ContentType: "application/json" };
+
+// nesting deeper than this is refused when decoding, so hostile bodies
+// cannot exhaust the stack
+const maxDepth = 256;
+
+type InvalidDataError struct {}
+func (self InvalidDataError) Error() string {
+    return "invalid_data";
+}
+
+var (
+    codecsMu sync.RWMutex;
+    // by preference, JSON comes first
+    codecs   = []Codec{ JSON };
+)
+
+func init() {
+    Register(MessagePack);
+    Register(CBOR);
+}
+
+// adds format to negotiation, after the ones registered before. Meant for
+// init functions
+func Register(format Codec) {
+    codecsMu.Lock();
+    defer codecsMu.Unlock();
+
+    codecs = append(codecs, format);
+}
+
+// media types of every registered format
+func ContentTypes() []string {
+    codecsMu.RLock();
+    defer codecsMu.RUnlock();
+
+    types := make([]string, 0, len(codecs));
+    for _, format := range codecs {
+        types = append(types, format.ContentType);
+    }
+
+    return types;
+}
+
+// encodes value as encoding/json sees it
+func (self Codec) Marshal(value any) ([]byte, error) {
+    data, err := json.Marshal(value);
+    if err != nil || self.Encode == nil {
+        return data, err;
+    }
+
+    decoder := json.NewDecoder(bytes.NewReader(data));
+    decoder.UseNumber();
+
+    var generic any;
+    if err := decoder.Decode(&generic); err != nil {
+        return nil, err;
+    }
+
+    return self.Encode(generic);
+}
+
+// turns data of the format into JSON text, so strict JSON decoding and
+// validation apply to every format
+func (self Codec) ToJSON(data []byte) ([]byte, error) {
+    if self.Decode == nil {
+        return data, nil;
+    }
+
+    generic, err := self.Decode(data);
+    if err != nil {
+        return nil, err;
+    }
+
+    return json.Marshal(generic);
+}
+
+func (self Codec) matches(mediaType string) bool {
+    if mediaType == self.ContentType {
+        return true;
+    }
+    for _, alias := range self.Aliases {
+        if mediaType == alias {
+            return true;
+        }
+    }
+
+    return false;
+}
+
+// format of a request body by its Content-Type header
+func ForContentType(contentType string) (Codec, bool) {
+    mediaType, _, err := mime.ParseMediaType(contentType);
+    if err != nil {
+        return Codec{}, false;
+    }
+
+    codecsMu.RLock();
+    defer codecsMu.RUnlock();
+
+    for _, format := range codecs {
+        if format.matches(mediaType) {
+            return format, true;
+        }
+    }
+
+    return Codec{}, false;
+}
+
+// format the Accept header ranks highest. Exact media types beat
+// wildcards of the same quality, remaining ties go to the format
+// registered first. JSON when the header names none of them
+func Negotiate(accept string) Codec {
+    ranges := parseAccept(accept);
+
+    codecsMu.RLock();
+    defer codecsMu.RUnlock();
+
+    best, bestQuality, bestExact := JSON, 0.0, false;
+    for _, format := range codecs {
+        quality, exact := rank(ranges, format);
+        if quality > bestQuality || (quality == bestQuality && exact && !bestExact) {
+            best, bestQuality, bestExact = format, quality, exact;
+        }
+    }
+
+    return best;
+}
+
+type mediaRange struct {
+    mediaType string
+    quality   float64
+}
+
+func parseAccept(accept string) []mediaRange {
+    ranges := []mediaRange{};
+    for _, part := range strings.Split(accept, ",") {
+        mediaType, params, err := mime.ParseMediaType(part);
+        if err != nil {
+            continue;
+        }
+
+        quality := 1.0;
+        if value, ok := params["q"]; ok {
+            quality, err = strconv.ParseFloat(value, 64);
+            if err != nil {
+                continue;
+            }
+        }
+
+        ranges = append(ranges, mediaRange{ mediaType, quality });
+    }
+
+    return ranges;
+}
+
+// quality of the most specific range matching the format
+func rank(ranges []mediaRange, format Codec) (float64, bool) {
+    kind, _, _ := strings.Cut(format.ContentType, "/");
+
+    quality, specificity := 0.0, 0;
+    for _, accepted := range ranges {
+        current := 0;
+        switch {
+        case format.matches(accepted.mediaType):
+            current = 3;
+        case accepted.mediaType == kind + "/*":
+            current = 2;
+        case accepted.mediaType == "*/*":
+            current = 1;
+        }
+
+        if current > specificity {
+            quality, specificity = accepted.quality, current;
+        }
+    }
+
+    return quality, specificity == 3;
+}
+
+// turns values decoders produce into the generic values of Codec
+func genericValue(value any) (any, error) {
+    switch value := value.(type) {
+    case nil, bool, string, []byte:
+        return value, nil;
+    case int8, int16, int32, int64, uint8, uint16, uint32, uint64:
+        return json.Number(fmt.Sprint(value)), nil;
+    case big.Int:
+        return json.Number(value.String()), nil;
+    case float32:
+        return float(float64(value));
+    case float64:
+        return float(value);
+    case time.Time:
+        return value.UTC().Format(time.RFC3339Nano), nil;
+    case []any:
+        for i, item := range value {
+            item, err := genericValue(item);
+            if err != nil {
+                return nil, err;
+            }
+            value[i] = item;
+        }
+        return value, nil;
+    case map[string]any:
+        for key, member := range value {
+            member, err := genericValue(member);
+            if err != nil {
+                return nil, err;
+            }
+            value[key] = member;
+        }
+        return value, nil;
+    default:
+        return nil, InvalidDataError{};
+    }
+}
+
+// JSON has no NaN and infinities
+func float(value float64) (any, error) {
+    if math.IsNaN(value) || math.IsInf(value, 0) {
+        return nil, InvalidDataError{};
+    }
+
+    return json.Number(strconv.FormatFloat(value, 'g', -1, 64)), nil;
+}
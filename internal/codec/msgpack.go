@@ -0,0 +1,178 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"github.com/vmihailenco/msgpack/v5/msgpcode"
+)
+
+// MessagePack, see https://github.com/msgpack/msgpack/blob/master/spec.md.
+// Timestamps decode to RFC 3339 text, other extension types are rejected
+var MessagePack = Codec{
+    ContentType: "application/msgpack",
+    Aliases: []string{ "application/x-msgpack", "application/vnd.msgpack" },
+    Encode: encodeMessagePack,
+    Decode: decodeMessagePack,
+};
+
+// integers in the smallest form that holds them, other numbers as float64
+func encodeMessagePack(value any) ([]byte, error) {
+    var out bytes.Buffer;
+    encoder := msgpack.NewEncoder(&out);
+    encoder.SetSortMapKeys(true);
+    encoder.UseCompactInts(true);
+
+    if err := encoder.Encode(nativeNumbers(value)); err != nil {
+        return nil, err;
+    }
+
+    return out.Bytes(), nil;
+}
+
+func decodeMessagePack(data []byte) (any, error) {
+    reader := bytes.NewReader(data);
+    walker := &messagePackWalker{ data, reader, msgpack.NewDecoder(reader) };
+
+    value, err := walker.value(0);
+    if err != nil {
+        return nil, err;
+    }
+    if reader.Len() != 0 {
+        return nil, InvalidDataError{};
+    }
+
+    return value, nil;
+}
+
+// walks arrays and maps itself and checks sizes against the data left
+// before the decoder allocates for them, it has no limits of its own
+type messagePackWalker struct {
+    data    []byte
+    reader  *bytes.Reader
+    decoder *msgpack.Decoder
+}
+
+func (self *messagePackWalker) value(depth int) (any, error) {
+    if depth > maxDepth {
+        return nil, InvalidDataError{};
+    }
+
+    code, err := self.decoder.PeekCode();
+    if err != nil {
+        return nil, InvalidDataError{};
+    }
+
+    switch {
+    case msgpcode.IsFixedArray(code) || code == msgpcode.Array16 || code == msgpcode.Array32:
+        size, err := self.decoder.DecodeArrayLen();
+        // every item takes a byte at least
+        if err != nil || size > self.reader.Len() {
+            return nil, InvalidDataError{};
+        }
+
+        items := make([]any, 0, size);
+        for i := 0; i < size; i++ {
+            item, err := self.value(depth + 1);
+            if err != nil {
+                return nil, err;
+            }
+            items = append(items, item);
+        }
+        return items, nil;
+    case msgpcode.IsFixedMap(code) || code == msgpcode.Map16 || code == msgpcode.Map32:
+        size, err := self.decoder.DecodeMapLen();
+        if err != nil || size > self.reader.Len() / 2 {
+            return nil, InvalidDataError{};
+        }
+
+        members := make(map[string]any, size);
+        for i := 0; i < size; i++ {
+            key, err := self.value(depth + 1);
+            if err != nil {
+                return nil, err;
+            }
+            name, ok := key.(string);
+            if !ok {
+                return nil, InvalidDataError{};
+            }
+
+            members[name], err = self.value(depth + 1);
+            if err != nil {
+                return nil, err;
+            }
+        }
+        return members, nil;
+    }
+
+    if size, ok := self.payloadSize(code); ok && size > uint64(self.reader.Len()) {
+        return nil, InvalidDataError{};
+    }
+
+    value, err := self.decoder.DecodeInterface();
+    if err != nil {
+        return nil, InvalidDataError{};
+    }
+
+    return genericValue(value);
+}
+
+// size strings, binaries and extensions with code at the current offset
+// declare in the bytes after it
+func (self *messagePackWalker) payloadSize(code byte) (uint64, bool) {
+    var width int;
+    switch code {
+    case msgpcode.Str8, msgpcode.Bin8, msgpcode.Ext8:
+        width = 1;
+    case msgpcode.Str16, msgpcode.Bin16, msgpcode.Ext16:
+        width = 2;
+    case msgpcode.Str32, msgpcode.Bin32, msgpcode.Ext32:
+        width = 4;
+    default:
+        return 0, false;
+    }
+
+    offset := len(self.data) - self.reader.Len() + 1;
+    if offset + width > len(self.data) {
+        return 0, false;
+    }
+
+    size := uint64(0);
+    for _, b := range self.data[offset:offset + width] {
+        size = size << 8 | uint64(b);
+    }
+
+    return size, true;
+}
+
+// json.Number as the integer or float it holds, for encoders that do not
+// know it
+func nativeNumbers(value any) any {
+    switch value := value.(type) {
+    case json.Number:
+        if number, err := value.Int64(); err == nil {
+            return number;
+        }
+        if number, err := strconv.ParseUint(string(value), 10, 64); err == nil {
+            return number;
+        }
+        number, _ := value.Float64();
+        return number;
+    case []any:
+        items := make([]any, len(value));
+        for i, item := range value {
+            items[i] = nativeNumbers(item);
+        }
+        return items;
+    case map[string]any:
+        members := make(map[string]any, len(value));
+        for key, member := range value {
+            members[key] = nativeNumbers(member);
+        }
+        return members;
+    default:
+        return value;
+    }
+}
@@ -0,0 +1,155 @@
+package diff
+
+import (
+	"strings"
+	"unicode"
+)
+
+type OpKind string;
+
+const (
+    OpEqual  OpKind = "equal";
+    OpInsert OpKind = "insert";
+    OpDelete OpKind = "delete";
+)
+
+type Op struct {
+    Kind OpKind `json:"op"`
+    Text string `json:"text"`
+}
+
+// computes word level diff turning a into b. Whitespace is kept attached
+// to the tokens, so concatenating equal and delete ops gives back a and
+// equal and insert ops gives back b
+func Words(a string, b string) []Op {
+    return diffTokens(tokenize(a), tokenize(b));
+}
+
+// splits text into alternating runs of whitespace and non-whitespace
+func tokenize(text string) []string {
+    tokens := []string{};
+
+    start := 0;
+    prevSpace := false;
+    for i, r := range text {
+        space := unicode.IsSpace(r);
+        if i > 0 && space != prevSpace {
+            tokens = append(tokens, text[start:i]);
+            start = i;
+        }
+        prevSpace = space;
+    }
+    if start < len(text) {
+        tokens = append(tokens, text[start:]);
+    }
+
+    return tokens;
+}
+
+// longest common subsequence over tokens, merged into runs of same kind.
+// Hirschberg's algorithm keeps memory linear in the token count, a full
+// table for two 5000 token posts would take hundreds of megabytes
+func diffTokens(a []string, b []string) []Op {
+    ops := []Op{};
+    push := func(kind OpKind, tokens ...string) {
+        for _, token := range tokens {
+            if len(ops) > 0 && ops[len(ops) - 1].Kind == kind {
+                ops[len(ops) - 1].Text += token;
+                continue;
+            }
+            ops = append(ops, Op{ Kind: kind, Text: token });
+        }
+    };
+
+    // common ends need no search
+    prefix := 0;
+    for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+        prefix++;
+    }
+    suffix := 0;
+    for suffix < len(a) - prefix && suffix < len(b) - prefix &&
+        a[len(a) - 1 - suffix] == b[len(b) - 1 - suffix] {
+        suffix++;
+    }
+
+    push(OpEqual, a[:prefix]...);
+    hirschberg(a[prefix:len(a) - suffix], b[prefix:len(b) - suffix], push);
+    push(OpEqual, a[len(a) - suffix:]...);
+
+    return ops;
+}
+
+func hirschberg(a []string, b []string, push func(OpKind, ...string)) {
+    switch {
+    case len(a) == 0:
+        push(OpInsert, b...);
+        return;
+    case len(b) == 0:
+        push(OpDelete, a...);
+        return;
+    case len(a) == 1:
+        for k, token := range b {
+            if token == a[0] {
+                push(OpInsert, b[:k]...);
+                push(OpEqual, token);
+                push(OpInsert, b[k + 1:]...);
+                return;
+            }
+        }
+        push(OpDelete, a[0]);
+        push(OpInsert, b...);
+        return;
+    }
+
+    // split b where the lcs of the upper half of a ends
+    mid := len(a) / 2;
+    forward := lcsLengths(a[:mid], b, false);
+    backward := lcsLengths(a[mid:], b, true);
+    split, best := 0, -1;
+    for k := 0; k <= len(b); k++ {
+        if length := forward[k] + backward[len(b) - k]; length > best {
+            split, best = k, length;
+        }
+    }
+
+    hirschberg(a[:mid], b[:split], push);
+    hirschberg(a[mid:], b[split:], push);
+}
+
+// lcs length of a with every prefix of b, of every suffix when reversed,
+// indexed by its length
+func lcsLengths(a []string, b []string, reversed bool) []int {
+    previous := make([]int, len(b) + 1);
+    current := make([]int, len(b) + 1);
+    at := func(tokens []string, i int) string {
+        if reversed {
+            return tokens[len(tokens) - 1 - i];
+        }
+        return tokens[i];
+    };
+
+    for i := range a {
+        for j := range b {
+            if at(a, i) == at(b, j) {
+                current[j + 1] = previous[j] + 1;
+            } else {
+                current[j + 1] = max(previous[j + 1], current[j]);
+            }
+        }
+        previous, current = current, previous;
+    }
+
+    return previous;
+}
+
+// joins ops back into one side of the diff
+func Apply(ops []Op, kind OpKind) string {
+    var sb strings.Builder;
+    for _, op := range ops {
+        if op.Kind == OpEqual || op.Kind == kind {
+            sb.WriteString(op.Text);
+        }
+    }
+
+    return sb.String();
+}
@@ -1,12 +1,223 @@
 package dto;
 
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cxcnxl/go-crud/internal/models"
+)
+
+// validate and pattern tags are checked by the validation package
 type CreateUserDto struct {
-    Email    string `json:"email"`;
+    Email    string `json:"email" validate:"required,email,max=255"`;
+    Username string `json:"username" validate:"required,username"`;
+    Password string `json:"password" validate:"required,min=8,max=128"`;
+}
+
+type PostLoginDto struct {
+    // username or email
+    Username string    `json:"username" validate:"required,max=255"`;
+    Password string    `json:"password" validate:"required,max=128"`;
+}
+
+// user with the email when the viewer may see it
+type UserViewDto struct {
+    models.User
+    Email string `json:"email,omitempty"`;
+}
+
+// part of the user the owner edits with PATCH /me. Members a merge patch
+// sets to null fall back to zero values
+type ProfileDto struct {
+    DisplayName string `json:"display_name" validate:"max=64"`;
+    Bio         string `json:"bio" validate:"max=500"`;
+    AvatarUrl   string `json:"avatar_url" validate:"max=255"`;
+    Website     string `json:"website" validate:"max=255"`;
+    Locale      string `json:"locale" validate:"max=35"`;
+    ShowEmail   bool   `json:"show_email"`;
+}
+
+type ChangeUsernameDto struct {
     Username string `json:"username"`;
+}
+
+type ChangeEmailDto struct {
+    Email    string `json:"email"`;
+    // current password, email changes need re-authentication
     Password string `json:"password"`;
 }
 
-type PostLoginDto struct {
-    Username string    `json:"username"`;
-    Password string    `json:"password"`;
+type ConfirmEmailChangeDto struct {
+    Token string `json:"token"`;
+}
+
+type CreatePostDto struct {
+    Body       string     `json:"body"`;
+    // plain, the default, or markdown
+    BodyFormat string     `json:"body_format,omitempty"`;
+    // draft, scheduled or published, the default
+    Status     string     `json:"status,omitempty"`;
+    PublishAt  *time.Time `json:"publish_at,omitempty"`;
+    // public, the default, followers, unlisted or private
+    Visibility string     `json:"visibility,omitempty"`;
+}
+
+type PublishPostDto struct {
+    // publishes right away when empty or in the past
+    PublishAt *time.Time `json:"publish_at,omitempty"`;
+}
+
+// also the part of a post patches edit
+type UpdatePostDto struct {
+    Body       string `json:"body" validate:"required,max=5000"`;
+    // keeps current format when empty
+    BodyFormat string `json:"body_format,omitempty"`;
+    // keeps current visibility when empty
+    Visibility string `json:"visibility,omitempty"`;
+}
+
+type CreateConversationDto struct {
+    // other participants, the caller joins automatically
+    ParticipantIDs []uint `json:"participant_ids"`;
+}
+
+type SendMessageDto struct {
+    Body string `json:"body"`;
+}
+
+type ReadConversationDto struct {
+    // last read message, 0 marks the whole conversation read
+    MessageID uint `json:"message_id"`;
+}
+
+// conversation with the number of messages the viewer has not read yet
+type ConversationViewDto struct {
+    models.Conversation
+    Unread int `json:"unread"`;
+}
+
+type ReportDto struct {
+    Reason string `json:"reason"`;
+}
+
+type ModerationActionDto struct {
+    // dismiss, approve, hide_post, suspend_author or delete_post
+    Action string `json:"action"`;
+    Note   string `json:"note,omitempty"`;
+}
+
+type ModerationFilterDto struct {
+    Pattern string `json:"pattern"`;
+    IsRegex bool   `json:"is_regex"`;
+}
+
+// requests run one after another by POST /batch
+type BatchDto struct {
+    // runs the database work of every operation in one transaction, rolled
+    // back and cut short when one fails
+    Atomic     bool                `json:"atomic,omitempty"`;
+    Operations []BatchOperationDto `json:"operations" validate:"required,min=1,max=50"`;
+}
+
+// sub-request of a batch. Path and body may refer to results of earlier
+// operations as ${id.data.data.id}, the id of the operation followed by
+// the members leading to the value in its response body
+type BatchOperationDto struct {
+    // names the result for later references
+    ID     string          `json:"id,omitempty" validate:"max=64"`;
+    Method string          `json:"method" validate:"required"`;
+    Path   string          `json:"path" validate:"required,max=2048"`;
+    Body   json.RawMessage `json:"body,omitempty"`;
+}
+
+type BatchResultDto struct {
+    ID     string          `json:"id,omitempty"`;
+    Status int             `json:"status"`;
+    // JSON bodies as they are, other ones as strings
+    Body   json.RawMessage `json:"body,omitempty"`;
+}
+
+type BatchViewDto struct {
+    // results by operation, in order
+    Results    []BatchResultDto `json:"results"`;
+    // atomic batch whose work was undone
+    RolledBack bool             `json:"rolled_back"`;
+}
+
+// enabled flags by notification type
+type NotificationPreferencesDto map[models.NotificationType]bool;
+
+// page of a cursor paginated list. NextCursor is 0 on the last page
+type PageDto[T any] struct {
+    Items      []T  `json:"items"`;
+    NextCursor uint `json:"next_cursor,omitempty"`;
+}
+
+// page of a list ordered by a time items may share. NextCursor is nil on
+// the last page
+type KeysetPageDto[T any] struct {
+    Items      []T     `json:"items"`;
+    NextCursor *Keyset `json:"next_cursor,omitempty"`;
+}
+
+// position in a list ordered newest first by time in unix milliseconds,
+// then by id. Written as "<time>_<id>"
+type Keyset struct {
+    At int64
+    ID uint
+}
+
+// cursor is not a Keyset
+type InvalidKeysetError struct {}
+func (self InvalidKeysetError) Error() string {
+    return "invalid_cursor";
+}
+
+// reports whether the Keyset points at the top of the list
+func (self Keyset) IsZero() bool {
+    return self.At == 0 && self.ID == 0;
+}
+
+func (self Keyset) MarshalText() ([]byte, error) {
+    return []byte(fmt.Sprintf("%d_%d", self.At, self.ID)), nil;
+}
+
+func (self *Keyset) UnmarshalText(text []byte) error {
+    at, id, ok := strings.Cut(string(text), "_");
+    if !ok {
+        return InvalidKeysetError{};
+    }
+
+    parsedAt, err := strconv.ParseInt(at, 10, 64);
+    if err != nil {
+        return InvalidKeysetError{};
+    }
+    parsedId, err := strconv.ParseUint(id, 10, 64);
+    if err != nil {
+        return InvalidKeysetError{};
+    }
+
+    *self = Keyset{ At: parsedAt, ID: uint(parsedId) };
+    return nil;
+}
+
+// post with everything its page shows. Body and BodyHtml shadow the post
+// body, so clients can ask for the source, the rendered html or both
+type PostViewDto struct {
+    models.Post
+    Body        *string         `json:"body,omitempty"`;
+    BodyHtml    *string         `json:"body_html,omitempty"`;
+    Attachments []AttachmentDto `json:"attachments"`;
+    Reactions   map[string]int  `json:"reactions"`;
+    MyReactions []string        `json:"my_reactions"`;
+}
+
+type AttachmentDto struct {
+    models.Attachment
+    // download links, signed and expiring for private attachments
+    Url          string `json:"url"`;
+    ThumbnailUrl string `json:"thumbnail_url,omitempty"`;
 }
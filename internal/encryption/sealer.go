@@ -0,0 +1,70 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+)
+
+// KeySize is the length of keys in bytes, they select AES-256
+const KeySize int = 32;
+
+// encrypts data at rest with AES-GCM. Every sealed value starts with its
+// own random nonce
+type Sealer struct {
+    aead cipher.AEAD
+}
+
+func NewSealer(key []byte) (*Sealer, error) {
+    if len(key) != KeySize {
+        return nil, InvalidKeyError{};
+    }
+
+    block, err := aes.NewCipher(key);
+    if err != nil {
+        return nil, err;
+    }
+
+    aead, err := cipher.NewGCM(block);
+    if err != nil {
+        return nil, err;
+    }
+
+    return &Sealer{ aead }, nil;
+}
+
+// encrypts plaintext. additional is authenticated but not stored, the same
+// value has to be passed to Open, so sealed values cannot be moved between
+// records
+func (self *Sealer) Seal(plaintext []byte, additional []byte) ([]byte, error) {
+    nonce := make([]byte, self.aead.NonceSize(), self.aead.NonceSize() + len(plaintext) + self.aead.Overhead());
+    if _, err := rand.Read(nonce); err != nil {
+        return nil, err;
+    }
+
+    return self.aead.Seal(nonce, nonce, plaintext, additional), nil;
+}
+
+func (self *Sealer) Open(sealed []byte, additional []byte) ([]byte, error) {
+    nonceSize := self.aead.NonceSize();
+    if len(sealed) < nonceSize + self.aead.Overhead() {
+        return nil, InvalidCiphertextError{};
+    }
+
+    plaintext, err := self.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], additional);
+    if err != nil {
+        return nil, InvalidCiphertextError{};
+    }
+
+    return plaintext, nil;
+}
+
+type InvalidKeyError struct {}
+func (self InvalidKeyError) Error() string {
+    return "invalid_encryption_key";
+}
+
+type InvalidCiphertextError struct {}
+func (self InvalidCiphertextError) Error() string {
+    return "invalid_ciphertext";
+}
@@ -0,0 +1,52 @@
+package entities
+
+import (
+	"regexp"
+	"strings"
+)
+
+// #tags and @mentions found in a post body
+type Entities struct {
+    // lowercased, without leading #
+    Tags     []string
+    // as written, without leading @
+    Mentions []string
+}
+
+// tag or mention must not be glued to a preceding word, so emails and
+// anchors like a#b are skipped
+var TagPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_&/])#([\p{L}\p{N}_]{1,64})`);
+var MentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_.@/])@([\p{L}\p{N}_]{1,64})`);
+
+// extracts unique tags and mentions in order of first appearance
+func Parse(body string) Entities {
+    return Entities{
+        Tags: matches(TagPattern, body, true),
+        Mentions: matches(MentionPattern, body, false),
+    };
+}
+
+func NormalizeTag(tag string) string {
+    return strings.ToLower(strings.TrimPrefix(tag, "#"));
+}
+
+func matches(pattern *regexp.Regexp, body string, normalize bool) []string {
+    found := []string{};
+    seen := map[string]bool{};
+
+    for _, match := range pattern.FindAllStringSubmatch(body, -1) {
+        value := match[1];
+        if normalize {
+            value = NormalizeTag(value);
+        }
+
+        key := strings.ToLower(value);
+        if seen[key] {
+            continue;
+        }
+        seen[key] = true;
+        found = append(found, value);
+    }
+
+    return found;
+}
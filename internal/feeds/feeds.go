@@ -0,0 +1,229 @@
+package feeds
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+    AtomContentType string = "application/atom+xml; charset=utf-8";
+    RSSContentType  string = "application/rss+xml; charset=utf-8";
+    JSONContentType string = "application/feed+json; charset=utf-8";
+)
+
+// format independent feed description
+type Feed struct {
+    Title   string
+    // html page the feed belongs to
+    HomeUrl string
+    // url of the feed itself in the rendered format
+    FeedUrl string
+    Author  string
+    Updated time.Time
+    Items   []Item
+}
+
+type Item struct {
+    // stable unique id, url of the item works
+    Id        string
+    Url       string
+    Content   string
+    Published time.Time
+    Updated   time.Time
+}
+
+// title for items, which only have a body: its first line, shortened
+func ItemTitle(content string) string {
+    title, _, _ := strings.Cut(strings.TrimSpace(content), "\n");
+    if utf8.RuneCountInString(title) > titleLength {
+        title = string([]rune(title)[:titleLength - 1]) + "…";
+    }
+
+    return title;
+}
+
+const titleLength int = 80;
+
+// ---------- Atom -----------
+
+type atomFeed struct {
+    XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+    Id      string      `xml:"id"`
+    Title   string      `xml:"title"`
+    Updated string      `xml:"updated"`
+    Links   []atomLink  `xml:"link"`
+    Author  atomAuthor  `xml:"author"`
+    Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+    Rel  string `xml:"rel,attr"`
+    Type string `xml:"type,attr,omitempty"`
+    Href string `xml:"href,attr"`
+}
+
+type atomAuthor struct {
+    Name string `xml:"name"`
+}
+
+type atomEntry struct {
+    Id        string      `xml:"id"`
+    Title     string      `xml:"title"`
+    Published string      `xml:"published"`
+    Updated   string      `xml:"updated"`
+    Link      atomLink    `xml:"link"`
+    Content   atomContent `xml:"content"`
+}
+
+type atomContent struct {
+    Type string `xml:"type,attr"`
+    Body string `xml:",chardata"`
+}
+
+func Atom(feed Feed) ([]byte, error) {
+    out := atomFeed{
+        Id: feed.FeedUrl,
+        Title: feed.Title,
+        Updated: feed.Updated.UTC().Format(time.RFC3339),
+        Links: []atomLink{
+            { Rel: "self", Type: "application/atom+xml", Href: feed.FeedUrl },
+            { Rel: "alternate", Href: feed.HomeUrl },
+        },
+        Author: atomAuthor{ feed.Author },
+        Entries: []atomEntry{},
+    };
+
+    for _, item := range feed.Items {
+        out.Entries = append(out.Entries, atomEntry{
+            Id: item.Id,
+            Title: ItemTitle(item.Content),
+            Published: item.Published.UTC().Format(time.RFC3339),
+            Updated: item.Updated.UTC().Format(time.RFC3339),
+            Link: atomLink{ Rel: "alternate", Href: item.Url },
+            Content: atomContent{ Type: "text", Body: item.Content },
+        });
+    }
+
+    return marshalXml(out);
+}
+
+// ---------- RSS 2.0 -----------
+
+type rssFeed struct {
+    XMLName xml.Name   `xml:"rss"`
+    Version string     `xml:"version,attr"`
+    AtomNs  string     `xml:"xmlns:atom,attr"`
+    Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+    Title         string    `xml:"title"`
+    Link          string    `xml:"link"`
+    Description   string    `xml:"description"`
+    LastBuildDate string    `xml:"lastBuildDate"`
+    SelfLink      atomLink  `xml:"atom:link"`
+    Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+    Title       string  `xml:"title"`
+    Link        string  `xml:"link"`
+    Guid        rssGuid `xml:"guid"`
+    PubDate     string  `xml:"pubDate"`
+    Description string  `xml:"description"`
+}
+
+type rssGuid struct {
+    IsPermaLink bool   `xml:"isPermaLink,attr"`
+    Value       string `xml:",chardata"`
+}
+
+func RSS(feed Feed) ([]byte, error) {
+    out := rssFeed{
+        Version: "2.0",
+        AtomNs: "http://www.w3.org/2005/Atom",
+        Channel: rssChannel{
+            Title: feed.Title,
+            Link: feed.HomeUrl,
+            Description: feed.Title,
+            LastBuildDate: feed.Updated.UTC().Format(time.RFC1123Z),
+            SelfLink: atomLink{
+                Rel: "self",
+                Type: "application/rss+xml",
+                Href: feed.FeedUrl,
+            },
+            Items: []rssItem{},
+        },
+    };
+
+    for _, item := range feed.Items {
+        out.Channel.Items = append(out.Channel.Items, rssItem{
+            Title: ItemTitle(item.Content),
+            Link: item.Url,
+            Guid: rssGuid{ IsPermaLink: item.Id == item.Url, Value: item.Id },
+            PubDate: item.Published.UTC().Format(time.RFC1123Z),
+            Description: item.Content,
+        });
+    }
+
+    return marshalXml(out);
+}
+
+// ---------- JSON Feed 1.1 -----------
+
+type jsonFeed struct {
+    Version     string           `json:"version"`
+    Title       string           `json:"title"`
+    HomePageUrl string           `json:"home_page_url"`
+    FeedUrl     string           `json:"feed_url"`
+    Authors     []jsonFeedAuthor `json:"authors"`
+    Items       []jsonFeedItem   `json:"items"`
+}
+
+type jsonFeedAuthor struct {
+    Name string `json:"name"`
+}
+
+type jsonFeedItem struct {
+    Id            string `json:"id"`
+    Url           string `json:"url"`
+    Title         string `json:"title,omitempty"`
+    ContentText   string `json:"content_text"`
+    DatePublished string `json:"date_published"`
+    DateModified  string `json:"date_modified"`
+}
+
+func JSONFeed(feed Feed) ([]byte, error) {
+    out := jsonFeed{
+        Version: "https://jsonfeed.org/version/1.1",
+        Title: feed.Title,
+        HomePageUrl: feed.HomeUrl,
+        FeedUrl: feed.FeedUrl,
+        Authors: []jsonFeedAuthor{{ feed.Author }},
+        Items: []jsonFeedItem{},
+    };
+
+    for _, item := range feed.Items {
+        out.Items = append(out.Items, jsonFeedItem{
+            Id: item.Id,
+            Url: item.Url,
+            ContentText: item.Content,
+            DatePublished: item.Published.UTC().Format(time.RFC3339),
+            DateModified: item.Updated.UTC().Format(time.RFC3339),
+        });
+    }
+
+    return json.Marshal(out);
+}
+
+func marshalXml(v any) ([]byte, error) {
+    body, err := xml.MarshalIndent(v, "", "  ");
+    if err != nil {
+        return nil, err;
+    }
+
+    return append([]byte(xml.Header), body...), nil;
+}
@@ -0,0 +1,244 @@
+package fieldset
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// relations ?include= may nest, author.x is 2
+const MaxDepth int = 2;
+
+// ?fields= names a member the model does not allow
+type UnknownFieldError struct {
+    Field string
+}
+func (self UnknownFieldError) Error() string {
+    return "unknown_field";
+}
+
+// ?include= names a relation the model does not allow
+type UnknownRelationError struct {
+    Relation string
+}
+func (self UnknownRelationError) Error() string {
+    return "unknown_relation";
+}
+
+// relation nested deeper than MaxDepth
+type IncludeTooDeepError struct {
+    Relation string
+}
+func (self IncludeTooDeepError) Error() string {
+    return "include_too_deep";
+}
+
+// allowlist of what clients may ask for of a model
+type Model struct {
+    Table     string
+    // columns every query selects, keys and what visibility checks read
+    Required  []string
+    // JSON members by name with the columns they are read from. Members
+    // computed after the query have none
+    Fields    map[string][]string
+    Relations map[string]Relation
+    // relations responses include when the request has no ?include=
+    Defaults  []string
+}
+
+type Relation struct {
+    // association name for Preload
+    Association string
+    // column of the model the association is loaded by
+    ForeignKey  string
+    Model       *Model
+}
+
+// members and relations a request asked for of a model
+type Spec struct {
+    model    *Model
+    // nil for every member
+    fields   map[string]bool
+    includes map[string]*Spec
+}
+
+// reads ?fields=id,author.username and ?include=author. Fields of a
+// relation include it
+func Parse(model *Model, query url.Values) (Spec, error) {
+    spec := newSpec(model);
+
+    if query.Has("include") {
+        for _, path := range splitList(query.Get("include")) {
+            if _, err := spec.include(strings.Split(path, "."), path); err != nil {
+                return Spec{}, err;
+            }
+        }
+    } else {
+        for _, name := range model.Defaults {
+            spec.include([]string{ name }, name);
+        }
+    }
+
+    if query.Has("fields") {
+        for _, path := range splitList(query.Get("fields")) {
+            parts := strings.Split(path, ".");
+            target, err := spec.include(parts[:len(parts) - 1], path);
+            if err != nil {
+                return Spec{}, err;
+            }
+
+            field := parts[len(parts) - 1];
+            if _, ok := target.model.Fields[field]; !ok {
+                return Spec{}, UnknownFieldError{ Field: path };
+            }
+            if target.fields == nil {
+                target.fields = map[string]bool{};
+            }
+            target.fields[field] = true;
+        }
+    }
+
+    return *spec, nil;
+}
+
+func newSpec(model *Model) *Spec {
+    return &Spec{ model: model, includes: map[string]*Spec{} };
+}
+
+// spec of the relation at path, included on the way
+func (self *Spec) include(path []string, name string) (*Spec, error) {
+    if len(path) > MaxDepth {
+        return nil, IncludeTooDeepError{ Relation: name };
+    }
+
+    spec := self;
+    for _, part := range path {
+        relation, ok := spec.model.Relations[part];
+        if !ok {
+            return nil, UnknownRelationError{ Relation: name };
+        }
+
+        nested, ok := spec.includes[part];
+        if !ok {
+            nested = newSpec(relation.Model);
+            spec.includes[part] = nested;
+        }
+        spec = nested;
+    }
+
+    return spec, nil;
+}
+
+func splitList(list string) []string {
+    names := []string{};
+    for _, name := range strings.Split(list, ",") {
+        if name = strings.TrimSpace(name); name != "" {
+            names = append(names, name);
+        }
+    }
+
+    return names;
+}
+
+// reports whether the Spec was not parsed, Project leaves responses of
+// zero Specs as they are
+func (self Spec) IsZero() bool {
+    return self.model == nil;
+}
+
+// reports whether responses carry the member
+func (self Spec) Wants(field string) bool {
+    return self.fields == nil || self.fields[field];
+}
+
+// selects the columns of the asked for members and preloads included
+// relations, selecting theirs
+func (self Spec) Scope(db *gorm.DB) *gorm.DB {
+    return self.preload(self.scope(db), "");
+}
+
+func (self Spec) scope(db *gorm.DB) *gorm.DB {
+    if self.fields == nil {
+        return db;
+    }
+
+    columns := []string{};
+    seen := map[string]bool{};
+    add := func(names []string) {
+        for _, name := range names {
+            if !seen[name] {
+                seen[name] = true;
+                columns = append(columns, self.model.Table + "." + name);
+            }
+        }
+    };
+
+    add(self.model.Required);
+    for field := range self.fields {
+        add(self.model.Fields[field]);
+    }
+    for name := range self.includes {
+        add([]string{ self.model.Relations[name].ForeignKey });
+    }
+
+    return db.Select(columns);
+}
+
+// nested relations preload by dotted association names
+func (self Spec) preload(db *gorm.DB, prefix string) *gorm.DB {
+    for name, nested := range self.includes {
+        association := prefix + self.model.Relations[name].Association;
+        db = db.Preload(association, nested.scope);
+        db = nested.preload(db, association + ".");
+    }
+
+    return db;
+}
+
+// JSON form of value with only the asked for members of it and of its
+// included relations. Slices are projected item by item
+func (self Spec) Project(value any) (any, error) {
+    encoded, err := json.Marshal(value);
+    if err != nil {
+        return nil, err;
+    }
+
+    decoder := json.NewDecoder(bytes.NewReader(encoded));
+    decoder.UseNumber();
+
+    var document any;
+    if err := decoder.Decode(&document); err != nil {
+        return nil, err;
+    }
+    if self.IsZero() {
+        return document, nil;
+    }
+
+    return self.project(document), nil;
+}
+
+func (self Spec) project(document any) any {
+    switch document := document.(type) {
+    case []any:
+        for i, item := range document {
+            document[i] = self.project(item);
+        }
+    case map[string]any:
+        for name, member := range document {
+            if _, ok := self.model.Relations[name]; ok {
+                if nested, ok := self.includes[name]; ok {
+                    document[name] = nested.project(member);
+                } else {
+                    delete(document, name);
+                }
+            } else if !self.Wants(name) {
+                delete(document, name);
+            }
+        }
+    }
+
+    return document;
+}
@@ -0,0 +1,48 @@
+package fieldset
+
+// public members of models.User and dto.UserViewDto
+var User = &Model{
+    Table: "users",
+    Required: []string{ "id" },
+    Fields: map[string][]string{
+        "id": { "id" },
+        "username": { "username" },
+        "display_name": { "display_name" },
+        "bio": { "bio" },
+        "avatar_url": { "avatar_url" },
+        "website": { "website" },
+        "locale": { "locale" },
+        "show_email": { "show_email" },
+        "email": { "email", "show_email" },
+        "followers_count": { "followers_count" },
+        "following_count": { "following_count" },
+    },
+    Relations: map[string]Relation{},
+};
+
+// members of models.Post and dto.PostViewDto. Attachments and reactions
+// are only part of single posts
+var Post = &Model{
+    Table: "posts",
+    Required: []string{ "id", "author_id", "status", "visibility" },
+    Fields: map[string][]string{
+        "id": { "id" },
+        "body": { "body" },
+        "body_format": { "body_format" },
+        "body_html": { "body", "body_format", "body_html" },
+        "status": { "status" },
+        "visibility": { "visibility" },
+        "publish_at": { "publish_at" },
+        "published_at": { "published_at" },
+        "created_at": { "created_at" },
+        "updated_at": { "updated_at" },
+        "deleted_at": { "deleted_at" },
+        "attachments": {},
+        "reactions": {},
+        "my_reactions": {},
+    },
+    Relations: map[string]Relation{
+        "author": { Association: "Author", ForeignKey: "author_id", Model: User },
+    },
+    Defaults: []string{ "author" },
+};
@@ -0,0 +1,89 @@
+package graph
+
+import (
+	"context"
+	"errors"
+
+	"github.com/vikstrous/dataloadgen"
+
+	"github.com/cxcnxl/go-crud/internal/app_service"
+	"github.com/cxcnxl/go-crud/internal/dto"
+	"github.com/cxcnxl/go-crud/internal/models"
+)
+
+type loadersKey struct {}
+
+type viewerKey struct {}
+
+// loaders of a single request. Keys resolvers ask for while the loader
+// waits are fetched in one query, so a list of posts loads its authors
+// at once instead of one by one. Results are cached for the request
+type loaders struct {
+    service     *appservice.AppService
+    viewerId    uint
+    users       *dataloadgen.Loader[uint, dto.UserViewDto]
+    posts       *dataloadgen.Loader[uint, models.Post]
+    authorPosts *dataloadgen.Loader[authorPage, []models.Post]
+}
+
+// latest posts of an author, limit of them
+type authorPage struct {
+    authorId uint
+    limit    int
+}
+
+// returns ctx carrying the id of the authenticated caller, 0 for
+// anonymous ones
+func WithViewer(ctx context.Context, viewerId uint) context.Context {
+    return context.WithValue(ctx, viewerKey{}, viewerId);
+}
+
+func newLoaders(service *appservice.AppService, viewerId uint) *loaders {
+    return &loaders{
+        service: service,
+        viewerId: viewerId,
+        users: dataloadgen.NewMappedLoader(func(ctx context.Context, ids []uint) (map[uint]dto.UserViewDto, error) {
+            return service.GetUsersByIds(ids, viewerId);
+        }),
+        posts: dataloadgen.NewMappedLoader(func(ctx context.Context, ids []uint) (map[uint]models.Post, error) {
+            return service.GetPostsByIds(ids, viewerId);
+        }),
+        authorPosts: dataloadgen.NewMappedLoader(func(ctx context.Context, pages []authorPage) (map[authorPage][]models.Post, error) {
+            // one query per page size, requests rarely mix them
+            authorIds := map[int][]uint{};
+            for _, page := range pages {
+                authorIds[page.limit] = append(authorIds[page.limit], page.authorId);
+            }
+
+            found := map[authorPage][]models.Post{};
+            for limit, ids := range authorIds {
+                byAuthor, err := service.GetAuthorsPosts(ids, viewerId, limit);
+                if err != nil {
+                    return nil, err;
+                }
+                for authorId, posts := range byAuthor {
+                    found[authorPage{ authorId, limit }] = posts;
+                }
+            }
+
+            return found, nil;
+        }),
+    };
+}
+
+func loadersOf(ctx context.Context) *loaders {
+    return ctx.Value(loadersKey{}).(*loaders);
+}
+
+// value of key or nil when the loader found none
+func load[K comparable, V any](ctx context.Context, loader *dataloadgen.Loader[K, V], key K) (*V, error) {
+    value, err := loader.Load(ctx, key);
+    if errors.Is(err, dataloadgen.ErrNotFound) {
+        return nil, nil;
+    }
+    if err != nil {
+        return nil, err;
+    }
+
+    return &value, nil;
+}
@@ -0,0 +1,16 @@
+// Code generated by github.com/99designs/gqlgen, DO NOT EDIT.
+
+package graph
+
+import (
+	"github.com/cxcnxl/go-crud/internal/models"
+)
+
+type PostPage struct {
+	Items []models.Post `json:"items"`
+	// id to pass as before for the next page, null on the last one
+	NextCursor *uint `json:"nextCursor,omitempty"`
+}
+
+type Query struct {
+}
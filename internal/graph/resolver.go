@@ -0,0 +1,125 @@
+// generates generated.go and models_gen.go from schema.graphqls
+//go:generate go tool gqlgen generate
+
+package graph
+
+import (
+	"context"
+	"errors"
+
+	"gorm.io/gorm"
+
+	"github.com/cxcnxl/go-crud/internal/dto"
+	"github.com/cxcnxl/go-crud/internal/models"
+)
+
+// largest page sizes of User.posts and Query.tagPosts
+const maxUserPosts int = 50;
+const maxTagPosts int = 100;
+
+// resolvers call the app service of the request, so GraphQL follows the
+// same rules as REST. Fields that fan out go through its loaders
+type Resolver struct {}
+
+type queryResolver struct { *Resolver }
+
+type userResolver struct { *Resolver }
+
+type postResolver struct { *Resolver }
+
+func (self *Resolver) Query() QueryResolver { return &queryResolver{ self }; }
+
+func (self *Resolver) User() UserResolver { return &userResolver{ self }; }
+
+func (self *Resolver) Post() PostResolver { return &postResolver{ self }; }
+
+func (self *queryResolver) Viewer(ctx context.Context) (*dto.UserViewDto, error) {
+    loaders := loadersOf(ctx);
+    if loaders.viewerId == 0 {
+        return nil, nil;
+    }
+
+    return load(ctx, loaders.users, loaders.viewerId);
+}
+
+func (self *queryResolver) User(ctx context.Context, username string) (*dto.UserViewDto, error) {
+    loaders := loadersOf(ctx);
+    profile, err := loaders.service.GetProfile(username, loaders.viewerId);
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        return nil, nil;
+    }
+    if err != nil {
+        return nil, err;
+    }
+
+    return &profile, nil;
+}
+
+func (self *queryResolver) Post(ctx context.Context, id uint) (*models.Post, error) {
+    return load(ctx, loadersOf(ctx).posts, id);
+}
+
+func (self *queryResolver) TagPosts(ctx context.Context, tag string, first *int, before *uint) (*PostPage, error) {
+    loaders := loadersOf(ctx);
+    cursor := uint(0);
+    if before != nil {
+        cursor = *before;
+    }
+
+    page, err := loaders.service.GetTagPosts(tag, loaders.viewerId, cursor, pageSize(first, maxTagPosts));
+    if err != nil {
+        return nil, err;
+    }
+
+    result := &PostPage{ Items: page.Items };
+    if page.NextCursor != 0 {
+        result.NextCursor = &page.NextCursor;
+    }
+    return result, nil;
+}
+
+func (self *userResolver) Email(ctx context.Context, user *dto.UserViewDto) (*string, error) {
+    if user.Email == "" {
+        return nil, nil;
+    }
+
+    return &user.Email, nil;
+}
+
+func (self *userResolver) Posts(ctx context.Context, user *dto.UserViewDto, first *int) ([]models.Post, error) {
+    page := authorPage{ user.ID, pageSize(first, maxUserPosts) };
+    posts, err := load(ctx, loadersOf(ctx).authorPosts, page);
+    if err != nil {
+        return nil, err;
+    }
+    if posts == nil {
+        return []models.Post{}, nil;
+    }
+
+    return *posts, nil;
+}
+
+func (self *postResolver) BodyFormat(ctx context.Context, post *models.Post) (string, error) {
+    return string(post.BodyFormat), nil;
+}
+
+func (self *postResolver) Status(ctx context.Context, post *models.Post) (string, error) {
+    return string(post.Status), nil;
+}
+
+func (self *postResolver) Visibility(ctx context.Context, post *models.Post) (string, error) {
+    return string(post.Visibility), nil;
+}
+
+func (self *postResolver) Author(ctx context.Context, post *models.Post) (*dto.UserViewDto, error) {
+    return load(ctx, loadersOf(ctx).users, post.AuthorID);
+}
+
+// first argument of list fields, clamped to 1..limit
+func pageSize(first *int, limit int) int {
+    if first == nil {
+        return 1;
+    }
+
+    return min(max(*first, 1), limit);
+}
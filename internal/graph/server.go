@@ -0,0 +1,154 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/errcode"
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/extension"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+
+	"github.com/cxcnxl/go-crud/internal/app_service"
+	"github.com/cxcnxl/go-crud/internal/problems"
+)
+
+// deepest field nesting allowed, root fields are at depth 1
+const MaxDepth int = 8;
+
+// highest complexity allowed. Every field costs 1 and list fields cost
+// their selections once per item of the page they ask for
+const MaxComplexity int = 1000;
+
+// body of a GraphQL request over HTTP
+type Request struct {
+    Query         string         `json:"query"`
+    OperationName string         `json:"operationName"`
+    Variables     map[string]any `json:"variables"`
+    Extensions    map[string]any `json:"extensions"`
+}
+
+// serves the schema over POST. Queries may be persisted in Redis with the
+// persistedQuery extension of Apollo clients, errors of the problem
+// catalog keep their code and status
+func NewHandler(service *appservice.AppService) http.Handler {
+    config := Config{ Resolvers: &Resolver{} };
+    config.Complexity.User.Posts = func(childComplexity int, first *int) int {
+        return 1 + childComplexity * pageSize(first, maxUserPosts);
+    };
+    config.Complexity.Query.TagPosts = func(childComplexity int, tag string, first *int, before *uint) int {
+        return 1 + childComplexity * pageSize(first, maxTagPosts);
+    };
+
+    server := handler.New(NewExecutableSchema(config));
+    server.AddTransport(transport.POST{});
+    server.Use(extension.AutomaticPersistedQuery{ Cache: persistedQueries{} });
+    server.Use(depthLimit(MaxDepth));
+    server.Use(extension.FixedComplexityLimit(MaxComplexity));
+    server.SetErrorPresenter(presentError);
+    server.SetRecoverFunc(func(ctx context.Context, value any) error {
+        return fmt.Errorf("panic resolving field: %v", value);
+    });
+
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        viewerId, _ := r.Context().Value(viewerKey{}).(uint);
+        loaders := newLoaders(service.For(r.Context()), viewerId);
+        server.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), loadersKey{}, loaders)));
+    });
+}
+
+// errors of gqlgen, e.g. of validation, are shown as they are. Resolver
+// errors of the problem catalog get its code and status, the rest are
+// logged and hidden like problems.Write does
+func presentError(ctx context.Context, err error) *gqlerror.Error {
+    var request *gqlerror.Error;
+    if errors.As(err, &request) {
+        return request;
+    }
+
+    presented := gqlerror.WrapPath(graphql.GetPath(ctx), err);
+    entry, _, ok := problems.Lookup(err);
+    if !ok {
+        slog.Error("Error resolving GraphQL field: " + err.Error());
+        presented.Message = "Internal server error";
+        presented.Extensions = map[string]any{ "code": "internal_server_error", "status": http.StatusInternalServerError };
+        return presented;
+    }
+
+    presented.Extensions = map[string]any{ "code": entry.Code, "status": entry.Status };
+    return presented;
+}
+
+// queries of the automatic persisted query protocol, stored in Redis under
+// their sha256 hash
+type persistedQueries struct {}
+
+func (persistedQueries) Get(ctx context.Context, hash string) (string, bool) {
+    query, ok, err := loadersOf(ctx).service.GetPersistedQuery(hash);
+    if err != nil {
+        slog.Error("Error reading persisted query: " + err.Error());
+    }
+
+    return query, ok;
+}
+
+func (persistedQueries) Add(ctx context.Context, hash string, query string) {
+    if err := loadersOf(ctx).service.PersistQuery(hash, query); err != nil {
+        slog.Error("Error persisting query: " + err.Error());
+    }
+}
+
+// rejects operations nesting fields deeper than its value. Runs after
+// validation, so fragments are known and free of cycles
+type depthLimit int;
+
+func (self depthLimit) ExtensionName() string {
+    return "DepthLimit";
+}
+
+func (self depthLimit) Validate(schema graphql.ExecutableSchema) error {
+    return nil;
+}
+
+func (self depthLimit) MutateOperationContext(ctx context.Context, operationContext *graphql.OperationContext) *gqlerror.Error {
+    operation := operationContext.Doc.Operations.ForName(operationContext.OperationName);
+    depth := selectionDepth(operation.SelectionSet, map[string]int{});
+    if depth <= int(self) {
+        return nil;
+    }
+
+    err := gqlerror.Errorf("operation is %d fields deep, which exceeds the limit of %d", depth, int(self));
+    errcode.Set(err, "QUERY_TOO_DEEP");
+    return err;
+}
+
+// depth of the deepest field of selections. Depths of fragments are kept
+// in fragments, spreading one many times costs a single walk
+func selectionDepth(selections ast.SelectionSet, fragments map[string]int) int {
+    deepest := 0;
+    for _, selection := range selections {
+        depth := 0;
+        switch selection := selection.(type) {
+        case *ast.Field:
+            depth = 1 + selectionDepth(selection.SelectionSet, fragments);
+        case *ast.InlineFragment:
+            depth = selectionDepth(selection.SelectionSet, fragments);
+        case *ast.FragmentSpread:
+            known, ok := fragments[selection.Name];
+            if !ok {
+                known = selectionDepth(selection.Definition.SelectionSet, fragments);
+                fragments[selection.Name] = known;
+            }
+            depth = known;
+        }
+        deepest = max(deepest, depth);
+    }
+
+    return deepest;
+}
@@ -0,0 +1,368 @@
+package jsonpatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// ContentType of JSON patch documents, RFC 6902
+const ContentType string = "application/json-patch+json";
+
+// patch document that is not an array of valid operations
+type InvalidPatchError struct {}
+func (self InvalidPatchError) Error() string {
+    return "invalid_patch";
+}
+
+// operation whose path, or from, names no value it can work on
+type ConflictError struct {
+    Path string
+}
+func (self ConflictError) Error() string {
+    return "patch_conflict";
+}
+
+// test operation that did not match
+type TestFailedError struct {
+    Path string
+}
+func (self TestFailedError) Error() string {
+    return "patch_test_failed";
+}
+
+type operation struct {
+    Op    string          `json:"op"`
+    Path  *string         `json:"path"`
+    From  *string         `json:"from"`
+    Value json.RawMessage `json:"value"`
+}
+
+// applies the operations of patch to target document in order and returns
+// the result. The whole patch fails when one operation does, see section 5
+// of the RFC
+func Apply(target []byte, patch []byte) ([]byte, error) {
+    var operations []operation;
+    if err := json.Unmarshal(patch, &operations); err != nil {
+        return nil, InvalidPatchError{};
+    }
+
+    document, err := decode(target);
+    if err != nil {
+        return nil, err;
+    }
+
+    for _, op := range operations {
+        document, err = apply(document, op);
+        if err != nil {
+            return nil, err;
+        }
+    }
+
+    return json.Marshal(document);
+}
+
+func apply(document any, op operation) (any, error) {
+    if op.Path == nil {
+        return nil, InvalidPatchError{};
+    }
+    path, err := parsePointer(*op.Path);
+    if err != nil {
+        return nil, err;
+    }
+
+    switch op.Op {
+    case "add", "replace", "test":
+        // null values are given, missing ones are not
+        if op.Value == nil {
+            return nil, InvalidPatchError{};
+        }
+        value, err := decode(op.Value);
+        if err != nil {
+            return nil, InvalidPatchError{};
+        }
+
+        switch op.Op {
+        case "add":
+            return add(document, path, value, *op.Path);
+        case "replace":
+            if _, err := get(document, path, *op.Path); err != nil {
+                return nil, err;
+            }
+            document, _, err = remove(document, path, *op.Path);
+            if err != nil {
+                return nil, err;
+            }
+            return add(document, path, value, *op.Path);
+        default:
+            current, err := get(document, path, *op.Path);
+            if err != nil {
+                return nil, err;
+            }
+            if !equal(current, value) {
+                return nil, TestFailedError{ Path: *op.Path };
+            }
+            return document, nil;
+        }
+    case "remove":
+        document, _, err = remove(document, path, *op.Path);
+        return document, err;
+    case "move", "copy":
+        if op.From == nil {
+            return nil, InvalidPatchError{};
+        }
+        from, err := parsePointer(*op.From);
+        if err != nil {
+            return nil, err;
+        }
+
+        if op.Op == "copy" {
+            value, err := get(document, from, *op.From);
+            if err != nil {
+                return nil, err;
+            }
+            return add(document, path, clone(value), *op.Path);
+        }
+
+        // a value can not move into itself
+        if len(from) < len(path) && isPrefix(from, path) {
+            return nil, ConflictError{ Path: *op.Path };
+        }
+        document, value, err := remove(document, from, *op.From);
+        if err != nil {
+            return nil, err;
+        }
+        return add(document, path, value, *op.Path);
+    default:
+        return nil, InvalidPatchError{};
+    }
+}
+
+// reference tokens of a JSON pointer, RFC 6901. "" points at the whole
+// document
+func parsePointer(pointer string) ([]string, error) {
+    if pointer == "" {
+        return []string{}, nil;
+    }
+    if pointer[0] != '/' {
+        return nil, InvalidPatchError{};
+    }
+
+    tokens := strings.Split(pointer[1:], "/");
+    for i, token := range tokens {
+        // ~1 first, so ~01 becomes ~1 and not /
+        tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~");
+    }
+
+    return tokens, nil;
+}
+
+func isPrefix(prefix []string, path []string) bool {
+    for i, token := range prefix {
+        if path[i] != token {
+            return false;
+        }
+    }
+
+    return true;
+}
+
+func get(document any, path []string, pointer string) (any, error) {
+    value := document;
+    for _, token := range path {
+        switch container := value.(type) {
+        case map[string]any:
+            member, ok := container[token];
+            if !ok {
+                return nil, ConflictError{ Path: pointer };
+            }
+            value = member;
+        case []any:
+            index, ok := arrayIndex(token, len(container) - 1);
+            if !ok {
+                return nil, ConflictError{ Path: pointer };
+            }
+            value = container[index];
+        default:
+            return nil, ConflictError{ Path: pointer };
+        }
+    }
+
+    return value, nil;
+}
+
+// sets the value at path, inserting into arrays. Returns the new document,
+// which only differs from the old one when path is the root
+func add(document any, path []string, value any, pointer string) (any, error) {
+    if len(path) == 0 {
+        return value, nil;
+    }
+
+    parent, err := get(document, path[:len(path) - 1], pointer);
+    if err != nil {
+        return nil, err;
+    }
+    last := path[len(path) - 1];
+
+    switch container := parent.(type) {
+    case map[string]any:
+        container[last] = value;
+    case []any:
+        index := len(container);
+        if last != "-" {
+            var ok bool;
+            index, ok = arrayIndex(last, len(container));
+            if !ok {
+                return nil, ConflictError{ Path: pointer };
+            }
+        }
+
+        grown := append(container, nil);
+        copy(grown[index + 1:], grown[index:]);
+        grown[index] = value;
+        return replaceIn(document, path[:len(path) - 1], grown), nil;
+    default:
+        return nil, ConflictError{ Path: pointer };
+    }
+
+    return document, nil;
+}
+
+// removes the value at path and returns the new document with it
+func remove(document any, path []string, pointer string) (any, any, error) {
+    if len(path) == 0 {
+        return nil, document, nil;
+    }
+
+    parent, err := get(document, path[:len(path) - 1], pointer);
+    if err != nil {
+        return nil, nil, err;
+    }
+    last := path[len(path) - 1];
+
+    switch container := parent.(type) {
+    case map[string]any:
+        value, ok := container[last];
+        if !ok {
+            return nil, nil, ConflictError{ Path: pointer };
+        }
+        delete(container, last);
+        return document, value, nil;
+    case []any:
+        index, ok := arrayIndex(last, len(container) - 1);
+        if !ok {
+            return nil, nil, ConflictError{ Path: pointer };
+        }
+        value := container[index];
+        shrunk := append(container[:index:index], container[index + 1:]...);
+        return replaceIn(document, path[:len(path) - 1], shrunk), value, nil;
+    default:
+        return nil, nil, ConflictError{ Path: pointer };
+    }
+}
+
+// puts a resized array back where it was, path is known to exist
+func replaceIn(document any, path []string, value any) any {
+    if len(path) == 0 {
+        return value;
+    }
+
+    parent, _ := get(document, path[:len(path) - 1], "");
+    last := path[len(path) - 1];
+    switch container := parent.(type) {
+    case map[string]any:
+        container[last] = value;
+    case []any:
+        index, _ := arrayIndex(last, len(container) - 1);
+        container[index] = value;
+    }
+
+    return document;
+}
+
+// decimal index without leading zeros, at most limit
+func arrayIndex(token string, limit int) (int, bool) {
+    if token == "" || (len(token) > 1 && token[0] == '0') {
+        return 0, false;
+    }
+
+    index, err := strconv.Atoi(token);
+    if err != nil || index < 0 || index > limit {
+        return 0, false;
+    }
+
+    return index, true;
+}
+
+// numbers stay json.Number, so they compare by value and keep precision
+func decode(data []byte) (any, error) {
+    decoder := json.NewDecoder(bytes.NewReader(data));
+    decoder.UseNumber();
+
+    var value any;
+    if err := decoder.Decode(&value); err != nil {
+        return nil, InvalidPatchError{};
+    }
+
+    return value, nil;
+}
+
+func clone(value any) any {
+    switch value := value.(type) {
+    case map[string]any:
+        copied := make(map[string]any, len(value));
+        for key, member := range value {
+            copied[key] = clone(member);
+        }
+        return copied;
+    case []any:
+        copied := make([]any, len(value));
+        for i, item := range value {
+            copied[i] = clone(item);
+        }
+        return copied;
+    default:
+        return value;
+    }
+}
+
+// equality of section 4.6, numbers are equal when their values are
+func equal(a any, b any) bool {
+    switch a := a.(type) {
+    case json.Number:
+        other, ok := b.(json.Number);
+        if !ok {
+            return false;
+        }
+        x, okX := new(big.Float).SetString(a.String());
+        y, okY := new(big.Float).SetString(other.String());
+        return okX && okY && x.Cmp(y) == 0;
+    case map[string]any:
+        other, ok := b.(map[string]any);
+        if !ok || len(a) != len(other) {
+            return false;
+        }
+        for key, member := range a {
+            otherMember, ok := other[key];
+            if !ok || !equal(member, otherMember) {
+                return false;
+            }
+        }
+        return true;
+    case []any:
+        other, ok := b.([]any);
+        if !ok || len(a) != len(other) {
+            return false;
+        }
+        for i := range a {
+            if !equal(a[i], other[i]) {
+                return false;
+            }
+        }
+        return true;
+    default:
+        return a == b;
+    }
+}
@@ -0,0 +1,108 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// plain text email
+type Message struct {
+    To      string
+    Subject string
+    Body    string
+}
+
+type Mailer interface {
+    Send(ctx context.Context, message Message) error
+}
+
+// delivers mail through an SMTP relay. Authentication is skipped when
+// username is empty
+type SmtpMailer struct {
+    addr string
+    from string
+    auth smtp.Auth
+}
+
+func NewSmtpMailer(host string, port int, username string, password string, from string) *SmtpMailer {
+    var auth smtp.Auth;
+    if username != "" {
+        auth = smtp.PlainAuth("", username, password, host);
+    }
+
+    return &SmtpMailer{
+        net.JoinHostPort(host, strconv.Itoa(port)),
+        from,
+        auth,
+    };
+}
+
+func (self *SmtpMailer) Send(ctx context.Context, message Message) error {
+    data, err := Format(self.from, message, time.Now());
+    if err != nil {
+        return err;
+    }
+
+    // net/smtp takes no context, give up waiting for it once ctx is done
+    done := make(chan error, 1);
+    go func() {
+        done <- smtp.SendMail(self.addr, self.auth, self.from, []string{ message.To }, data);
+    }();
+
+    select {
+    case err := <-done:
+        return err;
+    case <-ctx.Done():
+        return ctx.Err();
+    }
+}
+
+// writes mail to the log instead of sending it, for development
+type LogMailer struct {}
+
+func (self LogMailer) Send(_ context.Context, message Message) error {
+    slog.Info(
+        "Mail not sent, logging it instead",
+        "to", message.To,
+        "subject", message.Subject,
+        "body", message.Body,
+    );
+
+    return nil;
+}
+
+// renders message with headers. Header values must not contain line
+// breaks, those would let them inject headers
+func Format(from string, message Message, date time.Time) ([]byte, error) {
+    for _, value := range []string{ from, message.To, message.Subject } {
+        if strings.ContainsAny(value, "\r\n") {
+            return nil, InvalidHeaderError{};
+        }
+    }
+
+    var buf bytes.Buffer;
+    fmt.Fprintf(&buf, "From: %s\r\n", from);
+    fmt.Fprintf(&buf, "To: %s\r\n", message.To);
+    fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", message.Subject));
+    fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z));
+    buf.WriteString("MIME-Version: 1.0\r\n");
+    buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n");
+    buf.WriteString("\r\n");
+    body := strings.ReplaceAll(message.Body, "\r\n", "\n");
+    buf.WriteString(strings.ReplaceAll(body, "\n", "\r\n"));
+
+    return buf.Bytes(), nil;
+}
+
+type InvalidHeaderError struct {}
+func (self InvalidHeaderError) Error() string {
+    return "invalid_mail_header";
+}
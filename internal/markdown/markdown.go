@@ -0,0 +1,234 @@
+package markdown
+
+import (
+	"bytes"
+	"html"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+
+	"github.com/cxcnxl/go-crud/internal/entities"
+)
+
+// renders markdown to sanitized HTML. Raw HTML in the source is dropped,
+// urls are autolinked and #tags and @mentions become links
+func Render(source string) (string, error) {
+    var out bytes.Buffer;
+
+    err := renderer.Convert([]byte(source), &out);
+    if err != nil {
+        return "", err;
+    }
+
+    return policy.Sanitize(out.String()), nil;
+}
+
+// renders plain text to sanitized HTML, keeping line breaks and linking
+// #tags and @mentions
+func RenderPlain(source string) string {
+    escaped := html.EscapeString(strings.TrimSpace(source));
+
+    escaped = linkifyEscaped(escaped, entities.TagPattern, '#', tagUrl);
+    escaped = linkifyEscaped(escaped, entities.MentionPattern, '@', mentionUrl);
+
+    paragraphs := []string{};
+    for _, paragraph := range strings.Split(escaped, "\n\n") {
+        paragraph = strings.TrimSpace(paragraph);
+        if paragraph == "" {
+            continue;
+        }
+        paragraphs = append(
+            paragraphs,
+            "<p>" + strings.ReplaceAll(paragraph, "\n", "<br>\n") + "</p>",
+        );
+    }
+
+    return policy.Sanitize(strings.Join(paragraphs, "\n"));
+}
+
+func tagUrl(tag string) string {
+    return "/tags/" + url.PathEscape(entities.NormalizeTag(tag));
+}
+
+func mentionUrl(username string) string {
+    return "/users/" + url.PathEscape(username);
+}
+
+var renderer = goldmark.New(
+    goldmark.WithExtensions(
+        extension.Linkify,
+        extension.Strikethrough,
+    ),
+    goldmark.WithParserOptions(
+        parser.WithASTTransformers(
+            util.Prioritized(entityLinker{}, 100),
+        ),
+    ),
+);
+
+// strict allowlist applied to everything we render
+var policy = newPolicy();
+
+func newPolicy() *bluemonday.Policy {
+    p := bluemonday.NewPolicy();
+
+    p.AllowElements(
+        "p", "br", "hr", "em", "strong", "del", "blockquote", "pre", "code",
+        "ul", "ol", "li", "h1", "h2", "h3", "h4", "h5", "h6",
+    );
+    p.AllowAttrs("start").Matching(bluemonday.Integer).OnElements("ol");
+
+    p.AllowAttrs("href").OnElements("a");
+    p.AllowURLSchemes("http", "https", "mailto");
+    p.AllowRelativeURLs(true);
+    p.RequireParseableURLs(true);
+    p.RequireNoFollowOnLinks(true);
+    p.AddTargetBlankToFullyQualifiedLinks(true);
+    p.AllowAttrs("class").Matching(regexp.MustCompile(`^(hashtag|mention)$`)).OnElements("a");
+
+    // fenced code language for client side highlighting
+    p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[a-zA-Z0-9_+#-]{1,32}$`)).OnElements("code");
+
+    return p;
+}
+
+// turns #tags and @mentions in text nodes into links
+type entityLinker struct {}
+
+func (self entityLinker) Transform(doc *ast.Document, reader text.Reader, _ parser.Context) {
+    source := reader.Source();
+
+    texts := []*ast.Text{};
+    ast.Walk(doc, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+        if !entering {
+            return ast.WalkContinue, nil;
+        }
+
+        switch node := node.(type) {
+        case *ast.Link, *ast.AutoLink, *ast.CodeSpan, *ast.Image:
+            return ast.WalkSkipChildren, nil;
+        case *ast.Text:
+            texts = append(texts, node);
+        }
+
+        return ast.WalkContinue, nil;
+    });
+
+    for _, node := range texts {
+        linkifyText(node, source);
+    }
+}
+
+type entityMatch struct {
+    // offsets in source including the leading # or @
+    start int
+    stop  int
+    url   string
+    class string
+}
+
+func linkifyText(node *ast.Text, source []byte) {
+    segment := node.Segment;
+    value := segment.Value(source);
+
+    matches := []entityMatch{};
+    for _, pattern := range []struct {
+        re    *regexp.Regexp
+        url   func(string) string
+        class string
+    }{
+        { entities.TagPattern, tagUrl, "hashtag" },
+        { entities.MentionPattern, mentionUrl, "mention" },
+    } {
+        for _, loc := range pattern.re.FindAllSubmatchIndex(value, -1) {
+            name := string(value[loc[2]:loc[3]]);
+            matches = append(matches, entityMatch{
+                start: segment.Start + loc[2] - 1,
+                stop: segment.Start + loc[3],
+                url: pattern.url(name),
+                class: pattern.class,
+            });
+        }
+    }
+    if len(matches) == 0 {
+        return;
+    }
+
+    slices.SortFunc(matches, func(a entityMatch, b entityMatch) int {
+        return a.start - b.start;
+    });
+
+    parent := node.Parent();
+    prev := ast.Node(node);
+    pos := segment.Start;
+    insert := func(child ast.Node) {
+        parent.InsertAfter(parent, prev, child);
+        prev = child;
+    };
+
+    for _, match := range matches {
+        if match.start < pos {
+            // overlapping match
+            continue;
+        }
+        if match.start > pos {
+            insert(ast.NewTextSegment(text.NewSegment(pos, match.start)));
+        }
+
+        link := ast.NewLink();
+        link.Destination = []byte(match.url);
+        link.SetAttributeString("class", []byte(match.class));
+        link.AppendChild(link, ast.NewTextSegment(text.NewSegment(match.start, match.stop)));
+        insert(link);
+
+        pos = match.stop;
+    }
+
+    last := ast.NewTextSegment(text.NewSegment(pos, segment.Stop));
+    last.SetSoftLineBreak(node.SoftLineBreak());
+    last.SetHardLineBreak(node.HardLineBreak());
+    insert(last);
+
+    parent.RemoveChild(parent, node);
+}
+
+// linkifies entities in already escaped text
+func linkifyEscaped(
+    escaped string,
+    pattern *regexp.Regexp,
+    symbol byte,
+    toUrl func(string) string,
+) string {
+    var out strings.Builder;
+
+    pos := 0;
+    for _, loc := range pattern.FindAllStringSubmatchIndex(escaped, -1) {
+        start := loc[2] - 1;
+        name := escaped[loc[2]:loc[3]];
+
+        out.WriteString(escaped[pos:start]);
+        out.WriteString(`<a class="`);
+        if symbol == '#' {
+            out.WriteString("hashtag");
+        } else {
+            out.WriteString("mention");
+        }
+        out.WriteString(`" href="` + html.EscapeString(toUrl(name)) + `">`);
+        out.WriteString(escaped[start:loc[3]]);
+        out.WriteString("</a>");
+
+        pos = loc[3];
+    }
+    out.WriteString(escaped[pos:]);
+
+    return out.String();
+}
@@ -0,0 +1,97 @@
+package media
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"slices"
+	"strings"
+
+	"golang.org/x/image/draw"
+
+	_ "image/gif"
+	_ "image/png"
+)
+
+// content types accepted for attachments, detected from file contents
+// rather than trusting the client
+var AllowedTypes = []string{
+    "image/jpeg",
+    "image/png",
+    "image/gif",
+    "application/pdf",
+    "text/plain",
+};
+
+var imageTypes = []string{
+    "image/jpeg",
+    "image/png",
+    "image/gif",
+};
+
+// returns sniffed content type of data without parameters, or false when
+// the type is not allowed
+func DetectType(data []byte) (string, bool) {
+    contentType := http.DetectContentType(data);
+    contentType, _, _ = strings.Cut(contentType, ";");
+    contentType = strings.TrimSpace(contentType);
+
+    return contentType, slices.Contains(AllowedTypes, contentType);
+}
+
+func IsImage(contentType string) bool {
+    return slices.Contains(imageTypes, contentType);
+}
+
+// largest width x height Thumbnail decodes. Compressed images may declare
+// far more pixels than their size suggests, decoding those would take
+// gigabytes of memory
+const MaxPixels int = 40_000_000;
+
+// image with more than MaxPixels pixels
+type ImageTooLargeError struct {}
+func (self ImageTooLargeError) Error() string {
+    return "image_too_large";
+}
+
+// decodes image and scales it down to fit into size x size box, encoded as
+// jpeg. Images already smaller than the box are only re-encoded
+func Thumbnail(data []byte, size int) ([]byte, error) {
+    config, _, err := image.DecodeConfig(bytes.NewReader(data));
+    if err != nil {
+        return nil, err;
+    }
+    if config.Width <= 0 || config.Height <= 0 {
+        return nil, image.ErrFormat;
+    }
+    if config.Width > MaxPixels / config.Height {
+        return nil, ImageTooLargeError{};
+    }
+
+    src, _, err := image.Decode(bytes.NewReader(data));
+    if err != nil {
+        return nil, err;
+    }
+
+    bounds := src.Bounds();
+    width, height := bounds.Dx(), bounds.Dy();
+    if width == 0 || height == 0 {
+        return nil, image.ErrFormat;
+    }
+
+    scale := min(float64(size) / float64(width), float64(size) / float64(height), 1);
+    dstWidth := max(int(float64(width) * scale), 1);
+    dstHeight := max(int(float64(height) * scale), 1);
+
+    dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight));
+    draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil);
+
+    var out bytes.Buffer;
+    err = jpeg.Encode(&out, dst, &jpeg.Options{ Quality: 80 });
+    if err != nil {
+        return nil, err;
+    }
+
+    return out.Bytes(), nil;
+}
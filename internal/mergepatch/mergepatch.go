@@ -0,0 +1,55 @@
+package mergepatch
+
+import (
+	"encoding/json"
+)
+
+// ContentType of JSON merge patch documents, RFC 7386
+const ContentType string = "application/merge-patch+json";
+
+// applies merge patch to target document and returns the result. Members
+// set to null in the patch are removed, objects are merged recursively and
+// every other value replaces the target one
+func Apply(target []byte, patch []byte) ([]byte, error) {
+    var targetValue any;
+    if len(target) > 0 {
+        if err := json.Unmarshal(target, &targetValue); err != nil {
+            return nil, err;
+        }
+    }
+
+    var patchValue any;
+    if err := json.Unmarshal(patch, &patchValue); err != nil {
+        return nil, InvalidPatchError{};
+    }
+
+    return json.Marshal(merge(targetValue, patchValue));
+}
+
+func merge(target any, patch any) any {
+    patchObject, ok := patch.(map[string]any);
+    if !ok {
+        return patch;
+    }
+
+    targetObject, ok := target.(map[string]any);
+    if !ok {
+        targetObject = map[string]any{};
+    }
+
+    for key, value := range patchObject {
+        if value == nil {
+            delete(targetObject, key);
+            continue;
+        }
+
+        targetObject[key] = merge(targetObject[key], value);
+    }
+
+    return targetObject;
+}
+
+type InvalidPatchError struct {}
+func (self InvalidPatchError) Error() string {
+    return "invalid_patch";
+}
@@ -1,17 +1,18 @@
 package middleware
 
 import (
+	"bufio"
 	"context"
-	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"runtime/debug"
 	"strings"
 	"time"
 
 	"github.com/cxcnxl/go-crud/internal/auth_helpers"
-	"github.com/cxcnxl/go-crud/internal/responses"
+	"github.com/cxcnxl/go-crud/internal/problems"
 )
 
 type Middleware func(n http.HandlerFunc) http.HandlerFunc;
@@ -37,10 +38,29 @@ func Wrap (
 var UtilMiddleware = MiddlewareSet{
     RecovererMiddleware,
     LoggerMiddleware,
-    JSONResponserMiddleware, // (at least) for now all responses are JSON
+    JSONResponserMiddleware, // responses.Render negotiates other formats
 };
 
-var AuthMiddleware = append(UtilMiddleware, JWTAutherMiddleware);
+// fails when the account a valid token was issued to may not use it, e.g.
+// with an error of the problem catalog while it is suspended
+type AccountChecker func(ctx context.Context, userId uint) error;
+
+// middleware sets of routes reading the bearer token, which check passes
+type AuthSets struct {
+    Required MiddlewareSet
+    // for public routes whose response depends on the viewer when there is one
+    Optional MiddlewareSet
+    // for EventSource and WebSocket clients, which cannot set headers
+    Stream   MiddlewareSet
+}
+
+func NewAuthSets(check AccountChecker) AuthSets {
+    return AuthSets{
+        Required: UtilMiddleware.With(JWTAutherMiddleware(check)),
+        Optional: UtilMiddleware.With(OptionalJWTAutherMiddleware(check)),
+        Stream: UtilMiddleware.With(QueryTokenMiddleware, JWTAutherMiddleware(check)),
+    };
+}
 
 // --------- Implementations --------
 
@@ -76,55 +96,149 @@ func LoggerMiddleware(next http.HandlerFunc) http.HandlerFunc {
     });
 }
 
-func JWTAutherMiddleware(next http.HandlerFunc) http.HandlerFunc {
-    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-        throwUnauthorized := func() {
-            error := responses.NewErrorResponse("unauthorized");
-            w.Header().Add("Content-Type", "application/json");
-            w.WriteHeader(http.StatusUnauthorized);
-            w.Write(error.Json());
-        }
+func JWTAutherMiddleware(check AccountChecker) Middleware {
+    return func(next http.HandlerFunc) http.HandlerFunc {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            claims, err := Authenticate(r.Context(), r.Header.Get("Authorization"), check);
+            if err != nil {
+                problems.Write(w, r, err);
+                return;
+            }
 
-        auth := r.Header.Get("Authorization");
-        if auth == "" {
-            throwUnauthorized();
-            return;
-        }
+            ctx:= context.WithValue(r.Context(), "auth", claims);
+            r = r.WithContext(ctx);
 
-        auth = strings.TrimSpace(auth);
-        parts := strings.Split(auth, " ");
-        if len(parts) < 2 {
-            throwUnauthorized();
-            return;
-        }
+            next.ServeHTTP(w, r);
+        });
+    };
+}
 
-        token := parts[1];
-        claims, err := auth_helpers.DecodeJWT(token);
-        if err != nil && errors.Is(err, auth_helpers.InvalidJwtError{}) {
-            throwUnauthorized();
-            return;
+// returns claims of the bearer token in the Authorization header value.
+// Fails with a 401 problem for missing and bad tokens and with the error
+// of check for accounts it refuses. Shared by the HTTP and RPC transports
+func Authenticate(ctx context.Context, auth string, check AccountChecker) (map[string]any, error) {
+    unauthorized := problems.Status(http.StatusUnauthorized, "unauthorized");
+    if auth == "" {
+        return nil, unauthorized;
+    }
+
+    auth = strings.TrimSpace(auth);
+    parts := strings.Split(auth, " ");
+    if len(parts) < 2 {
+        return nil, unauthorized;
+    }
+
+    token := parts[1];
+    claims, err := auth_helpers.DecodeJWT(token);
+    if err != nil {
+        // malformed and expired tokens as well as bad signatures
+        return nil, unauthorized;
+    }
+
+    // json numbers are decoded as float64
+    if id, ok := claims["id"].(float64); ok {
+        if err := check(ctx, uint(id)); err != nil {
+            return nil, err;
         }
+    }
 
-        ctx:= context.WithValue(r.Context(), "auth", claims);
-        r = r.WithContext(ctx);
+    return claims, nil;
+}
+
+// lets requests without Authorization header through anonymously, the rest
+// go through JWTAutherMiddleware, so bad tokens are still rejected
+func OptionalJWTAutherMiddleware(check AccountChecker) Middleware {
+    return func(next http.HandlerFunc) http.HandlerFunc {
+        authed := JWTAutherMiddleware(check)(next);
+
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            if r.Header.Get("Authorization") == "" {
+                next.ServeHTTP(w, r);
+                return;
+            }
+
+            authed.ServeHTTP(w, r);
+        });
+    };
+}
+
+// moves ?access_token= into Authorization header when there is none
+func QueryTokenMiddleware(next http.HandlerFunc) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        token := r.URL.Query().Get("access_token");
+        if token != "" && r.Header.Get("Authorization") == "" {
+            r.Header.Set("Authorization", "Bearer " + token);
+        }
 
         next.ServeHTTP(w, r);
     });
 }
 
+// defaults responses to JSON. Handlers that set Content-Type themselves
+// (feeds, downloads, streams, responses.Render) keep theirs
 func JSONResponserMiddleware(next http.HandlerFunc) http.HandlerFunc {
     return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-        w.Header().Add("Content-Type", "application/json");
-        
-        next.ServeHTTP(w, r)
+        next.ServeHTTP(&contentTypeWriter{
+            ResponseWriter: w,
+            contentType: "application/json",
+        }, r);
     })
 }
 
+// sets default Content-Type right before headers are sent
+type contentTypeWriter struct {
+    http.ResponseWriter
+    contentType string
+    wroteHeader bool
+}
+
+func (self *contentTypeWriter) WriteHeader(status int) {
+    if !self.wroteHeader {
+        self.wroteHeader = true;
+        if self.Header().Get("Content-Type") == "" {
+            self.Header().Set("Content-Type", self.contentType);
+        }
+    }
+
+    self.ResponseWriter.WriteHeader(status);
+}
+
+func (self *contentTypeWriter) Write(data []byte) (int, error) {
+    if !self.wroteHeader {
+        self.WriteHeader(http.StatusOK);
+    }
+
+    return self.ResponseWriter.Write(data);
+}
+
+func (self *contentTypeWriter) Flush() {
+    if !self.wroteHeader {
+        self.WriteHeader(http.StatusOK);
+    }
+
+    if flusher, ok := self.ResponseWriter.(http.Flusher); ok {
+        flusher.Flush();
+    }
+}
+
+// websocket upgrades take the connection over
+func (self *contentTypeWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+    hijacker, ok := self.ResponseWriter.(http.Hijacker);
+    if !ok {
+        return nil, nil, http.ErrNotSupported;
+    }
+
+    return hijacker.Hijack();
+}
+
+func (self *contentTypeWriter) Unwrap() http.ResponseWriter {
+    return self.ResponseWriter;
+}
+
 func POSTHandlerMiddleware(next http.HandlerFunc) http.HandlerFunc {
     return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
         if r.Method != "POST" {
-            error := responses.NewErrorResponse("Only POST method allowed");
-            http.Error(w, error.JsonString(), http.StatusMethodNotAllowed);
+            problems.Write(w, r, problems.Status(http.StatusMethodNotAllowed, "Only POST method allowed"));
             return;
         }
 
@@ -135,8 +249,7 @@ func POSTHandlerMiddleware(next http.HandlerFunc) http.HandlerFunc {
 func GETHandlerMiddleware (next http.HandlerFunc) http.HandlerFunc {
     return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
         if r.Method != "GET" {
-            error := responses.NewErrorResponse("Only GET method allowed");
-            http.Error(w, error.JsonString(), http.StatusMethodNotAllowed);
+            problems.Write(w, r, problems.Status(http.StatusMethodNotAllowed, "Only GET method allowed"));
             return;
         }
 
@@ -147,8 +260,7 @@ func GETHandlerMiddleware (next http.HandlerFunc) http.HandlerFunc {
 func PUTHandlerMiddleware (next http.HandlerFunc) http.HandlerFunc {
     return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
         if r.Method != "PUT" {
-            error := responses.NewErrorResponse("Only PUT method allowed");
-            http.Error(w, error.JsonString(), http.StatusMethodNotAllowed);
+            problems.Write(w, r, problems.Status(http.StatusMethodNotAllowed, "Only PUT method allowed"));
             return;
         }
 
@@ -159,8 +271,7 @@ func PUTHandlerMiddleware (next http.HandlerFunc) http.HandlerFunc {
 func DELETEHandlerMiddleware (next http.HandlerFunc) http.HandlerFunc {
     return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
         if r.Method != "DELETE" {
-            error := responses.NewErrorResponse("Only DELETE method allowed");
-            http.Error(w, error.JsonString(), http.StatusMethodNotAllowed);
+            problems.Write(w, r, problems.Status(http.StatusMethodNotAllowed, "Only DELETE method allowed"));
             return;
         }
 
@@ -1,17 +1,300 @@
 package models
 
-import "time"
+import (
+	"time"
 
+	"gorm.io/gorm"
+)
+
+// previous username of a user. Nobody else can take it until
+// ReservedUntil, profile urls with it redirect to the current one meanwhile
+type UsernameChange struct {
+    ID            uint      `json:"id"`
+    UserID        uint      `gorm:"index" json:"-"`
+    OldUsername   string    `gorm:"size:64;index" json:"old_username"`
+    NewUsername   string    `gorm:"size:64" json:"new_username"`
+    ReservedUntil time.Time `json:"reserved_until"`
+    CreatedAt     time.Time `json:"created_at"`
+}
+
+// pending email change, applied once the link sent to NewEmail is opened.
+// Only the hash of the link token is stored
+type EmailChange struct {
+    ID          uint       `json:"id"`
+    UserID      uint       `gorm:"index" json:"-"`
+    NewEmail    string     `gorm:"size:255" json:"new_email"`
+    TokenHash   string     `gorm:"size:64;uniqueIndex" json:"-"`
+    ExpiresAt   time.Time  `json:"expires_at"`
+    ConfirmedAt *time.Time `json:"confirmed_at,omitempty"`
+    CreatedAt   time.Time  `json:"created_at"`
+}
+
+type UserRole string;
+
+const (
+    RoleUser  UserRole = "user";
+    // moderators, granted by hand in the database
+    RoleAdmin UserRole = "admin";
+)
+
+// users are embedded into many responses, so email is left out of json.
+// dto.UserViewDto adds it where the viewer may see it
 type User struct {
-    ID             uint     `json:"id"`
-    Email          string   `json:"email"`
-    Username       string   `json:"username"`
-    PasswordHashed string   `json:"-"`
+    ID             uint       `json:"id"`
+    Email          string     `gorm:"size:255;uniqueIndex" json:"-"`
+    Username       string     `gorm:"size:64;uniqueIndex" json:"username"`
+    PasswordHashed string     `json:"-"`
+    DisplayName    string     `gorm:"size:64" json:"display_name"`
+    Bio            string     `gorm:"size:500" json:"bio"`
+    AvatarUrl      string     `json:"avatar_url"`
+    Website        string     `json:"website"`
+    Locale         string     `gorm:"size:35" json:"locale"`
+    // lets everyone see the email on the public profile
+    ShowEmail      bool       `json:"show_email"`
+    FollowersCount int        `json:"followers_count"`
+    FollowingCount int        `json:"following_count"`
+    Role           UserRole   `gorm:"size:16;default:user" json:"-"`
+    SuspendedAt    *time.Time `json:"-"`
 }
 
+type PostStatus string;
+
+const (
+    PostStatusDraft     PostStatus = "draft";
+    PostStatusScheduled PostStatus = "scheduled";
+    PostStatusPublished PostStatus = "published";
+    // matched a moderation filter, waits for review
+    PostStatusHeld      PostStatus = "held";
+    // hidden by a moderator
+    PostStatusHidden    PostStatus = "hidden";
+)
+
+type PostVisibility string;
+
+const (
+    // listed everywhere
+    VisibilityPublic    PostVisibility = "public";
+    // author followers only
+    VisibilityFollowers PostVisibility = "followers";
+    // anyone with the link, kept out of tag pages and syndication feeds
+    VisibilityUnlisted  PostVisibility = "unlisted";
+    // author only
+    VisibilityPrivate   PostVisibility = "private";
+)
+
+type BodyFormat string;
+
+const (
+    BodyFormatPlain    BodyFormat = "plain";
+    BodyFormatMarkdown BodyFormat = "markdown";
+)
+
 type Post struct {
+    ID          uint           `json:"id"`
+    Body        string         `json:"body"`
+    BodyFormat  BodyFormat     `gorm:"size:16;default:plain" json:"body_format"`
+    // sanitized html rendered from Body, cached on every write
+    BodyHtml    string         `gorm:"type:text" json:"-"`
+    Status      PostStatus     `gorm:"size:16;index;default:published" json:"status"`
+    Visibility  PostVisibility `gorm:"size:16;index;default:public" json:"visibility"`
+    PublishAt   *time.Time     `gorm:"index" json:"publish_at,omitempty"`
+    PublishedAt *time.Time     `gorm:"index" json:"published_at,omitempty"`
+    CreatedAt   time.Time      `json:"created_at"`
+    UpdatedAt   time.Time      `json:"updated_at"`
+    DeletedAt   gorm.DeletedAt `gorm:"index" json:"deleted_at"`
+    AuthorID    uint           `gorm:"index" json:"-"`
+    Author      User           `gorm:"foreignKey:AuthorID" json:"author"`
+    Attachments []Attachment   `json:"-"`
+}
+
+// uploaded file of a post. Blob and its thumbnail live in the blob store
+// under BlobKey and ThumbnailKey
+type Attachment struct {
+    ID           uint      `json:"id"`
+    PostID       uint      `gorm:"index" json:"post_id"`
+    FileName     string    `json:"file_name"`
+    ContentType  string    `gorm:"size:128" json:"content_type"`
+    Size         int64     `json:"size"`
+    Private      bool      `json:"private"`
+    BlobKey      string    `json:"-"`
+    ThumbnailKey string    `json:"-"`
+    CreatedAt    time.Time `json:"created_at"`
+}
+
+// body of the post as it was before an edit
+type PostRevision struct {
+    ID         uint       `json:"id"`
+    PostID     uint       `gorm:"index" json:"post_id"`
+    Body       string     `json:"body"`
+    BodyFormat BodyFormat `gorm:"size:16;default:plain" json:"body_format"`
+    CreatedAt  time.Time  `json:"created_at"`
+}
+
+type Follow struct {
+    ID         uint      `json:"id"`
+    FollowerID uint      `gorm:"uniqueIndex:idx_follow" json:"follower_id"`
+    FolloweeID uint      `gorm:"uniqueIndex:idx_follow;index" json:"followee_id"`
+    CreatedAt  time.Time `json:"created_at"`
+}
+
+// hides content of both users from each other and keeps them from
+// interacting
+type Block struct {
+    ID        uint      `json:"id"`
+    BlockerID uint      `gorm:"uniqueIndex:idx_block" json:"blocker_id"`
+    BlockedID uint      `gorm:"uniqueIndex:idx_block;index" json:"blocked_id"`
+    CreatedAt time.Time `json:"created_at"`
+}
+
+// hides content of the muted user from the muter only
+type Mute struct {
+    ID        uint      `json:"id"`
+    MuterID   uint      `gorm:"uniqueIndex:idx_mute" json:"muter_id"`
+    MutedID   uint      `gorm:"uniqueIndex:idx_mute" json:"muted_id"`
+    CreatedAt time.Time `json:"created_at"`
+}
+
+// one row per (post, user, emoji), so each user can leave every kind of
+// reaction on a post at most once
+type Reaction struct {
     ID        uint      `json:"id"`
-    Body      string    `json:"body"`
+    PostID    uint      `gorm:"uniqueIndex:idx_reaction" json:"post_id"`
+    UserID    uint      `gorm:"uniqueIndex:idx_reaction" json:"user_id"`
+    Emoji     string    `gorm:"uniqueIndex:idx_reaction;size:32" json:"emoji"`
     CreatedAt time.Time `json:"created_at"`
-    Author    User      `gorm:"foreignKey:author" json:"author"`
+}
+
+// reaction totals written back from redis by the reconciler
+type ReactionCount struct {
+    PostID    uint      `gorm:"primaryKey;autoIncrement:false" json:"post_id"`
+    Emoji     string    `gorm:"primaryKey;size:32" json:"emoji"`
+    Count     int       `json:"count"`
+    UpdatedAt time.Time `json:"updated_at"`
+}
+
+type Tag struct {
+    ID   uint   `json:"id"`
+    Name string `gorm:"uniqueIndex;size:64" json:"name"`
+}
+
+type PostTag struct {
+    PostID uint `gorm:"primaryKey;autoIncrement:false"`
+    TagID  uint `gorm:"primaryKey;autoIncrement:false;index"`
+}
+
+// user mentioned in a post. NotifiedAt is set once the mention notification
+// went out, so edits do not notify twice
+type PostMention struct {
+    PostID     uint       `gorm:"primaryKey;autoIncrement:false"`
+    UserID     uint       `gorm:"primaryKey;autoIncrement:false;index"`
+    NotifiedAt *time.Time
+}
+
+type NotificationType string;
+
+const (
+    NotificationMention  NotificationType = "mention";
+    NotificationFollow   NotificationType = "follow";
+    NotificationReaction NotificationType = "reaction";
+)
+
+var NotificationTypes = []NotificationType{
+    NotificationMention,
+    NotificationFollow,
+    NotificationReaction,
+};
+
+type Notification struct {
+    ID        uint             `json:"id"`
+    UserID    uint             `gorm:"index" json:"-"`
+    Type      NotificationType `gorm:"size:32" json:"type"`
+    ActorID   uint             `json:"-"`
+    Actor     User             `gorm:"foreignKey:ActorID" json:"actor"`
+    PostID    *uint            `json:"post_id,omitempty"`
+    ReadAt    *time.Time       `gorm:"index" json:"read_at"`
+    CreatedAt time.Time        `json:"created_at"`
+}
+
+// opt-out of a notification type. Types without a row are enabled
+type NotificationPreference struct {
+    UserID  uint             `gorm:"primaryKey;autoIncrement:false"`
+    Type    NotificationType `gorm:"primaryKey;size:32"`
+    Enabled bool
+}
+
+// one-to-one or small group chat
+type Conversation struct {
+    ID            uint          `json:"id"`
+    CreatedByID   uint          `json:"created_by_id"`
+    Participants  []Participant `json:"participants"`
+    LastMessageAt *time.Time    `gorm:"index" json:"last_message_at"`
+    // "<user id>:<user id>" of one-to-one conversations, lower id first.
+    // Unique, so two users never get a second one
+    DirectKey     *string       `gorm:"size:64;uniqueIndex" json:"-"`
+    CreatedAt     time.Time     `json:"created_at"`
+}
+
+// member of a conversation. LastReadMessageID is the read receipt shown to
+// the other participants
+type Participant struct {
+    ConversationID    uint      `gorm:"primaryKey;autoIncrement:false" json:"-"`
+    UserID            uint      `gorm:"primaryKey;autoIncrement:false;index" json:"-"`
+    User              User      `gorm:"foreignKey:UserID" json:"user"`
+    LastReadMessageID uint      `json:"last_read_message_id"`
+    CreatedAt         time.Time `json:"joined_at"`
+}
+
+// Body is encrypted into SealedBody before saving and is never stored as
+// plain text
+type Message struct {
+    ID             uint      `json:"id"`
+    ConversationID uint      `gorm:"index" json:"conversation_id"`
+    SenderID       uint      `json:"sender_id"`
+    Body           string    `gorm:"-" json:"body"`
+    SealedBody     []byte    `gorm:"type:blob" json:"-"`
+    CreatedAt      time.Time `json:"created_at"`
+}
+
+type ReportStatus string;
+
+const (
+    ReportOpen     ReportStatus = "open";
+    ReportResolved ReportStatus = "resolved";
+)
+
+// complaint about a post or an account. Reports of held posts are filed by
+// the system and have no reporter
+type Report struct {
+    ID           uint         `json:"id"`
+    ReporterID   *uint        `json:"reporter_id,omitempty"`
+    PostID       *uint        `gorm:"index" json:"post_id,omitempty"`
+    UserID       uint         `gorm:"index" json:"user_id"`
+    Reason       string       `gorm:"size:1000" json:"reason"`
+    Status       ReportStatus `gorm:"size:16;index" json:"status"`
+    Resolution   string       `gorm:"size:32" json:"resolution,omitempty"`
+    ResolvedByID *uint        `json:"resolved_by_id,omitempty"`
+    ResolvedAt   *time.Time   `json:"resolved_at,omitempty"`
+    CreatedAt    time.Time    `json:"created_at"`
+}
+
+// keyword or regex holding matching new posts for review
+type ModerationFilter struct {
+    ID          uint      `json:"id"`
+    Pattern     string    `gorm:"size:255" json:"pattern"`
+    IsRegex     bool      `json:"is_regex"`
+    CreatedByID uint      `json:"created_by_id"`
+    CreatedAt   time.Time `json:"created_at"`
+}
+
+// audit trail entry of a moderator action
+type ModerationAction struct {
+    ID          uint      `json:"id"`
+    ModeratorID uint      `gorm:"index" json:"moderator_id"`
+    Action      string    `gorm:"size:32" json:"action"`
+    ReportID    *uint     `json:"report_id,omitempty"`
+    PostID      *uint     `json:"post_id,omitempty"`
+    UserID      *uint     `json:"user_id,omitempty"`
+    FilterID    *uint     `json:"filter_id,omitempty"`
+    Note        string    `gorm:"size:1000" json:"note,omitempty"`
+    CreatedAt   time.Time `gorm:"index" json:"created_at"`
 }
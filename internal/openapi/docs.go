@@ -0,0 +1,7 @@
+package openapi
+
+import _ "embed"
+
+// page rendering the spec served next to it as openapi.json
+//go:embed docs.html
+var DocsPage []byte;
@@ -0,0 +1,306 @@
+package openapi
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/cxcnxl/go-crud/internal/codec"
+	"github.com/cxcnxl/go-crud/internal/problems"
+)
+
+const Version = "3.1.0";
+
+// description of a route, given to routes.MethodHandler when the route is
+// registered. Routes registered without one are left out of the spec
+type Doc struct {
+    Summary      string
+    // request body, nil when the route reads none. Schema values are used
+    // as they are, anything else is reflected
+    Request      any
+    // content type of the request body, application/json when empty
+    RequestType  string
+    // data of the response envelope, nil when the route answers without
+    // content. Schema values describe bare bodies of ResponseType instead
+    Response     any
+    // kind of the envelope data
+    Kind         string
+    // content type of bare response bodies, application/json when empty
+    ResponseType string
+    // status of successful responses. Defaults to 200, or 204 without
+    // Response
+    Status       int
+    // query params by name, mapped to their description
+    Query        map[string]string
+    // reads cursor pagination params, see routes.pageParams
+    Paginated    bool
+    // the cursor is a "<time>_<id>" keyset rather than an id, see
+    // routes.keysetParams
+    Keyset       bool
+}
+
+type Auth int;
+
+const (
+    AuthNone Auth = iota;
+    AuthRequired;
+    // anonymous callers are let in, the response depends on the caller
+    // when there is one
+    AuthOptional;
+)
+
+// registered route, Doc is nil for undocumented ones
+type Route struct {
+    Method string
+    Path   string
+    Auth   Auth
+    Doc    *Doc
+}
+
+type Document struct {
+    OpenAPI    string              `json:"openapi"`
+    Info       Info                `json:"info"`
+    Paths      map[string]PathItem `json:"paths"`
+    Components Components          `json:"components"`
+}
+
+type Info struct {
+    Title   string `json:"title"`
+    Version string `json:"version"`
+}
+
+// operations of a path by lowercase method
+type PathItem map[string]*Operation;
+
+type Operation struct {
+    OperationID string                `json:"operationId"`
+    Summary     string                `json:"summary,omitempty"`
+    Tags        []string              `json:"tags,omitempty"`
+    Parameters  []Parameter           `json:"parameters,omitempty"`
+    RequestBody *RequestBody          `json:"requestBody,omitempty"`
+    Responses   map[string]Response   `json:"responses"`
+    Security    []map[string][]string `json:"security,omitempty"`
+}
+
+type Parameter struct {
+    Name        string `json:"name"`
+    In          string `json:"in"`
+    Description string `json:"description,omitempty"`
+    Required    bool   `json:"required,omitempty"`
+    Schema      Schema `json:"schema"`
+}
+
+type RequestBody struct {
+    Required bool                 `json:"required"`
+    Content  map[string]MediaType `json:"content"`
+}
+
+type MediaType struct {
+    Schema Schema `json:"schema"`
+}
+
+type Response struct {
+    Description string               `json:"description"`
+    Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type Components struct {
+    Schemas         map[string]Schema `json:"schemas"`
+    SecuritySchemes map[string]Schema `json:"securitySchemes"`
+}
+
+// JSON Schema, as OpenAPI 3.1 uses it
+type Schema map[string]any;
+
+const bearerAuth = "bearerAuth";
+
+// builds spec of documented routes
+func Build(title string, version string, routes []Route) Document {
+    schemas := newSchemaBuilder();
+    document := Document{
+        OpenAPI: Version,
+        Info: Info{ title, version },
+        Paths: map[string]PathItem{},
+    };
+
+    for _, route := range routes {
+        if route.Doc == nil {
+            continue;
+        }
+
+        path := PathOf(route.Path);
+        item, ok := document.Paths[path];
+        if !ok {
+            item = PathItem{};
+            document.Paths[path] = item;
+        }
+        item[strings.ToLower(route.Method)] = buildOperation(schemas, route, path);
+    }
+
+    document.Components = Components{
+        Schemas: schemas.components,
+        SecuritySchemes: map[string]Schema{
+            bearerAuth: {
+                "type": "http",
+                "scheme": "bearer",
+                "bearerFormat": "JWT",
+            },
+        },
+    };
+
+    return document;
+}
+
+// turns ServeMux pattern into OpenAPI path template
+func PathOf(pattern string) string {
+    return strings.ReplaceAll(strings.TrimSuffix(pattern, "{$}"), "...}", "}");
+}
+
+func buildOperation(schemas *schemaBuilder, route Route, path string) *Operation {
+    doc := route.Doc;
+    operation := &Operation{
+        OperationID: operationId(route.Method, path),
+        Summary: doc.Summary,
+        Responses: map[string]Response{},
+    };
+
+    segments := strings.Split(strings.Trim(path, "/"), "/");
+    if segments[0] != "" && !strings.HasPrefix(segments[0], "{") {
+        operation.Tags = []string{ segments[0] };
+    }
+
+    for _, segment := range segments {
+        if !strings.HasPrefix(segment, "{") || !strings.HasSuffix(segment, "}") {
+            continue;
+        }
+
+        name := strings.Trim(segment, "{}");
+        schema := Schema{ "type": "string" };
+        if name == "id" || strings.HasSuffix(name, "_id") {
+            schema = Schema{ "type": "integer", "minimum": 1 };
+        }
+        operation.Parameters = append(operation.Parameters, Parameter{
+            Name: name,
+            In: "path",
+            Required: true,
+            Schema: schema,
+        });
+    }
+
+    if doc.Paginated {
+        operation.Parameters = append(operation.Parameters,
+            Parameter{
+                Name: "before",
+                In: "query",
+                Description: "next_cursor of the previous page",
+                Schema: cursorSchema(doc),
+            },
+            Parameter{
+                Name: "limit",
+                In: "query",
+                Description: "page size",
+                Schema: Schema{ "type": "integer", "minimum": 1, "maximum": 100 },
+            },
+        );
+    }
+
+    names := make([]string, 0, len(doc.Query));
+    for name := range doc.Query {
+        names = append(names, name);
+    }
+    sort.Strings(names);
+    for _, name := range names {
+        operation.Parameters = append(operation.Parameters, Parameter{
+            Name: name,
+            In: "query",
+            Description: doc.Query[name],
+            Schema: Schema{ "type": "string" },
+        });
+    }
+
+    if doc.Request != nil {
+        content := map[string]MediaType{};
+        if doc.RequestType != "" {
+            content[doc.RequestType] = MediaType{ schemas.of(doc.Request) };
+        } else {
+            content = negotiated(schemas.of(doc.Request));
+        }
+        operation.RequestBody = &RequestBody{ Required: true, Content: content };
+    }
+
+    status := doc.Status;
+    if status == 0 {
+        status = 200;
+        if doc.Response == nil {
+            status = 204;
+        }
+    }
+
+    response := Response{ Description: "Success" };
+    if schema, ok := doc.Response.(Schema); ok {
+        response.Content = map[string]MediaType{
+            orDefault(doc.ResponseType, "application/json"): { schema },
+        };
+    } else if doc.Response != nil {
+        response.Content = negotiated(schemas.envelope(doc.Kind, doc.Response));
+    }
+    operation.Responses[strconv.Itoa(status)] = response;
+    failures := negotiated(schemas.ref(envelopeType));
+    failures[problems.ContentType] = MediaType{ schemas.problem() };
+    operation.Responses["default"] = Response{ Description: "Error", Content: failures };
+
+    switch route.Auth {
+    case AuthRequired:
+        operation.Security = []map[string][]string{ { bearerAuth: {} } };
+    case AuthOptional:
+        operation.Security = []map[string][]string{ {}, { bearerAuth: {} } };
+    }
+
+    return operation;
+}
+
+// e.g. getPostsIdRevisions for GET /posts/{id}/revisions
+func operationId(method string, path string) string {
+    var id strings.Builder;
+    id.WriteString(strings.ToLower(method));
+
+    words := strings.FieldsFunc(path, func(r rune) bool {
+        return !unicode.IsLetter(r) && !unicode.IsDigit(r);
+    });
+    for _, word := range words {
+        runes := []rune(word);
+        runes[0] = unicode.ToUpper(runes[0]);
+        id.WriteString(string(runes));
+    }
+
+    return id.String();
+}
+
+// the schema in every format of codec, bodies and envelopes are
+// negotiated
+func negotiated(schema Schema) map[string]MediaType {
+    content := map[string]MediaType{};
+    for _, contentType := range codec.ContentTypes() {
+        content[contentType] = MediaType{ schema };
+    }
+
+    return content;
+}
+
+func orDefault(value string, fallback string) string {
+    if value == "" {
+        return fallback;
+    }
+
+    return value;
+}
+
+// schema of the before param
+func cursorSchema(doc *Doc) Schema {
+    if doc.Keyset {
+        return Schema{ "type": "string", "pattern": "^[0-9]+_[0-9]+$" };
+    }
+
+    return Schema{ "type": "integer", "minimum": 0 };
+}
@@ -0,0 +1,296 @@
+package openapi
+
+import (
+	"encoding"
+	"database/sql"
+	"encoding/json"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cxcnxl/go-crud/internal/responses"
+)
+
+var (
+    timeType      = reflect.TypeOf(time.Time{});
+    nullTimeType  = reflect.TypeOf(sql.NullTime{});
+    marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem();
+    textType      = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem();
+    envelopeType  = reflect.TypeOf(responses.Response{});
+)
+
+// reflects Go types into schemas. Named structs become components
+// referenced by name, the rest is inlined
+type schemaBuilder struct {
+    components map[string]Schema
+    names      map[reflect.Type]string
+}
+
+func newSchemaBuilder() *schemaBuilder {
+    return &schemaBuilder{
+        components: map[string]Schema{},
+        names: map[reflect.Type]string{},
+    };
+}
+
+// schema of value, Schema values are taken as they are
+func (self *schemaBuilder) of(value any) Schema {
+    if schema, ok := value.(Schema); ok {
+        return schema;
+    }
+
+    return self.schemaOf(reflect.TypeOf(value));
+}
+
+// responses.Response carrying data of the kind
+func (self *schemaBuilder) envelope(kind string, data any) Schema {
+    return Schema{
+        "allOf": []Schema{
+            self.ref(envelopeType),
+            {
+                "type": "object",
+                "properties": Schema{
+                    "data": Schema{
+                        "type": "object",
+                        "properties": Schema{
+                            "kind": Schema{ "const": kind },
+                            "data": self.of(data),
+                        },
+                    },
+                },
+            },
+        },
+    };
+}
+
+func (self *schemaBuilder) schemaOf(t reflect.Type) Schema {
+    if t == nil {
+        return Schema{};
+    }
+
+    switch {
+    case t == timeType:
+        return Schema{ "type": "string", "format": "date-time" };
+    case t.ConvertibleTo(nullTimeType):
+        return Schema{ "type": []string{ "string", "null" }, "format": "date-time" };
+    case t.Kind() != reflect.Pointer && t.Implements(marshalerType):
+        // encoded by its own rules, nothing to reflect
+        return Schema{};
+    case t.Kind() != reflect.Pointer && t.Implements(textType):
+        return Schema{ "type": "string" };
+    }
+
+    switch t.Kind() {
+    case reflect.Pointer:
+        return nullable(self.schemaOf(t.Elem()));
+    case reflect.Bool:
+        return Schema{ "type": "boolean" };
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+        return Schema{ "type": "integer" };
+    case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+        return Schema{ "type": "integer", "minimum": 0 };
+    case reflect.Float32, reflect.Float64:
+        return Schema{ "type": "number" };
+    case reflect.String:
+        return Schema{ "type": "string" };
+    case reflect.Slice:
+        if t.Elem().Kind() == reflect.Uint8 {
+            return Schema{ "type": "string", "contentEncoding": "base64" };
+        }
+        return Schema{ "type": "array", "items": self.schemaOf(t.Elem()) };
+    case reflect.Array:
+        return Schema{
+            "type": "array",
+            "items": self.schemaOf(t.Elem()),
+            "minItems": t.Len(),
+            "maxItems": t.Len(),
+        };
+    case reflect.Map:
+        return Schema{ "type": "object", "additionalProperties": self.schemaOf(t.Elem()) };
+    case reflect.Struct:
+        if t.Name() == "" {
+            return self.structSchema(t);
+        }
+        return self.ref(t);
+    default:
+        return Schema{};
+    }
+}
+
+// RFC 9457 problem details, which clients get instead of the envelope when
+// they ask for them, see problems.Write. Cataloged errors may add members
+func (self *schemaBuilder) problem() Schema {
+    if _, ok := self.components["Problem"]; !ok {
+        self.components["Problem"] = Schema{
+            "type": "object",
+            "properties": Schema{
+                "type": Schema{ "type": "string", "format": "uri" },
+                "title": Schema{ "type": "string" },
+                "status": Schema{ "type": "integer" },
+                "detail": Schema{ "type": "string" },
+                "instance": Schema{ "type": "string" },
+                "code": Schema{ "type": "string" },
+            },
+            "required": []string{ "type", "title", "status", "code" },
+        };
+    }
+
+    return Schema{ "$ref": "#/components/schemas/Problem" };
+}
+
+// reference to the component of a named struct, building it on first use
+func (self *schemaBuilder) ref(t reflect.Type) Schema {
+    name, ok := self.names[t];
+    if !ok {
+        name = self.componentName(t);
+        self.names[t] = name;
+        // recursive types find the name before their schema is done
+        self.components[name] = Schema{};
+        self.components[name] = self.structSchema(t);
+    }
+
+    return Schema{ "$ref": "#/components/schemas/" + name };
+}
+
+// object with properties of the exported fields, as encoding/json sees
+// them. Fields of embedded structs are promoted, outer fields shadow them
+func (self *schemaBuilder) structSchema(t reflect.Type) Schema {
+    properties := Schema{};
+    required := map[string]bool{};
+    self.addFields(properties, required, t);
+
+    schema := Schema{ "type": "object", "properties": properties };
+    if len(required) > 0 {
+        names := make([]string, 0, len(required));
+        for name := range required {
+            names = append(names, name);
+        }
+        sort.Strings(names);
+        schema["required"] = names;
+    }
+
+    return schema;
+}
+
+func (self *schemaBuilder) addFields(properties Schema, required map[string]bool, t reflect.Type) {
+    own := []reflect.StructField{};
+    for i := 0; i < t.NumField(); i++ {
+        field := t.Field(i);
+        name, _, _ := strings.Cut(field.Tag.Get("json"), ",");
+        if name == "-" {
+            continue;
+        }
+
+        embedded := field.Type;
+        if embedded.Kind() == reflect.Pointer {
+            embedded = embedded.Elem();
+        }
+        if field.Anonymous && name == "" && embedded.Kind() == reflect.Struct {
+            self.addFields(properties, required, embedded);
+            continue;
+        }
+        if !field.IsExported() {
+            continue;
+        }
+
+        own = append(own, field);
+    }
+
+    for _, field := range own {
+        name, _, _ := strings.Cut(field.Tag.Get("json"), ",");
+        if name == "" {
+            name = field.Name;
+        }
+        properties[name] = constrain(self.schemaOf(field.Type), field.Tag);
+        if slices.Contains(strings.Split(field.Tag.Get("validate"), ","), "required") {
+            required[name] = true;
+        } else {
+            delete(required, name);
+        }
+    }
+}
+
+// adds constraints of validation tags, see validation.Struct. Custom rules
+// have no schema counterpart and are left out
+func constrain(schema Schema, tag reflect.StructTag) Schema {
+    rules := tag.Get("validate");
+    pattern := tag.Get("pattern");
+    if rules == "" && pattern == "" {
+        return schema;
+    }
+
+    constrained := Schema{};
+    for key, value := range schema {
+        constrained[key] = value;
+    }
+
+    text := schema["type"] == "string";
+    for _, rule := range strings.Split(rules, ",") {
+        name, param, _ := strings.Cut(rule, "=");
+        bound, err := strconv.ParseFloat(param, 64);
+
+        switch {
+        case name == "email" && text:
+            constrained["format"] = "email";
+        case name == "min" && err == nil && text:
+            constrained["minLength"] = bound;
+        case name == "max" && err == nil && text:
+            constrained["maxLength"] = bound;
+        case name == "min" && err == nil && schema["type"] == "array":
+            constrained["minItems"] = bound;
+        case name == "max" && err == nil && schema["type"] == "array":
+            constrained["maxItems"] = bound;
+        case name == "min" && err == nil:
+            constrained["minimum"] = bound;
+        case name == "max" && err == nil:
+            constrained["maximum"] = bound;
+        }
+    }
+    if pattern != "" && text {
+        constrained["pattern"] = pattern;
+    }
+
+    return constrained;
+}
+
+// e.g. PageDto_Post for dto.PageDto[models.Post]
+func (self *schemaBuilder) componentName(t reflect.Type) string {
+    name := t.Name();
+    if base, args, ok := strings.Cut(name, "["); ok {
+        parts := []string{ base };
+        for _, arg := range strings.Split(strings.TrimSuffix(args, "]"), ",") {
+            arg = strings.Trim(arg, "*[] ");
+            parts = append(parts, arg[strings.LastIndex(arg, ".") + 1:]);
+        }
+        name = strings.Join(parts, "_");
+    }
+
+    if _, taken := self.components[name]; taken {
+        pkg := t.PkgPath();
+        name = pkg[strings.LastIndex(pkg, "/") + 1:] + "." + name;
+    }
+
+    return name;
+}
+
+func nullable(schema Schema) Schema {
+    if _, ok := schema["$ref"]; ok {
+        return Schema{ "anyOf": []Schema{ schema, { "type": "null" } } };
+    }
+
+    kind, ok := schema["type"].(string);
+    if !ok {
+        return schema;
+    }
+
+    nullable := Schema{};
+    for key, value := range schema {
+        nullable[key] = value;
+    }
+    nullable["type"] = []string{ kind, "null" };
+
+    return nullable;
+}
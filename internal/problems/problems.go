@@ -0,0 +1,329 @@
+package problems
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/text/language"
+
+	"github.com/cxcnxl/go-crud/internal/responses"
+	"github.com/cxcnxl/go-crud/internal/validation"
+)
+
+// media type of RFC 9457 problem details
+const ContentType = "application/problem+json";
+
+// prefix of type URIs of cataloged problems
+const TypePrefix = "urn:go-crud:problem:";
+
+// catalog entry of an error
+type Entry struct {
+    // stable machine code, e.g. "duplicate_user_email"
+    Code       string
+    Status     int
+    // short English summary, the same for every occurrence of the code
+    Title      string
+    // extension members taken from the error, e.g. the invalid field.
+    // Optional
+    Extensions func(err error) map[string]any
+}
+
+// problem details document. Extensions are written as top level members
+type Problem struct {
+    Type       string
+    Title      string
+    Status     int
+    Detail     string
+    Instance   string
+    Code       string
+    Extensions map[string]any
+}
+
+func (self Problem) MarshalJSON() ([]byte, error) {
+    members := map[string]any{};
+    for name, value := range self.Extensions {
+        members[name] = value;
+    }
+
+    members["type"] = self.Type;
+    members["title"] = self.Title;
+    members["status"] = self.Status;
+    members["code"] = self.Code;
+    if self.Detail != "" {
+        members["detail"] = self.Detail;
+    }
+    if self.Instance != "" {
+        members["instance"] = self.Instance;
+    }
+
+    return json.Marshal(members);
+}
+
+// plain HTTP status with a message, for failures found by the handlers
+// themselves such as malformed ids. The message is what envelopes carry
+type StatusError struct {
+    Status  int
+    Message string
+}
+
+func (self StatusError) Error() string {
+    return self.Message;
+}
+
+func Status(status int, message string) StatusError {
+    return StatusError{ status, message };
+}
+
+// e.g. "method_not_allowed" for 405
+func (self StatusError) entry() Entry {
+    text := http.StatusText(self.Status);
+
+    return Entry{
+        Code: strings.ToLower(strings.ReplaceAll(text, " ", "_")),
+        Status: self.Status,
+        Title: text,
+    };
+}
+
+// missing row or object, named after what was looked for. Routes turn
+// gorm.ErrRecordNotFound into it
+type NotFoundError struct {
+    Resource string
+}
+
+func (self NotFoundError) Error() string {
+    if self.Resource == "" {
+        return "not_found";
+    }
+
+    return self.Resource + "_not_found";
+}
+
+var (
+    catalogMu sync.RWMutex;
+    // errors compared by type, e.g. marker structs
+    byType    = map[reflect.Type]Entry{};
+    // errors compared by identity, e.g. gorm.ErrRecordNotFound
+    sentinels = []sentinel{};
+)
+
+type sentinel struct {
+    target error
+    entry  Entry
+}
+
+func init() {
+    Register(NotFoundError{}, Entry{
+        Code: "not_found",
+        Status: http.StatusNotFound,
+        Title: "Resource not found",
+        Extensions: func(err error) map[string]any {
+            resource := err.(NotFoundError).Resource;
+            if resource == "" {
+                return nil;
+            }
+            return map[string]any{ "resource": resource };
+        },
+    });
+}
+
+// adds target to the catalog. Pointer errors match themselves, anything
+// else every error of its type, whatever its fields. Meant for init
+// functions
+func Register(target error, entry Entry) {
+    catalogMu.Lock();
+    defer catalogMu.Unlock();
+
+    t := reflect.TypeOf(target);
+    if t.Kind() == reflect.Pointer {
+        sentinels = append(sentinels, sentinel{ target, entry });
+        return;
+    }
+
+    byType[t] = entry;
+}
+
+// entry of the first cataloged error in the chain of err, with that error
+func Lookup(err error) (Entry, error, bool) {
+    if err == nil {
+        return Entry{}, nil, false;
+    }
+
+    if status, ok := err.(StatusError); ok {
+        return status.entry(), err, true;
+    }
+
+    catalogMu.RLock();
+    t := reflect.TypeOf(err);
+    entry, ok := byType[t];
+    if !ok && t.Kind() == reflect.Pointer {
+        for _, sentinel := range sentinels {
+            if err == sentinel.target {
+                entry, ok = sentinel.entry, true;
+                break;
+            }
+        }
+    }
+    catalogMu.RUnlock();
+    if ok {
+        return entry, err, true;
+    }
+
+    switch wrapped := err.(type) {
+    case interface{ Unwrap() error }:
+        return Lookup(wrapped.Unwrap());
+    case interface{ Unwrap() []error }:
+        for _, inner := range wrapped.Unwrap() {
+            if entry, matched, ok := Lookup(inner); ok {
+                return entry, matched, true;
+            }
+        }
+    }
+
+    return Entry{}, nil, false;
+}
+
+var (
+    titlesMu  sync.RWMutex;
+    // English is the fallback, it comes first
+    languages = []language.Tag{ language.English };
+    titles    = map[language.Tag]map[string]string{};
+    matcher   = language.NewMatcher(languages);
+)
+
+// adds titles in the language by code. Codes left out fall back to the
+// English title of their entry
+func Translate(tag language.Tag, translated map[string]string) {
+    titlesMu.Lock();
+    defer titlesMu.Unlock();
+
+    if _, ok := titles[tag]; !ok {
+        titles[tag] = map[string]string{};
+        if tag != language.English {
+            languages = append(languages, tag);
+            matcher = language.NewMatcher(languages);
+        }
+    }
+    for code, title := range translated {
+        titles[tag][code] = title;
+    }
+}
+
+// title of the entry in the language the request prefers
+func localize(r *http.Request, entry Entry) (string, language.Tag) {
+    titlesMu.RLock();
+    defer titlesMu.RUnlock();
+
+    _, index := language.MatchStrings(matcher, r.Header.Get("Accept-Language"));
+    tag := languages[index];
+    if title, ok := titles[tag][entry.Code]; ok {
+        return title, tag;
+    }
+
+    return entry.Title, language.English;
+}
+
+// writes err as problem details when the request prefers them to JSON,
+// as the response envelope in the negotiated format otherwise. Errors missing from the catalog are
+// logged and answered with 500
+func Write(w http.ResponseWriter, r *http.Request, err error) {
+    entry, matched, ok := Lookup(err);
+    if !ok {
+        slog.Error(err.Error());
+        matched = Status(http.StatusInternalServerError, "Internal server error");
+        entry, _, _ = Lookup(matched);
+    }
+
+    // titles are localized
+    w.Header().Add("Vary", "Accept-Language");
+    if WantsProblem(r) {
+        writeProblem(w, r, entry, matched);
+        return;
+    }
+
+    response := responses.NewErrorResponse(matched.Error());
+    var fields validation.Errors;
+    if errors.As(matched, &fields) {
+        response = responses.NewValidationErrorResponse(fields);
+    }
+
+    responses.RenderStatus(w, r, entry.Status, response);
+}
+
+func writeProblem(w http.ResponseWriter, r *http.Request, entry Entry, err error) {
+    problem, tag := build(r, entry, err);
+    body, marshalErr := json.Marshal(problem);
+    if marshalErr != nil {
+        panic(marshalErr);
+    }
+
+    w.Header().Set("Content-Type", ContentType);
+    w.Header().Set("Content-Language", tag.String());
+    w.Header().Add("Vary", "Accept");
+    w.WriteHeader(entry.Status);
+    w.Write(body);
+}
+
+func build(r *http.Request, entry Entry, err error) (Problem, language.Tag) {
+    title, tag := localize(r, entry);
+    problem := Problem{
+        Type: TypePrefix + entry.Code,
+        Title: title,
+        Status: entry.Status,
+        Instance: r.URL.Path,
+        Code: entry.Code,
+    };
+
+    // RFC 9457 types problems that say no more than their status as
+    // about:blank
+    if _, ok := err.(StatusError); ok {
+        problem.Type = "about:blank";
+    }
+
+    message := err.Error();
+    if message != entry.Code && !strings.EqualFold(message, entry.Title) {
+        problem.Detail = message;
+    }
+    if entry.Extensions != nil {
+        problem.Extensions = entry.Extensions(err);
+    }
+
+    return problem, tag;
+}
+
+// whether the Accept header ranks problem details at least as high as
+// JSON. Clients that do not name them get the envelope
+func WantsProblem(r *http.Request) bool {
+    problem, plain := 0.0, 0.0;
+
+    for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+        mediaType, params, err := mime.ParseMediaType(part);
+        if err != nil {
+            continue;
+        }
+
+        quality := 1.0;
+        if value, ok := params["q"]; ok {
+            quality, err = strconv.ParseFloat(value, 64);
+            if err != nil {
+                continue;
+            }
+        }
+
+        switch mediaType {
+        case ContentType:
+            problem = max(problem, quality);
+        case "application/json":
+            plain = max(plain, quality);
+        }
+    }
+
+    return problem > 0 && problem >= plain;
+}
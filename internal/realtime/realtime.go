@@ -0,0 +1,127 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cxcnxl/go-crud/internal/redis"
+)
+
+// number of events buffered per connection. A client that falls this far
+// behind is disconnected and expected to resume with its last event id
+const SubscriberBuffer int = 64;
+
+// local registry of realtime connections, fed with events of all server
+// instances through redis pub/sub
+type Hub struct {
+    mu          sync.RWMutex
+    subscribers map[uint]map[*Subscriber]struct{}
+}
+
+type Subscriber struct {
+    UserID  uint
+    Events  chan redis.StreamEvent
+    // closed when the subscriber was dropped for being too slow
+    Dropped chan struct{}
+    once    sync.Once
+}
+
+func NewHub() *Hub {
+    return &Hub{
+        subscribers: make(map[uint]map[*Subscriber]struct{}),
+    };
+}
+
+func (self *Hub) Subscribe(userId uint) *Subscriber {
+    subscriber := &Subscriber{
+        UserID: userId,
+        Events: make(chan redis.StreamEvent, SubscriberBuffer),
+        Dropped: make(chan struct{}),
+    };
+
+    self.mu.Lock();
+    defer self.mu.Unlock();
+
+    if self.subscribers[userId] == nil {
+        self.subscribers[userId] = make(map[*Subscriber]struct{});
+    }
+    self.subscribers[userId][subscriber] = struct{}{};
+
+    return subscriber;
+}
+
+func (self *Hub) Unsubscribe(subscriber *Subscriber) {
+    self.mu.Lock();
+    defer self.mu.Unlock();
+
+    subscribers := self.subscribers[subscriber.UserID];
+    delete(subscribers, subscriber);
+    if len(subscribers) == 0 {
+        delete(self.subscribers, subscriber.UserID);
+    }
+}
+
+// hands event to local subscribers of its user without blocking
+func (self *Hub) Dispatch(event redis.StreamEvent) {
+    self.mu.RLock();
+    defer self.mu.RUnlock();
+
+    for subscriber := range self.subscribers[event.UserID] {
+        select {
+        case subscriber.Events <- event:
+        default:
+            subscriber.drop();
+        }
+    }
+}
+
+// receives events from redis until ctx is done. Pub/sub connection is
+// re-established by the client itself, events missed meanwhile are picked
+// up by clients resuming from their last event id
+func (self *Hub) Run(ctx context.Context, rdb *redis.RedisWrapper) {
+    pubsub := rdb.SubscribeEvents(ctx);
+    go func() {
+        <-ctx.Done();
+        pubsub.Close();
+    }();
+
+    for message := range pubsub.Channel() {
+        var event redis.StreamEvent;
+        err := json.Unmarshal([]byte(message.Payload), &event);
+        if err != nil {
+            slog.Error("Error decoding realtime event: " + err.Error());
+            continue;
+        }
+
+        self.Dispatch(event);
+    }
+}
+
+func (self *Subscriber) drop() {
+    self.once.Do(func() {
+        close(self.Dropped);
+    });
+}
+
+// reports whether stream id a comes after b. Empty id is before everything
+func IdAfter(a string, b string) bool {
+    aMs, aSeq := splitId(a);
+    bMs, bSeq := splitId(b);
+
+    if aMs != bMs {
+        return aMs > bMs;
+    }
+    return aSeq > bSeq;
+}
+
+func splitId(id string) (uint64, uint64) {
+    msPart, seqPart, _ := strings.Cut(id, "-");
+    ms, _ := strconv.ParseUint(msPart, 10, 64);
+    seq, _ := strconv.ParseUint(seqPart, 10, 64);
+
+    return ms, seq;
+}
@@ -2,7 +2,9 @@ package redis
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"slices"
 	"strconv"
 	"time"
 
@@ -84,6 +86,471 @@ func (self *RedisWrapper) SetLoginBlocked(id uint, blocked bool) error {
     ).Err();
 }
 
+// returns reaction counters of the post; ok is false when the counters are
+// not cached and have to be loaded from the database. Hashes without the
+// marker field were not written by SetReactionCounts and count as missing
+func (self *RedisWrapper) GetReactionCounts(postId uint) (map[string]int, bool, error) {
+    vals, err := self.rdb.HGetAll(self.ctx, self.reactionCountsKey(postId)).Result();
+    if err != nil {
+        return nil, false, err;
+    }
+
+    if _, ok := vals[reactionCountsMarker]; !ok {
+        return map[string]int{}, false, nil;
+    }
+
+    counts := make(map[string]int, len(vals));
+    for emoji, val := range vals {
+        if emoji == reactionCountsMarker {
+            continue;
+        }
+
+        count, err := strconv.Atoi(val);
+        if err != nil {
+            return nil, false, err;
+        }
+        if count > 0 {
+            counts[emoji] = count;
+        }
+    }
+
+    return counts, true, nil;
+}
+
+func (self *RedisWrapper) SetReactionCounts(postId uint, counts map[string]int) error {
+    key := self.reactionCountsKey(postId);
+
+    // marker field keeps the hash alive for posts without reactions, so
+    // they are not reloaded from the database on every read
+    fields := map[string]any{ reactionCountsMarker: "1" };
+    for emoji, count := range counts {
+        fields[emoji] = count;
+    }
+
+    pipe := self.rdb.TxPipeline();
+    pipe.Del(self.ctx, key);
+    pipe.HSet(self.ctx, key, fields);
+    pipe.Expire(self.ctx, key, reactionCountsTTL);
+    _, err := pipe.Exec(self.ctx);
+
+    return err;
+}
+
+// changes reaction counter by delta and marks the post for reconciliation.
+// Returns false without touching anything when counters are not cached
+func (self *RedisWrapper) IncrReactionCount(
+    postId uint,
+    emoji string,
+    delta int,
+) (bool, error) {
+    cached, err := incrReactionCountScript.Run(
+        self.ctx,
+        self.rdb,
+        []string{ self.reactionCountsKey(postId), reactionsDirtyKey },
+        reactionCountsMarker,
+        emoji,
+        delta,
+        int(reactionCountsTTL.Seconds()),
+        postId,
+    ).Int();
+
+    return cached == 1, err;
+}
+
+func (self *RedisWrapper) MarkReactionsDirty(postId uint) error {
+    return self.rdb.SAdd(self.ctx, reactionsDirtyKey, postId).Err();
+}
+
+// pops up to limit posts whose counters changed since last reconciliation
+func (self *RedisWrapper) PopDirtyReactionPosts(limit int) ([]uint, error) {
+    vals, err := self.rdb.SPopN(self.ctx, reactionsDirtyKey, int64(limit)).Result();
+    if err != nil {
+        if err == rdb.Nil {
+            return []uint{}, nil;
+        }
+        return nil, err;
+    }
+
+    return parseIds(vals);
+}
+
+type TimelineEntry struct {
+    PostID uint
+    // publication time of the post in unix milliseconds
+    Score  int64
+}
+
+// reports whether the entry comes after other in a timeline, which is
+// ordered newest first and by post id among posts published in the same
+// millisecond
+func (self TimelineEntry) OlderThan(other TimelineEntry) bool {
+    return self.Score < other.Score || (self.Score == other.Score && self.PostID < other.PostID);
+}
+
+// merges two entry lists sorted newest first, dropping duplicates that
+// appear when an account crosses celebrity threshold
+func MergeTimelineEntries(a []TimelineEntry, b []TimelineEntry) []TimelineEntry {
+    merged := make([]TimelineEntry, 0, len(a) + len(b));
+    seen := make(map[uint]bool, len(a) + len(b));
+
+    i, j := 0, 0;
+    for i < len(a) || j < len(b) {
+        var next TimelineEntry;
+        if j >= len(b) || (i < len(a) && !a[i].OlderThan(b[j])) {
+            next = a[i];
+            i++;
+        } else {
+            next = b[j];
+            j++;
+        }
+
+        if seen[next.PostID] {
+            continue;
+        }
+        seen[next.PostID] = true;
+        merged = append(merged, next);
+    }
+
+    return merged;
+}
+
+// pushes post into timelines of the users. Timelines that are not cached
+// are skipped, they get the post when rebuilt from the database
+func (self *RedisWrapper) AddToTimelines(userIds []uint, entry TimelineEntry) error {
+    pipe := self.rdb.Pipeline();
+    for _, userId := range userIds {
+        addToTimelineScript.Eval(
+            self.ctx,
+            pipe,
+            []string{ self.timelineKey(userId) },
+            timelineMember(entry.PostID),
+            entry.Score,
+            TimelineSize,
+        );
+    }
+
+    _, err := pipe.Exec(self.ctx);
+    return err;
+}
+
+// returns up to limit entries of the timeline older than before, newest
+// first. Zero before means from the top. trimmed reports that the timeline
+// holds TimelineSize entries, so older posts may have been dropped from it.
+// ok is false when the timeline is not cached
+func (self *RedisWrapper) GetTimeline(
+    userId uint,
+    before TimelineEntry,
+    limit int,
+) (entries []TimelineEntry, trimmed bool, ok bool, err error) {
+    vals, err := getTimelineScript.Run(
+        self.ctx,
+        self.rdb,
+        []string{ self.timelineKey(userId) },
+        before.Score,
+        timelineMember(before.PostID),
+        limit,
+    ).StringSlice();
+    if err != nil {
+        if err == rdb.Nil {
+            return []TimelineEntry{}, false, false, nil;
+        }
+        return nil, false, false, err;
+    }
+
+    size, err := strconv.Atoi(vals[0]);
+    if err != nil {
+        return nil, false, false, err;
+    }
+
+    entries = make([]TimelineEntry, 0, len(vals) / 2);
+    for i := 1; i + 1 < len(vals); i += 2 {
+        id, err := strconv.ParseUint(vals[i], 10, 64);
+        if err != nil {
+            return nil, false, false, err;
+        }
+        score, err := strconv.ParseFloat(vals[i + 1], 64);
+        if err != nil {
+            return nil, false, false, err;
+        }
+
+        entries = append(entries, TimelineEntry{
+            PostID: uint(id),
+            Score: int64(score),
+        });
+    }
+
+    return entries, size >= TimelineSize, true, nil;
+}
+
+func (self *RedisWrapper) SetTimeline(userId uint, entries []TimelineEntry) error {
+    key := self.timelineKey(userId);
+
+    // sentinel keeps empty timelines cached
+    members := []rdb.Z{{ Score: 0, Member: timelineSentinel }};
+    for _, entry := range entries {
+        members = append(members, rdb.Z{
+            Score: float64(entry.Score),
+            Member: timelineMember(entry.PostID),
+        });
+    }
+
+    pipe := self.rdb.TxPipeline();
+    pipe.Del(self.ctx, key);
+    pipe.ZAdd(self.ctx, key, members...);
+    pipe.Expire(self.ctx, key, timelineTTL);
+    _, err := pipe.Exec(self.ctx);
+
+    return err;
+}
+
+func (self *RedisWrapper) DeleteTimeline(userId uint) error {
+    return self.rdb.Del(self.ctx, self.timelineKey(userId)).Err();
+}
+
+// returns unread notifications counter, ok is false when it is not cached
+func (self *RedisWrapper) GetUnreadNotifications(userId uint) (int, bool, error) {
+    val, err := self.rdb.Get(self.ctx, self.unreadNotificationsKey(userId)).Result();
+    if err != nil {
+        if err == rdb.Nil {
+            return 0, false, nil;
+        }
+        return 0, false, err;
+    }
+
+    count, err := strconv.Atoi(val);
+    if err != nil {
+        return 0, false, err;
+    }
+
+    return count, true, nil;
+}
+
+func (self *RedisWrapper) SetUnreadNotifications(userId uint, count int) error {
+    return self.rdb.SetEx(
+        self.ctx,
+        self.unreadNotificationsKey(userId),
+        count,
+        unreadNotificationsTTL,
+    ).Err();
+}
+
+// changes cached unread counter by delta, uncached counters are left to be
+// counted on next read
+func (self *RedisWrapper) IncrUnreadNotifications(userId uint, delta int) error {
+    return incrIfExistsScript.Run(
+        self.ctx,
+        self.rdb,
+        []string{ self.unreadNotificationsKey(userId) },
+        delta,
+    ).Err();
+}
+
+// returns ids of users blocked by or blocking the user; ok is false when
+// they are not cached. version is to be passed to SetBlockedUsers
+func (self *RedisWrapper) GetBlockedUsers(userId uint) ([]uint, int64, bool, error) {
+    return self.getIdSet(self.blockedUsersKey(userId), userId);
+}
+
+// caches ids loaded after GetBlockedUsers returned version. They are
+// dropped when relations of the user changed in between, so a slow reader
+// does not put back what DeleteUserRelations just removed
+func (self *RedisWrapper) SetBlockedUsers(userId uint, ids []uint, version int64) error {
+    return self.setIdSet(self.blockedUsersKey(userId), userId, ids, version);
+}
+
+// returns ids of users muted by the user; ok is false when they are not
+// cached. version is to be passed to SetMutedUsers
+func (self *RedisWrapper) GetMutedUsers(userId uint) ([]uint, int64, bool, error) {
+    return self.getIdSet(self.mutedUsersKey(userId), userId);
+}
+
+// see SetBlockedUsers
+func (self *RedisWrapper) SetMutedUsers(userId uint, ids []uint, version int64) error {
+    return self.setIdSet(self.mutedUsersKey(userId), userId, ids, version);
+}
+
+// drops cached blocks and mutes of the users, they are reloaded on next
+// read. Unread notifications counters leave out hidden actors, so they go
+// too
+func (self *RedisWrapper) DeleteUserRelations(userIds ...uint) error {
+    pipe := self.rdb.TxPipeline();
+    for _, userId := range userIds {
+        pipe.Incr(self.ctx, self.relationsVersionKey(userId));
+        pipe.Expire(self.ctx, self.relationsVersionKey(userId), userRelationsTTL);
+        pipe.Del(
+            self.ctx,
+            self.blockedUsersKey(userId),
+            self.mutedUsersKey(userId),
+            self.unreadNotificationsKey(userId),
+        );
+    }
+    _, err := pipe.Exec(self.ctx);
+
+    return err;
+}
+
+func (self *RedisWrapper) getIdSet(key string, userId uint) ([]uint, int64, bool, error) {
+    pipe := self.rdb.Pipeline();
+    // read first, changes after it make the later write a no-op
+    version := pipe.Get(self.ctx, self.relationsVersionKey(userId));
+    members := pipe.SMembers(self.ctx, key);
+    _, err := pipe.Exec(self.ctx);
+    if err != nil && err != rdb.Nil {
+        return nil, 0, false, err;
+    }
+
+    current, err := version.Int64();
+    if err != nil && err != rdb.Nil {
+        return nil, 0, false, err;
+    }
+
+    vals := members.Val();
+    if len(vals) == 0 {
+        return []uint{}, current, false, nil;
+    }
+
+    ids, err := parseIds(vals);
+    if err != nil {
+        return nil, 0, false, err;
+    }
+
+    return slices.DeleteFunc(ids, func(id uint) bool { return id == 0 }), current, true, nil;
+}
+
+func (self *RedisWrapper) setIdSet(key string, userId uint, ids []uint, version int64) error {
+    // sentinel keeps empty sets cached
+    args := []any{ version, int(userRelationsTTL.Seconds()), idSetSentinel };
+    for _, id := range ids {
+        args = append(args, id);
+    }
+
+    return setIdSetScript.Run(
+        self.ctx,
+        self.rdb,
+        []string{ key, self.relationsVersionKey(userId) },
+        args...,
+    ).Err();
+}
+
+// event delivered to a user over realtime connections. ID is the id of
+// the entry in the user event stream, used to resume after reconnects
+type StreamEvent struct {
+    ID     string          `json:"id"`
+    UserID uint            `json:"user_id"`
+    Type   string          `json:"type"`
+    Data   json.RawMessage `json:"data"`
+}
+
+// appends event to streams of the users and announces it to every server
+// instance over pub/sub
+func (self *RedisWrapper) PublishEvent(userIds []uint, eventType string, data []byte) error {
+    pipe := self.rdb.Pipeline();
+    for _, userId := range userIds {
+        publishEventScript.Eval(
+            self.ctx,
+            pipe,
+            []string{ self.eventStreamKey(userId) },
+            eventStreamLength,
+            eventType,
+            string(data),
+            eventsChannel,
+            userId,
+        );
+    }
+
+    _, err := pipe.Exec(self.ctx);
+    return err;
+}
+
+// returns up to limit events of the user stream after lastId
+func (self *RedisWrapper) GetEventsAfter(
+    userId uint,
+    lastId string,
+    limit int,
+) ([]StreamEvent, error) {
+    messages, err := self.rdb.XRangeN(
+        self.ctx,
+        self.eventStreamKey(userId),
+        "(" + lastId,
+        "+",
+        int64(limit),
+    ).Result();
+    if err != nil {
+        return nil, err;
+    }
+
+    events := make([]StreamEvent, 0, len(messages));
+    for _, message := range messages {
+        eventType, _ := message.Values["type"].(string);
+        data, _ := message.Values["data"].(string);
+
+        events = append(events, StreamEvent{
+            ID: message.ID,
+            UserID: userId,
+            Type: eventType,
+            Data: json.RawMessage(data),
+        });
+    }
+
+    return events, nil;
+}
+
+// subscribes to events published by any server instance
+func (self *RedisWrapper) SubscribeEvents(ctx context.Context) *rdb.PubSub {
+    return self.rdb.Subscribe(ctx, eventsChannel);
+}
+
+// returns cached suspension flag of the user; ok is false on cache miss
+func (self *RedisWrapper) GetUserSuspended(userId uint) (bool, bool, error) {
+    val, err := self.rdb.Get(self.ctx, self.userSuspendedKey(userId)).Result();
+    if err != nil {
+        if err == rdb.Nil {
+            return false, false, nil;
+        }
+        return false, false, err;
+    }
+
+    return val == "true", true, nil;
+}
+
+func (self *RedisWrapper) SetUserSuspended(userId uint, suspended bool) error {
+    suspendedStr := "false";
+    if suspended {
+        suspendedStr = "true";
+    }
+
+    return self.rdb.SetEx(
+        self.ctx,
+        self.userSuspendedKey(userId),
+        suspendedStr,
+        5 * time.Minute,
+    ).Err();
+}
+
+// returns the GraphQL query stored under its sha256 hash; ok is false when
+// it is unknown or expired
+func (self *RedisWrapper) GetPersistedQuery(hash string) (string, bool, error) {
+    val, err := self.rdb.Get(self.ctx, self.persistedQueryKey(hash)).Result();
+    if err != nil {
+        if err == rdb.Nil {
+            return "", false, nil;
+        }
+        return "", false, err;
+    }
+
+    return val, true, nil;
+}
+
+// stores the query for a day, every hit extends it
+func (self *RedisWrapper) SetPersistedQuery(hash string, query string) error {
+    return self.rdb.SetEx(
+        self.ctx,
+        self.persistedQueryKey(hash),
+        query,
+        24 * time.Hour,
+    ).Err();
+}
+
 func (self *RedisWrapper) loginAttemptsKey(id uint) string {
     return fmt.Sprintf("auth:login_attempts:%d", id);
 }
@@ -91,3 +558,148 @@ func (self *RedisWrapper) loginAttemptsKey(id uint) string {
 func (self *RedisWrapper) loginBlockedKey(id uint) string {
     return fmt.Sprintf("auth:login_blocked:%d", id);
 }
+
+func (self *RedisWrapper) userSuspendedKey(id uint) string {
+    return fmt.Sprintf("auth:suspended:%d", id);
+}
+
+func (self *RedisWrapper) blockedUsersKey(userId uint) string {
+    return fmt.Sprintf("users:blocked:%d", userId);
+}
+
+func (self *RedisWrapper) mutedUsersKey(userId uint) string {
+    return fmt.Sprintf("users:muted:%d", userId);
+}
+
+func (self *RedisWrapper) relationsVersionKey(userId uint) string {
+    return fmt.Sprintf("users:relations:version:%d", userId);
+}
+
+func (self *RedisWrapper) reactionCountsKey(postId uint) string {
+    return fmt.Sprintf("posts:reactions:%d", postId);
+}
+
+func (self *RedisWrapper) timelineKey(userId uint) string {
+    return fmt.Sprintf("timeline:v2:%d", userId);
+}
+
+func (self *RedisWrapper) unreadNotificationsKey(userId uint) string {
+    return fmt.Sprintf("notifications:unread:%d", userId);
+}
+
+func (self *RedisWrapper) persistedQueryKey(hash string) string {
+    return fmt.Sprintf("graphql:persisted:%s", hash);
+}
+
+func (self *RedisWrapper) eventStreamKey(userId uint) string {
+    return fmt.Sprintf("events:user:%d", userId);
+}
+
+func parseIds(vals []string) ([]uint, error) {
+    ids := make([]uint, 0, len(vals));
+    for _, val := range vals {
+        id, err := strconv.ParseUint(val, 10, 64);
+        if err != nil {
+            return nil, err;
+        }
+        ids = append(ids, uint(id));
+    }
+
+    return ids, nil;
+}
+
+// number of newest posts kept in a cached timeline
+const TimelineSize int = 800;
+
+const timelineSentinel string = "0";
+const timelineTTL = 72 * time.Hour;
+
+// post ids are zero padded, redis orders members of equal score by their
+// bytes and timelines order posts of the same millisecond by id
+func timelineMember(postId uint) string {
+    return fmt.Sprintf("%020d", postId);
+}
+
+// number of entries in the timeline followed by the entries older than
+// (ARGV[1], ARGV[2]), which are after the ones of the same score with a
+// member of at least ARGV[2]. Returns nil when the timeline is not cached
+var getTimelineScript = rdb.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+    return false
+end
+local max = "+inf"
+local skip = 0
+if ARGV[1] ~= "0" then
+    max = ARGV[1]
+    for _, member in ipairs(redis.call("ZRANGEBYSCORE", KEYS[1], ARGV[1], ARGV[1])) do
+        if member >= ARGV[2] then
+            skip = skip + 1
+        end
+    end
+end
+-- sentinel member has score 0
+local entries = redis.call("ZREVRANGEBYSCORE", KEYS[1], max, "(0", "WITHSCORES", "LIMIT", skip, ARGV[3])
+table.insert(entries, 1, tostring(redis.call("ZCARD", KEYS[1]) - 1))
+return entries
+`);
+
+var addToTimelineScript = rdb.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+    return 0
+end
+redis.call("ZADD", KEYS[1], ARGV[2], ARGV[1])
+redis.call("ZREMRANGEBYRANK", KEYS[1], 1, -(tonumber(ARGV[3]) + 2))
+return 1
+`);
+
+const unreadNotificationsTTL = 24 * time.Hour;
+
+var incrIfExistsScript = rdb.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+    return 0
+end
+return redis.call("INCRBY", KEYS[1], ARGV[1])
+`);
+
+const idSetSentinel string = "0";
+
+// replaces the set unless the version changed since it was read
+var setIdSetScript = rdb.NewScript(`
+if (redis.call("GET", KEYS[2]) or "0") ~= ARGV[1] then
+    return 0
+end
+redis.call("DEL", KEYS[1])
+redis.call("SADD", KEYS[1], unpack(ARGV, 3))
+redis.call("EXPIRE", KEYS[1], ARGV[2])
+return 1
+`);
+const userRelationsTTL = 24 * time.Hour;
+
+const eventsChannel string = "events";
+
+// approximate number of recent events kept per user for resuming
+const eventStreamLength int = 500;
+
+// adds event to the stream and publishes it with the id it got
+var publishEventScript = rdb.NewScript(`
+local id = redis.call("XADD", KEYS[1], "MAXLEN", "~", ARGV[1], "*", "type", ARGV[2], "data", ARGV[3])
+redis.call("EXPIRE", KEYS[1], 86400)
+redis.call("PUBLISH", ARGV[4], '{"id":"' .. id .. '","user_id":' .. ARGV[5] .. ',"type":"' .. ARGV[2] .. '","data":' .. ARGV[3] .. '}')
+return id
+`);
+
+const reactionsDirtyKey string = "posts:reactions:dirty";
+const reactionCountsMarker string = "_";
+
+// counters expiring between a check and the increment would leave a hash
+// with a single counter, so both happen in one script
+var incrReactionCountScript = rdb.NewScript(`
+if redis.call("HEXISTS", KEYS[1], ARGV[1]) == 0 then
+    return 0
+end
+redis.call("HINCRBY", KEYS[1], ARGV[2], ARGV[3])
+redis.call("EXPIRE", KEYS[1], ARGV[4])
+redis.call("SADD", KEYS[2], ARGV[5])
+return 1
+`);
+const reactionCountsTTL = 24 * time.Hour;
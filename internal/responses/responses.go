@@ -1,6 +1,11 @@
 package responses
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/cxcnxl/go-crud/internal/codec"
+)
 
 type Response struct {
 	Status string        `json:"status"`
@@ -42,3 +47,34 @@ func NewDataResponse(kind string, data any) Response {
 		},
 	}
 }
+
+// failed validation rule by field, sent with status 422
+func NewValidationErrorResponse(fields map[string]string) Response {
+	return Response{
+		Status: "error",
+		Error:  "validation_failed",
+		Data: &ResponseData{
+			Kind: "validation_errors",
+			Data: fields,
+		},
+	}
+}
+
+// writes the response in the format the Accept header prefers, see
+// codec.Negotiate
+func Render(w http.ResponseWriter, r *http.Request, response Response) {
+    RenderStatus(w, r, http.StatusOK, response);
+}
+
+func RenderStatus(w http.ResponseWriter, r *http.Request, status int, response Response) {
+    format := codec.Negotiate(r.Header.Get("Accept"));
+    body, err := format.Marshal(response);
+    if err != nil {
+        panic(err);
+    }
+
+    w.Header().Set("Content-Type", format.ContentType);
+    w.Header().Add("Vary", "Accept");
+    w.WriteHeader(status);
+    w.Write(body);
+}
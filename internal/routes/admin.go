@@ -0,0 +1,324 @@
+package routes
+
+import (
+	"net/http"
+
+	"gorm.io/gorm"
+
+	"github.com/cxcnxl/go-crud/internal/app_service"
+	"github.com/cxcnxl/go-crud/internal/dto"
+	"github.com/cxcnxl/go-crud/internal/middleware"
+	"github.com/cxcnxl/go-crud/internal/openapi"
+	"github.com/cxcnxl/go-crud/internal/models"
+	"github.com/cxcnxl/go-crud/internal/problems"
+	"github.com/cxcnxl/go-crud/internal/responses"
+)
+
+func registerModerationRoutes(methodHandler *MethodHandler, service *appservice.AppService) {
+    auth := middleware.NewAuthSets(service.CheckActiveUser);
+    methodHandler.HandleFunc(
+        "POST",
+        "/posts/{id}/report",
+        routeReportPost(service),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Report post to moderators",
+            Request: dto.ReportDto{},
+            Response: models.Report{},
+            Kind: "report",
+            Status: http.StatusCreated,
+        },
+    );
+    methodHandler.HandleFunc(
+        "POST",
+        "/users/{id}/report",
+        routeReportUser(service),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Report user to moderators",
+            Request: dto.ReportDto{},
+            Response: models.Report{},
+            Kind: "report",
+            Status: http.StatusCreated,
+        },
+    );
+    methodHandler.HandleFunc(
+        "GET",
+        "/admin/reports",
+        routeReports(service),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Review queue",
+            Response: dto.PageDto[models.Report]{},
+            Kind: "reports",
+            Paginated: true,
+            Query: map[string]string{ "status": "open, the default, or resolved" },
+        },
+    );
+    methodHandler.HandleFunc(
+        "POST",
+        "/admin/reports/{id}/actions",
+        routeResolveReport(service),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Resolve report",
+            Request: dto.ModerationActionDto{},
+            Response: models.Report{},
+            Kind: "report",
+        },
+    );
+    methodHandler.HandleFunc(
+        "POST",
+        "/admin/users/{id}/suspend",
+        routeSuspendUser(service, true),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Suspend user",
+            Request: dto.ModerationActionDto{},
+        },
+    );
+    methodHandler.HandleFunc(
+        "POST",
+        "/admin/users/{id}/unsuspend",
+        routeSuspendUser(service, false),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Lift suspension of user",
+            Request: dto.ModerationActionDto{},
+        },
+    );
+    RegisterResource(methodHandler, service, moderationFilterResource(service));
+    methodHandler.HandleFunc(
+        "GET",
+        "/admin/audit",
+        routeModerationAudit(service),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Moderation actions, newest first",
+            Response: dto.PageDto[models.ModerationAction]{},
+            Kind: "actions",
+            Paginated: true,
+        },
+    );
+}
+
+func routeReportPost(service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        defer r.Body.Close();
+
+        postId, userId, ok := postTarget(w, r);
+        if !ok {
+            return;
+        }
+
+        data, ok := readReport(w, r);
+        if !ok {
+            return;
+        }
+
+        report, err := service.ReportPost(userId, postId, data.Reason);
+        if err != nil {
+            writeModerationError(w, r, err);
+            return;
+        }
+
+        response := responses.NewDataResponse("report", report);
+        responses.RenderStatus(w, r, http.StatusCreated, response);
+    });
+}
+
+func routeReportUser(service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        defer r.Body.Close();
+
+        targetId, userId, ok := userTarget(w, r);
+        if !ok {
+            return;
+        }
+
+        data, ok := readReport(w, r);
+        if !ok {
+            return;
+        }
+
+        report, err := service.ReportUser(userId, targetId, data.Reason);
+        if err != nil {
+            writeModerationError(w, r, err);
+            return;
+        }
+
+        response := responses.NewDataResponse("report", report);
+        responses.RenderStatus(w, r, http.StatusCreated, response);
+    });
+}
+
+// lists the review queue, ?status=resolved shows handled reports
+func routeReports(service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        userId, ok := authUserId(r);
+        if !ok {
+            problems.Write(w, r, problems.Status(http.StatusUnauthorized, "unauthorized"));
+            return;
+        }
+
+        status := models.ReportStatus(r.URL.Query().Get("status"));
+        if status == "" {
+            status = models.ReportOpen;
+        }
+        before, limit := pageParams(r);
+
+        page, err := service.GetReports(userId, status, before, limit);
+        if err != nil {
+            writeModerationError(w, r, err);
+            return;
+        }
+
+        response := responses.NewDataResponse("reports", page);
+        responses.Render(w, r, response);
+    });
+}
+
+func routeResolveReport(service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        defer r.Body.Close();
+
+        reportId, ok := pathId(r, "id");
+        if !ok {
+            problems.Write(w, r, problems.Status(http.StatusBadRequest, "Invalid report id"));
+            return;
+        }
+
+        userId, ok := authUserId(r);
+        if !ok {
+            problems.Write(w, r, problems.Status(http.StatusUnauthorized, "unauthorized"));
+            return;
+        }
+
+        var data dto.ModerationActionDto;
+        if !decodeValid(w, r, &data) {
+            return;
+        }
+
+        report, err := service.ResolveReport(userId, reportId, data);
+        if err != nil {
+            writeModerationError(w, r, err);
+            return;
+        }
+
+        response := responses.NewDataResponse("report", report);
+        responses.Render(w, r, response);
+    });
+}
+
+// suspends or lifts suspension of the user, body may carry {"note": ".."}
+func routeSuspendUser(service *appservice.AppService, suspend bool) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        defer r.Body.Close();
+
+        targetId, userId, ok := userTarget(w, r);
+        if !ok {
+            return;
+        }
+
+        body, err := readBody(w, r);
+        if err != nil {
+            problems.Write(w, r, err);
+            return;
+        }
+
+        var data dto.ModerationActionDto;
+        if len(body) > 0 {
+            if !validBody(w, r, body, &data) {
+                return;
+            }
+        }
+
+        if suspend {
+            err = service.SuspendUser(userId, targetId, data.Note);
+        } else {
+            err = service.UnsuspendUser(userId, targetId, data.Note);
+        }
+        if err != nil {
+            writeModerationError(w, r, err);
+            return;
+        }
+
+        w.WriteHeader(http.StatusNoContent);
+    });
+}
+
+func routeModerationAudit(service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        userId, ok := authUserId(r);
+        if !ok {
+            problems.Write(w, r, problems.Status(http.StatusUnauthorized, "unauthorized"));
+            return;
+        }
+
+        before, limit := pageParams(r);
+
+        page, err := service.GetModerationAudit(userId, before, limit);
+        if err != nil {
+            writeModerationError(w, r, err);
+            return;
+        }
+
+        response := responses.NewDataResponse("actions", page);
+        responses.Render(w, r, response);
+    });
+}
+
+func readReport(w http.ResponseWriter, r *http.Request) (dto.ReportDto, bool) {
+    var data dto.ReportDto;
+    ok := decodeValid(w, r, &data);
+
+    return data, ok;
+}
+
+// keyword and regex filters holding matching posts for review, managed
+// by moderators only
+func moderationFilterResource(
+    service *appservice.AppService,
+) Resource[models.ModerationFilter, dto.ModerationFilterDto, struct{}] {
+    auth := middleware.NewAuthSets(service.CheckActiveUser);
+    return Resource[models.ModerationFilter, dto.ModerationFilterDto, struct{}]{
+        Name: "filter",
+        Path: "/admin/filters",
+        Operations: map[ResourceOperation]middleware.MiddlewareSet{
+            ResourceList: auth.Required,
+            ResourceGet: auth.Required,
+            ResourceCreate: auth.Required,
+            ResourceDelete: auth.Required,
+        },
+        Policy: func(
+            req ResourceRequest,
+            _ ResourceOperation,
+            _ *models.ModerationFilter,
+        ) error {
+            return service.For(req.Request.Context()).RequireAdmin(req.UserID);
+        },
+        Create: func(
+            req ResourceRequest,
+            data dto.ModerationFilterDto,
+        ) (models.ModerationFilter, error) {
+            return appservice.NewModerationFilter(req.UserID, data);
+        },
+        AfterCreate: func(tx *gorm.DB, req ResourceRequest, filter *models.ModerationFilter) error {
+            return appservice.AuditFilterChange(tx, req.UserID, filter, true);
+        },
+        AfterDelete: func(tx *gorm.DB, req ResourceRequest, filter *models.ModerationFilter) error {
+            return appservice.AuditFilterChange(tx, req.UserID, filter, false);
+        },
+        WriteError: writeModerationError,
+    };
+}
+
+func writeModerationError(w http.ResponseWriter, r *http.Request, err error) {
+    problems.Write(w, r, notFound(err, ""));
+}
@@ -0,0 +1,200 @@
+package routes
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+
+	"github.com/cxcnxl/go-crud/internal/app_service"
+	"github.com/cxcnxl/go-crud/internal/middleware"
+	"github.com/cxcnxl/go-crud/internal/models"
+	"github.com/cxcnxl/go-crud/internal/openapi"
+	"github.com/cxcnxl/go-crud/internal/problems"
+	"github.com/cxcnxl/go-crud/internal/responses"
+)
+
+func registerAttachmentRoutes(methodHandler *MethodHandler, service *appservice.AppService) {
+    auth := middleware.NewAuthSets(service.CheckActiveUser);
+    methodHandler.HandleFunc(
+        "POST",
+        "/posts/{id}/attachments",
+        routeUploadAttachment(service),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Upload attachment to own post",
+            Request: openapi.Schema{
+                "type": "object",
+                "properties": openapi.Schema{
+                    "file": openapi.Schema{ "type": "string", "contentMediaType": "application/octet-stream" },
+                    "private": openapi.Schema{ "type": "boolean" },
+                },
+            },
+            RequestType: "multipart/form-data",
+            Response: models.Attachment{},
+            Kind: "attachment",
+            Status: http.StatusCreated,
+        },
+    );
+    methodHandler.HandleFunc(
+        "DELETE",
+        "/attachments/{id}",
+        routeDeleteAttachment(service),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Delete own attachment",
+        },
+    );
+    methodHandler.HandleFunc(
+        "GET",
+        "/attachments/{id}",
+        routeDownloadAttachment(service, false),
+        middleware.UtilMiddleware,
+        openapi.Doc{
+            Summary: "Download attachment, private ones need a signed link",
+            Response: openapi.Schema{ "type": "string", "contentMediaType": "application/octet-stream" },
+            ResponseType: "application/octet-stream",
+            Query: map[string]string{
+                "expires": "expiry of a signed link",
+                "sig": "signature of a signed link",
+            },
+        },
+    );
+    methodHandler.HandleFunc(
+        "GET",
+        "/attachments/{id}/thumbnail",
+        routeDownloadAttachment(service, true),
+        middleware.UtilMiddleware,
+        openapi.Doc{
+            Summary: "Thumbnail of image attachment",
+            Response: openapi.Schema{ "type": "string", "contentMediaType": "image/jpeg" },
+            ResponseType: "image/jpeg",
+            Query: map[string]string{
+                "expires": "expiry of a signed link",
+                "sig": "signature of a signed link",
+            },
+        },
+    );
+}
+
+// expects multipart form with "file" field and optional "private" flag
+func routeUploadAttachment(service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        defer r.Body.Close();
+
+        postId, userId, ok := postTarget(w, r);
+        if !ok {
+            return;
+        }
+
+        r.Body = http.MaxBytesReader(w, r.Body, appservice.MaxAttachmentSize + multipartOverhead);
+        err := r.ParseMultipartForm(multipartMemory);
+        if err != nil {
+            var tooLarge *http.MaxBytesError;
+            if errors.As(err, &tooLarge) {
+                writeAttachmentError(w, r, appservice.AttachmentTooLargeError{});
+                return;
+            }
+
+            problems.Write(w, r, problems.Status(http.StatusBadRequest, "Invalid payload. Expected multipart form"));
+            return;
+        }
+        defer r.MultipartForm.RemoveAll();
+
+        file, header, err := r.FormFile("file");
+        if err != nil {
+            problems.Write(w, r, problems.Status(http.StatusBadRequest, "Missing file"));
+            return;
+        }
+        defer file.Close();
+
+        data, err := io.ReadAll(io.LimitReader(file, appservice.MaxAttachmentSize + 1));
+        if err != nil {
+            problems.Write(w, r, problems.Status(http.StatusBadRequest, "Failed to read file"));
+            return;
+        }
+
+        private := r.FormValue("private") == "true";
+
+        attachment, err := service.AddAttachment(postId, userId, header.Filename, data, private);
+        if err != nil {
+            writeAttachmentError(w, r, err);
+            return;
+        }
+
+        response := responses.NewDataResponse("attachment", attachment);
+        responses.RenderStatus(w, r, http.StatusCreated, response);
+    });
+}
+
+func routeDeleteAttachment(service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        attachmentId, ok := pathId(r, "id");
+        if !ok {
+            problems.Write(w, r, problems.Status(http.StatusBadRequest, "Invalid attachment id"));
+            return;
+        }
+
+        userId, ok := authUserId(r);
+        if !ok {
+            problems.Write(w, r, problems.Status(http.StatusUnauthorized, "unauthorized"));
+            return;
+        }
+
+        err := service.DeleteAttachment(attachmentId, userId);
+        if err != nil {
+            writeAttachmentError(w, r, err);
+            return;
+        }
+
+        w.WriteHeader(http.StatusNoContent);
+    });
+}
+
+func routeDownloadAttachment(service *appservice.AppService, thumbnail bool) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        attachmentId, ok := pathId(r, "id");
+        if !ok {
+            problems.Write(w, r, problems.Status(http.StatusBadRequest, "Invalid attachment id"));
+            return;
+        }
+
+        attachment, blob, err := service.OpenAttachment(attachmentId, thumbnail, r.URL.Query());
+        if err != nil {
+            writeAttachmentError(w, r, err);
+            return;
+        }
+        defer blob.Close();
+
+        contentType := attachment.ContentType;
+        if thumbnail {
+            contentType = "image/jpeg";
+        }
+
+        w.Header().Set("Content-Type", contentType);
+        w.Header().Set("X-Content-Type-Options", "nosniff");
+        w.Header().Set(
+            "Content-Disposition",
+            mime.FormatMediaType("inline", map[string]string{ "filename": attachment.FileName }),
+        );
+        if attachment.Private {
+            w.Header().Set("Cache-Control", "private, no-store");
+        }
+
+        _, err = io.Copy(w, blob);
+        if err != nil {
+            slog.Error("Error writing attachment: " + err.Error());
+        }
+    });
+}
+
+func writeAttachmentError(w http.ResponseWriter, r *http.Request, err error) {
+    problems.Write(w, r, notFound(err, "attachment"));
+}
+
+const multipartOverhead int64 = 1 << 20;
+const multipartMemory int64 = 1 << 20;
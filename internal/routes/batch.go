@@ -0,0 +1,353 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/cxcnxl/go-crud/internal/app_service"
+	"github.com/cxcnxl/go-crud/internal/codec"
+	"github.com/cxcnxl/go-crud/internal/dto"
+	"github.com/cxcnxl/go-crud/internal/middleware"
+	"github.com/cxcnxl/go-crud/internal/openapi"
+	"github.com/cxcnxl/go-crud/internal/problems"
+	"github.com/cxcnxl/go-crud/internal/responses"
+	"github.com/cxcnxl/go-crud/internal/validation"
+)
+
+func registerBatchRoutes(methodHandler *MethodHandler, service *appservice.AppService) {
+    methodHandler.HandleFunc(
+        "POST",
+        "/batch",
+        routeBatch(methodHandler, service),
+        middleware.UtilMiddleware,
+        openapi.Doc{
+            Summary: "Run several requests in order, optionally in one transaction",
+            Request: dto.BatchDto{},
+            Response: dto.BatchViewDto{},
+            Kind: "batch",
+        },
+    );
+}
+
+// atomic batch stopped at a failed operation
+type batchFailedError struct {}
+func (self batchFailedError) Error() string {
+    return "batch_failed";
+}
+
+// ${id.members}, see dto.BatchOperationDto
+var batchReference = regexp.MustCompile(`\$\{([^}]*)\}`);
+
+// answers 200 with the status and body of every operation. Operations go
+// through the router with their own middlewares and the Authorization
+// header of the batch, so each is authorized like a request of its own.
+// Atomic operations find the transaction in their context
+func routeBatch(methodHandler *MethodHandler, service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        defer r.Body.Close();
+
+        var data dto.BatchDto;
+        if !decodeValid(w, r, &data) {
+            return;
+        }
+
+        // validation does not descend into slices
+        fields := validation.Errors{};
+        for i := range data.Operations {
+            var invalid validation.Errors;
+            if errors.As(validation.Struct(&data.Operations[i]), &invalid) {
+                for name, rule := range invalid {
+                    fields[fmt.Sprintf("operations.%d.%s", i, name)] = rule;
+                }
+            }
+        }
+        if len(fields) > 0 {
+            problems.Write(w, r, fields);
+            return;
+        }
+
+        ids := map[string]bool{};
+        for _, operation := range data.Operations {
+            if operation.ID == "" {
+                continue;
+            }
+            if ids[operation.ID] {
+                problems.Write(w, r, problems.Status(http.StatusBadRequest, "Duplicate operation id " + operation.ID));
+                return;
+            }
+            ids[operation.ID] = true;
+        }
+
+        var view dto.BatchViewDto;
+        if !data.Atomic {
+            view.Results = runBatch(methodHandler, r, data.Operations, false);
+        } else {
+            err := service.Transaction(func(tx *appservice.AppService) error {
+                request := r.WithContext(appservice.ContextWithService(r.Context(), tx));
+                view.Results = runBatch(methodHandler, request, data.Operations, true);
+                for _, result := range view.Results {
+                    if result.Status >= http.StatusMultipleChoices {
+                        return batchFailedError{};
+                    }
+                }
+                return nil;
+            });
+
+            view.RolledBack = errors.Is(err, batchFailedError{});
+            if err != nil && !view.RolledBack {
+                problems.Write(w, r, err);
+                return;
+            }
+        }
+
+        response := responses.NewDataResponse("batch", view);
+        responses.Render(w, r, response);
+    });
+}
+
+// runs operations in order. Operations that refer to a failed or missing
+// result fail with 424, and so do the ones after a failure when
+// stopOnFailure is set
+func runBatch(
+    methodHandler *MethodHandler,
+    parent *http.Request,
+    operations []dto.BatchOperationDto,
+    stopOnFailure bool,
+) []dto.BatchResultDto {
+    results := make([]dto.BatchResultDto, len(operations));
+    // decoded bodies of successful operations by id
+    named := map[string]any{};
+    failed := false;
+
+    for i, operation := range operations {
+        recorder := &batchRecorder{ header: http.Header{} };
+
+        if failed {
+            problems.Write(recorder, batchRequest(parent, "GET", "/", nil), problems.Status(
+                http.StatusFailedDependency,
+                "Skipped after a failed operation",
+            ));
+        } else if request, err := resolveOperation(parent, operation, named); err != nil {
+            problems.Write(recorder, batchRequest(parent, "GET", "/", nil), err);
+        } else {
+            methodHandler.Mux.ServeHTTP(recorder, request);
+        }
+
+        results[i] = recorder.result(operation.ID);
+        if results[i].Status >= http.StatusMultipleChoices {
+            failed = stopOnFailure;
+        } else if operation.ID != "" {
+            named[operation.ID] = decodeResult(results[i].Body);
+        }
+    }
+
+    return results;
+}
+
+// request of the operation with its references replaced
+func resolveOperation(parent *http.Request, operation dto.BatchOperationDto, named map[string]any) (*http.Request, error) {
+    method := strings.ToUpper(operation.Method);
+    switch method {
+    case "GET", "POST", "PUT", "PATCH", "DELETE":
+    default:
+        return nil, problems.Status(http.StatusBadRequest, "Invalid method " + operation.Method);
+    }
+
+    path, err := replaceReferences(operation.Path, named, url.PathEscape);
+    if err != nil {
+        return nil, err;
+    }
+    target, err := url.ParseRequestURI(path);
+    if err != nil || target.Host != "" || !strings.HasPrefix(target.Path, "/") {
+        return nil, problems.Status(http.StatusBadRequest, "Invalid path " + operation.Path);
+    }
+    if target.Path == "/batch" {
+        return nil, problems.Status(http.StatusBadRequest, "Batches can not be nested");
+    }
+
+    var body []byte;
+    if len(operation.Body) > 0 {
+        var value any;
+        decoder := json.NewDecoder(bytes.NewReader(operation.Body));
+        decoder.UseNumber();
+        if err := decoder.Decode(&value); err != nil {
+            return nil, problems.Status(http.StatusBadRequest, "Invalid operation body");
+        }
+
+        value, err = replaceBodyReferences(value, named);
+        if err != nil {
+            return nil, err;
+        }
+        body, _ = json.Marshal(value);
+    }
+
+    return batchRequest(parent, method, path, body), nil;
+}
+
+// sub-request carrying the credentials and language of the batch. Bodies
+// are JSON and so are responses, which results embed
+func batchRequest(parent *http.Request, method string, path string, body []byte) *http.Request {
+    request, _ := http.NewRequestWithContext(parent.Context(), method, path, bytes.NewReader(body));
+    request.RemoteAddr = parent.RemoteAddr;
+
+    for _, name := range []string{ "Authorization", "Accept-Language", "User-Agent" } {
+        if value := parent.Header.Get(name); value != "" {
+            request.Header.Set(name, value);
+        }
+    }
+
+    accept := codec.JSON.ContentType;
+    if problems.WantsProblem(parent) {
+        accept = "application/problem+json, " + accept;
+    }
+    request.Header.Set("Accept", accept);
+    if body != nil {
+        request.Header.Set("Content-Type", codec.JSON.ContentType);
+    }
+
+    return request;
+}
+
+// replaces strings made of one reference by the referenced value and
+// references within longer strings by its text
+func replaceBodyReferences(value any, named map[string]any) (any, error) {
+    switch value := value.(type) {
+    case string:
+        if match := batchReference.FindStringSubmatch(value); match != nil && match[0] == value {
+            return lookupReference(match[1], named);
+        }
+        return replaceReferences(value, named, func(text string) string { return text });
+    case []any:
+        for i, item := range value {
+            replaced, err := replaceBodyReferences(item, named);
+            if err != nil {
+                return nil, err;
+            }
+            value[i] = replaced;
+        }
+    case map[string]any:
+        for key, item := range value {
+            replaced, err := replaceBodyReferences(item, named);
+            if err != nil {
+                return nil, err;
+            }
+            value[key] = replaced;
+        }
+    }
+
+    return value, nil;
+}
+
+func replaceReferences(text string, named map[string]any, escape func(string) string) (string, error) {
+    var failure error;
+    replaced := batchReference.ReplaceAllStringFunc(text, func(reference string) string {
+        value, err := lookupReference(reference[2:len(reference) - 1], named);
+        if err != nil {
+            failure = err;
+            return "";
+        }
+
+        switch value := value.(type) {
+        case string:
+            return escape(value);
+        case json.Number:
+            return value.String();
+        default:
+            encoded, _ := json.Marshal(value);
+            return escape(string(encoded));
+        }
+    });
+
+    return replaced, failure;
+}
+
+// value at id.member.member, members of arrays are indexes
+func lookupReference(reference string, named map[string]any) (any, error) {
+    unresolved := problems.Status(http.StatusFailedDependency, "Unresolved reference ${" + reference + "}");
+
+    parts := strings.Split(reference, ".");
+    value, ok := named[parts[0]];
+    if !ok {
+        return nil, unresolved;
+    }
+
+    for _, member := range parts[1:] {
+        switch container := value.(type) {
+        case map[string]any:
+            value, ok = container[member];
+        case []any:
+            index, err := strconv.Atoi(member);
+            ok = err == nil && index >= 0 && index < len(container);
+            if ok {
+                value = container[index];
+            }
+        default:
+            ok = false;
+        }
+
+        if !ok {
+            return nil, unresolved;
+        }
+    }
+
+    return value, nil;
+}
+
+func decodeResult(body json.RawMessage) any {
+    decoder := json.NewDecoder(bytes.NewReader(body));
+    decoder.UseNumber();
+
+    var value any;
+    decoder.Decode(&value);
+
+    return value;
+}
+
+// response of an operation. Streams are not supported, it is no Flusher
+type batchRecorder struct {
+    header http.Header
+    status int
+    body   bytes.Buffer
+}
+
+func (self *batchRecorder) Header() http.Header {
+    return self.header;
+}
+
+func (self *batchRecorder) WriteHeader(status int) {
+    if self.status == 0 {
+        self.status = status;
+    }
+}
+
+func (self *batchRecorder) Write(data []byte) (int, error) {
+    self.WriteHeader(http.StatusOK);
+    return self.body.Write(data);
+}
+
+func (self *batchRecorder) result(id string) dto.BatchResultDto {
+    result := dto.BatchResultDto{ ID: id, Status: self.status };
+    if result.Status == 0 {
+        result.Status = http.StatusOK;
+    }
+    if self.body.Len() == 0 {
+        return result;
+    }
+
+    mediaType, _, _ := mime.ParseMediaType(self.header.Get("Content-Type"));
+    if (mediaType == codec.JSON.ContentType || strings.HasSuffix(mediaType, "+json")) && json.Valid(self.body.Bytes()) {
+        result.Body = bytes.Clone(self.body.Bytes());
+    } else {
+        result.Body, _ = json.Marshal(self.body.String());
+    }
+
+    return result;
+}
@@ -0,0 +1,59 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/cxcnxl/go-crud/internal/middleware"
+	"github.com/cxcnxl/go-crud/internal/openapi"
+)
+
+func registerDocsRoutes(methodHandler *MethodHandler) {
+    methodHandler.HandleFunc(
+        "GET",
+        "/openapi.json",
+        routeOpenAPI(methodHandler),
+        middleware.UtilMiddleware,
+        openapi.Doc{
+            Summary: "OpenAPI spec of this API",
+            Response: openapi.Schema{ "type": "object" },
+        },
+    );
+    methodHandler.HandleFunc(
+        "GET",
+        "/docs",
+        routeDocs(),
+        middleware.UtilMiddleware,
+        openapi.Doc{
+            Summary: "Docs page rendering the spec",
+            Response: openapi.Schema{ "type": "string" },
+            ResponseType: "text/html",
+        },
+    );
+}
+
+// spec is built on first request, once every route is registered
+func routeOpenAPI(methodHandler *MethodHandler) http.HandlerFunc {
+    var once sync.Once;
+    var spec []byte;
+    var specErr error;
+
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        once.Do(func() {
+            spec, specErr = json.Marshal(methodHandler.OpenAPI());
+        });
+        if specErr != nil {
+            panic(specErr);
+        }
+
+        w.Write(spec);
+    });
+}
+
+func routeDocs() http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "text/html; charset=utf-8");
+        w.Write(openapi.DocsPage);
+    });
+}
@@ -0,0 +1,160 @@
+package routes
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cxcnxl/go-crud/internal/app_service"
+	"github.com/cxcnxl/go-crud/internal/feeds"
+	"github.com/cxcnxl/go-crud/internal/middleware"
+	"github.com/cxcnxl/go-crud/internal/openapi"
+	"github.com/cxcnxl/go-crud/internal/models"
+	"github.com/cxcnxl/go-crud/internal/problems"
+)
+
+type feedFormat struct {
+    extension   string
+    contentType string
+    render      func(feeds.Feed) ([]byte, error)
+}
+
+var feedFormats = []feedFormat{
+    { "atom", feeds.AtomContentType, feeds.Atom },
+    { "rss", feeds.RSSContentType, feeds.RSS },
+    { "json", feeds.JSONContentType, feeds.JSONFeed },
+};
+
+func registerFeedRoutes(methodHandler *MethodHandler, service *appservice.AppService) {
+    for _, format := range feedFormats {
+        methodHandler.HandleFunc(
+            "GET",
+            "/users/{username}/feed." + format.extension,
+            routeUserFeed(service, format),
+            middleware.UtilMiddleware,
+            openapi.Doc{
+                Summary: "Public posts of user as " + format.extension + " feed",
+                Response: openapi.Schema{ "type": "string" },
+                ResponseType: format.contentType,
+            },
+        );
+    }
+}
+
+// public feed of user posts. Supports conditional GET through ETag and
+// Last-Modified
+func routeUserFeed(service *appservice.AppService, format feedFormat) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        user, posts, err := service.GetUserFeed(r.PathValue("username"));
+        if err != nil {
+            problems.Write(w, r, notFound(err, "user"));
+            return;
+        }
+
+        etag, lastModified := feedVersion(format, user, posts);
+        w.Header().Set("ETag", etag);
+        w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat));
+        w.Header().Set("Cache-Control", "public, max-age=300");
+
+        if notModified(r, etag, lastModified) {
+            w.WriteHeader(http.StatusNotModified);
+            return;
+        }
+
+        base := baseUrl(r);
+        feed := feeds.Feed{
+            Title: fmt.Sprintf("Posts by %s", user.Username),
+            HomeUrl: fmt.Sprintf("%s/users/%s", base, user.Username),
+            FeedUrl: base + r.URL.Path,
+            Author: user.Username,
+            Updated: lastModified,
+            Items: []feeds.Item{},
+        };
+        for _, post := range posts {
+            url := fmt.Sprintf("%s/posts/%d", base, post.ID);
+            feed.Items = append(feed.Items, feeds.Item{
+                Id: url,
+                Url: url,
+                Content: post.Body,
+                Published: *post.PublishedAt,
+                Updated: post.UpdatedAt,
+            });
+        }
+
+        body, err := format.render(feed);
+        if err != nil {
+            slog.Error("Error rendering feed: " + err.Error());
+            problems.Write(w, r, problems.Status(http.StatusInternalServerError, "Internal server error"));
+            return;
+        }
+
+        w.Header().Set("Content-Type", format.contentType);
+        w.Write(body);
+    });
+}
+
+// strong validator and modification time of the feed contents
+func feedVersion(
+    format feedFormat,
+    user models.User,
+    posts []models.Post,
+) (string, time.Time) {
+    hash := sha256.New();
+    fmt.Fprintf(hash, "%s\n%d\n%s\n", format.extension, user.ID, user.Username);
+
+    // epoch, not zero time, so empty feeds get a sane Last-Modified
+    lastModified := time.Unix(0, 0).UTC();
+    for _, post := range posts {
+        fmt.Fprintf(hash, "%d:%d\n", post.ID, post.UpdatedAt.UnixNano());
+        if post.UpdatedAt.After(lastModified) {
+            lastModified = post.UpdatedAt;
+        }
+        if post.PublishedAt != nil && post.PublishedAt.After(lastModified) {
+            lastModified = *post.PublishedAt;
+        }
+    }
+
+    etag := fmt.Sprintf("\"%s\"", hex.EncodeToString(hash.Sum(nil))[:32]);
+
+    // http dates have second precision
+    return etag, lastModified.UTC().Truncate(time.Second);
+}
+
+// evaluates If-None-Match, falling back to If-Modified-Since as RFC 9110
+// prescribes
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+    if match := r.Header.Get("If-None-Match"); match != "" {
+        for _, candidate := range strings.Split(match, ",") {
+            candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/");
+            if candidate == etag || candidate == "*" {
+                return true;
+            }
+        }
+        return false;
+    }
+
+    since, err := http.ParseTime(r.Header.Get("If-Modified-Since"));
+    if err != nil {
+        return false;
+    }
+
+    return !lastModified.After(since);
+}
+
+// scheme and host the request was made to, honoring reverse proxy headers
+func baseUrl(r *http.Request) string {
+    scheme := "http";
+    if r.TLS != nil {
+        scheme = "https";
+    }
+    if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+        scheme = proto;
+    }
+
+    return fmt.Sprintf("%s://%s", scheme, r.Host);
+}
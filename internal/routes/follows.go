@@ -0,0 +1,257 @@
+package routes
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/cxcnxl/go-crud/internal/app_service"
+	"github.com/cxcnxl/go-crud/internal/dto"
+	"github.com/cxcnxl/go-crud/internal/fieldset"
+	"github.com/cxcnxl/go-crud/internal/middleware"
+	"github.com/cxcnxl/go-crud/internal/models"
+	"github.com/cxcnxl/go-crud/internal/openapi"
+	"github.com/cxcnxl/go-crud/internal/problems"
+	"github.com/cxcnxl/go-crud/internal/responses"
+)
+
+func registerFollowRoutes(methodHandler *MethodHandler, service *appservice.AppService) {
+    auth := middleware.NewAuthSets(service.CheckActiveUser);
+    methodHandler.HandleFunc(
+        "POST",
+        "/users/{id}/follow",
+        routeFollow(service),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Follow user",
+        },
+    );
+    methodHandler.HandleFunc(
+        "DELETE",
+        "/users/{id}/follow",
+        routeUnfollow(service),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Unfollow user",
+        },
+    );
+    methodHandler.HandleFunc(
+        "POST",
+        "/users/{id}/block",
+        routeUserRelation(service, (*appservice.AppService).BlockUser),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Block user, removing follows both ways",
+        },
+    );
+    methodHandler.HandleFunc(
+        "DELETE",
+        "/users/{id}/block",
+        routeUserRelation(service, (*appservice.AppService).UnblockUser),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Unblock user",
+        },
+    );
+    methodHandler.HandleFunc(
+        "POST",
+        "/users/{id}/mute",
+        routeUserRelation(service, (*appservice.AppService).MuteUser),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Mute user",
+        },
+    );
+    methodHandler.HandleFunc(
+        "DELETE",
+        "/users/{id}/mute",
+        routeUserRelation(service, (*appservice.AppService).UnmuteUser),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Unmute user",
+        },
+    );
+    methodHandler.HandleFunc(
+        "GET",
+        "/users/{id}/followers",
+        routeFollowers(service),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Followers of user",
+            Response: dto.PageDto[models.User]{},
+            Kind: "users",
+            Paginated: true,
+        },
+    );
+    methodHandler.HandleFunc(
+        "GET",
+        "/users/{id}/following",
+        routeFollowing(service),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Users the user follows",
+            Response: dto.PageDto[models.User]{},
+            Kind: "users",
+            Paginated: true,
+        },
+    );
+    methodHandler.HandleFunc(
+        "GET",
+        "/timeline",
+        routeTimeline(service),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Posts of followed users, newest first",
+            Response: dto.KeysetPageDto[models.Post]{},
+            Kind: "posts",
+            Paginated: true,
+            Keyset: true,
+            Query: fieldsQuery(fieldset.Post, nil),
+        },
+    );
+}
+
+func routeFollow(service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        targetId, userId, ok := userTarget(w, r);
+        if !ok {
+            return;
+        }
+
+        err := service.FollowUser(userId, targetId);
+        if err != nil {
+            writeFollowError(w, r, err);
+            return;
+        }
+
+        w.WriteHeader(http.StatusNoContent);
+    });
+}
+
+func routeUnfollow(service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        targetId, userId, ok := userTarget(w, r);
+        if !ok {
+            return;
+        }
+
+        err := service.UnfollowUser(userId, targetId);
+        if err != nil {
+            writeFollowError(w, r, err);
+            return;
+        }
+
+        w.WriteHeader(http.StatusNoContent);
+    });
+}
+
+// blocks, mutes or lifts them; change is called with the service of the
+// request, the caller and target user ids
+func routeUserRelation(
+    service *appservice.AppService,
+    change func(service *appservice.AppService, userId uint, targetId uint) error,
+) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        targetId, userId, ok := userTarget(w, r);
+        if !ok {
+            return;
+        }
+
+        err := change(service.For(r.Context()), userId, targetId);
+        if err != nil {
+            writeFollowError(w, r, err);
+            return;
+        }
+
+        w.WriteHeader(http.StatusNoContent);
+    });
+}
+
+func routeFollowers(service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        targetId, userId, ok := userTarget(w, r);
+        if !ok {
+            return;
+        }
+
+        before, limit := pageParams(r);
+        page, err := service.GetFollowers(targetId, userId, before, limit);
+        if err != nil {
+            writeFollowError(w, r, err);
+            return;
+        }
+
+        response := responses.NewDataResponse("users", page);
+        responses.Render(w, r, response);
+    });
+}
+
+func routeFollowing(service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        targetId, userId, ok := userTarget(w, r);
+        if !ok {
+            return;
+        }
+
+        before, limit := pageParams(r);
+        page, err := service.GetFollowing(targetId, userId, before, limit);
+        if err != nil {
+            writeFollowError(w, r, err);
+            return;
+        }
+
+        response := responses.NewDataResponse("users", page);
+        responses.Render(w, r, response);
+    });
+}
+
+func routeTimeline(service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        userId, ok := authUserId(r);
+        if !ok {
+            problems.Write(w, r, problems.Status(http.StatusUnauthorized, "unauthorized"));
+            return;
+        }
+
+        spec, ok := readSpec(w, r, fieldset.Post);
+        if !ok {
+            return;
+        }
+
+        before, limit := keysetParams(r);
+        page, err := service.Reading(spec).GetTimeline(userId, before, limit);
+        if err != nil {
+            slog.Error(err.Error());
+            problems.Write(w, r, problems.Status(http.StatusInternalServerError, "Failed to load timeline"));
+            return;
+        }
+
+        renderProjectedKeysetPage(w, r, "posts", spec, page);
+    });
+}
+
+// reads target user id from path and caller id from auth claims or writes
+// an error
+func userTarget(w http.ResponseWriter, r *http.Request) (uint, uint, bool) {
+    targetId, ok := pathId(r, "id");
+    if !ok {
+        problems.Write(w, r, problems.Status(http.StatusBadRequest, "Invalid user id"));
+        return 0, 0, false;
+    }
+
+    userId, ok := authUserId(r);
+    if !ok {
+        problems.Write(w, r, problems.Status(http.StatusUnauthorized, "unauthorized"));
+        return 0, 0, false;
+    }
+
+    return targetId, userId, true;
+}
+
+func writeFollowError(w http.ResponseWriter, r *http.Request, err error) {
+    problems.Write(w, r, notFound(err, "user"));
+}
@@ -0,0 +1,52 @@
+package routes
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+
+	"github.com/cxcnxl/go-crud/internal/app_service"
+	"github.com/cxcnxl/go-crud/internal/graph"
+	"github.com/cxcnxl/go-crud/internal/middleware"
+	"github.com/cxcnxl/go-crud/internal/openapi"
+	"github.com/cxcnxl/go-crud/internal/problems"
+)
+
+func registerGraphRoutes(methodHandler *MethodHandler, service *appservice.AppService) {
+    auth := middleware.NewAuthSets(service.CheckActiveUser);
+    methodHandler.HandleFunc(
+        "POST",
+        "/graphql",
+        routeGraphQL(graph.NewHandler(service)),
+        auth.Optional,
+        openapi.Doc{
+            Summary: "GraphQL queries over users, posts and the viewer",
+            Request: graph.Request{},
+            Response: openapi.Schema{
+                "type": "object",
+                "properties": openapi.Schema{
+                    "data": openapi.Schema{ "type": "object" },
+                    "errors": openapi.Schema{ "type": "array", "items": openapi.Schema{ "type": "object" } },
+                },
+            },
+        },
+    );
+}
+
+// hands requests to handler with the caller as the viewer. Bodies are read
+// here so oversized ones get the usual problem
+func routeGraphQL(handler http.Handler) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        defer r.Body.Close();
+
+        body, err := readBody(w, r);
+        if err != nil {
+            problems.Write(w, r, err);
+            return;
+        }
+        r.Body = io.NopCloser(bytes.NewReader(body));
+
+        viewerId, _ := authUserId(r);
+        handler.ServeHTTP(w, r.WithContext(graph.WithViewer(r.Context(), viewerId)));
+    });
+}
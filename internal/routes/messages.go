@@ -0,0 +1,292 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/cxcnxl/go-crud/internal/app_service"
+	"github.com/cxcnxl/go-crud/internal/dto"
+	"github.com/cxcnxl/go-crud/internal/middleware"
+	"github.com/cxcnxl/go-crud/internal/models"
+	"github.com/cxcnxl/go-crud/internal/openapi"
+	"github.com/cxcnxl/go-crud/internal/problems"
+	"github.com/cxcnxl/go-crud/internal/responses"
+)
+
+func registerMessageRoutes(methodHandler *MethodHandler, service *appservice.AppService) {
+    auth := middleware.NewAuthSets(service.CheckActiveUser);
+    methodHandler.HandleFunc(
+        "POST",
+        "/conversations",
+        routeCreateConversation(service),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Start conversation, one-to-one ones are reused",
+            Request: dto.CreateConversationDto{},
+            Response: dto.ConversationViewDto{},
+            Kind: "conversation",
+        },
+    );
+    methodHandler.HandleFunc(
+        "GET",
+        "/conversations",
+        routeConversations(service),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Own conversations, most recently active first",
+            Response: dto.KeysetPageDto[dto.ConversationViewDto]{},
+            Kind: "conversations",
+            Paginated: true,
+            Keyset: true,
+        },
+    );
+    methodHandler.HandleFunc(
+        "GET",
+        "/conversations/{id}",
+        routeConversation(service),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Conversation with participants and read receipts",
+            Response: dto.ConversationViewDto{},
+            Kind: "conversation",
+        },
+    );
+    methodHandler.HandleFunc(
+        "GET",
+        "/conversations/{id}/messages",
+        routeMessages(service),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Messages of conversation, newest first",
+            Response: dto.PageDto[models.Message]{},
+            Kind: "messages",
+            Paginated: true,
+        },
+    );
+    methodHandler.HandleFunc(
+        "POST",
+        "/conversations/{id}/messages",
+        routeSendMessage(service),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Send message",
+            Request: dto.SendMessageDto{},
+            Response: models.Message{},
+            Kind: "message",
+            Status: http.StatusCreated,
+        },
+    );
+    methodHandler.HandleFunc(
+        "POST",
+        "/conversations/{id}/read",
+        routeReadConversation(service),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Move own read receipt",
+            Request: dto.ReadConversationDto{},
+        },
+    );
+    methodHandler.HandleFunc(
+        "GET",
+        "/me/messages/unread-count",
+        routeUnreadMessagesCount(service),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Number of unread messages",
+            Response: map[string]int{},
+            Kind: "unread_count",
+        },
+    );
+}
+
+func routeCreateConversation(service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        defer r.Body.Close();
+
+        userId, ok := authUserId(r);
+        if !ok {
+            problems.Write(w, r, problems.Status(http.StatusUnauthorized, "unauthorized"));
+            return;
+        }
+
+        var data dto.CreateConversationDto;
+        if !decodeValid(w, r, &data) {
+            return;
+        }
+
+        conversation, err := service.CreateConversation(userId, data.ParticipantIDs);
+        if err != nil {
+            writeMessageError(w, r, err);
+            return;
+        }
+
+        response := responses.NewDataResponse("conversation", conversation);
+        responses.Render(w, r, response);
+    });
+}
+
+func routeConversations(service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        userId, ok := authUserId(r);
+        if !ok {
+            problems.Write(w, r, problems.Status(http.StatusUnauthorized, "unauthorized"));
+            return;
+        }
+
+        before, limit := keysetParams(r);
+
+        page, err := service.GetConversations(userId, before, limit);
+        if err != nil {
+            writeMessageError(w, r, err);
+            return;
+        }
+
+        response := responses.NewDataResponse("conversations", page);
+        responses.Render(w, r, response);
+    });
+}
+
+func routeConversation(service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        conversationId, userId, ok := conversationTarget(w, r);
+        if !ok {
+            return;
+        }
+
+        conversation, err := service.GetConversation(userId, conversationId);
+        if err != nil {
+            writeMessageError(w, r, err);
+            return;
+        }
+
+        response := responses.NewDataResponse("conversation", conversation);
+        responses.Render(w, r, response);
+    });
+}
+
+func routeMessages(service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        conversationId, userId, ok := conversationTarget(w, r);
+        if !ok {
+            return;
+        }
+
+        before, limit := pageParams(r);
+
+        page, err := service.GetMessages(userId, conversationId, before, limit);
+        if err != nil {
+            writeMessageError(w, r, err);
+            return;
+        }
+
+        response := responses.NewDataResponse("messages", page);
+        responses.Render(w, r, response);
+    });
+}
+
+func routeSendMessage(service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        defer r.Body.Close();
+
+        conversationId, userId, ok := conversationTarget(w, r);
+        if !ok {
+            return;
+        }
+
+        var data dto.SendMessageDto;
+        if !decodeValid(w, r, &data) {
+            return;
+        }
+
+        message, err := service.SendMessage(userId, conversationId, data.Body);
+        if err != nil {
+            writeMessageError(w, r, err);
+            return;
+        }
+
+        response := responses.NewDataResponse("message", message);
+        responses.RenderStatus(w, r, http.StatusCreated, response);
+    });
+}
+
+// moves read receipt of the caller, empty body marks everything read
+func routeReadConversation(service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        defer r.Body.Close();
+
+        conversationId, userId, ok := conversationTarget(w, r);
+        if !ok {
+            return;
+        }
+
+        body, err := readBody(w, r);
+        if err != nil {
+            problems.Write(w, r, err);
+            return;
+        }
+
+        var data dto.ReadConversationDto;
+        if len(body) > 0 {
+            if !validBody(w, r, body, &data) {
+                return;
+            }
+        }
+
+        err = service.MarkConversationRead(userId, conversationId, data.MessageID);
+        if err != nil {
+            writeMessageError(w, r, err);
+            return;
+        }
+
+        w.WriteHeader(http.StatusNoContent);
+    });
+}
+
+func routeUnreadMessagesCount(service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        userId, ok := authUserId(r);
+        if !ok {
+            problems.Write(w, r, problems.Status(http.StatusUnauthorized, "unauthorized"));
+            return;
+        }
+
+        count, err := service.GetUnreadMessagesCount(userId);
+        if err != nil {
+            writeMessageError(w, r, err);
+            return;
+        }
+
+        response := responses.NewDataResponse("unread_count", map[string]int{
+            "unread": count,
+        });
+        responses.Render(w, r, response);
+    });
+}
+
+// reads conversation id from path and user id from auth claims or writes
+// an error
+func conversationTarget(w http.ResponseWriter, r *http.Request) (uint, uint, bool) {
+    conversationId, ok := pathId(r, "id");
+    if !ok {
+        problems.Write(w, r, problems.Status(http.StatusBadRequest, "Invalid conversation id"));
+        return 0, 0, false;
+    }
+
+    userId, ok := authUserId(r);
+    if !ok {
+        problems.Write(w, r, problems.Status(http.StatusUnauthorized, "unauthorized"));
+        return 0, 0, false;
+    }
+
+    return conversationId, userId, true;
+}
+
+func writeMessageError(w http.ResponseWriter, r *http.Request, err error) {
+    problems.Write(w, r, notFound(err, "conversation"));
+}
@@ -0,0 +1,234 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/cxcnxl/go-crud/internal/app_service"
+	"github.com/cxcnxl/go-crud/internal/dto"
+	"github.com/cxcnxl/go-crud/internal/middleware"
+	"github.com/cxcnxl/go-crud/internal/models"
+	"github.com/cxcnxl/go-crud/internal/openapi"
+	"github.com/cxcnxl/go-crud/internal/problems"
+	"github.com/cxcnxl/go-crud/internal/responses"
+)
+
+func registerNotificationRoutes(methodHandler *MethodHandler, service *appservice.AppService) {
+    auth := middleware.NewAuthSets(service.CheckActiveUser);
+    methodHandler.HandleFunc(
+        "GET",
+        "/me/notifications",
+        routeNotifications(service),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Own notifications, newest first",
+            Response: dto.PageDto[models.Notification]{},
+            Kind: "notifications",
+            Paginated: true,
+            Query: map[string]string{ "unread": "true lists unread ones only" },
+        },
+    );
+    methodHandler.HandleFunc(
+        "GET",
+        "/me/notifications/unread-count",
+        routeUnreadNotificationsCount(service),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Number of unread notifications",
+            Response: map[string]int{},
+            Kind: "unread_count",
+        },
+    );
+    methodHandler.HandleFunc(
+        "POST",
+        "/me/notifications/read",
+        routeMarkAllNotificationsRead(service),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Mark every notification read",
+        },
+    );
+    methodHandler.HandleFunc(
+        "POST",
+        "/me/notifications/{id}/read",
+        routeMarkNotificationRead(service),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Mark notification read",
+        },
+    );
+    methodHandler.HandleFunc(
+        "GET",
+        "/me/notification-preferences",
+        routeNotificationPreferences(service),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Enabled notification types",
+            Response: dto.NotificationPreferencesDto{},
+            Kind: "notification_preferences",
+        },
+    );
+    methodHandler.HandleFunc(
+        "PUT",
+        "/me/notification-preferences",
+        routeSetNotificationPreferences(service),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Enable or disable notification types",
+            Request: dto.NotificationPreferencesDto{},
+            Response: dto.NotificationPreferencesDto{},
+            Kind: "notification_preferences",
+        },
+    );
+}
+
+// lists notifications, ?unread=true keeps unread ones only
+func routeNotifications(service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        userId, ok := authUserId(r);
+        if !ok {
+            problems.Write(w, r, problems.Status(http.StatusUnauthorized, "unauthorized"));
+            return;
+        }
+
+        before, limit := pageParams(r);
+        unreadOnly := r.URL.Query().Get("unread") == "true";
+
+        page, err := service.GetNotifications(userId, unreadOnly, before, limit);
+        if err != nil {
+            writeNotificationError(w, r, err);
+            return;
+        }
+
+        response := responses.NewDataResponse("notifications", page);
+        responses.Render(w, r, response);
+    });
+}
+
+func routeUnreadNotificationsCount(service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        userId, ok := authUserId(r);
+        if !ok {
+            problems.Write(w, r, problems.Status(http.StatusUnauthorized, "unauthorized"));
+            return;
+        }
+
+        count, err := service.GetUnreadNotificationsCount(userId);
+        if err != nil {
+            writeNotificationError(w, r, err);
+            return;
+        }
+
+        response := responses.NewDataResponse("unread_count", map[string]int{
+            "unread": count,
+        });
+        responses.Render(w, r, response);
+    });
+}
+
+func routeMarkNotificationRead(service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        notificationId, userId, ok := notificationTarget(w, r);
+        if !ok {
+            return;
+        }
+
+        err := service.MarkNotificationRead(userId, notificationId);
+        if err != nil {
+            writeNotificationError(w, r, err);
+            return;
+        }
+
+        w.WriteHeader(http.StatusNoContent);
+    });
+}
+
+func routeMarkAllNotificationsRead(service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        userId, ok := authUserId(r);
+        if !ok {
+            problems.Write(w, r, problems.Status(http.StatusUnauthorized, "unauthorized"));
+            return;
+        }
+
+        err := service.MarkAllNotificationsRead(userId);
+        if err != nil {
+            writeNotificationError(w, r, err);
+            return;
+        }
+
+        w.WriteHeader(http.StatusNoContent);
+    });
+}
+
+func routeNotificationPreferences(service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        userId, ok := authUserId(r);
+        if !ok {
+            problems.Write(w, r, problems.Status(http.StatusUnauthorized, "unauthorized"));
+            return;
+        }
+
+        preferences, err := service.GetNotificationPreferences(userId);
+        if err != nil {
+            writeNotificationError(w, r, err);
+            return;
+        }
+
+        response := responses.NewDataResponse("notification_preferences", preferences);
+        responses.Render(w, r, response);
+    });
+}
+
+func routeSetNotificationPreferences(service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        defer r.Body.Close();
+
+        userId, ok := authUserId(r);
+        if !ok {
+            problems.Write(w, r, problems.Status(http.StatusUnauthorized, "unauthorized"));
+            return;
+        }
+
+        var data dto.NotificationPreferencesDto;
+        if !decodeValid(w, r, &data) {
+            return;
+        }
+
+        preferences, err := service.SetNotificationPreferences(userId, data);
+        if err != nil {
+            writeNotificationError(w, r, err);
+            return;
+        }
+
+        response := responses.NewDataResponse("notification_preferences", preferences);
+        responses.Render(w, r, response);
+    });
+}
+
+// reads notification id from path and user id from auth claims or writes
+// an error
+func notificationTarget(w http.ResponseWriter, r *http.Request) (uint, uint, bool) {
+    notificationId, ok := pathId(r, "id");
+    if !ok {
+        problems.Write(w, r, problems.Status(http.StatusBadRequest, "Invalid notification id"));
+        return 0, 0, false;
+    }
+
+    userId, ok := authUserId(r);
+    if !ok {
+        problems.Write(w, r, problems.Status(http.StatusUnauthorized, "unauthorized"));
+        return 0, 0, false;
+    }
+
+    return notificationId, userId, true;
+}
+
+func writeNotificationError(w http.ResponseWriter, r *http.Request, err error) {
+    problems.Write(w, r, err);
+}
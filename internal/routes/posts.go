@@ -0,0 +1,540 @@
+package routes
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/cxcnxl/go-crud/internal/app_service"
+	"github.com/cxcnxl/go-crud/internal/codec"
+	"github.com/cxcnxl/go-crud/internal/diff"
+	"github.com/cxcnxl/go-crud/internal/dto"
+	"github.com/cxcnxl/go-crud/internal/fieldset"
+	"github.com/cxcnxl/go-crud/internal/middleware"
+	"github.com/cxcnxl/go-crud/internal/models"
+	"github.com/cxcnxl/go-crud/internal/openapi"
+	"github.com/cxcnxl/go-crud/internal/problems"
+	"github.com/cxcnxl/go-crud/internal/responses"
+)
+
+func registerPostRoutes(methodHandler *MethodHandler, service *appservice.AppService) {
+    auth := middleware.NewAuthSets(service.CheckActiveUser);
+    methodHandler.HandleFunc(
+        "POST",
+        "/posts",
+        routeCreatePost(service),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Create post",
+            Request: dto.CreatePostDto{},
+            Response: models.Post{},
+            Kind: "post",
+            Status: http.StatusCreated,
+        },
+    );
+    methodHandler.HandleFunc(
+        "GET",
+        "/posts/{id}",
+        routeGetPost(service),
+        auth.Optional,
+        openapi.Doc{
+            Summary: "Post with attachments and reactions",
+            Response: dto.PostViewDto{},
+            Kind: "post",
+            Query: fieldsQuery(fieldset.Post, map[string]string{ "body": "source, html or both, the default" }),
+        },
+    );
+    methodHandler.HandleFunc(
+        "PATCH",
+        "/posts/{id}",
+        routeUpdatePost(service),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Edit post with JSON, a JSON merge patch or JSON patch, keeps a revision of the previous body",
+            Request: dto.UpdatePostDto{},
+            Response: models.Post{},
+            Kind: "post",
+        },
+    );
+    methodHandler.HandleFunc(
+        "DELETE",
+        "/posts/{id}",
+        routeDeletePost(service),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Move post to trash",
+        },
+    );
+    methodHandler.HandleFunc(
+        "POST",
+        "/posts/{id}/restore",
+        routeRestorePost(service),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Restore post from trash",
+            Response: models.Post{},
+            Kind: "post",
+        },
+    );
+    methodHandler.HandleFunc(
+        "POST",
+        "/posts/{id}/publish",
+        routePublishPost(service),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Publish draft now or schedule it",
+            Request: dto.PublishPostDto{},
+            Response: models.Post{},
+            Kind: "post",
+        },
+    );
+    methodHandler.HandleFunc(
+        "GET",
+        "/me/drafts",
+        routeDrafts(service),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Own drafts and scheduled posts",
+            Response: dto.PageDto[models.Post]{},
+            Kind: "posts",
+            Paginated: true,
+            Query: fieldsQuery(fieldset.Post, nil),
+        },
+    );
+    methodHandler.HandleFunc(
+        "GET",
+        "/posts/{id}/revisions",
+        routePostRevisions(service),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Revisions of own post",
+            Response: []models.PostRevision{},
+            Kind: "revisions",
+        },
+    );
+    methodHandler.HandleFunc(
+        "GET",
+        "/posts/{id}/revisions/diff",
+        routePostRevisionsDiff(service),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Diff between two revisions",
+            Response: []diff.Op{},
+            Kind: "diff",
+            Query: map[string]string{
+                "from": "revision id, current body when missing",
+                "to": "revision id, current body when missing",
+            },
+        },
+    );
+    methodHandler.HandleFunc(
+        "GET",
+        "/me/trash",
+        routeTrash(service),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Own deleted posts",
+            Response: dto.PageDto[models.Post]{},
+            Kind: "posts",
+            Paginated: true,
+            Query: fieldsQuery(fieldset.Post, nil),
+        },
+    );
+    methodHandler.HandleFunc(
+        "PUT",
+        "/posts/{id}/reactions/{emoji}",
+        routePutReaction(service),
+        auth.Required,
+        openapi.Doc{
+            Summary: "React to post",
+        },
+    );
+    methodHandler.HandleFunc(
+        "DELETE",
+        "/posts/{id}/reactions/{emoji}",
+        routeDeleteReaction(service),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Remove own reaction",
+        },
+    );
+    methodHandler.HandleFunc(
+        "GET",
+        "/tags/{tag}",
+        routeTagPosts(service),
+        auth.Optional,
+        openapi.Doc{
+            Summary: "Public posts with the tag",
+            Response: dto.PageDto[models.Post]{},
+            Kind: "posts",
+            Paginated: true,
+            Query: fieldsQuery(fieldset.Post, nil),
+        },
+    );
+}
+
+func routeCreatePost(service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        defer r.Body.Close();
+
+        userId, ok := authUserId(r);
+        if !ok {
+            problems.Write(w, r, problems.Status(http.StatusUnauthorized, "unauthorized"));
+            return;
+        }
+
+        var data dto.CreatePostDto;
+        if !decodeValid(w, r, &data) {
+            return;
+        }
+
+        post, err := service.CreatePost(userId, data);
+        if err != nil {
+            writePostError(w, r, err);
+            return;
+        }
+
+        response := responses.NewDataResponse("post", post);
+        responses.RenderStatus(w, r, http.StatusCreated, response);
+    });
+}
+
+func routeGetPost(service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        postId, ok := pathId(r, "id");
+        if !ok {
+            problems.Write(w, r, problems.Status(http.StatusBadRequest, "Invalid post id"));
+            return;
+        }
+
+        viewerId, _ := authUserId(r);
+
+        spec, ok := readSpec(w, r, fieldset.Post);
+        if !ok {
+            return;
+        }
+
+        post, err := service.Reading(spec).GetPostView(postId, viewerId);
+        if err != nil {
+            writePostError(w, r, err);
+            return;
+        }
+
+        // ?body=source, html or both, the default
+        switch r.URL.Query().Get("body") {
+        case "", "both":
+        case "source":
+            post.BodyHtml = nil;
+        case "html":
+            post.Body = nil;
+        default:
+            problems.Write(w, r, problems.Status(http.StatusBadRequest, "Invalid body param. Expected source, html or both"));
+            return;
+        }
+
+        renderProjected(w, r, "post", spec, post);
+    });
+}
+
+// plain JSON bodies keep fields they leave empty, merge and JSON patches
+// work on body, body_format and visibility, see AppService.PatchPost
+func routeUpdatePost(service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        defer r.Body.Close();
+
+        postId, userId, ok := postTarget(w, r);
+        if !ok {
+            return;
+        }
+
+        contentType, ok := patchContentType(w, r);
+        if !ok {
+            return;
+        }
+
+        body, err := readBody(w, r);
+        if err != nil {
+            problems.Write(w, r, err);
+            return;
+        }
+
+        var post models.Post;
+        if contentType == codec.JSON.ContentType {
+            var data dto.UpdatePostDto;
+            if !validBody(w, r, body, &data) {
+                return;
+            }
+            post, err = service.UpdatePost(postId, userId, data);
+        } else {
+            post, err = service.PatchPost(postId, userId, contentType, body);
+        }
+        if err != nil {
+            writePostError(w, r, err);
+            return;
+        }
+
+        response := responses.NewDataResponse("post", post);
+        responses.Render(w, r, response);
+    });
+}
+
+func routeDeletePost(service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        postId, userId, ok := postTarget(w, r);
+        if !ok {
+            return;
+        }
+
+        err := service.DeletePost(postId, userId);
+        if err != nil {
+            writePostError(w, r, err);
+            return;
+        }
+
+        w.WriteHeader(http.StatusNoContent);
+    });
+}
+
+func routeRestorePost(service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        postId, userId, ok := postTarget(w, r);
+        if !ok {
+            return;
+        }
+
+        post, err := service.RestorePost(postId, userId);
+        if err != nil {
+            writePostError(w, r, err);
+            return;
+        }
+
+        response := responses.NewDataResponse("post", post);
+        responses.Render(w, r, response);
+    });
+}
+
+// publishes draft now or schedules it when body carries future publish_at
+func routePublishPost(service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        defer r.Body.Close();
+
+        postId, userId, ok := postTarget(w, r);
+        if !ok {
+            return;
+        }
+
+        body, err := readBody(w, r);
+        if err != nil {
+            problems.Write(w, r, err);
+            return;
+        }
+
+        var data dto.PublishPostDto;
+        if len(body) > 0 {
+            if !validBody(w, r, body, &data) {
+                return;
+            }
+        }
+
+        post, err := service.PublishPost(postId, userId, data.PublishAt);
+        if err != nil {
+            writePostError(w, r, err);
+            return;
+        }
+
+        response := responses.NewDataResponse("post", post);
+        responses.Render(w, r, response);
+    });
+}
+
+func routeDrafts(service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        userId, ok := authUserId(r);
+        if !ok {
+            problems.Write(w, r, problems.Status(http.StatusUnauthorized, "unauthorized"));
+            return;
+        }
+
+        spec, ok := readSpec(w, r, fieldset.Post);
+        if !ok {
+            return;
+        }
+
+        before, limit := pageParams(r);
+        page, err := service.Reading(spec).GetDrafts(userId, before, limit);
+        if err != nil {
+            writePostError(w, r, err);
+            return;
+        }
+
+        renderProjectedPage(w, r, "posts", spec, page);
+    });
+}
+
+func routePostRevisions(service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        postId, userId, ok := postTarget(w, r);
+        if !ok {
+            return;
+        }
+
+        revisions, err := service.GetPostRevisions(postId, userId);
+        if err != nil {
+            writePostError(w, r, err);
+            return;
+        }
+
+        response := responses.NewDataResponse("revisions", revisions);
+        responses.Render(w, r, response);
+    });
+}
+
+// diffs ?from= and ?to= revisions. Missing param means current body
+func routePostRevisionsDiff(service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        postId, userId, ok := postTarget(w, r);
+        if !ok {
+            return;
+        }
+
+        revisionIds := [2]uint{};
+        for i, name := range []string{"from", "to"} {
+            val := r.URL.Query().Get(name);
+            if val == "" || val == "current" {
+                continue;
+            }
+
+            id, err := strconv.ParseUint(val, 10, 64);
+            if err != nil {
+                problems.Write(w, r, problems.Status(http.StatusBadRequest, "Invalid revision id"));
+                return;
+            }
+            revisionIds[i] = uint(id);
+        }
+
+        ops, err := service.DiffPostRevisions(
+            postId,
+            userId,
+            revisionIds[0],
+            revisionIds[1],
+        );
+        if err != nil {
+            writePostError(w, r, err);
+            return;
+        }
+
+        response := responses.NewDataResponse("diff", ops);
+        responses.Render(w, r, response);
+    });
+}
+
+func routeTrash(service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        userId, ok := authUserId(r);
+        if !ok {
+            problems.Write(w, r, problems.Status(http.StatusUnauthorized, "unauthorized"));
+            return;
+        }
+
+        spec, ok := readSpec(w, r, fieldset.Post);
+        if !ok {
+            return;
+        }
+
+        before, limit := pageParams(r);
+        page, err := service.Reading(spec).GetTrash(userId, before, limit);
+        if err != nil {
+            writePostError(w, r, err);
+            return;
+        }
+
+        renderProjectedPage(w, r, "posts", spec, page);
+    });
+}
+
+func routePutReaction(service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        postId, userId, ok := postTarget(w, r);
+        if !ok {
+            return;
+        }
+
+        err := service.AddReaction(postId, userId, r.PathValue("emoji"));
+        if err != nil {
+            writePostError(w, r, err);
+            return;
+        }
+
+        w.WriteHeader(http.StatusNoContent);
+    });
+}
+
+func routeDeleteReaction(service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        postId, userId, ok := postTarget(w, r);
+        if !ok {
+            return;
+        }
+
+        err := service.RemoveReaction(postId, userId, r.PathValue("emoji"));
+        if err != nil {
+            writePostError(w, r, err);
+            return;
+        }
+
+        w.WriteHeader(http.StatusNoContent);
+    });
+}
+
+// reads post id from path and user id from auth claims or writes an error
+func postTarget(w http.ResponseWriter, r *http.Request) (uint, uint, bool) {
+    postId, ok := pathId(r, "id");
+    if !ok {
+        problems.Write(w, r, problems.Status(http.StatusBadRequest, "Invalid post id"));
+        return 0, 0, false;
+    }
+
+    userId, ok := authUserId(r);
+    if !ok {
+        problems.Write(w, r, problems.Status(http.StatusUnauthorized, "unauthorized"));
+        return 0, 0, false;
+    }
+
+    return postId, userId, true;
+}
+
+func routeTagPosts(service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        viewerId, _ := authUserId(r);
+        before, limit := pageParams(r);
+
+        spec, ok := readSpec(w, r, fieldset.Post);
+        if !ok {
+            return;
+        }
+
+        page, err := service.Reading(spec).GetTagPosts(r.PathValue("tag"), viewerId, before, limit);
+        if err != nil {
+            problems.Write(w, r, err);
+            return;
+        }
+
+        renderProjectedPage(w, r, "posts", spec, page);
+    });
+}
+
+func writePostError(w http.ResponseWriter, r *http.Request, err error) {
+    problems.Write(w, r, notFound(err, "post"));
+}
@@ -0,0 +1,233 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+
+	"golang.org/x/text/language"
+	"gorm.io/gorm"
+
+	"github.com/cxcnxl/go-crud/internal/app_service"
+	"github.com/cxcnxl/go-crud/internal/blobstore"
+	"github.com/cxcnxl/go-crud/internal/fieldset"
+	"github.com/cxcnxl/go-crud/internal/jsonpatch"
+	"github.com/cxcnxl/go-crud/internal/mergepatch"
+	"github.com/cxcnxl/go-crud/internal/problems"
+	"github.com/cxcnxl/go-crud/internal/validation"
+)
+
+// catalog of the errors handlers pass to problems.Write. Codes are the
+// Error() strings envelopes always carried, so clients keep matching them
+var catalog = []struct {
+    err   error
+    entry problems.Entry
+}{
+    // accounts
+    { appservice.DuplicateUserEmailError{}, entry("duplicate_user_email", http.StatusConflict, "Email is already registered") },
+    { appservice.DuplicateUserUsernameError{}, entry("duplicate_user_username", http.StatusConflict, "Username is taken") },
+    { appservice.UsernameReservedError{}, entry("username_reserved", http.StatusConflict, "Username is reserved") },
+    { appservice.InvalidPasswordError{}, entry("invalid_password", http.StatusUnauthorized, "Invalid credentials") },
+    { appservice.LoginBlockedError{}, entry("login_blocked", http.StatusTooManyRequests, "Too many failed logins") },
+    { appservice.SuspendedUserError{}, entry("user_suspended", http.StatusForbidden, "Account is suspended") },
+    { appservice.InvalidUsernameError{}, entry("invalid_username", http.StatusBadRequest, "Invalid username") },
+    { appservice.InvalidEmailError{}, entry("invalid_email", http.StatusBadRequest, "Invalid email address") },
+    { appservice.InvalidEmailChangeTokenError{}, entry("invalid_email_change_token", http.StatusBadRequest, "Invalid or expired confirmation link") },
+    {
+        appservice.InvalidProfileError{},
+        problems.Entry{
+            Code: "invalid_profile",
+            Status: http.StatusBadRequest,
+            Title: "Invalid profile field",
+            Extensions: func(err error) map[string]any {
+                return map[string]any{ "field": err.(appservice.InvalidProfileError).Field };
+            },
+        },
+    },
+
+    // posts
+    { appservice.NotPostAuthorError{}, entry("not_post_author", http.StatusForbidden, "Only the author may do this") },
+    { appservice.PostAlreadyPublishedError{}, entry("post_already_published", http.StatusConflict, "Post is already published") },
+    { appservice.PostUnderReviewError{}, entry("post_under_review", http.StatusConflict, "Post is under review") },
+    { appservice.InvalidReactionError{}, entry("invalid_reaction", http.StatusBadRequest, "Invalid reaction") },
+    { appservice.InvalidPostBodyError{}, entry("invalid_post_body", http.StatusBadRequest, "Invalid post body") },
+    { appservice.InvalidPostStatusError{}, entry("invalid_post_status", http.StatusBadRequest, "Invalid post status") },
+    { appservice.InvalidPublishAtError{}, entry("invalid_publish_at", http.StatusBadRequest, "Invalid publish time") },
+    { appservice.InvalidBodyFormatError{}, entry("invalid_body_format", http.StatusBadRequest, "Invalid body format") },
+    { appservice.InvalidVisibilityError{}, entry("invalid_visibility", http.StatusBadRequest, "Invalid visibility") },
+
+    // attachments
+    { appservice.AttachmentTooLargeError{}, entry("attachment_too_large", http.StatusRequestEntityTooLarge, "Attachment is too large") },
+    { appservice.UnsupportedAttachmentTypeError{}, entry("unsupported_attachment_type", http.StatusUnsupportedMediaType, "Unsupported attachment type") },
+    { appservice.TooManyAttachmentsError{}, entry("too_many_attachments", http.StatusConflict, "Too many attachments") },
+
+    // follows, blocks and messages
+    { appservice.SelfFollowError{}, entry("self_follow", http.StatusBadRequest, "Cannot follow yourself") },
+    { appservice.SelfBlockError{}, entry("self_block", http.StatusBadRequest, "Cannot block yourself") },
+    { appservice.SelfMuteError{}, entry("self_mute", http.StatusBadRequest, "Cannot mute yourself") },
+    { appservice.UserBlockedError{}, entry("user_blocked", http.StatusForbidden, "User is blocked") },
+    { appservice.InvalidParticipantsError{}, entry("invalid_participants", http.StatusBadRequest, "Invalid participants") },
+    { appservice.InvalidMessageBodyError{}, entry("invalid_message_body", http.StatusBadRequest, "Invalid message body") },
+    { appservice.InvalidNotificationTypeError{}, entry("invalid_notification_type", http.StatusBadRequest, "Invalid notification type") },
+
+    // moderation
+    { appservice.NotAdminError{}, entry("not_admin", http.StatusForbidden, "Admins only") },
+    { appservice.InvalidReportError{}, entry("invalid_report", http.StatusBadRequest, "Invalid report") },
+    { appservice.ReportClosedError{}, entry("report_closed", http.StatusConflict, "Report is closed") },
+    { appservice.InvalidModerationActionError{}, entry("invalid_moderation_action", http.StatusBadRequest, "Invalid moderation action") },
+    { appservice.PostChangedError{}, entry("post_changed", http.StatusConflict, "Post changed while it was moderated") },
+    { appservice.InvalidFilterError{}, entry("invalid_filter", http.StatusBadRequest, "Invalid filter") },
+    { ResourceForbiddenError{}, entry("forbidden", http.StatusForbidden, "Forbidden") },
+
+    // requests
+    {
+        validation.Errors{},
+        problems.Entry{
+            Code: "validation_failed",
+            Status: http.StatusUnprocessableEntity,
+            Title: "Validation failed",
+            Extensions: func(err error) map[string]any {
+                return map[string]any{ "errors": err.(validation.Errors) };
+            },
+        },
+    },
+    { validation.InvalidJSONError{}, entry("invalid_json", http.StatusBadRequest, "Invalid JSON") },
+    { mergepatch.InvalidPatchError{}, entry("invalid_patch", http.StatusBadRequest, "Invalid patch") },
+    { jsonpatch.InvalidPatchError{}, entry("invalid_patch", http.StatusBadRequest, "Invalid patch") },
+    {
+        jsonpatch.ConflictError{},
+        problems.Entry{
+            Code: "patch_conflict",
+            Status: http.StatusConflict,
+            Title: "Patch does not apply",
+            Extensions: func(err error) map[string]any {
+                return map[string]any{ "path": err.(jsonpatch.ConflictError).Path };
+            },
+        },
+    },
+    {
+        jsonpatch.TestFailedError{},
+        problems.Entry{
+            Code: "patch_test_failed",
+            Status: http.StatusConflict,
+            Title: "Patch test failed",
+            Extensions: func(err error) map[string]any {
+                return map[string]any{ "path": err.(jsonpatch.TestFailedError).Path };
+            },
+        },
+    },
+    { appservice.UnsupportedPatchError{}, entry("unsupported_patch", http.StatusUnsupportedMediaType, "Unsupported patch format") },
+    {
+        fieldset.UnknownFieldError{},
+        problems.Entry{
+            Code: "unknown_field",
+            Status: http.StatusBadRequest,
+            Title: "Unknown field",
+            Extensions: func(err error) map[string]any {
+                return map[string]any{ "field": err.(fieldset.UnknownFieldError).Field };
+            },
+        },
+    },
+    {
+        fieldset.UnknownRelationError{},
+        problems.Entry{
+            Code: "unknown_relation",
+            Status: http.StatusBadRequest,
+            Title: "Unknown relation",
+            Extensions: func(err error) map[string]any {
+                return map[string]any{ "relation": err.(fieldset.UnknownRelationError).Relation };
+            },
+        },
+    },
+    {
+        fieldset.IncludeTooDeepError{},
+        problems.Entry{
+            Code: "include_too_deep",
+            Status: http.StatusBadRequest,
+            Title: "Included relation nested too deep",
+            Extensions: func(err error) map[string]any {
+                return map[string]any{ "relation": err.(fieldset.IncludeTooDeepError).Relation };
+            },
+        },
+    },
+
+    // storage
+    { gorm.ErrRecordNotFound, entry("not_found", http.StatusNotFound, "Resource not found") },
+    { gorm.ErrDuplicatedKey, entry("conflict", http.StatusConflict, "Conflict") },
+    { blobstore.NotFoundError{}, entry("not_found", http.StatusNotFound, "Resource not found") },
+};
+
+var germanTitles = map[string]string{
+    "duplicate_user_email": "E-Mail-Adresse ist bereits registriert",
+    "duplicate_user_username": "Benutzername ist vergeben",
+    "username_reserved": "Benutzername ist reserviert",
+    "invalid_password": "Ungültige Anmeldedaten",
+    "login_blocked": "Zu viele fehlgeschlagene Anmeldungen",
+    "user_suspended": "Konto ist gesperrt",
+    "invalid_username": "Ungültiger Benutzername",
+    "invalid_email": "Ungültige E-Mail-Adresse",
+    "invalid_email_change_token": "Ungültiger oder abgelaufener Bestätigungslink",
+    "invalid_profile": "Ungültiges Profilfeld",
+    "not_post_author": "Nur der Autor darf das",
+    "post_already_published": "Beitrag ist bereits veröffentlicht",
+    "post_under_review": "Beitrag wird geprüft",
+    "invalid_reaction": "Ungültige Reaktion",
+    "invalid_post_body": "Ungültiger Beitragstext",
+    "invalid_post_status": "Ungültiger Beitragsstatus",
+    "invalid_publish_at": "Ungültiger Veröffentlichungszeitpunkt",
+    "invalid_body_format": "Ungültiges Textformat",
+    "invalid_visibility": "Ungültige Sichtbarkeit",
+    "attachment_too_large": "Anhang ist zu groß",
+    "unsupported_attachment_type": "Nicht unterstützter Anhangstyp",
+    "too_many_attachments": "Zu viele Anhänge",
+    "self_follow": "Du kannst dir nicht selbst folgen",
+    "self_block": "Du kannst dich nicht selbst blockieren",
+    "self_mute": "Du kannst dich nicht selbst stummschalten",
+    "user_blocked": "Benutzer ist blockiert",
+    "invalid_participants": "Ungültige Teilnehmer",
+    "invalid_message_body": "Ungültiger Nachrichtentext",
+    "invalid_notification_type": "Ungültiger Benachrichtigungstyp",
+    "not_admin": "Nur für Administratoren",
+    "invalid_report": "Ungültige Meldung",
+    "report_closed": "Meldung ist geschlossen",
+    "invalid_moderation_action": "Ungültige Moderationsaktion",
+    "post_changed": "Beitrag wurde während der Moderation geändert",
+    "invalid_filter": "Ungültiger Filter",
+    "forbidden": "Verboten",
+    "validation_failed": "Validierung fehlgeschlagen",
+    "invalid_json": "Ungültiges JSON",
+    "invalid_patch": "Ungültiger Patch",
+    "patch_conflict": "Patch ist nicht anwendbar",
+    "patch_test_failed": "Patch-Test fehlgeschlagen",
+    "unsupported_patch": "Nicht unterstütztes Patch-Format",
+    "unknown_field": "Unbekanntes Feld",
+    "unknown_relation": "Unbekannte Beziehung",
+    "include_too_deep": "Eingebundene Beziehung zu tief verschachtelt",
+    "not_found": "Ressource nicht gefunden",
+    "conflict": "Konflikt",
+    "bad_request": "Ungültige Anfrage",
+    "unauthorized": "Nicht angemeldet",
+    "method_not_allowed": "Methode nicht erlaubt",
+    "internal_server_error": "Interner Serverfehler",
+};
+
+func init() {
+    for _, item := range catalog {
+        problems.Register(item.err, item.entry);
+    }
+
+    problems.Translate(language.German, germanTitles);
+}
+
+func entry(code string, status int, title string) problems.Entry {
+    return problems.Entry{ Code: code, Status: status, Title: title };
+}
+
+// names what was not found for the routes of a resource, e.g.
+// "post_not_found" in envelopes
+func notFound(err error, resource string) error {
+    if errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, blobstore.NotFoundError{}) {
+        return problems.NotFoundError{ Resource: resource };
+    }
+
+    return err;
+}
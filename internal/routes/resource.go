@@ -0,0 +1,432 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+
+	"gorm.io/gorm"
+
+	"github.com/cxcnxl/go-crud/internal/app_service"
+	"github.com/cxcnxl/go-crud/internal/dto"
+	"github.com/cxcnxl/go-crud/internal/middleware"
+	"github.com/cxcnxl/go-crud/internal/openapi"
+	"github.com/cxcnxl/go-crud/internal/problems"
+	"github.com/cxcnxl/go-crud/internal/responses"
+)
+
+type ResourceOperation string;
+
+const (
+    ResourceList   ResourceOperation = "list";
+    ResourceGet    ResourceOperation = "get";
+    ResourceCreate ResourceOperation = "create";
+    ResourceUpdate ResourceOperation = "update";
+    ResourceDelete ResourceOperation = "delete";
+)
+
+// caller of a resource operation, as hooks and policies see it
+type ResourceRequest struct {
+    Request *http.Request
+    // id of the authenticated user, 0 for anonymous callers
+    UserID  uint
+}
+
+// implemented by DTOs checking themselves. Resource handlers call it on
+// every decoded body after its validation tags, before hooks see it
+type Validator interface {
+    Validate() error
+}
+
+// CRUD routes of a GORM model T, with C and U the DTOs of create and update
+// bodies. Use struct{} for the DTO of an operation the resource leaves out.
+// Routes are Path for list and create, Path + "/{id}" for the rest
+type Resource[T any, C any, U any] struct {
+    // kind of single items in responses, e.g. "filter". Lists use Name + "s"
+    Name        string
+    Path        string
+    // middlewares of each operation, operations left out are not routed
+    Operations  map[ResourceOperation]middleware.MiddlewareSet
+    // column with id of the owning user. When set, callers must be
+    // authenticated and only list, get and change their own rows. Created
+    // and updated rows get the caller as owner, whatever the hooks set
+    OwnerColumn string
+    // narrows every query, e.g. by query params
+    Scope       func(req ResourceRequest, query *gorm.DB) *gorm.DB
+    // decides whether the caller may run the operation. item is nil for
+    // list and create, which are checked before anything is read
+    Policy      func(req ResourceRequest, op ResourceOperation, item *T) error
+    // builds new item from the validated body
+    Create      func(req ResourceRequest, data C) (T, error)
+    // applies the validated body to the item
+    Update      func(req ResourceRequest, item *T, data U) error
+    // run in the transaction of the write, see appservice.WriteHook
+    AfterCreate func(tx *gorm.DB, req ResourceRequest, item *T) error
+    AfterUpdate func(tx *gorm.DB, req ResourceRequest, item *T) error
+    AfterDelete func(tx *gorm.DB, req ResourceRequest, item *T) error
+    // writes errors of hooks and policies instead of writeResourceError
+    WriteError  func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+// routes operations of the resource on methodHandler
+func RegisterResource[T any, C any, U any](
+    methodHandler *MethodHandler,
+    service *appservice.AppService,
+    resource Resource[T, C, U],
+) {
+    if _, ok := resource.Operations[ResourceCreate]; ok && resource.Create == nil {
+        panic("resource " + resource.Name + " routes create without Create hook");
+    }
+    if _, ok := resource.Operations[ResourceUpdate]; ok && resource.Update == nil {
+        panic("resource " + resource.Name + " routes update without Update hook");
+    }
+
+    handler := resourceHandler[T, C, U]{ resource, service };
+    itemPath := resource.Path + "/{id}";
+
+    var item T;
+    var create C;
+    var update U;
+    routes := []struct {
+        op      ResourceOperation
+        method  string
+        path    string
+        handler http.HandlerFunc
+        doc     openapi.Doc
+    }{
+        {
+            ResourceList, "GET", resource.Path, handler.list,
+            openapi.Doc{
+                Summary: "List " + resource.Name + "s, newest first",
+                Response: dto.PageDto[T]{},
+                Kind: resource.Name + "s",
+                Paginated: true,
+            },
+        },
+        {
+            ResourceCreate, "POST", resource.Path, handler.create,
+            openapi.Doc{
+                Summary: "Create " + resource.Name,
+                Request: create,
+                Response: item,
+                Kind: resource.Name,
+                Status: http.StatusCreated,
+            },
+        },
+        {
+            ResourceGet, "GET", itemPath, handler.get,
+            openapi.Doc{
+                Summary: "Get " + resource.Name,
+                Response: item,
+                Kind: resource.Name,
+            },
+        },
+        {
+            ResourceUpdate, "PATCH", itemPath, handler.update,
+            openapi.Doc{
+                Summary: "Update " + resource.Name,
+                Request: update,
+                Response: item,
+                Kind: resource.Name,
+            },
+        },
+        {
+            ResourceDelete, "DELETE", itemPath, handler.delete,
+            openapi.Doc{ Summary: "Delete " + resource.Name },
+        },
+    };
+    for _, route := range routes {
+        middlewares, ok := resource.Operations[route.op];
+        if !ok {
+            continue;
+        }
+
+        methodHandler.HandleFunc(route.method, route.path, route.handler, middlewares, route.doc);
+    }
+}
+
+type resourceHandler[T any, C any, U any] struct {
+    resource Resource[T, C, U]
+    service  *appservice.AppService
+}
+
+// store of the service serving r, see appservice.AppService.For
+func (self resourceHandler[T, C, U]) store(r *http.Request) appservice.Store[T] {
+    return appservice.NewStore[T](self.service.For(r.Context()));
+}
+
+func (self resourceHandler[T, C, U]) list(w http.ResponseWriter, r *http.Request) {
+    req, ok := self.request(w, r);
+    if !ok {
+        return;
+    }
+    if err := self.authorize(req, ResourceList, nil); err != nil {
+        self.writeError(w, r, err);
+        return;
+    }
+
+    before, limit := pageParams(r);
+
+    page, err := self.store(r).List(self.scope(req), before, limit);
+    if err != nil {
+        self.writeError(w, r, err);
+        return;
+    }
+
+    response := responses.NewDataResponse(self.resource.Name + "s", page);
+    responses.Render(w, r, response);
+}
+
+func (self resourceHandler[T, C, U]) get(w http.ResponseWriter, r *http.Request) {
+    _, item, ok := self.load(w, r, ResourceGet);
+    if !ok {
+        return;
+    }
+
+    response := responses.NewDataResponse(self.resource.Name, item);
+    responses.Render(w, r, response);
+}
+
+func (self resourceHandler[T, C, U]) create(w http.ResponseWriter, r *http.Request) {
+    defer r.Body.Close();
+
+    req, ok := self.request(w, r);
+    if !ok {
+        return;
+    }
+    if err := self.authorize(req, ResourceCreate, nil); err != nil {
+        self.writeError(w, r, err);
+        return;
+    }
+
+    data, ok := decodeResourceBody[C](w, r);
+    if !ok {
+        return;
+    }
+
+    item, err := self.resource.Create(req, data);
+    if err == nil {
+        err = self.setOwner(r, req, &item);
+    }
+    if err != nil {
+        self.writeError(w, r, err);
+        return;
+    }
+
+    err = self.store(r).Create(&item, self.hook(req, self.resource.AfterCreate));
+    if err != nil {
+        self.writeError(w, r, err);
+        return;
+    }
+
+    response := responses.NewDataResponse(self.resource.Name, item);
+    responses.RenderStatus(w, r, http.StatusCreated, response);
+}
+
+// applies the body to the item while its row is locked, so concurrent
+// updates do not overwrite each other
+func (self resourceHandler[T, C, U]) update(w http.ResponseWriter, r *http.Request) {
+    defer r.Body.Close();
+
+    id, ok := self.itemId(w, r);
+    if !ok {
+        return;
+    }
+
+    req, ok := self.request(w, r);
+    if !ok {
+        return;
+    }
+
+    data, ok := decodeResourceBody[U](w, r);
+    if !ok {
+        return;
+    }
+
+    item, err := self.store(r).Update(self.scope(req), id, func(item *T) error {
+        if err := self.authorize(req, ResourceUpdate, item); err != nil {
+            return err;
+        }
+        if err := self.resource.Update(req, item, data); err != nil {
+            return err;
+        }
+
+        return self.setOwner(r, req, item);
+    }, self.hook(req, self.resource.AfterUpdate));
+    if err != nil {
+        self.writeError(w, r, err);
+        return;
+    }
+
+    response := responses.NewDataResponse(self.resource.Name, item);
+    responses.Render(w, r, response);
+}
+
+func (self resourceHandler[T, C, U]) delete(w http.ResponseWriter, r *http.Request) {
+    req, item, ok := self.load(w, r, ResourceDelete);
+    if !ok {
+        return;
+    }
+
+    err := self.store(r).Delete(&item, self.hook(req, self.resource.AfterDelete));
+    if err != nil {
+        self.writeError(w, r, err);
+        return;
+    }
+
+    w.WriteHeader(http.StatusNoContent);
+}
+
+// reads the caller, owned resources need an authenticated one
+func (self resourceHandler[T, C, U]) request(
+    w http.ResponseWriter,
+    r *http.Request,
+) (ResourceRequest, bool) {
+    userId, _ := authUserId(r);
+    if self.resource.OwnerColumn != "" && userId == 0 {
+        problems.Write(w, r, problems.Status(http.StatusUnauthorized, "unauthorized"));
+        return ResourceRequest{}, false;
+    }
+
+    return ResourceRequest{ Request: r, UserID: userId }, true;
+}
+
+// loads the item of the path and checks the policy on it
+func (self resourceHandler[T, C, U]) load(
+    w http.ResponseWriter,
+    r *http.Request,
+    op ResourceOperation,
+) (ResourceRequest, T, bool) {
+    var item T;
+
+    id, ok := self.itemId(w, r);
+    if !ok {
+        return ResourceRequest{}, item, false;
+    }
+
+    req, ok := self.request(w, r);
+    if !ok {
+        return req, item, false;
+    }
+
+    item, err := self.store(r).Get(self.scope(req), id);
+    if err != nil {
+        self.writeError(w, r, err);
+        return req, item, false;
+    }
+
+    if err := self.authorize(req, op, &item); err != nil {
+        self.writeError(w, r, err);
+        return req, item, false;
+    }
+
+    return req, item, true;
+}
+
+// id of the item in the path
+func (self resourceHandler[T, C, U]) itemId(w http.ResponseWriter, r *http.Request) (uint, bool) {
+    id, ok := pathId(r, "id");
+    if !ok {
+        problems.Write(w, r, problems.Status(http.StatusBadRequest, "Invalid " + self.resource.Name + " id"));
+    }
+
+    return id, ok;
+}
+
+// makes the caller owner of the item of an owned resource
+func (self resourceHandler[T, C, U]) setOwner(r *http.Request, req ResourceRequest, item *T) error {
+    if self.resource.OwnerColumn == "" {
+        return nil;
+    }
+
+    return self.store(r).SetColumn(item, self.resource.OwnerColumn, req.UserID);
+}
+
+func (self resourceHandler[T, C, U]) authorize(
+    req ResourceRequest,
+    op ResourceOperation,
+    item *T,
+) error {
+    if self.resource.Policy == nil {
+        return nil;
+    }
+
+    return self.resource.Policy(req, op, item);
+}
+
+// combines ownership with the scope of the resource
+func (self resourceHandler[T, C, U]) scope(req ResourceRequest) appservice.Scope {
+    return func(query *gorm.DB) *gorm.DB {
+        if self.resource.OwnerColumn != "" {
+            query = query.Where(self.resource.OwnerColumn + " = ?", req.UserID);
+        }
+        if self.resource.Scope != nil {
+            query = self.resource.Scope(req, query);
+        }
+
+        return query;
+    };
+}
+
+func (self resourceHandler[T, C, U]) hook(
+    req ResourceRequest,
+    hook func(tx *gorm.DB, req ResourceRequest, item *T) error,
+) appservice.WriteHook[T] {
+    if hook == nil {
+        return nil;
+    }
+
+    return func(tx *gorm.DB, item *T) error {
+        return hook(tx, req, item);
+    };
+}
+
+func (self resourceHandler[T, C, U]) writeError(
+    w http.ResponseWriter,
+    r *http.Request,
+    err error,
+) {
+    if self.resource.WriteError != nil {
+        self.resource.WriteError(w, r, err);
+        return;
+    }
+
+    writeResourceError(w, r, self.resource.Name, err);
+}
+
+// decodes and validates body of a create or update request or writes
+// an error
+func decodeResourceBody[D any](w http.ResponseWriter, r *http.Request) (D, bool) {
+    var data D;
+    if !decodeValid(w, r, &data) {
+        return data, false;
+    }
+
+    if validator, ok := any(&data).(Validator); ok {
+        if err := validator.Validate(); err != nil {
+            // uncataloged errors of DTOs are bad requests, not failures
+            if _, _, ok := problems.Lookup(err); !ok {
+                err = problems.Status(http.StatusBadRequest, err.Error());
+            }
+            problems.Write(w, r, err);
+            return data, false;
+        }
+    }
+
+    return data, true;
+}
+
+// refused by the policy of a resource
+type ResourceForbiddenError struct {}
+func (self ResourceForbiddenError) Error() string {
+    return "forbidden";
+}
+
+// names the resource in envelopes, e.g. "filter_not_found" or
+// "filter_exists"
+func writeResourceError(w http.ResponseWriter, r *http.Request, name string, err error) {
+    if errors.Is(err, gorm.ErrDuplicatedKey) {
+        err = problems.Status(http.StatusConflict, name + "_exists");
+    }
+
+    problems.Write(w, r, notFound(err, name));
+}
@@ -1,26 +1,36 @@
 package routes
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
+	"mime"
 	"net/http"
-
-	"gorm.io/gorm"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/cxcnxl/go-crud/internal/app_service"
 	auth_helpers "github.com/cxcnxl/go-crud/internal/auth_helpers"
+	"github.com/cxcnxl/go-crud/internal/codec"
 	"github.com/cxcnxl/go-crud/internal/dto"
+	"github.com/cxcnxl/go-crud/internal/fieldset"
 	"github.com/cxcnxl/go-crud/internal/middleware"
+	"github.com/cxcnxl/go-crud/internal/openapi"
+	"github.com/cxcnxl/go-crud/internal/problems"
+	"github.com/cxcnxl/go-crud/internal/realtime"
 	"github.com/cxcnxl/go-crud/internal/responses"
-	"github.com/cxcnxl/go-crud/internal/redis"
+	"github.com/cxcnxl/go-crud/internal/rpcapi"
+	"github.com/cxcnxl/go-crud/internal/validation"
 )
 
-func NewRouter(db *gorm.DB, redis *redis.RedisWrapper) *MethodHandler {
+func NewRouter(service *appservice.AppService, hub *realtime.Hub) *MethodHandler {
+    auth := middleware.NewAuthSets(service.CheckActiveUser);
     mux := http.NewServeMux();
-    service := appservice.NewAppService(db, redis);
     methodHandler := NewMethodHandler(mux);
 
     methodHandler.HandleFunc(
@@ -28,26 +38,68 @@ func NewRouter(db *gorm.DB, redis *redis.RedisWrapper) *MethodHandler {
         "/",
         routeIndex(service),
         middleware.UtilMiddleware,
+        openapi.Doc{
+            Summary: "Greeting, tells the API is up",
+            Response: openapi.Schema{ "type": "string" },
+            ResponseType: "text/plain",
+        },
     );
     methodHandler.HandleFunc(
         "POST",
         "/register",
         routeRegister(service),
         middleware.UtilMiddleware,
+        openapi.Doc{
+            Summary: "Create an account",
+            Request: dto.CreateUserDto{},
+            Response: dto.UserViewDto{},
+            Kind: "user",
+        },
     );
     methodHandler.HandleFunc(
         "POST",
         "/login",
         routeLogin(service),
         middleware.UtilMiddleware,
+        openapi.Doc{
+            Summary: "Log in, returns a JWT",
+            Request: dto.PostLoginDto{},
+            Response: map[string]string{},
+            Kind: "auth",
+            Status: http.StatusCreated,
+        },
     );
     methodHandler.HandleFunc(
         "GET",
         "/me",
         routeMe(service),
-        middleware.AuthMiddleware,
+        auth.Required,
+        openapi.Doc{
+            Summary: "Current user",
+            Response: dto.UserViewDto{},
+            Kind: "user",
+            Query: fieldsQuery(fieldset.User, nil),
+        },
     );
 
+    registerUserRoutes(methodHandler, service);
+    registerPostRoutes(methodHandler, service);
+    registerFollowRoutes(methodHandler, service);
+    registerAttachmentRoutes(methodHandler, service);
+    registerNotificationRoutes(methodHandler, service);
+    registerStreamRoutes(methodHandler, service, hub);
+    registerFeedRoutes(methodHandler, service);
+    registerMessageRoutes(methodHandler, service);
+    registerModerationRoutes(methodHandler, service);
+    registerGraphRoutes(methodHandler, service);
+    registerBatchRoutes(methodHandler, service);
+    registerDocsRoutes(methodHandler);
+
+    // Connect and gRPC counterparts of the account and post routes, kept
+    // out of the OpenAPI document
+    methodHandler.Mux.Handle(rpcapi.NewHandler(service));
+
+
     return methodHandler;
 }
 
@@ -62,65 +114,43 @@ func routeIndex(_ *appservice.AppService) http.HandlerFunc {
 
 func routeRegister(service *appservice.AppService) http.HandlerFunc {
     return http.HandlerFunc(func (w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
         defer r.Body.Close();
 
-        body, err := io.ReadAll(r.Body);
-        if err != nil {
-            error := responses.NewErrorResponse("Failed to read request body");
-            http.Error(w, error.JsonString(), http.StatusBadRequest);
-            return;
-        }
-
         var data dto.CreateUserDto;
-        if err := json.Unmarshal(body, &data); err != nil {
-            error := responses.NewErrorResponse("Invalid payload. Expected JSON");
-            http.Error(w, error.JsonString(), http.StatusBadRequest);
+        if !decodeValid(w, r, &data) {
             return;
         }
 
         user, err := service.CreateUser(data);
         if err != nil {
-            if errors.Is(err, appservice.DuplicateUserEmailError{}) ||
-                errors.Is(err, appservice.DuplicateUserUsernameError{}) {
-                error := responses.NewErrorResponse(err.Error());
-                http.Error(w, error.JsonString(), http.StatusConflict);
-                return;
-            }
-
-            slog.Error(err.Error());
-            error := responses.NewErrorResponse("Failed to create user");
-            http.Error(w, error.JsonString(), http.StatusInternalServerError);
+            problems.Write(w, r, err);
             return;
         }
 
-        response := responses.NewDataResponse("user", user);
-        w.Header().Add("Content-Type", "application/json");
-        w.Write(response.Json());
+        response := responses.NewDataResponse("user", dto.UserViewDto{
+            User: user,
+            Email: user.Email,
+        });
+        responses.Render(w, r, response);
     });
 }
 
 func routeLogin(service *appservice.AppService) http.HandlerFunc {
     return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
         defer r.Body.Close();
 
-        body, err := io.ReadAll(r.Body);
-        if err != nil {
-            error := responses.NewErrorResponse("Failed to read request body");
-            http.Error(w, error.JsonString(), http.StatusBadRequest);
-            return;
-        }
-        
         var data dto.PostLoginDto;
-        if err := json.Unmarshal(body, &data); err != nil {
-            error := responses.NewErrorResponse("Invalid body");
-            http.Error(w, error.JsonString(), http.StatusBadRequest);
+        if !decodeValid(w, r, &data) {
             return;
         }
 
         user, err := service.LoginUser(data);
         if err != nil {
-            error := responses.NewErrorResponse(err.Error());
-            http.Error(w, error.JsonString(), http.StatusUnauthorized);
+            // bad credentials answer 401, blocked logins 429 and the rest,
+            // e.g. database outages, 500
+            problems.Write(w, r, err);
             return;
         }
 
@@ -135,56 +165,362 @@ func routeLogin(service *appservice.AppService) http.HandlerFunc {
         res := responses.NewDataResponse("auth", map[string]string{
             "auth_token": jwt,
         });
-        w.Header().Add("Content-Type", "application/json");
-        w.WriteHeader(http.StatusCreated);
-        w.Write(res.Json());
+        responses.RenderStatus(w, r, http.StatusCreated, res);
     });
 }
 
-func routeMe(_ *appservice.AppService) http.HandlerFunc {
+// returns the stored user, claims in the token may be out of date
+func routeMe(service *appservice.AppService) http.HandlerFunc {
     return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-        defer r.Body.Close();
+        service := service.For(r.Context());
+        userId, ok := authUserId(r);
+        if !ok {
+            problems.Write(w, r, problems.Status(http.StatusUnauthorized, "unauthorized"));
+            return;
+        }
+
+        spec, ok := readSpec(w, r, fieldset.User);
+        if !ok {
+            return;
+        }
 
-        auth := r.Context().Value("auth");
+        user, err := service.GetMe(userId);
+        if err != nil {
+            writeProfileError(w, r, err);
+            return;
+        }
 
-        data, _ := json.Marshal(auth);
-        w.Write(data);
+        renderProjected(w, r, "user", spec, user);
     });
 }
 
 // ---------- Utils -----------
 
+// returns id of the authenticated user from JWT claims put into context
+// by JWTAutherMiddleware
+func authUserId(r *http.Request) (uint, bool) {
+    claims, ok := r.Context().Value("auth").(map[string]any);
+    if !ok {
+        return 0, false;
+    }
+
+    // json numbers are decoded as float64
+    id, ok := claims["id"].(float64);
+    if !ok || id <= 0 {
+        return 0, false;
+    }
+
+    return uint(id), true;
+}
+
+// largest request body read, larger ones fail with 413
+const maxBodySize int64 = 1 << 20;
+
+// reads the request body as JSON text. Bodies of other registered formats,
+// e.g. MessagePack, are transcoded, see codec.ForContentType. Errors are
+// problems ready for problems.Write
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+    body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize));
+    if err != nil {
+        var tooLarge *http.MaxBytesError;
+        if errors.As(err, &tooLarge) {
+            return nil, problems.Status(http.StatusRequestEntityTooLarge, "Request body is too large");
+        }
+        return nil, problems.Status(http.StatusBadRequest, "Failed to read request body");
+    }
+
+    format, ok := codec.ForContentType(r.Header.Get("Content-Type"));
+    if !ok {
+        return body, nil;
+    }
+
+    body, err = format.ToJSON(body);
+    if err != nil {
+        return nil, problems.Status(http.StatusBadRequest, "Failed to read request body");
+    }
+
+    return body, nil;
+}
+
+// media type of a PATCH body, one of appservice.PatchContentTypes or JSON
+// for plain bodies of every codec, which readBody turns into JSON. Writes
+// 415 with Accept-Patch for anything else
+func patchContentType(w http.ResponseWriter, r *http.Request) (string, bool) {
+    header := r.Header.Get("Content-Type");
+    contentType, _, _ := mime.ParseMediaType(header);
+    if slices.Contains(appservice.PatchContentTypes, contentType) {
+        return contentType, true;
+    }
+    if _, ok := codec.ForContentType(header); ok || header == "" {
+        return codec.JSON.ContentType, true;
+    }
+
+    w.Header().Set("Accept-Patch", strings.Join(appservice.PatchContentTypes, ", "));
+    problems.Write(w, r, appservice.UnsupportedPatchError{});
+    return "", false;
+}
+
+// strictly decodes JSON body into data and checks its validation tags.
+// Writes 400 for malformed bodies and 422 naming the failing fields
+func decodeValid(w http.ResponseWriter, r *http.Request, data any) bool {
+    body, err := readBody(w, r);
+    if err != nil {
+        problems.Write(w, r, err);
+        return false;
+    }
+
+    return validBody(w, r, body, data);
+}
+
+// decodeValid for a body read already, e.g. one that may be empty or
+// needs its content type checked first
+func validBody(w http.ResponseWriter, r *http.Request, body []byte, data any) bool {
+    err := validation.Decode(body, data);
+    if err == nil {
+        err = validation.Struct(data);
+    }
+
+    var fields validation.Errors;
+    if errors.As(err, &fields) {
+        problems.Write(w, r, fields);
+        return false;
+    }
+    if err != nil {
+        problems.Write(w, r, problems.Status(http.StatusBadRequest, "Invalid payload. Expected JSON"));
+        return false;
+    }
+
+    return true;
+}
+
+// reads cursor pagination params from the query. Invalid values fall back
+// to defaults
+func pageParams(r *http.Request) (uint, int) {
+    before, err := strconv.ParseUint(r.URL.Query().Get("before"), 10, 64);
+    if err != nil {
+        before = 0;
+    }
+
+    return uint(before), pageLimit(r);
+}
+
+// reads keyset pagination params from the query, see pageParams
+func keysetParams(r *http.Request) (dto.Keyset, int) {
+    var before dto.Keyset;
+    if err := before.UnmarshalText([]byte(r.URL.Query().Get("before"))); err != nil {
+        before = dto.Keyset{};
+    }
+
+    return before, pageLimit(r);
+}
+
+func pageLimit(r *http.Request) int {
+    limit, err := strconv.Atoi(r.URL.Query().Get("limit"));
+    if err != nil || limit <= 0 {
+        limit = defaultPageSize;
+    }
+    if limit > maxPageSize {
+        limit = maxPageSize;
+    }
+
+    return limit;
+}
+
+const defaultPageSize int = 20;
+const maxPageSize int = 100;
+
+// query docs of reads taking ?fields= and, for models with relations,
+// ?include=
+func fieldsQuery(model *fieldset.Model, query map[string]string) map[string]string {
+    docs := map[string]string{
+        "fields": "comma separated members to return, e.g. id,username. Members of included relations are dotted",
+    };
+    if len(model.Relations) > 0 {
+        docs["include"] = fmt.Sprintf(
+            "comma separated relations to return, up to %d deep, e.g. author. Empty for none",
+            fieldset.MaxDepth,
+        );
+    }
+    maps.Copy(docs, query);
+
+    return docs;
+}
+
+// parses ?fields= and ?include= against the allowlist of model. Writes 400
+// for members and relations it does not allow
+func readSpec(w http.ResponseWriter, r *http.Request, model *fieldset.Model) (fieldset.Spec, bool) {
+    spec, err := fieldset.Parse(model, r.URL.Query());
+    if err != nil {
+        problems.Write(w, r, err);
+        return spec, false;
+    }
+
+    return spec, true;
+}
+
+// renders data with only the members spec asks for
+func renderProjected(w http.ResponseWriter, r *http.Request, kind string, spec fieldset.Spec, data any) {
+    projected, err := spec.Project(data);
+    if err != nil {
+        problems.Write(w, r, err);
+        return;
+    }
+
+    response := responses.NewDataResponse(kind, projected);
+    responses.Render(w, r, response);
+}
+
+// renders page with its items projected, see renderProjected
+func renderProjectedPage[T any](
+    w http.ResponseWriter,
+    r *http.Request,
+    kind string,
+    spec fieldset.Spec,
+    page dto.PageDto[T],
+) {
+    items, ok := projectItems(w, r, spec, page.Items);
+    if !ok {
+        return;
+    }
+
+    response := responses.NewDataResponse(kind, dto.PageDto[any]{ Items: items, NextCursor: page.NextCursor });
+    responses.Render(w, r, response);
+}
+
+func renderProjectedKeysetPage[T any](
+    w http.ResponseWriter,
+    r *http.Request,
+    kind string,
+    spec fieldset.Spec,
+    page dto.KeysetPageDto[T],
+) {
+    items, ok := projectItems(w, r, spec, page.Items);
+    if !ok {
+        return;
+    }
+
+    response := responses.NewDataResponse(kind, dto.KeysetPageDto[any]{ Items: items, NextCursor: page.NextCursor });
+    responses.Render(w, r, response);
+}
+
+func projectItems[T any](w http.ResponseWriter, r *http.Request, spec fieldset.Spec, items []T) ([]any, bool) {
+    projected, err := spec.Project(items);
+    if err != nil {
+        problems.Write(w, r, err);
+        return nil, false;
+    }
+
+    list, _ := projected.([]any);
+    return list, true;
+}
+
+func pathId(r *http.Request, name string) (uint, bool) {
+    id, err := strconv.ParseUint(r.PathValue(name), 10, 64);
+    if err != nil || id == 0 {
+        return 0, false;
+    }
+
+    return uint(id), true;
+}
+
 type MethodHandler struct {
     Mux *http.ServeMux
     methods map[string]middleware.MiddlewareSet
+    handlers map[string]map[string]http.HandlerFunc
+    docs map[string]openapi.Doc
 }
 
 func NewMethodHandler(mux *http.ServeMux) *MethodHandler {
     return &MethodHandler{
         mux,
         make(map[string]middleware.MiddlewareSet),
+        make(map[string]map[string]http.HandlerFunc),
+        make(map[string]openapi.Doc),
     };
 }
 
+// routes handler for method and path. doc describes the route in the
+// OpenAPI spec, routes without one are left out of it
 func (self *MethodHandler) HandleFunc(
     method string,
     path string,
     handler http.HandlerFunc,
     middlewares middleware.MiddlewareSet,
+    doc ...openapi.Doc,
 ) {
     self.methods[method+path] = middlewares;
+    if len(doc) > 0 {
+        self.docs[method+path] = doc[0];
+    }
 
     wrapped := middleware.Wrap(handler, middlewares);
 
+    // mux accepts every pattern once, so all methods of a path share
+    // a single dispatcher
+    if handlers, ok := self.handlers[path]; ok {
+        handlers[method] = wrapped;
+        return;
+    }
+
+    handlers := map[string]http.HandlerFunc{ method: wrapped };
+    self.handlers[path] = handlers;
+
     self.Mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
-        if r.Method == method {
+        if wrapped, ok := handlers[r.Method]; ok {
             wrapped(w, r);
         } else {
             // TODO: this avoides logger and restore middlewares
-            error := responses.NewErrorResponse("Method not allowed");
-            w.Header().Add("Content-Type", "application/json");
-            w.WriteHeader(http.StatusMethodNotAllowed);
-            w.Write(error.Json());
+            problems.Write(w, r, problems.Status(http.StatusMethodNotAllowed, "Method not allowed"));
         }
     });
 }
+
+// every registered route, sorted by path and method
+func (self *MethodHandler) Routes() []openapi.Route {
+    routes := make([]openapi.Route, 0, len(self.methods));
+    for key, middlewares := range self.methods {
+        // paths start with a slash, methods never have one
+        split := strings.Index(key, "/");
+        route := openapi.Route{
+            Method: key[:split],
+            Path: key[split:],
+            Auth: authOf(middlewares),
+        };
+        if doc, ok := self.docs[key]; ok {
+            route.Doc = &doc;
+        }
+
+        routes = append(routes, route);
+    }
+
+    sort.Slice(routes, func(i, j int) bool {
+        if routes[i].Path != routes[j].Path {
+            return routes[i].Path < routes[j].Path;
+        }
+        return routes[i].Method < routes[j].Method;
+    });
+
+    return routes;
+}
+
+// OpenAPI spec of the documented routes
+func (self *MethodHandler) OpenAPI() openapi.Document {
+    return openapi.Build("go-crud", "1.0.0", self.Routes());
+}
+
+// tells from the middlewares whether a route needs the caller to log in
+func authOf(middlewares middleware.MiddlewareSet) openapi.Auth {
+    required := reflect.ValueOf(middleware.JWTAutherMiddleware).Pointer();
+    optional := reflect.ValueOf(middleware.OptionalJWTAutherMiddleware).Pointer();
+
+    for _, m := range middlewares {
+        switch reflect.ValueOf(m).Pointer() {
+        case required:
+            return openapi.AuthRequired;
+        case optional:
+            return openapi.AuthOptional;
+        }
+    }
+
+    return openapi.AuthNone;
+}
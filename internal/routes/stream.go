@@ -0,0 +1,258 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/cxcnxl/go-crud/internal/app_service"
+	"github.com/cxcnxl/go-crud/internal/middleware"
+	"github.com/cxcnxl/go-crud/internal/openapi"
+	"github.com/cxcnxl/go-crud/internal/problems"
+	"github.com/cxcnxl/go-crud/internal/realtime"
+	"github.com/cxcnxl/go-crud/internal/redis"
+)
+
+const heartbeatInterval = 25 * time.Second;
+const wsWriteTimeout = 10 * time.Second;
+
+var upgrader = websocket.Upgrader{
+    ReadBufferSize: 1024,
+    WriteBufferSize: 1024,
+};
+
+func registerStreamRoutes(
+    methodHandler *MethodHandler,
+    service *appservice.AppService,
+    hub *realtime.Hub,
+) {
+    auth := middleware.NewAuthSets(service.CheckActiveUser);
+    methodHandler.HandleFunc(
+        "GET",
+        "/stream",
+        routeStream(service, hub),
+        auth.Stream,
+        openapi.Doc{
+            Summary: "Server-sent events of the caller",
+            Response: openapi.Schema{ "type": "string" },
+            ResponseType: "text/event-stream",
+            Query: map[string]string{
+                "access_token": "JWT, for clients that cannot set headers",
+                "last_event_id": "replays events after it",
+            },
+        },
+    );
+    methodHandler.HandleFunc(
+        "GET",
+        "/ws",
+        routeWebSocket(service, hub),
+        auth.Stream,
+        openapi.Doc{
+            Summary: "WebSocket with events of the caller",
+            Status: http.StatusSwitchingProtocols,
+            Query: map[string]string{
+                "access_token": "JWT, for clients that cannot set headers",
+                "last_event_id": "replays events after it",
+            },
+        },
+    );
+}
+
+// server-sent events. Resumes after Last-Event-ID header when given
+func routeStream(service *appservice.AppService, hub *realtime.Hub) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        userId, ok := authUserId(r);
+        if !ok {
+            problems.Write(w, r, problems.Status(http.StatusUnauthorized, "unauthorized"));
+            return;
+        }
+
+        flusher, ok := w.(http.Flusher);
+        if !ok {
+            problems.Write(w, r, problems.Status(http.StatusInternalServerError, "Streaming not supported"));
+            return;
+        }
+
+        lastId := r.Header.Get("Last-Event-ID");
+        if lastId == "" {
+            lastId = r.URL.Query().Get("last_event_id");
+        }
+
+        subscriber := hub.Subscribe(userId);
+        defer hub.Unsubscribe(subscriber);
+
+        w.Header().Set("Content-Type", "text/event-stream");
+        w.Header().Set("Cache-Control", "no-cache");
+        w.Header().Set("X-Accel-Buffering", "no");
+        w.WriteHeader(http.StatusOK);
+        flusher.Flush();
+
+        send := func(event redis.StreamEvent) error {
+            _, err := fmt.Fprintf(
+                w,
+                "id: %s\nevent: %s\ndata: %s\n\n",
+                event.ID,
+                event.Type,
+                event.Data,
+            );
+            flusher.Flush();
+            return err;
+        };
+
+        lastId, err := replayEvents(service, userId, lastId, send);
+        if err != nil {
+            slog.Error("Error replaying events: " + err.Error());
+            return;
+        }
+
+        heartbeat := time.NewTicker(heartbeatInterval);
+        defer heartbeat.Stop();
+
+        for {
+            select {
+            case <-r.Context().Done():
+                return;
+            case <-subscriber.Dropped:
+                // slow client, it reconnects with Last-Event-ID
+                fmt.Fprint(w, "event: dropped\ndata: {}\n\n");
+                flusher.Flush();
+                return;
+            case <-heartbeat.C:
+                _, err := fmt.Fprint(w, ": ping\n\n");
+                if err != nil {
+                    return;
+                }
+                flusher.Flush();
+            case event := <-subscriber.Events:
+                if !realtime.IdAfter(event.ID, lastId) {
+                    continue;
+                }
+                if err := send(event); err != nil {
+                    return;
+                }
+                lastId = event.ID;
+            }
+        }
+    });
+}
+
+// websocket carrying events as JSON text messages. Resumes after
+// ?last_event_id= when given
+func routeWebSocket(service *appservice.AppService, hub *realtime.Hub) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        userId, ok := authUserId(r);
+        if !ok {
+            problems.Write(w, r, problems.Status(http.StatusUnauthorized, "unauthorized"));
+            return;
+        }
+
+        conn, err := upgrader.Upgrade(w, r, nil);
+        if err != nil {
+            // upgrader already replied with an error
+            return;
+        }
+        defer conn.Close();
+
+        subscriber := hub.Subscribe(userId);
+        defer hub.Unsubscribe(subscriber);
+
+        // reader only handles control frames and notices disconnects
+        closed := make(chan struct{});
+        conn.SetReadLimit(512);
+        conn.SetReadDeadline(time.Now().Add(2 * heartbeatInterval));
+        conn.SetPongHandler(func(string) error {
+            return conn.SetReadDeadline(time.Now().Add(2 * heartbeatInterval));
+        });
+        go func() {
+            defer close(closed);
+            for {
+                if _, _, err := conn.ReadMessage(); err != nil {
+                    return;
+                }
+            }
+        }();
+
+        send := func(event redis.StreamEvent) error {
+            message, err := json.Marshal(event);
+            if err != nil {
+                return err;
+            }
+
+            conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout));
+            return conn.WriteMessage(websocket.TextMessage, message);
+        };
+
+        lastId, err := replayEvents(service, userId, r.URL.Query().Get("last_event_id"), send);
+        if err != nil {
+            slog.Error("Error replaying events: " + err.Error());
+            return;
+        }
+
+        heartbeat := time.NewTicker(heartbeatInterval);
+        defer heartbeat.Stop();
+
+        for {
+            select {
+            case <-closed:
+                return;
+            case <-subscriber.Dropped:
+                conn.WriteControl(
+                    websocket.CloseMessage,
+                    websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "slow consumer"),
+                    time.Now().Add(wsWriteTimeout),
+                );
+                return;
+            case <-heartbeat.C:
+                err := conn.WriteControl(
+                    websocket.PingMessage,
+                    nil,
+                    time.Now().Add(wsWriteTimeout),
+                );
+                if err != nil {
+                    return;
+                }
+            case event := <-subscriber.Events:
+                if !realtime.IdAfter(event.ID, lastId) {
+                    continue;
+                }
+                if err := send(event); err != nil {
+                    return;
+                }
+                lastId = event.ID;
+            }
+        }
+    });
+}
+
+// sends events missed since lastId. Returns id of the last event sent, so
+// live events already replayed can be skipped
+func replayEvents(
+    service *appservice.AppService,
+    userId uint,
+    lastId string,
+    send func(redis.StreamEvent) error,
+) (string, error) {
+    if lastId == "" {
+        return "", nil;
+    }
+
+    events, err := service.GetEventsAfter(userId, lastId);
+    if err != nil {
+        return lastId, err;
+    }
+
+    for _, event := range events {
+        if err := send(event); err != nil {
+            return lastId, err;
+        }
+        lastId = event.ID;
+    }
+
+    return lastId, nil;
+}
@@ -0,0 +1,252 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"gorm.io/gorm"
+
+	"github.com/cxcnxl/go-crud/internal/app_service"
+	"github.com/cxcnxl/go-crud/internal/dto"
+	"github.com/cxcnxl/go-crud/internal/fieldset"
+	"github.com/cxcnxl/go-crud/internal/mergepatch"
+	"github.com/cxcnxl/go-crud/internal/middleware"
+	"github.com/cxcnxl/go-crud/internal/models"
+	"github.com/cxcnxl/go-crud/internal/openapi"
+	"github.com/cxcnxl/go-crud/internal/problems"
+	"github.com/cxcnxl/go-crud/internal/responses"
+)
+
+func registerUserRoutes(methodHandler *MethodHandler, service *appservice.AppService) {
+    auth := middleware.NewAuthSets(service.CheckActiveUser);
+    methodHandler.HandleFunc(
+        "PATCH",
+        "/me",
+        routeUpdateProfile(service),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Update own profile with a JSON merge patch or JSON patch",
+            Request: dto.ProfileDto{},
+            RequestType: mergepatch.ContentType,
+            Response: dto.UserViewDto{},
+            Kind: "user",
+        },
+    );
+    methodHandler.HandleFunc(
+        "PUT",
+        "/me/username",
+        routeChangeUsername(service),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Change own username",
+            Request: dto.ChangeUsernameDto{},
+            Response: dto.UserViewDto{},
+            Kind: "user",
+        },
+    );
+    methodHandler.HandleFunc(
+        "GET",
+        "/me/username-history",
+        routeUsernameHistory(service),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Previous usernames, newest first",
+            Response: []models.UsernameChange{},
+            Kind: "username_history",
+        },
+    );
+    methodHandler.HandleFunc(
+        "POST",
+        "/me/email",
+        routeChangeEmail(service),
+        auth.Required,
+        openapi.Doc{
+            Summary: "Request email change, mails a confirmation link to the new address",
+            Request: dto.ChangeEmailDto{},
+            Status: http.StatusAccepted,
+        },
+    );
+    // opened from the confirmation mail, the token identifies the user
+    methodHandler.HandleFunc(
+        "GET",
+        "/me/email/confirm",
+        routeConfirmEmailChange(service),
+        middleware.UtilMiddleware,
+        openapi.Doc{
+            Summary: "Confirm email change from the mailed link",
+            Response: dto.UserViewDto{},
+            Kind: "user",
+            Query: map[string]string{ "token": "token from the confirmation link" },
+        },
+    );
+    methodHandler.HandleFunc(
+        "GET",
+        "/users/{username}",
+        routeProfile(service),
+        auth.Optional,
+        openapi.Doc{
+            Summary: "Public profile, old usernames redirect to the current one",
+            Response: dto.UserViewDto{},
+            Kind: "user",
+            Query: fieldsQuery(fieldset.User, nil),
+        },
+    );
+}
+
+// updates profile with a JSON merge patch, null members reset fields, or
+// a JSON patch
+func routeUpdateProfile(service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        defer r.Body.Close();
+
+        userId, ok := authUserId(r);
+        if !ok {
+            problems.Write(w, r, problems.Status(http.StatusUnauthorized, "unauthorized"));
+            return;
+        }
+
+        contentType, ok := patchContentType(w, r);
+        if !ok {
+            return;
+        }
+
+        body, err := readBody(w, r);
+        if err != nil {
+            problems.Write(w, r, err);
+            return;
+        }
+
+        user, err := service.UpdateProfile(userId, contentType, body);
+        if err != nil {
+            writeProfileError(w, r, err);
+            return;
+        }
+
+        response := responses.NewDataResponse("user", user);
+        responses.Render(w, r, response);
+    });
+}
+
+func routeProfile(service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        viewerId, _ := authUserId(r);
+
+        spec, ok := readSpec(w, r, fieldset.User);
+        if !ok {
+            return;
+        }
+
+        user, err := service.GetProfile(r.PathValue("username"), viewerId);
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            // renamed users keep their old profile url during the cooldown
+            current, resolveErr := service.ResolveOldUsername(r.PathValue("username"));
+            if resolveErr == nil {
+                target := url.URL{ Path: "/users/" + current, RawQuery: r.URL.RawQuery };
+                http.Redirect(w, r, target.String(), http.StatusFound);
+                return;
+            }
+        }
+        if err != nil {
+            writeProfileError(w, r, err);
+            return;
+        }
+
+        renderProjected(w, r, "user", spec, user);
+    });
+}
+
+func routeChangeUsername(service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        defer r.Body.Close();
+
+        userId, ok := authUserId(r);
+        if !ok {
+            problems.Write(w, r, problems.Status(http.StatusUnauthorized, "unauthorized"));
+            return;
+        }
+
+        var data dto.ChangeUsernameDto;
+        if !decodeValid(w, r, &data) {
+            return;
+        }
+
+        user, err := service.ChangeUsername(userId, data.Username);
+        if err != nil {
+            writeProfileError(w, r, err);
+            return;
+        }
+
+        response := responses.NewDataResponse("user", user);
+        responses.Render(w, r, response);
+    });
+}
+
+func routeUsernameHistory(service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        userId, ok := authUserId(r);
+        if !ok {
+            problems.Write(w, r, problems.Status(http.StatusUnauthorized, "unauthorized"));
+            return;
+        }
+
+        changes, err := service.GetUsernameHistory(userId);
+        if err != nil {
+            writeProfileError(w, r, err);
+            return;
+        }
+
+        response := responses.NewDataResponse("username_history", changes);
+        responses.Render(w, r, response);
+    });
+}
+
+// mails confirmation link to the new address, the email changes once it
+// is opened
+func routeChangeEmail(service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        defer r.Body.Close();
+
+        userId, ok := authUserId(r);
+        if !ok {
+            problems.Write(w, r, problems.Status(http.StatusUnauthorized, "unauthorized"));
+            return;
+        }
+
+        var data dto.ChangeEmailDto;
+        if !decodeValid(w, r, &data) {
+            return;
+        }
+
+        err := service.RequestEmailChange(userId, data, baseUrl(r) + "/me/email/confirm");
+        if err != nil {
+            writeProfileError(w, r, err);
+            return;
+        }
+
+        w.WriteHeader(http.StatusAccepted);
+    });
+}
+
+func routeConfirmEmailChange(service *appservice.AppService) http.HandlerFunc {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        service := service.For(r.Context());
+        user, err := service.ConfirmEmailChange(r.URL.Query().Get("token"));
+        if err != nil {
+            writeProfileError(w, r, err);
+            return;
+        }
+
+        response := responses.NewDataResponse("user", user);
+        responses.Render(w, r, response);
+    });
+}
+
+func writeProfileError(w http.ResponseWriter, r *http.Request, err error) {
+    problems.Write(w, r, notFound(err, "user"));
+}
@@ -0,0 +1,75 @@
+package rpc
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+
+	"connectrpc.com/connect"
+
+	"github.com/cxcnxl/go-crud/internal/problems"
+	"github.com/cxcnxl/go-crud/internal/validation"
+)
+
+// code of errors the REST routes answer with status
+func CodeOfStatus(status int) connect.Code {
+    switch status {
+    case http.StatusBadRequest, http.StatusUnprocessableEntity, http.StatusUnsupportedMediaType:
+        return connect.CodeInvalidArgument;
+    case http.StatusUnauthorized:
+        return connect.CodeUnauthenticated;
+    case http.StatusForbidden:
+        return connect.CodePermissionDenied;
+    case http.StatusNotFound, http.StatusGone:
+        return connect.CodeNotFound;
+    case http.StatusConflict:
+        return connect.CodeAlreadyExists;
+    case http.StatusPreconditionFailed:
+        return connect.CodeFailedPrecondition;
+    case http.StatusRequestEntityTooLarge, http.StatusTooManyRequests:
+        return connect.CodeResourceExhausted;
+    case http.StatusNotImplemented:
+        return connect.CodeUnimplemented;
+    case http.StatusServiceUnavailable:
+        return connect.CodeUnavailable;
+    case http.StatusGatewayTimeout:
+        return connect.CodeDeadlineExceeded;
+    }
+
+    if status >= 500 {
+        return connect.CodeInternal;
+    }
+    return connect.CodeFailedPrecondition;
+}
+
+// turns errors of procedures into connect errors. Errors of the problem
+// catalog get the code of their HTTP status, unknown ones are logged and
+// hidden like problems.Write does
+func errorOf(err error) *connect.Error {
+    var connectError *connect.Error;
+    if errors.As(err, &connectError) {
+        return connectError;
+    }
+
+    entry, _, ok := problems.Lookup(err);
+    if !ok {
+        slog.Error("Error handling rpc call: " + err.Error());
+        return connect.NewError(connect.CodeInternal, errors.New("Internal server error"));
+    }
+
+    message := err.Error();
+    var fields validation.Errors;
+    if errors.As(err, &fields) {
+        // e.g. validation_failed: email required, password min
+        names := make([]string, 0, len(fields));
+        for name, rule := range fields {
+            names = append(names, name + " " + rule);
+        }
+        sort.Strings(names);
+        message += ": " + strings.Join(names, ", ");
+    }
+
+    return connect.NewError(CodeOfStatus(entry.Status), errors.New(message));
+}
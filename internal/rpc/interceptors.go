@@ -0,0 +1,110 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"connectrpc.com/connect"
+
+	"github.com/cxcnxl/go-crud/internal/middleware"
+)
+
+// what a procedure needs of the bearer token, the counterparts of
+// middleware.UtilMiddleware and the Required and Optional sets of
+// middleware.AuthSets
+type Auth int;
+
+const (
+    AuthNone Auth = iota;
+    AuthRequired;
+    AuthOptional;
+)
+
+// largest request message accepted
+const maxMessageSize = 4 << 20;
+
+// handler options shared by every service. auth maps procedures, e.g.
+// /gocrud.v1.ApiService/Me, to what they need, missing ones need nothing.
+// Tokens are checked against check like the middleware does
+func HandlerOptions(auth map[string]Auth, check middleware.AccountChecker) connect.HandlerOption {
+    return connect.WithHandlerOptions(
+        connect.WithRecover(recoverer),
+        connect.WithReadMaxBytes(maxMessageSize),
+        connect.WithInterceptors(
+            LoggerInterceptor(),
+            ErrorInterceptor(),
+            JWTAutherInterceptor(auth, check),
+        ),
+    );
+}
+
+// --------- Implementations --------
+
+func recoverer(_ context.Context, _ connect.Spec, _ http.Header, recovered any) error {
+    slog.Error("Error handling rpc call:\n", recovered);
+    debug.PrintStack();
+
+    return connect.NewError(connect.CodeInternal, errors.New("Internal server error"));
+}
+
+func LoggerInterceptor() connect.UnaryInterceptorFunc {
+    return func(next connect.UnaryFunc) connect.UnaryFunc {
+        return func(ctx context.Context, request connect.AnyRequest) (connect.AnyResponse, error) {
+            fmt.Printf(
+                "[%v] -- %v -- (%v) RPC %v\n",
+                time.Now(),
+                request.Peer().Addr,
+                request.Header().Get("User-Agent"),
+                request.Spec().Procedure,
+            );
+
+            return next(ctx, request);
+        };
+    };
+}
+
+// sends errors of the problem catalog with the code of their status
+func ErrorInterceptor() connect.UnaryInterceptorFunc {
+    return func(next connect.UnaryFunc) connect.UnaryFunc {
+        return func(ctx context.Context, request connect.AnyRequest) (connect.AnyResponse, error) {
+            response, err := next(ctx, request);
+            if err != nil {
+                return nil, errorOf(err);
+            }
+
+            return response, nil;
+        };
+    };
+}
+
+// puts claims of the bearer token into context under "auth", like
+// middleware.JWTAutherMiddleware does. AuthOptional procedures let calls
+// without Authorization header through anonymously
+func JWTAutherInterceptor(auth map[string]Auth, check middleware.AccountChecker) connect.UnaryInterceptorFunc {
+    return func(next connect.UnaryFunc) connect.UnaryFunc {
+        return func(ctx context.Context, request connect.AnyRequest) (connect.AnyResponse, error) {
+            header := request.Header().Get("Authorization");
+
+            switch auth[request.Spec().Procedure] {
+            case AuthNone:
+                return next(ctx, request);
+            case AuthOptional:
+                if header == "" {
+                    return next(ctx, request);
+                }
+            }
+
+            claims, err := middleware.Authenticate(ctx, header, check);
+            if err != nil {
+                return nil, err;
+            }
+
+            return next(context.WithValue(ctx, "auth", claims), request);
+        };
+    };
+}
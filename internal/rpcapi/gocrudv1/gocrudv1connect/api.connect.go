@@ -0,0 +1,324 @@
+// RPC counterpart of the REST routes for accounts and posts, served over
+// Connect and gRPC on the same listener as the REST API. Go code in
+// gocrudv1 is generated from this file, see go:generate in service.go
+
+// Code generated by protoc-gen-connect-go. DO NOT EDIT.
+//
+// Source: api.proto
+
+package gocrudv1connect
+
+import (
+	connect "connectrpc.com/connect"
+	context "context"
+	errors "errors"
+	gocrudv1 "github.com/cxcnxl/go-crud/internal/rpcapi/gocrudv1"
+	http "net/http"
+	strings "strings"
+)
+
+// This is a compile-time assertion to ensure that this generated file and the connect package are
+// compatible. If you get a compiler error that this constant is not defined, this code was
+// generated with a version of connect newer than the one compiled into your binary. You can fix the
+// problem by either regenerating this code with an older version of connect or updating the connect
+// version compiled into your binary.
+const _ = connect.IsAtLeastVersion1_13_0
+
+const (
+	// ApiServiceName is the fully-qualified name of the ApiService service.
+	ApiServiceName = "gocrud.v1.ApiService"
+)
+
+// These constants are the fully-qualified names of the RPCs defined in this package. They're
+// exposed at runtime as Spec.Procedure and as the final two segments of the HTTP route.
+//
+// Note that these are different from the fully-qualified method names used by
+// google.golang.org/protobuf/reflect/protoreflect. To convert from these constants to
+// reflection-formatted method names, remove the leading slash and convert the remaining slash to a
+// period.
+const (
+	// ApiServiceRegisterProcedure is the fully-qualified name of the ApiService's Register RPC.
+	ApiServiceRegisterProcedure = "/gocrud.v1.ApiService/Register"
+	// ApiServiceLoginProcedure is the fully-qualified name of the ApiService's Login RPC.
+	ApiServiceLoginProcedure = "/gocrud.v1.ApiService/Login"
+	// ApiServiceMeProcedure is the fully-qualified name of the ApiService's Me RPC.
+	ApiServiceMeProcedure = "/gocrud.v1.ApiService/Me"
+	// ApiServiceCreatePostProcedure is the fully-qualified name of the ApiService's CreatePost RPC.
+	ApiServiceCreatePostProcedure = "/gocrud.v1.ApiService/CreatePost"
+	// ApiServiceGetPostProcedure is the fully-qualified name of the ApiService's GetPost RPC.
+	ApiServiceGetPostProcedure = "/gocrud.v1.ApiService/GetPost"
+	// ApiServiceUpdatePostProcedure is the fully-qualified name of the ApiService's UpdatePost RPC.
+	ApiServiceUpdatePostProcedure = "/gocrud.v1.ApiService/UpdatePost"
+	// ApiServiceDeletePostProcedure is the fully-qualified name of the ApiService's DeletePost RPC.
+	ApiServiceDeletePostProcedure = "/gocrud.v1.ApiService/DeletePost"
+	// ApiServicePublishPostProcedure is the fully-qualified name of the ApiService's PublishPost RPC.
+	ApiServicePublishPostProcedure = "/gocrud.v1.ApiService/PublishPost"
+)
+
+// ApiServiceClient is a client for the gocrud.v1.ApiService service.
+type ApiServiceClient interface {
+	// POST /register
+	Register(context.Context, *connect.Request[gocrudv1.RegisterRequest]) (*connect.Response[gocrudv1.UserResponse], error)
+	// POST /login
+	Login(context.Context, *connect.Request[gocrudv1.LoginRequest]) (*connect.Response[gocrudv1.LoginResponse], error)
+	// GET /me, needs a bearer token
+	Me(context.Context, *connect.Request[gocrudv1.Empty]) (*connect.Response[gocrudv1.UserResponse], error)
+	// POST /posts, needs a bearer token
+	CreatePost(context.Context, *connect.Request[gocrudv1.CreatePostRequest]) (*connect.Response[gocrudv1.PostResponse], error)
+	// GET /posts/{id}, the token is optional
+	GetPost(context.Context, *connect.Request[gocrudv1.PostIdRequest]) (*connect.Response[gocrudv1.PostResponse], error)
+	// PATCH /posts/{id}, needs a bearer token
+	UpdatePost(context.Context, *connect.Request[gocrudv1.UpdatePostRequest]) (*connect.Response[gocrudv1.PostResponse], error)
+	// DELETE /posts/{id}, needs a bearer token
+	DeletePost(context.Context, *connect.Request[gocrudv1.PostIdRequest]) (*connect.Response[gocrudv1.Empty], error)
+	// POST /posts/{id}/publish, needs a bearer token
+	PublishPost(context.Context, *connect.Request[gocrudv1.PublishPostRequest]) (*connect.Response[gocrudv1.PostResponse], error)
+}
+
+// NewApiServiceClient constructs a client for the gocrud.v1.ApiService service. By default, it uses
+// the Connect protocol with the binary Protobuf Codec, asks for gzipped responses, and sends
+// uncompressed requests. To use the gRPC or gRPC-Web protocols, supply the connect.WithGRPC() or
+// connect.WithGRPCWeb() options.
+//
+// The URL supplied here should be the base URL for the Connect or gRPC server (for example,
+// http://api.acme.com or https://acme.com/grpc).
+func NewApiServiceClient(httpClient connect.HTTPClient, baseURL string, opts ...connect.ClientOption) ApiServiceClient {
+	baseURL = strings.TrimRight(baseURL, "/")
+	apiServiceMethods := gocrudv1.File_api_proto.Services().ByName("ApiService").Methods()
+	return &apiServiceClient{
+		register: connect.NewClient[gocrudv1.RegisterRequest, gocrudv1.UserResponse](
+			httpClient,
+			baseURL+ApiServiceRegisterProcedure,
+			connect.WithSchema(apiServiceMethods.ByName("Register")),
+			connect.WithClientOptions(opts...),
+		),
+		login: connect.NewClient[gocrudv1.LoginRequest, gocrudv1.LoginResponse](
+			httpClient,
+			baseURL+ApiServiceLoginProcedure,
+			connect.WithSchema(apiServiceMethods.ByName("Login")),
+			connect.WithClientOptions(opts...),
+		),
+		me: connect.NewClient[gocrudv1.Empty, gocrudv1.UserResponse](
+			httpClient,
+			baseURL+ApiServiceMeProcedure,
+			connect.WithSchema(apiServiceMethods.ByName("Me")),
+			connect.WithClientOptions(opts...),
+		),
+		createPost: connect.NewClient[gocrudv1.CreatePostRequest, gocrudv1.PostResponse](
+			httpClient,
+			baseURL+ApiServiceCreatePostProcedure,
+			connect.WithSchema(apiServiceMethods.ByName("CreatePost")),
+			connect.WithClientOptions(opts...),
+		),
+		getPost: connect.NewClient[gocrudv1.PostIdRequest, gocrudv1.PostResponse](
+			httpClient,
+			baseURL+ApiServiceGetPostProcedure,
+			connect.WithSchema(apiServiceMethods.ByName("GetPost")),
+			connect.WithClientOptions(opts...),
+		),
+		updatePost: connect.NewClient[gocrudv1.UpdatePostRequest, gocrudv1.PostResponse](
+			httpClient,
+			baseURL+ApiServiceUpdatePostProcedure,
+			connect.WithSchema(apiServiceMethods.ByName("UpdatePost")),
+			connect.WithClientOptions(opts...),
+		),
+		deletePost: connect.NewClient[gocrudv1.PostIdRequest, gocrudv1.Empty](
+			httpClient,
+			baseURL+ApiServiceDeletePostProcedure,
+			connect.WithSchema(apiServiceMethods.ByName("DeletePost")),
+			connect.WithClientOptions(opts...),
+		),
+		publishPost: connect.NewClient[gocrudv1.PublishPostRequest, gocrudv1.PostResponse](
+			httpClient,
+			baseURL+ApiServicePublishPostProcedure,
+			connect.WithSchema(apiServiceMethods.ByName("PublishPost")),
+			connect.WithClientOptions(opts...),
+		),
+	}
+}
+
+// apiServiceClient implements ApiServiceClient.
+type apiServiceClient struct {
+	register    *connect.Client[gocrudv1.RegisterRequest, gocrudv1.UserResponse]
+	login       *connect.Client[gocrudv1.LoginRequest, gocrudv1.LoginResponse]
+	me          *connect.Client[gocrudv1.Empty, gocrudv1.UserResponse]
+	createPost  *connect.Client[gocrudv1.CreatePostRequest, gocrudv1.PostResponse]
+	getPost     *connect.Client[gocrudv1.PostIdRequest, gocrudv1.PostResponse]
+	updatePost  *connect.Client[gocrudv1.UpdatePostRequest, gocrudv1.PostResponse]
+	deletePost  *connect.Client[gocrudv1.PostIdRequest, gocrudv1.Empty]
+	publishPost *connect.Client[gocrudv1.PublishPostRequest, gocrudv1.PostResponse]
+}
+
+// Register calls gocrud.v1.ApiService.Register.
+func (c *apiServiceClient) Register(ctx context.Context, req *connect.Request[gocrudv1.RegisterRequest]) (*connect.Response[gocrudv1.UserResponse], error) {
+	return c.register.CallUnary(ctx, req)
+}
+
+// Login calls gocrud.v1.ApiService.Login.
+func (c *apiServiceClient) Login(ctx context.Context, req *connect.Request[gocrudv1.LoginRequest]) (*connect.Response[gocrudv1.LoginResponse], error) {
+	return c.login.CallUnary(ctx, req)
+}
+
+// Me calls gocrud.v1.ApiService.Me.
+func (c *apiServiceClient) Me(ctx context.Context, req *connect.Request[gocrudv1.Empty]) (*connect.Response[gocrudv1.UserResponse], error) {
+	return c.me.CallUnary(ctx, req)
+}
+
+// CreatePost calls gocrud.v1.ApiService.CreatePost.
+func (c *apiServiceClient) CreatePost(ctx context.Context, req *connect.Request[gocrudv1.CreatePostRequest]) (*connect.Response[gocrudv1.PostResponse], error) {
+	return c.createPost.CallUnary(ctx, req)
+}
+
+// GetPost calls gocrud.v1.ApiService.GetPost.
+func (c *apiServiceClient) GetPost(ctx context.Context, req *connect.Request[gocrudv1.PostIdRequest]) (*connect.Response[gocrudv1.PostResponse], error) {
+	return c.getPost.CallUnary(ctx, req)
+}
+
+// UpdatePost calls gocrud.v1.ApiService.UpdatePost.
+func (c *apiServiceClient) UpdatePost(ctx context.Context, req *connect.Request[gocrudv1.UpdatePostRequest]) (*connect.Response[gocrudv1.PostResponse], error) {
+	return c.updatePost.CallUnary(ctx, req)
+}
+
+// DeletePost calls gocrud.v1.ApiService.DeletePost.
+func (c *apiServiceClient) DeletePost(ctx context.Context, req *connect.Request[gocrudv1.PostIdRequest]) (*connect.Response[gocrudv1.Empty], error) {
+	return c.deletePost.CallUnary(ctx, req)
+}
+
+// PublishPost calls gocrud.v1.ApiService.PublishPost.
+func (c *apiServiceClient) PublishPost(ctx context.Context, req *connect.Request[gocrudv1.PublishPostRequest]) (*connect.Response[gocrudv1.PostResponse], error) {
+	return c.publishPost.CallUnary(ctx, req)
+}
+
+// ApiServiceHandler is an implementation of the gocrud.v1.ApiService service.
+type ApiServiceHandler interface {
+	// POST /register
+	Register(context.Context, *connect.Request[gocrudv1.RegisterRequest]) (*connect.Response[gocrudv1.UserResponse], error)
+	// POST /login
+	Login(context.Context, *connect.Request[gocrudv1.LoginRequest]) (*connect.Response[gocrudv1.LoginResponse], error)
+	// GET /me, needs a bearer token
+	Me(context.Context, *connect.Request[gocrudv1.Empty]) (*connect.Response[gocrudv1.UserResponse], error)
+	// POST /posts, needs a bearer token
+	CreatePost(context.Context, *connect.Request[gocrudv1.CreatePostRequest]) (*connect.Response[gocrudv1.PostResponse], error)
+	// GET /posts/{id}, the token is optional
+	GetPost(context.Context, *connect.Request[gocrudv1.PostIdRequest]) (*connect.Response[gocrudv1.PostResponse], error)
+	// PATCH /posts/{id}, needs a bearer token
+	UpdatePost(context.Context, *connect.Request[gocrudv1.UpdatePostRequest]) (*connect.Response[gocrudv1.PostResponse], error)
+	// DELETE /posts/{id}, needs a bearer token
+	DeletePost(context.Context, *connect.Request[gocrudv1.PostIdRequest]) (*connect.Response[gocrudv1.Empty], error)
+	// POST /posts/{id}/publish, needs a bearer token
+	PublishPost(context.Context, *connect.Request[gocrudv1.PublishPostRequest]) (*connect.Response[gocrudv1.PostResponse], error)
+}
+
+// NewApiServiceHandler builds an HTTP handler from the service implementation. It returns the path
+// on which to mount the handler and the handler itself.
+//
+// By default, handlers support the Connect, gRPC, and gRPC-Web protocols with the binary Protobuf
+// and JSON codecs. They also support gzip compression.
+func NewApiServiceHandler(svc ApiServiceHandler, opts ...connect.HandlerOption) (string, http.Handler) {
+	apiServiceMethods := gocrudv1.File_api_proto.Services().ByName("ApiService").Methods()
+	apiServiceRegisterHandler := connect.NewUnaryHandler(
+		ApiServiceRegisterProcedure,
+		svc.Register,
+		connect.WithSchema(apiServiceMethods.ByName("Register")),
+		connect.WithHandlerOptions(opts...),
+	)
+	apiServiceLoginHandler := connect.NewUnaryHandler(
+		ApiServiceLoginProcedure,
+		svc.Login,
+		connect.WithSchema(apiServiceMethods.ByName("Login")),
+		connect.WithHandlerOptions(opts...),
+	)
+	apiServiceMeHandler := connect.NewUnaryHandler(
+		ApiServiceMeProcedure,
+		svc.Me,
+		connect.WithSchema(apiServiceMethods.ByName("Me")),
+		connect.WithHandlerOptions(opts...),
+	)
+	apiServiceCreatePostHandler := connect.NewUnaryHandler(
+		ApiServiceCreatePostProcedure,
+		svc.CreatePost,
+		connect.WithSchema(apiServiceMethods.ByName("CreatePost")),
+		connect.WithHandlerOptions(opts...),
+	)
+	apiServiceGetPostHandler := connect.NewUnaryHandler(
+		ApiServiceGetPostProcedure,
+		svc.GetPost,
+		connect.WithSchema(apiServiceMethods.ByName("GetPost")),
+		connect.WithHandlerOptions(opts...),
+	)
+	apiServiceUpdatePostHandler := connect.NewUnaryHandler(
+		ApiServiceUpdatePostProcedure,
+		svc.UpdatePost,
+		connect.WithSchema(apiServiceMethods.ByName("UpdatePost")),
+		connect.WithHandlerOptions(opts...),
+	)
+	apiServiceDeletePostHandler := connect.NewUnaryHandler(
+		ApiServiceDeletePostProcedure,
+		svc.DeletePost,
+		connect.WithSchema(apiServiceMethods.ByName("DeletePost")),
+		connect.WithHandlerOptions(opts...),
+	)
+	apiServicePublishPostHandler := connect.NewUnaryHandler(
+		ApiServicePublishPostProcedure,
+		svc.PublishPost,
+		connect.WithSchema(apiServiceMethods.ByName("PublishPost")),
+		connect.WithHandlerOptions(opts...),
+	)
+	return "/gocrud.v1.ApiService/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case ApiServiceRegisterProcedure:
+			apiServiceRegisterHandler.ServeHTTP(w, r)
+		case ApiServiceLoginProcedure:
+			apiServiceLoginHandler.ServeHTTP(w, r)
+		case ApiServiceMeProcedure:
+			apiServiceMeHandler.ServeHTTP(w, r)
+		case ApiServiceCreatePostProcedure:
+			apiServiceCreatePostHandler.ServeHTTP(w, r)
+		case ApiServiceGetPostProcedure:
+			apiServiceGetPostHandler.ServeHTTP(w, r)
+		case ApiServiceUpdatePostProcedure:
+			apiServiceUpdatePostHandler.ServeHTTP(w, r)
+		case ApiServiceDeletePostProcedure:
+			apiServiceDeletePostHandler.ServeHTTP(w, r)
+		case ApiServicePublishPostProcedure:
+			apiServicePublishPostHandler.ServeHTTP(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+// UnimplementedApiServiceHandler returns CodeUnimplemented from all methods.
+type UnimplementedApiServiceHandler struct{}
+
+func (UnimplementedApiServiceHandler) Register(context.Context, *connect.Request[gocrudv1.RegisterRequest]) (*connect.Response[gocrudv1.UserResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("gocrud.v1.ApiService.Register is not implemented"))
+}
+
+func (UnimplementedApiServiceHandler) Login(context.Context, *connect.Request[gocrudv1.LoginRequest]) (*connect.Response[gocrudv1.LoginResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("gocrud.v1.ApiService.Login is not implemented"))
+}
+
+func (UnimplementedApiServiceHandler) Me(context.Context, *connect.Request[gocrudv1.Empty]) (*connect.Response[gocrudv1.UserResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("gocrud.v1.ApiService.Me is not implemented"))
+}
+
+func (UnimplementedApiServiceHandler) CreatePost(context.Context, *connect.Request[gocrudv1.CreatePostRequest]) (*connect.Response[gocrudv1.PostResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("gocrud.v1.ApiService.CreatePost is not implemented"))
+}
+
+func (UnimplementedApiServiceHandler) GetPost(context.Context, *connect.Request[gocrudv1.PostIdRequest]) (*connect.Response[gocrudv1.PostResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("gocrud.v1.ApiService.GetPost is not implemented"))
+}
+
+func (UnimplementedApiServiceHandler) UpdatePost(context.Context, *connect.Request[gocrudv1.UpdatePostRequest]) (*connect.Response[gocrudv1.PostResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("gocrud.v1.ApiService.UpdatePost is not implemented"))
+}
+
+func (UnimplementedApiServiceHandler) DeletePost(context.Context, *connect.Request[gocrudv1.PostIdRequest]) (*connect.Response[gocrudv1.Empty], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("gocrud.v1.ApiService.DeletePost is not implemented"))
+}
+
+func (UnimplementedApiServiceHandler) PublishPost(context.Context, *connect.Request[gocrudv1.PublishPostRequest]) (*connect.Response[gocrudv1.PostResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("gocrud.v1.ApiService.PublishPost is not implemented"))
+}
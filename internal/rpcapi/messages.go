@@ -0,0 +1,71 @@
+package rpcapi
+
+import (
+	"time"
+
+	"github.com/cxcnxl/go-crud/internal/dto"
+	"github.com/cxcnxl/go-crud/internal/models"
+	"github.com/cxcnxl/go-crud/internal/rpcapi/gocrudv1"
+)
+
+// messages of api.proto built from the DTOs the REST routes render
+
+func userMessage(user dto.UserViewDto) *gocrudv1.User {
+    return &gocrudv1.User{
+        Id: uint64(user.ID),
+        Username: user.Username,
+        DisplayName: user.DisplayName,
+        Bio: user.Bio,
+        AvatarUrl: user.AvatarUrl,
+        Website: user.Website,
+        Email: user.Email,
+        FollowersCount: int32(user.FollowersCount),
+        FollowingCount: int32(user.FollowingCount),
+    };
+}
+
+func postMessage(post models.Post) *gocrudv1.Post {
+    message := &gocrudv1.Post{
+        Id: uint64(post.ID),
+        Body: post.Body,
+        BodyFormat: string(post.BodyFormat),
+        Status: string(post.Status),
+        Visibility: string(post.Visibility),
+        CreatedAt: timeText(&post.CreatedAt),
+        UpdatedAt: timeText(&post.UpdatedAt),
+        PublishedAt: timeText(post.PublishedAt),
+        PublishAt: timeText(post.PublishAt),
+    };
+    if post.Author.ID != 0 {
+        message.Author = userMessage(dto.UserViewDto{ User: post.Author });
+    }
+
+    return message;
+}
+
+func postViewMessage(view dto.PostViewDto) *gocrudv1.Post {
+    message := postMessage(view.Post);
+    message.Body = "";
+    if view.Body != nil {
+        message.Body = *view.Body;
+    }
+    if view.BodyHtml != nil {
+        message.BodyHtml = *view.BodyHtml;
+    }
+    if len(view.Reactions) > 0 {
+        message.Reactions = make(map[string]int32, len(view.Reactions));
+        for reaction, count := range view.Reactions {
+            message.Reactions[reaction] = int32(count);
+        }
+    }
+
+    return message;
+}
+
+func timeText(moment *time.Time) string {
+    if moment == nil || moment.IsZero() {
+        return "";
+    }
+
+    return moment.UTC().Format(time.RFC3339);
+}
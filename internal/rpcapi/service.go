@@ -0,0 +1,296 @@
+// generates gocrudv1 from api.proto, needs protoc-gen-go and
+// protoc-gen-connect-go on PATH
+//go:generate protoc --go_out=../.. --go_opt=module=github.com/cxcnxl/go-crud --connect-go_out=../.. --connect-go_opt=module=github.com/cxcnxl/go-crud api.proto
+
+package rpcapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"connectrpc.com/connect"
+	"gorm.io/gorm"
+
+	"github.com/cxcnxl/go-crud/internal/app_service"
+	auth_helpers "github.com/cxcnxl/go-crud/internal/auth_helpers"
+	"github.com/cxcnxl/go-crud/internal/dto"
+	"github.com/cxcnxl/go-crud/internal/problems"
+	"github.com/cxcnxl/go-crud/internal/rpc"
+	"github.com/cxcnxl/go-crud/internal/rpcapi/gocrudv1"
+	"github.com/cxcnxl/go-crud/internal/rpcapi/gocrudv1/gocrudv1connect"
+	"github.com/cxcnxl/go-crud/internal/validation"
+)
+
+// ApiService of api.proto. Procedures call the app service like the REST
+// routes do and fail with the same problems, which rpc turns into codes
+type Server struct {
+    service *appservice.AppService
+}
+
+// procedures by what they need of the bearer token, like the middleware
+// sets of their REST routes
+var procedureAuth = map[string]rpc.Auth{
+    gocrudv1connect.ApiServiceMeProcedure: rpc.AuthRequired,
+    gocrudv1connect.ApiServiceCreatePostProcedure: rpc.AuthRequired,
+    gocrudv1connect.ApiServiceGetPostProcedure: rpc.AuthOptional,
+    gocrudv1connect.ApiServiceUpdatePostProcedure: rpc.AuthRequired,
+    gocrudv1connect.ApiServiceDeletePostProcedure: rpc.AuthRequired,
+    gocrudv1connect.ApiServicePublishPostProcedure: rpc.AuthRequired,
+};
+
+// path to mount the handler at and the handler serving ApiService over
+// Connect, gRPC and gRPC-Web
+func NewHandler(service *appservice.AppService) (string, http.Handler) {
+    return gocrudv1connect.NewApiServiceHandler(
+        &Server{ service },
+        rpc.HandlerOptions(procedureAuth, service.CheckActiveUser),
+    );
+}
+
+func (self *Server) Register(
+    ctx context.Context,
+    request *connect.Request[gocrudv1.RegisterRequest],
+) (*connect.Response[gocrudv1.UserResponse], error) {
+    service := self.service.For(ctx);
+    data := dto.CreateUserDto{
+        Email: request.Msg.Email,
+        Username: request.Msg.Username,
+        Password: request.Msg.Password,
+    };
+    if err := validation.Struct(&data); err != nil {
+        return nil, err;
+    }
+
+    user, err := service.CreateUser(data);
+    if err != nil {
+        return nil, err;
+    }
+
+    view := dto.UserViewDto{ User: user, Email: user.Email };
+    return connect.NewResponse(&gocrudv1.UserResponse{ User: userMessage(view) }), nil;
+}
+
+func (self *Server) Login(
+    ctx context.Context,
+    request *connect.Request[gocrudv1.LoginRequest],
+) (*connect.Response[gocrudv1.LoginResponse], error) {
+    service := self.service.For(ctx);
+    data := dto.PostLoginDto{
+        Username: request.Msg.Username,
+        Password: request.Msg.Password,
+    };
+    if err := validation.Struct(&data); err != nil {
+        return nil, err;
+    }
+
+    user, err := service.LoginUser(data);
+    if err != nil {
+        return nil, err;
+    }
+
+    jwt := auth_helpers.SignJWT(map[string]any{
+        "id": user.ID,
+        "email": user.Email,
+        "username": user.Username,
+    });
+
+    return connect.NewResponse(&gocrudv1.LoginResponse{ AuthToken: jwt }), nil;
+}
+
+func (self *Server) Me(
+    ctx context.Context,
+    request *connect.Request[gocrudv1.Empty],
+) (*connect.Response[gocrudv1.UserResponse], error) {
+    service := self.service.For(ctx);
+    userId, err := authUserId(ctx);
+    if err != nil {
+        return nil, err;
+    }
+
+    user, err := service.GetMe(userId);
+    if err != nil {
+        return nil, notFound(err, "user");
+    }
+
+    return connect.NewResponse(&gocrudv1.UserResponse{ User: userMessage(user) }), nil;
+}
+
+func (self *Server) CreatePost(
+    ctx context.Context,
+    request *connect.Request[gocrudv1.CreatePostRequest],
+) (*connect.Response[gocrudv1.PostResponse], error) {
+    service := self.service.For(ctx);
+    userId, err := authUserId(ctx);
+    if err != nil {
+        return nil, err;
+    }
+
+    message := request.Msg;
+    publishAt, err := parseTime(message.PublishAt, "Invalid publish_at");
+    if err != nil {
+        return nil, err;
+    }
+
+    post, err := service.CreatePost(userId, dto.CreatePostDto{
+        Body: message.Body,
+        BodyFormat: message.BodyFormat,
+        Status: message.Status,
+        PublishAt: publishAt,
+        Visibility: message.Visibility,
+    });
+    if err != nil {
+        return nil, notFound(err, "post");
+    }
+
+    return connect.NewResponse(&gocrudv1.PostResponse{ Post: postMessage(post) }), nil;
+}
+
+func (self *Server) GetPost(
+    ctx context.Context,
+    request *connect.Request[gocrudv1.PostIdRequest],
+) (*connect.Response[gocrudv1.PostResponse], error) {
+    service := self.service.For(ctx);
+    postId, err := postIdOf(request.Msg.Id);
+    if err != nil {
+        return nil, err;
+    }
+
+    viewerId, _ := authUserId(ctx);
+
+    post, err := service.GetPostView(postId, viewerId);
+    if err != nil {
+        return nil, notFound(err, "post");
+    }
+
+    return connect.NewResponse(&gocrudv1.PostResponse{ Post: postViewMessage(post) }), nil;
+}
+
+func (self *Server) UpdatePost(
+    ctx context.Context,
+    request *connect.Request[gocrudv1.UpdatePostRequest],
+) (*connect.Response[gocrudv1.PostResponse], error) {
+    service := self.service.For(ctx);
+    message := request.Msg;
+    postId, userId, err := postTarget(ctx, message.Id);
+    if err != nil {
+        return nil, err;
+    }
+
+    post, err := service.UpdatePost(postId, userId, dto.UpdatePostDto{
+        Body: message.Body,
+        BodyFormat: message.BodyFormat,
+        Visibility: message.Visibility,
+    });
+    if err != nil {
+        return nil, notFound(err, "post");
+    }
+
+    return connect.NewResponse(&gocrudv1.PostResponse{ Post: postMessage(post) }), nil;
+}
+
+func (self *Server) DeletePost(
+    ctx context.Context,
+    request *connect.Request[gocrudv1.PostIdRequest],
+) (*connect.Response[gocrudv1.Empty], error) {
+    service := self.service.For(ctx);
+    postId, userId, err := postTarget(ctx, request.Msg.Id);
+    if err != nil {
+        return nil, err;
+    }
+
+    if err := service.DeletePost(postId, userId); err != nil {
+        return nil, notFound(err, "post");
+    }
+
+    return connect.NewResponse(&gocrudv1.Empty{}), nil;
+}
+
+func (self *Server) PublishPost(
+    ctx context.Context,
+    request *connect.Request[gocrudv1.PublishPostRequest],
+) (*connect.Response[gocrudv1.PostResponse], error) {
+    service := self.service.For(ctx);
+    message := request.Msg;
+    postId, userId, err := postTarget(ctx, message.Id);
+    if err != nil {
+        return nil, err;
+    }
+
+    publishAt, err := parseTime(message.PublishAt, "Invalid publish_at");
+    if err != nil {
+        return nil, err;
+    }
+
+    post, err := service.PublishPost(postId, userId, publishAt);
+    if err != nil {
+        return nil, notFound(err, "post");
+    }
+
+    return connect.NewResponse(&gocrudv1.PostResponse{ Post: postMessage(post) }), nil;
+}
+
+// ---------- Utils -----------
+
+// id of the authenticated user from claims put into context by
+// rpc.JWTAutherInterceptor
+func authUserId(ctx context.Context) (uint, error) {
+    claims, ok := ctx.Value("auth").(map[string]any);
+    if !ok {
+        return 0, problems.Status(http.StatusUnauthorized, "unauthorized");
+    }
+
+    // json numbers are decoded as float64
+    id, ok := claims["id"].(float64);
+    if !ok || id <= 0 {
+        return 0, problems.Status(http.StatusUnauthorized, "unauthorized");
+    }
+
+    return uint(id), nil;
+}
+
+func postIdOf(id uint64) (uint, error) {
+    if id == 0 || uint64(uint(id)) != id {
+        return 0, problems.Status(http.StatusBadRequest, "Invalid post id");
+    }
+
+    return uint(id), nil;
+}
+
+// same checks and order as postTarget of the REST routes
+func postTarget(ctx context.Context, id uint64) (uint, uint, error) {
+    postId, err := postIdOf(id);
+    if err != nil {
+        return 0, 0, err;
+    }
+
+    userId, err := authUserId(ctx);
+    if err != nil {
+        return 0, 0, err;
+    }
+
+    return postId, userId, nil;
+}
+
+// RFC 3339 text of a message, nil when empty
+func parseTime(text string, message string) (*time.Time, error) {
+    if text == "" {
+        return nil, nil;
+    }
+
+    moment, err := time.Parse(time.RFC3339, text);
+    if err != nil {
+        return nil, problems.Status(http.StatusBadRequest, message);
+    }
+
+    return &moment, nil;
+}
+
+func notFound(err error, resource string) error {
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        return problems.NotFoundError{ Resource: resource };
+    }
+
+    return err;
+}
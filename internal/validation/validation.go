@@ -0,0 +1,265 @@
+package validation
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// failed rule by json name of the field, e.g. {"email": "required"}
+type Errors map[string]string;
+
+func (self Errors) Error() string {
+    return "validation_failed";
+}
+
+// body is not a single JSON value
+type InvalidJSONError struct {}
+func (self InvalidJSONError) Error() string {
+    return "invalid_json";
+}
+
+// checks value of a field against the rule. param is the text after "="
+// in the tag, empty when there is none
+type Func func(value reflect.Value, param string) bool;
+
+var validators = map[string]Func{
+    "required": validateRequired,
+    "email": validateEmail,
+    "min": validateMin,
+    "max": validateMax,
+    "username": validateUsername,
+};
+var validatorsMu sync.RWMutex;
+
+var patterns sync.Map;
+
+// usernames are what @mentions match
+var usernamePattern = regexp.MustCompile(`^[\p{L}\p{N}_]{3,64}$`);
+
+// reports whether username is one @mentions can match
+func Username(username string) bool {
+    return usernamePattern.MatchString(username);
+}
+
+// adds a rule usable in validate tags under name. Meant for init functions
+func Register(name string, validator Func) {
+    validatorsMu.Lock();
+    defer validatorsMu.Unlock();
+
+    validators[name] = validator;
+}
+
+// decodes data holding exactly one JSON value into target. Unknown fields
+// and values of the wrong type are reported as Errors, anything else
+// malformed as InvalidJSONError
+func Decode(data []byte, target any) error {
+    decoder := json.NewDecoder(bytes.NewReader(data));
+    decoder.DisallowUnknownFields();
+
+    if err := decoder.Decode(target); err != nil {
+        var typeError *json.UnmarshalTypeError;
+        if errors.As(err, &typeError) && typeError.Field != "" {
+            return Errors{ typeError.Field: "type" };
+        }
+        if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+            return Errors{ strings.Trim(field, `"`): "unknown" };
+        }
+
+        return InvalidJSONError{};
+    }
+
+    if _, err := decoder.Token(); err != io.EOF {
+        return InvalidJSONError{};
+    }
+
+    return nil;
+}
+
+// checks fields of the struct against their tags:
+//
+//     validate:"required,email,min=3,max=64,custom"
+//     pattern:"^[a-z]+$"
+//
+// Rules run in order and the first failing one is reported. Rules other
+// than required skip empty values, so optional fields are only checked
+// when given. Returns Errors or nil
+func Struct(value any) error {
+    errs := Errors{};
+    validateStruct(reflect.ValueOf(value), "", errs);
+
+    if len(errs) == 0 {
+        return nil;
+    }
+
+    return errs;
+}
+
+func validateStruct(value reflect.Value, prefix string, errs Errors) {
+    for value.Kind() == reflect.Pointer {
+        if value.IsNil() {
+            return;
+        }
+        value = value.Elem();
+    }
+    if value.Kind() != reflect.Struct {
+        return;
+    }
+
+    for i := 0; i < value.NumField(); i++ {
+        field := value.Type().Field(i);
+        name, _, _ := strings.Cut(field.Tag.Get("json"), ",");
+        if name == "-" {
+            continue;
+        }
+
+        if field.Anonymous && name == "" {
+            validateStruct(value.Field(i), prefix, errs);
+            continue;
+        }
+        if !field.IsExported() {
+            continue;
+        }
+        if name == "" {
+            name = field.Name;
+        }
+
+        if rule, ok := validateField(value.Field(i), field.Tag); !ok {
+            errs[prefix + name] = rule;
+            continue;
+        }
+
+        nested := value.Field(i);
+        if nested.Kind() == reflect.Pointer {
+            nested = nested.Elem();
+        }
+        if nested.Kind() == reflect.Struct && nested.Type().PkgPath() != "time" {
+            validateStruct(nested, prefix + name + ".", errs);
+        }
+    }
+}
+
+// returns the first rule the value fails
+func validateField(value reflect.Value, tag reflect.StructTag) (string, bool) {
+    rules := tag.Get("validate");
+    if rules != "" {
+        for _, rule := range strings.Split(rules, ",") {
+            name, param, _ := strings.Cut(rule, "=");
+
+            validatorsMu.RLock();
+            validator, ok := validators[name];
+            validatorsMu.RUnlock();
+            if !ok {
+                panic("validation: unknown rule " + name);
+            }
+
+            if name != "required" && isEmpty(value) {
+                continue;
+            }
+            if !validator(value, param) {
+                return name, false;
+            }
+        }
+    }
+
+    if pattern := tag.Get("pattern"); pattern != "" && !isEmpty(value) {
+        if !compile(pattern).MatchString(text(value)) {
+            return "pattern", false;
+        }
+    }
+
+    return "", true;
+}
+
+func validateRequired(value reflect.Value, _ string) bool {
+    return !isEmpty(value);
+}
+
+func validateEmail(value reflect.Value, _ string) bool {
+    address, err := mail.ParseAddress(text(value));
+    return err == nil && address.Address == text(value);
+}
+
+func validateUsername(value reflect.Value, _ string) bool {
+    value = reflect.Indirect(value);
+    return value.Kind() == reflect.String && Username(value.String());
+}
+
+func validateMin(value reflect.Value, param string) bool {
+    size, ok := sizeOf(value);
+    return ok && size >= mustFloat(param);
+}
+
+func validateMax(value reflect.Value, param string) bool {
+    size, ok := sizeOf(value);
+    return ok && size <= mustFloat(param);
+}
+
+// length of strings in runes and of collections, numbers themselves
+func sizeOf(value reflect.Value) (float64, bool) {
+    value = reflect.Indirect(value);
+
+    switch value.Kind() {
+    case reflect.String:
+        return float64(utf8.RuneCountInString(value.String())), true;
+    case reflect.Slice, reflect.Map, reflect.Array:
+        return float64(value.Len()), true;
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+        return float64(value.Int()), true;
+    case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+        return float64(value.Uint()), true;
+    case reflect.Float32, reflect.Float64:
+        return value.Float(), true;
+    default:
+        return 0, false;
+    }
+}
+
+// zero values and blank strings
+func isEmpty(value reflect.Value) bool {
+    if value.Kind() == reflect.Pointer {
+        return value.IsNil();
+    }
+    if value.Kind() == reflect.String {
+        return strings.TrimSpace(value.String()) == "";
+    }
+
+    return value.IsZero();
+}
+
+func text(value reflect.Value) string {
+    value = reflect.Indirect(value);
+    if value.Kind() != reflect.String {
+        return "";
+    }
+
+    return value.String();
+}
+
+func mustFloat(param string) float64 {
+    number, err := strconv.ParseFloat(param, 64);
+    if err != nil {
+        panic("validation: invalid rule param " + param);
+    }
+
+    return number;
+}
+
+func compile(pattern string) *regexp.Regexp {
+    if compiled, ok := patterns.Load(pattern); ok {
+        return compiled.(*regexp.Regexp);
+    }
+
+    compiled := regexp.MustCompile(pattern);
+    patterns.Store(pattern, compiled);
+
+    return compiled;
+}
@@ -0,0 +1,201 @@
+package test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/cxcnxl/go-crud/internal/app_service"
+	"github.com/cxcnxl/go-crud/internal/auth_helpers"
+	"github.com/cxcnxl/go-crud/internal/dto"
+	"github.com/cxcnxl/go-crud/internal/models"
+	"github.com/cxcnxl/go-crud/internal/routes"
+)
+
+var confirmToken = regexp.MustCompile(`token=([0-9a-f]+)`);
+
+func TestChangeUsernameRefusesTakenNames(t *testing.T) {
+    service := newTestService(t);
+    ann := createTestUser(t, service, "ann");
+    bob := createTestUser(t, service, "bob");
+
+    if _, err := service.ChangeUsername(ann.ID, "bob"); !errors.As(err, &appservice.DuplicateUserUsernameError{}) {
+        t.Errorf("taking a used name returned %v", err);
+    }
+    if _, err := service.ChangeUsername(ann.ID, "a b"); !errors.As(err, &appservice.InvalidUsernameError{}) {
+        t.Errorf("taking an invalid name returned %v", err);
+    }
+
+    user, err := service.ChangeUsername(ann.ID, "anna");
+    if err != nil || user.Username != "anna" {
+        t.Fatalf("rename returned %v %v", user, err);
+    }
+
+    // the old name stays with ann for the cooldown
+    if _, err := service.ChangeUsername(bob.ID, "ann"); !errors.As(err, &appservice.UsernameReservedError{}) {
+        t.Errorf("taking a reserved name returned %v", err);
+    }
+    history, err := service.GetUsernameHistory(ann.ID);
+    if err != nil || len(history) != 1 || history[0].OldUsername != "ann" || history[0].NewUsername != "anna" {
+        t.Errorf("history is %v %v", history, err);
+    }
+    reservedFor := time.Until(history[0].ReservedUntil);
+    if reservedFor < appservice.UsernameCooldown - time.Minute || reservedFor > appservice.UsernameCooldown {
+        t.Errorf("name reserved for %v", reservedFor);
+    }
+
+    service.db.Model(&models.UsernameChange{}).Where("user_id = ?", ann.ID).Update("reserved_until", time.Now().Add(-time.Second));
+    if user, err := service.ChangeUsername(bob.ID, "ann"); err != nil || user.Username != "ann" {
+        t.Errorf("taking a name after the cooldown returned %v %v", user, err);
+    }
+}
+
+func TestChangeUsernameLetsOwnerTakeNameBack(t *testing.T) {
+    service := newTestService(t);
+    ann := createTestUser(t, service, "ann");
+
+    for _, username := range []string{ "anna", "ann" } {
+        if user, err := service.ChangeUsername(ann.ID, username); err != nil || user.Username != username {
+            t.Fatalf("rename to %s returned %v %v", username, user, err);
+        }
+    }
+}
+
+func TestOldUsernameRedirectsToProfile(t *testing.T) {
+    service := newTestService(t);
+    router := routes.NewRouter(service.AppService, nil);
+    ann := createTestUser(t, service, "ann");
+    if _, err := service.ChangeUsername(ann.ID, "anna"); err != nil {
+        t.Fatal(err);
+    }
+
+    getProfile := func(path string) *httptest.ResponseRecorder {
+        recorder := httptest.NewRecorder();
+        router.Mux.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil));
+        return recorder;
+    };
+
+    recorder := getProfile("/users/ann?fields=username");
+    if recorder.Code != http.StatusFound || recorder.Header().Get("Location") != "/users/anna?fields=username" {
+        t.Errorf("old username answered %d to %s", recorder.Code, recorder.Header().Get("Location"));
+    }
+    if current, err := service.ResolveOldUsername("ann"); err != nil || current != "anna" {
+        t.Errorf("old username resolved to %s %v", current, err);
+    }
+
+    service.db.Model(&models.UsernameChange{}).Where("user_id = ?", ann.ID).Update("reserved_until", time.Now().Add(-time.Second));
+    if recorder := getProfile("/users/ann"); recorder.Code != http.StatusNotFound {
+        t.Errorf("old username after the cooldown answered %d", recorder.Code);
+    }
+}
+
+// inserts user with the password "password1"
+func createTestUserWithPassword(t *testing.T, service testService, username string) models.User {
+    t.Helper();
+
+    user := createTestUser(t, service, username);
+    user.PasswordHashed = auth_helpers.HashPassword("password1", auth_helpers.GenerateRandomSalt());
+    if err := service.db.Model(&user).Update("password_hashed", user.PasswordHashed).Error; err != nil {
+        t.Fatal(err);
+    }
+
+    return user;
+}
+
+// requests email change of the user and returns the token mailed to the
+// new address
+func requestEmailChange(t *testing.T, service testService, userId uint, email string) string {
+    t.Helper();
+
+    sent := len(service.mailer.messages);
+    err := service.RequestEmailChange(userId, dto.ChangeEmailDto{ Email: email, Password: "password1" }, "https://example.com/confirm");
+    if err != nil {
+        t.Fatal(err);
+    }
+    if len(service.mailer.messages) != sent + 2 {
+        t.Fatalf("%d mails sent", len(service.mailer.messages) - sent);
+    }
+
+    match := confirmToken.FindStringSubmatch(service.mailer.messages[sent].Body);
+    if match == nil {
+        t.Fatalf("no token in %s", service.mailer.messages[sent].Body);
+    }
+
+    return match[1];
+}
+
+func TestEmailChangeIsConfirmedByLink(t *testing.T) {
+    service := newTestService(t);
+    ann := createTestUserWithPassword(t, service, "ann");
+
+    token := requestEmailChange(t, service, ann.ID, "ann@example.org");
+
+    confirmation, alert := service.mailer.messages[0], service.mailer.messages[1];
+    if confirmation.To != "ann@example.org" {
+        t.Errorf("confirmation mailed to %s", confirmation.To);
+    }
+    if alert.To != "ann@example.com" || !regexp.MustCompile(`ann@example\.org`).MatchString(alert.Body) {
+        t.Errorf("alert mailed to %s: %s", alert.To, alert.Body);
+    }
+
+    // nothing changes until the link is opened
+    service.db.First(&ann, ann.ID);
+    if ann.Email != "ann@example.com" {
+        t.Errorf("email changed to %s before confirmation", ann.Email);
+    }
+
+    user, err := service.ConfirmEmailChange(token);
+    if err != nil || user.Email != "ann@example.org" {
+        t.Fatalf("confirmation returned %v %v", user, err);
+    }
+    service.db.First(&ann, ann.ID);
+    if ann.Email != "ann@example.org" {
+        t.Errorf("email is %s after confirmation", ann.Email);
+    }
+
+    if _, err := service.ConfirmEmailChange(token); !errors.As(err, &appservice.InvalidEmailChangeTokenError{}) {
+        t.Errorf("second confirmation returned %v", err);
+    }
+    if _, err := service.ConfirmEmailChange("unknown"); !errors.As(err, &appservice.InvalidEmailChangeTokenError{}) {
+        t.Errorf("unknown token returned %v", err);
+    }
+}
+
+func TestEmailChangeRefusesExpiredAndTakenAddresses(t *testing.T) {
+    service := newTestService(t);
+    ann := createTestUserWithPassword(t, service, "ann");
+    createTestUser(t, service, "bob");
+
+    err := service.RequestEmailChange(ann.ID, dto.ChangeEmailDto{ Email: "bob@example.com", Password: "password1" }, "");
+    if !errors.As(err, &appservice.DuplicateUserEmailError{}) {
+        t.Errorf("changing to a used address returned %v", err);
+    }
+    err = service.RequestEmailChange(ann.ID, dto.ChangeEmailDto{ Email: "ann@example.org", Password: "wrong" }, "");
+    if !errors.As(err, &appservice.InvalidPasswordError{}) {
+        t.Errorf("changing with a wrong password returned %v", err);
+    }
+    if len(service.mailer.messages) != 0 {
+        t.Errorf("%d mails sent for refused changes", len(service.mailer.messages));
+    }
+
+    expired := requestEmailChange(t, service, ann.ID, "ann@example.org");
+    service.db.Model(&models.EmailChange{}).Where("user_id = ?", ann.ID).Update("expires_at", time.Now().Add(-time.Second));
+    if _, err := service.ConfirmEmailChange(expired); !errors.As(err, &appservice.InvalidEmailChangeTokenError{}) {
+        t.Errorf("expired token returned %v", err);
+    }
+
+    // someone else took the address after the link was sent
+    taken := requestEmailChange(t, service, ann.ID, "carol@example.com");
+    createTestUser(t, service, "carol");
+    if _, err := service.ConfirmEmailChange(taken); !errors.As(err, &appservice.DuplicateUserEmailError{}) {
+        t.Errorf("confirming a taken address returned %v", err);
+    }
+
+    service.db.First(&ann, ann.ID);
+    if ann.Email != "ann@example.com" {
+        t.Errorf("email changed to %s", ann.Email);
+    }
+}
@@ -0,0 +1,183 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/cxcnxl/go-crud/internal/app_service"
+	"github.com/cxcnxl/go-crud/internal/auth_helpers"
+	"github.com/cxcnxl/go-crud/internal/blobstore"
+	"github.com/cxcnxl/go-crud/internal/models"
+	"github.com/cxcnxl/go-crud/internal/routes"
+)
+
+// uploads data as the file of the post, declared with contentType, and
+// returns the response
+func uploadAttachment(
+    t *testing.T,
+    router *routes.MethodHandler,
+    token string,
+    postId uint,
+    fileName string,
+    contentType string,
+    data []byte,
+) *httptest.ResponseRecorder {
+    t.Helper();
+
+    var body bytes.Buffer;
+    form := multipart.NewWriter(&body);
+    header := textproto.MIMEHeader{};
+    header.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{
+        "name": "file",
+        "filename": fileName,
+    }));
+    header.Set("Content-Type", contentType);
+    part, err := form.CreatePart(header);
+    if err != nil {
+        t.Fatal(err);
+    }
+    part.Write(data);
+    form.Close();
+
+    request := httptest.NewRequest("POST", fmt.Sprintf("/posts/%d/attachments", postId), &body);
+    request.Header.Set("Content-Type", form.FormDataContentType());
+    request.Header.Set("Authorization", "Bearer " + token);
+    recorder := httptest.NewRecorder();
+    router.Mux.ServeHTTP(recorder, request);
+
+    return recorder;
+}
+
+func TestAttachmentTypeIsSniffed(t *testing.T) {
+    t.Setenv("JWT_SECRET", "test_secret");
+    service := newTestService(t);
+    router := routes.NewRouter(service.AppService, nil);
+    ann := createTestUser(t, service, "ann");
+    post := createTestPost(t, service, ann.ID, time.Now());
+    token := auth_helpers.SignJWT(map[string]any{ "id": ann.ID });
+
+    // the declared type and extension do not matter
+    recorder := uploadAttachment(t, router, token, post.ID, `naïve "cat".html`, "text/html", encodePNG(t, 40, 20));
+    if recorder.Code != http.StatusCreated {
+        t.Fatalf("png upload answered %d %s", recorder.Code, recorder.Body.String());
+    }
+    var attachment models.Attachment;
+    service.db.First(&attachment);
+    if attachment.ContentType != "image/png" || attachment.ThumbnailKey == "" {
+        t.Errorf("png stored as %s, thumbnail %q", attachment.ContentType, attachment.ThumbnailKey);
+    }
+
+    recorder = uploadAttachment(t, router, token, post.ID, "cat.png", "image/png", []byte("<html><script>alert(1)</script></html>"));
+    if recorder.Code != http.StatusUnsupportedMediaType {
+        t.Errorf("html declared as png answered %d", recorder.Code);
+    }
+
+    download := httptest.NewRecorder();
+    router.Mux.ServeHTTP(download, httptest.NewRequest("GET", fmt.Sprintf("/attachments/%d", attachment.ID), nil));
+    if download.Code != http.StatusOK || download.Header().Get("Content-Type") != "image/png" ||
+        download.Header().Get("X-Content-Type-Options") != "nosniff" {
+        t.Errorf("download answered %d with %v", download.Code, download.Header());
+    }
+    disposition, params, err := mime.ParseMediaType(download.Header().Get("Content-Disposition"));
+    if err != nil || disposition != "inline" || params["filename"] != `naïve "cat".html` {
+        t.Errorf("Content-Disposition is %q", download.Header().Get("Content-Disposition"));
+    }
+}
+
+func TestAttachmentSizeIsLimited(t *testing.T) {
+    service := newTestService(t);
+    ann := createTestUser(t, service, "ann");
+    post := createTestPost(t, service, ann.ID, time.Now());
+
+    data := make([]byte, appservice.MaxAttachmentSize + 1);
+    copy(data, "%PDF-1.4\n");
+
+    _, err := service.AddAttachment(post.ID, ann.ID, "big.pdf", data, false);
+    if !errors.As(err, &appservice.AttachmentTooLargeError{}) {
+        t.Errorf("oversized upload returned %v", err);
+    }
+    attachment, err := service.AddAttachment(post.ID, ann.ID, "max.pdf", data[:appservice.MaxAttachmentSize], false);
+    if err != nil || attachment.ContentType != "application/pdf" || attachment.Size != appservice.MaxAttachmentSize {
+        t.Errorf("upload of the largest size returned %v %v", attachment, err);
+    }
+
+    bob := createTestUser(t, service, "bob");
+    if _, err := service.AddAttachment(post.ID, bob.ID, "cat.pdf", data[:100], false); !errors.As(err, &appservice.NotPostAuthorError{}) {
+        t.Errorf("upload to a post of another user returned %v", err);
+    }
+}
+
+func TestPrivateAttachmentNeedsValidSignature(t *testing.T) {
+    service := newTestService(t);
+    ann := createTestUser(t, service, "ann");
+    post := createTestPost(t, service, ann.ID, time.Now());
+
+    attachment, err := service.AddAttachment(post.ID, ann.ID, "cat.png", encodePNG(t, 8, 8), true);
+    if err != nil {
+        t.Fatal(err);
+    }
+    other, err := service.AddAttachment(post.ID, ann.ID, "dog.png", encodePNG(t, 8, 8), true);
+    if err != nil {
+        t.Fatal(err);
+    }
+
+    views, err := service.GetAttachmentViews(post);
+    if err != nil || len(views) != 2 {
+        t.Fatalf("views are %v %v", views, err);
+    }
+    signed, err := url.Parse(views[0].Url);
+    if err != nil || signed.Path != fmt.Sprintf("/attachments/%d", attachment.ID) {
+        t.Fatalf("url is %s", views[0].Url);
+    }
+    query := signed.Query();
+
+    open := func(id uint, thumbnail bool, query url.Values) error {
+        _, blob, err := service.OpenAttachment(id, thumbnail, query);
+        if err == nil {
+            io.Copy(io.Discard, blob);
+            blob.Close();
+        }
+        return err;
+    };
+
+    if err := open(attachment.ID, false, query); err != nil {
+        t.Errorf("signed url returned %v", err);
+    }
+
+    tampered := url.Values{ "expires": query["expires"], "sig": []string{ strings.ToUpper(query.Get("sig")) } };
+    later := url.Values{ "expires": []string{ "99999999999" }, "sig": query["sig"] };
+    expired, _ := url.Parse(blobstore.NewUrlSigner(testSigningKey).Sign(signed.Path, -time.Minute));
+    forged, _ := url.Parse(blobstore.NewUrlSigner("another-key").Sign(signed.Path, time.Minute));
+    cases := []struct {
+        name      string
+        id        uint
+        thumbnail bool
+        query     url.Values
+    }{
+        { "unsigned", attachment.ID, false, url.Values{} },
+        { "tampered signature", attachment.ID, false, tampered },
+        { "extended expiry", attachment.ID, false, later },
+        { "expired", attachment.ID, false, expired.Query() },
+        { "signed with another key", attachment.ID, false, forged.Query() },
+        { "signature of another attachment", other.ID, false, query },
+        { "signature of the full file for the thumbnail", attachment.ID, true, query },
+    };
+    for _, c := range cases {
+        if err := open(c.id, c.thumbnail, c.query); !errors.Is(err, gorm.ErrRecordNotFound) {
+            t.Errorf("%s returned %v", c.name, err);
+        }
+    }
+}
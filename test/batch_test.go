@@ -0,0 +1,183 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cxcnxl/go-crud/internal/auth_helpers"
+	"github.com/cxcnxl/go-crud/internal/dto"
+	"github.com/cxcnxl/go-crud/internal/models"
+	"github.com/cxcnxl/go-crud/internal/routes"
+)
+
+func postBatch(t *testing.T, body string) (int, dto.BatchViewDto) {
+    t.Helper();
+
+    return postBatchTo(t, newTestRouter(t), body, "");
+}
+
+func postBatchTo(t *testing.T, router *routes.MethodHandler, body string, token string) (int, dto.BatchViewDto) {
+    t.Helper();
+
+    request := httptest.NewRequest("POST", "/batch", strings.NewReader(body));
+    request.Header.Set("Content-Type", "application/json");
+    if token != "" {
+        request.Header.Set("Authorization", "Bearer " + token);
+    }
+    recorder := httptest.NewRecorder();
+    router.Mux.ServeHTTP(recorder, request);
+
+    var response struct {
+        Data struct {
+            Data dto.BatchViewDto
+        }
+    }
+    json.Unmarshal(recorder.Body.Bytes(), &response);
+
+    return recorder.Code, response.Data.Data;
+}
+
+func TestBatchRunsEveryOperation(t *testing.T) {
+    status, view := postBatch(t, `{"operations":[
+        {"id":"spec","method":"GET","path":"/openapi.json"},
+        {"method":"POST","path":"/register","body":{"email":"${spec.info}","username":"ann","password":"password1"}},
+        {"method":"POST","path":"/register","body":{"email":"${spec.info.title}@","username":"ann","password":"password1"}},
+        {"method":"GET","path":"/posts/${nope.id}"},
+        {"method":"POST","path":"/batch","body":{"operations":[]}},
+        {"method":"TRACE","path":"/"},
+        {"method":"get","path":"/me"},
+        {"id":"hello","method":"GET","path":"/"}
+    ]}`);
+    if status != http.StatusOK {
+        t.Fatalf("batch answered %d", status);
+    }
+
+    expected := []int{ 200, 422, 422, 424, 400, 400, 401, 200 };
+    if len(view.Results) != len(expected) {
+        t.Fatalf("got %d results, expected %d", len(view.Results), len(expected));
+    }
+    for i, result := range view.Results {
+        if result.Status != expected[i] {
+            t.Errorf("operation %d answered %d, expected %d: %s", i, result.Status, expected[i], result.Body);
+        }
+    }
+
+    // whole strings take the referenced value, others its text
+    if body := string(view.Results[1].Body); !strings.Contains(body, `"email":"type"`) {
+        t.Errorf("object reference gave %s", body);
+    }
+    if body := string(view.Results[2].Body); !strings.Contains(body, `"email":"email"`) {
+        t.Errorf("text reference gave %s", body);
+    }
+    if body := string(view.Results[7].Body); body != `"Hello, World!"` || view.Results[7].ID != "hello" {
+        t.Errorf("text response gave %s", body);
+    }
+    if view.RolledBack {
+        t.Error("batch that is not atomic was rolled back");
+    }
+}
+
+func TestBatchRejectsInvalidBatches(t *testing.T) {
+    cases := map[string]int{
+        `{"operations":[]}`: http.StatusUnprocessableEntity,
+        `{"operations":[{"method":"GET"}]}`: http.StatusUnprocessableEntity,
+        `{"operations":[{"id":"a","method":"GET","path":"/"},{"id":"a","method":"GET","path":"/"}]}`: http.StatusBadRequest,
+    };
+
+    for body, expected := range cases {
+        if status, _ := postBatch(t, body); status != expected {
+            t.Errorf("%s answered %d, expected %d", body, status, expected);
+        }
+    }
+}
+
+// router over service with ann, who has a cached timeline, and bob. The
+// batch of ann follows bob, which publishes an event to bob and drops the
+// timeline of ann, and asks for an email change, which sends mails
+func newAtomicBatch(t *testing.T) (testService, *routes.MethodHandler, string, string) {
+    t.Helper();
+
+    t.Setenv("JWT_SECRET", "test_secret");
+    service := newTestService(t);
+    router := routes.NewRouter(service.AppService, nil);
+
+    ann := createTestUser(t, service, "ann");
+    service.db.Model(&ann).Update("password_hashed", auth_helpers.HashPassword("password1", auth_helpers.GenerateRandomSalt()));
+    bob := createTestUser(t, service, "bob");
+    service.redis.Set(fmt.Sprintf("timeline:v2:%d", ann.ID), "cached");
+
+    operations := fmt.Sprintf(`
+        {"method":"POST","path":"/users/%d/follow"},
+        {"method":"POST","path":"/me/email","body":{"email":"ann@example.org","password":"password1"}}
+    `, bob.ID);
+    token := auth_helpers.SignJWT(map[string]any{ "id": ann.ID });
+
+    return service, router, operations, token;
+}
+
+func TestAtomicBatchRollsBackOnFailure(t *testing.T) {
+    service, router, operations, token := newAtomicBatch(t);
+
+    status, view := postBatchTo(t, router, `{"atomic":true,"operations":[` + operations + `,
+        {"method":"PATCH","path":"/posts/404","body":{"body":"nope"}},
+        {"method":"GET","path":"/"}
+    ]}`, token);
+    if status != http.StatusOK {
+        t.Fatalf("batch answered %d", status);
+    }
+
+    expected := []int{ http.StatusNoContent, http.StatusAccepted, http.StatusNotFound, http.StatusFailedDependency };
+    for i, result := range view.Results {
+        if result.Status != expected[i] {
+            t.Errorf("operation %d answered %d, expected %d: %s", i, result.Status, expected[i], result.Body);
+        }
+    }
+    if !view.RolledBack {
+        t.Error("failed atomic batch was not rolled back");
+    }
+
+    var follows, changes, notifications int64;
+    service.db.Model(&models.Follow{}).Count(&follows);
+    service.db.Model(&models.EmailChange{}).Count(&changes);
+    service.db.Model(&models.Notification{}).Count(&notifications);
+    if follows != 0 || changes != 0 || notifications != 0 {
+        t.Errorf("rolled back batch left %d follows, %d email changes and %d notifications", follows, changes, notifications);
+    }
+    if keys := service.redis.Keys(); len(keys) != 1 || !strings.HasPrefix(keys[0], "timeline:") {
+        t.Errorf("rolled back batch left redis with %v", keys);
+    }
+    if len(service.mailer.messages) != 0 {
+        t.Errorf("rolled back batch sent %d mails", len(service.mailer.messages));
+    }
+}
+
+func TestAtomicBatchRunsEffectsOnCommit(t *testing.T) {
+    service, router, operations, token := newAtomicBatch(t);
+
+    status, view := postBatchTo(t, router, `{"atomic":true,"operations":[` + operations + `]}`, token);
+    if status != http.StatusOK || view.RolledBack {
+        t.Fatalf("batch answered %d, rolled back %t", status, view.RolledBack);
+    }
+    for i, result := range view.Results {
+        if result.Status >= http.StatusMultipleChoices {
+            t.Errorf("operation %d answered %d: %s", i, result.Status, result.Body);
+        }
+    }
+
+    var follows int64;
+    service.db.Model(&models.Follow{}).Count(&follows);
+    if follows != 1 {
+        t.Errorf("committed batch left %d follows", follows);
+    }
+    keys := strings.Join(service.redis.Keys(), " ");
+    if strings.Contains(keys, "timeline:") || !strings.Contains(keys, "events:user:") {
+        t.Errorf("committed batch left redis with %s", keys);
+    }
+    if len(service.mailer.messages) != 2 {
+        t.Errorf("committed batch sent %d mails, expected the confirmation and the alert", len(service.mailer.messages));
+    }
+}
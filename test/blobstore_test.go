@@ -0,0 +1,144 @@
+package test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cxcnxl/go-crud/internal/blobstore"
+)
+
+// minimal in-memory stand-in for S3 compatible storage. Checks that every
+// request is signed and payload hash matches the body
+type fakeS3 struct {
+    mu      sync.Mutex
+    objects map[string][]byte
+    t       *testing.T
+}
+
+func (self *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    auth := r.Header.Get("Authorization");
+    if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=test-access/") ||
+        !strings.Contains(auth, "SignedHeaders=") ||
+        !strings.Contains(auth, "Signature=") {
+        self.t.Errorf("Request is not signed: %q", auth);
+        w.WriteHeader(http.StatusForbidden);
+        return;
+    }
+
+    body, _ := io.ReadAll(r.Body);
+    hash := sha256.Sum256(body);
+    if r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(hash[:]) {
+        self.t.Error("Payload hash does not match body");
+        w.WriteHeader(http.StatusBadRequest);
+        return;
+    }
+
+    self.mu.Lock();
+    defer self.mu.Unlock();
+
+    switch r.Method {
+    case "PUT":
+        self.objects[r.URL.Path] = body;
+    case "GET":
+        object, ok := self.objects[r.URL.Path];
+        if !ok {
+            w.WriteHeader(http.StatusNotFound);
+            return;
+        }
+        w.Write(object);
+    case "DELETE":
+        delete(self.objects, r.URL.Path);
+        w.WriteHeader(http.StatusNoContent);
+    }
+}
+
+func testBlobStore(t *testing.T, store blobstore.BlobStore) {
+    ctx := context.Background();
+
+    err := store.Put(ctx, "posts/1/file", strings.NewReader("hello"), "text/plain");
+    if err != nil {
+        t.Fatalf("Put throwed an error %v", err);
+    }
+
+    blob, err := store.Get(ctx, "posts/1/file");
+    if err != nil {
+        t.Fatalf("Get throwed an error %v", err);
+    }
+    data, _ := io.ReadAll(blob);
+    blob.Close();
+    if string(data) != "hello" {
+        t.Errorf("Get returned %q, expected %q", data, "hello");
+    }
+
+    err = store.Delete(ctx, "posts/1/file");
+    if err != nil {
+        t.Fatalf("Delete throwed an error %v", err);
+    }
+
+    _, err = store.Get(ctx, "posts/1/file");
+    if !errors.Is(err, blobstore.NotFoundError{}) {
+        t.Errorf("Get after delete returned %v, expected not found", err);
+    }
+}
+
+func TestLocalBlobStore(t *testing.T) {
+    store, err := blobstore.NewLocalStore(t.TempDir());
+    if err != nil {
+        t.Fatal(err);
+    }
+
+    testBlobStore(t, store);
+
+    err = store.Put(context.Background(), "../escape", strings.NewReader("x"), "");
+    if !errors.Is(err, blobstore.InvalidKeyError{}) {
+        t.Errorf("Put outside of root returned %v, expected invalid key", err);
+    }
+}
+
+func TestS3BlobStore(t *testing.T) {
+    server := httptest.NewServer(&fakeS3{ objects: map[string][]byte{}, t: t });
+    defer server.Close();
+
+    store := blobstore.NewS3Store(
+        server.URL,
+        "bucket",
+        "us-east-1",
+        "test-access",
+        "test-secret",
+    );
+
+    testBlobStore(t, store);
+}
+
+func TestUrlSigner(t *testing.T) {
+    signer := blobstore.NewUrlSigner("secret");
+
+    signed := signer.Sign("/attachments/1", time.Minute);
+    parsed, err := url.Parse(signed);
+    if err != nil {
+        t.Fatal(err);
+    }
+
+    if !signer.Verify("/attachments/1", parsed.Query()) {
+        t.Error("Signed url was not verified");
+    }
+    if signer.Verify("/attachments/2", parsed.Query()) {
+        t.Error("Signature verified for another path");
+    }
+
+    expired := signer.Sign("/attachments/1", -time.Minute);
+    parsed, _ = url.Parse(expired);
+    if signer.Verify("/attachments/1", parsed.Query()) {
+        t.Error("Expired url was verified");
+    }
+}
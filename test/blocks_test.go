@@ -0,0 +1,202 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	rdb "github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+
+	"github.com/cxcnxl/go-crud/internal/app_service"
+	"github.com/cxcnxl/go-crud/internal/dto"
+	"github.com/cxcnxl/go-crud/internal/redis"
+)
+
+func timelineAuthors(t *testing.T, service testService, userId uint) []uint {
+    t.Helper();
+
+    page, err := service.GetTimeline(userId, dto.Keyset{}, 20);
+    if err != nil {
+        t.Fatal(err);
+    }
+
+    authors := []uint{};
+    for _, post := range page.Items {
+        if !slices.Contains(authors, post.AuthorID) {
+            authors = append(authors, post.AuthorID);
+        }
+    }
+    slices.Sort(authors);
+
+    return authors;
+}
+
+func followerIds(t *testing.T, service testService, userId uint, viewerId uint) []uint {
+    t.Helper();
+
+    page, err := service.GetFollowers(userId, viewerId, 0, 20);
+    if err != nil {
+        t.Fatal(err);
+    }
+
+    ids := []uint{};
+    for _, user := range page.Items {
+        ids = append(ids, user.ID);
+    }
+    slices.Sort(ids);
+
+    return ids;
+}
+
+func TestBlockingHidesUsersFromEachOther(t *testing.T) {
+    service := newTestService(t);
+    ann := createTestUser(t, service, "ann");
+    bob := createTestUser(t, service, "bob");
+    carl := createTestUser(t, service, "carl");
+    for _, pair := range [][2]uint{ { ann.ID, bob.ID }, { bob.ID, ann.ID }, { carl.ID, ann.ID }, { bob.ID, carl.ID } } {
+        if err := service.FollowUser(pair[0], pair[1]); err != nil {
+            t.Fatal(err);
+        }
+    }
+    createTestPost(t, service, bob.ID, time.Now());
+
+    if err := service.BlockUser(bob.ID, ann.ID); err != nil {
+        t.Fatal(err);
+    }
+
+    // follows both ways are gone
+    if ids := followerIds(t, service, ann.ID, carl.ID); !slices.Equal(ids, []uint{ carl.ID }) {
+        t.Errorf("followers of ann are %v", ids);
+    }
+    if err := service.FollowUser(ann.ID, bob.ID); !errors.Is(err, appservice.UserBlockedError{}) {
+        t.Errorf("following the blocker failed with %v", err);
+    }
+    if authors := timelineAuthors(t, service, ann.ID); len(authors) != 0 {
+        t.Errorf("timeline of ann has posts of %v", authors);
+    }
+    if _, err := service.GetProfile("bob", ann.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+        t.Errorf("profile of the blocker loaded with %v", err);
+    }
+
+    // lists of others leave out users blocked by the viewer, lists of the
+    // blocked user are gone for them
+    if ids := followerIds(t, service, carl.ID, ann.ID); len(ids) != 0 {
+        t.Errorf("ann sees followers %v of carl", ids);
+    }
+    if ids := followerIds(t, service, carl.ID, carl.ID); !slices.Equal(ids, []uint{ bob.ID }) {
+        t.Errorf("carl sees followers %v", ids);
+    }
+    if _, err := service.GetFollowing(bob.ID, ann.ID, 0, 20); !errors.Is(err, gorm.ErrRecordNotFound) {
+        t.Errorf("list of the blocker loaded with %v", err);
+    }
+
+    if err := service.UnblockUser(bob.ID, ann.ID); err != nil {
+        t.Fatal(err);
+    }
+    if ids := followerIds(t, service, carl.ID, ann.ID); !slices.Equal(ids, []uint{ bob.ID }) {
+        t.Errorf("ann sees followers %v of carl after the unblock", ids);
+    }
+    if err := service.FollowUser(ann.ID, bob.ID); err != nil {
+        t.Errorf("following after the unblock failed with %v", err);
+    }
+}
+
+func TestMutingHidesPostsOnly(t *testing.T) {
+    service := newTestService(t);
+    ann := createTestUser(t, service, "ann");
+    bob := createTestUser(t, service, "bob");
+    service.FollowUser(ann.ID, bob.ID);
+    createTestPost(t, service, bob.ID, time.Now());
+    createTestPost(t, service, ann.ID, time.Now());
+
+    if err := service.MuteUser(ann.ID, bob.ID); err != nil {
+        t.Fatal(err);
+    }
+    if authors := timelineAuthors(t, service, ann.ID); !slices.Equal(authors, []uint{ ann.ID }) {
+        t.Errorf("timeline of ann has posts of %v", authors);
+    }
+    if _, err := service.GetProfile("bob", ann.ID); err != nil {
+        t.Errorf("profile of the muted user failed with %v", err);
+    }
+    if ids := followerIds(t, service, bob.ID, ann.ID); !slices.Equal(ids, []uint{ ann.ID }) {
+        t.Errorf("followers of bob are %v", ids);
+    }
+    // the other way nothing changes
+    if authors := timelineAuthors(t, service, bob.ID); !slices.Equal(authors, []uint{ bob.ID }) {
+        t.Errorf("timeline of bob has posts of %v", authors);
+    }
+
+    if err := service.UnmuteUser(ann.ID, bob.ID); err != nil {
+        t.Fatal(err);
+    }
+    if authors := timelineAuthors(t, service, ann.ID); !slices.Equal(authors, []uint{ ann.ID, bob.ID }) {
+        t.Errorf("timeline of ann has posts of %v after the unmute", authors);
+    }
+
+    if err := service.MuteUser(ann.ID, ann.ID); !errors.Is(err, appservice.SelfMuteError{}) {
+        t.Errorf("muting oneself failed with %v", err);
+    }
+}
+
+func TestEmptyRelationsStayCached(t *testing.T) {
+    service := newTestService(t);
+    ann := createTestUser(t, service, "ann");
+    bob := createTestUser(t, service, "bob");
+    createTestPost(t, service, bob.ID, time.Now());
+
+    // any read that checks blocks caches them, the sentinel keeps the empty
+    // set from being a miss
+    if _, err := service.GetProfile("bob", ann.ID); err != nil {
+        t.Fatal(err);
+    }
+    key := fmt.Sprintf("users:blocked:%d", ann.ID);
+    if members, _ := service.redis.Members(key); !slices.Equal(members, []string{ "0" }) {
+        t.Errorf("cached blocks are %v", members);
+    }
+
+    service.BlockUser(ann.ID, bob.ID);
+    if service.redis.Exists(key) {
+        t.Errorf("blocks are still cached after a change");
+    }
+    if _, err := service.GetProfile("bob", ann.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+        t.Errorf("profile of the blocked user loaded with %v", err);
+    }
+    if members, _ := service.redis.Members(key); !slices.Equal(members, []string{ "0", fmt.Sprint(bob.ID) }) {
+        t.Errorf("cached blocks are %v", members);
+    }
+}
+
+func TestRelationsLoadedBeforeAChangeAreNotCached(t *testing.T) {
+    service := newTestService(t);
+    client := rdb.NewClient(&rdb.Options{ Addr: service.redis.Addr() });
+    defer client.Close();
+    wrapper := redis.NewRedisWrapper(client);
+
+    // a reader misses the cache and loads blocks from the database
+    _, version, ok, err := wrapper.GetBlockedUsers(1);
+    if err != nil || ok {
+        t.Fatalf("cold cache read %v, %v", ok, err);
+    }
+
+    // meanwhile a block commits and drops the cache
+    if err := wrapper.DeleteUserRelations(1, 2); err != nil {
+        t.Fatal(err);
+    }
+
+    // what the reader loaded is stale by now
+    if err := wrapper.SetBlockedUsers(1, []uint{}, version); err != nil {
+        t.Fatal(err);
+    }
+    if _, _, ok, _ := wrapper.GetBlockedUsers(1); ok {
+        t.Errorf("stale blocks were cached");
+    }
+
+    _, version, _, _ = wrapper.GetBlockedUsers(1);
+    wrapper.SetBlockedUsers(1, []uint{ 2 }, version);
+    if ids, _, ok, _ := wrapper.GetBlockedUsers(1); !ok || !slices.Equal(ids, []uint{ 2 }) {
+        t.Errorf("fresh blocks are cached as %v, %v", ids, ok);
+    }
+}
@@ -0,0 +1,219 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cxcnxl/go-crud/internal/codec"
+)
+
+type codecSample struct {
+    Name    string         `json:"name"`
+    Count   int64          `json:"count"`
+    Big     uint64         `json:"big"`
+    Ratio   float64        `json:"ratio"`
+    Tags    []string       `json:"tags"`
+    Parent  *codecSample   `json:"parent"`
+    Extra   map[string]any `json:"extra,omitempty"`
+    Secret  string         `json:"-"`
+}
+
+func TestCodecsRoundTripThroughJSONShape(t *testing.T) {
+    sample := codecSample{
+        Name: "post",
+        Count: -70000,
+        Big: 1 << 63,
+        Ratio: 0.25,
+        Tags: []string{ "a", "ü" },
+        Parent: &codecSample{ Name: "root", Count: 3 },
+        Secret: "hidden",
+    };
+    expected, _ := json.Marshal(sample);
+
+    for _, format := range []codec.Codec{ codec.MessagePack, codec.CBOR } {
+        encoded, err := format.Marshal(sample);
+        if err != nil {
+            t.Fatalf("%s: %v", format.ContentType, err);
+        }
+
+        decoded, err := format.ToJSON(encoded);
+        if err != nil {
+            t.Fatalf("%s: %v", format.ContentType, err);
+        }
+
+        var got, want any;
+        json.Unmarshal(decoded, &got);
+        json.Unmarshal(expected, &want);
+        if !reflect.DeepEqual(got, want) {
+            t.Errorf("%s gave %s, expected %s", format.ContentType, decoded, expected);
+        }
+    }
+}
+
+func TestCodecsMatchSpecExamples(t *testing.T) {
+    cases := []struct {
+        format codec.Codec
+        data   []byte
+        json   string
+    }{
+        { codec.MessagePack, []byte{ 0x81, 0xa1, 'a', 0x01 }, `{"a":1}` },
+        { codec.MessagePack, []byte{ 0x92, 0xd0, 0x80, 0xc4, 0x01, 0xff }, `[-128,"/w=="]` },
+        { codec.CBOR, []byte{ 0xa1, 0x61, 'a', 0x01 }, `{"a":1}` },
+        // indefinite array of a half float and a tagged epoch time, which
+        // reads as RFC 3339 text like times of JSON bodies
+        { codec.CBOR, []byte{ 0x9f, 0xf9, 0x3c, 0x00, 0xc1, 0x1a, 0x51, 0x4b, 0x67, 0xb0, 0xff }, `[1,"2013-03-21T20:04:00Z"]` },
+        // other tags are dropped
+        { codec.CBOR, []byte{ 0xd8, 0x20, 0x61, 'a' }, `"a"` },
+        { codec.CBOR, []byte{ 0x3b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff }, `-18446744073709551616` },
+    };
+
+    for _, c := range cases {
+        decoded, err := c.format.ToJSON(c.data);
+        if err != nil || string(decoded) != c.json {
+            t.Errorf("%s of %x gave %s %v, expected %s", c.format.ContentType, c.data, decoded, err, c.json);
+        }
+    }
+
+    encoded, _ := codec.CBOR.Marshal(map[string]int{ "a": 1 });
+    if !bytes.Equal(encoded, []byte{ 0xa1, 0x61, 'a', 0x01 }) {
+        t.Errorf("CBOR encoding is %x", encoded);
+    }
+}
+
+func TestCodecsRejectMalformedData(t *testing.T) {
+    nested := bytes.Repeat([]byte{ 0x91 }, 1000);
+    cases := map[string][]byte{
+        "truncated msgpack": { 0x92, 0x01 },
+        "huge msgpack array": { 0xdd, 0xff, 0xff, 0xff, 0xff },
+        "deep msgpack": append(nested, 0xc0),
+        "msgpack trailing data": { 0xc0, 0xc0 },
+        // the decoder would allocate the declared 3 GB first
+        "msgpack bin longer than the body": { 0x91, 0xc6, 0xc6, 0xc6, 0xc6, 0xc6, 0x91 },
+        "cbor break outside indefinite": { 0x82, 0x01, 0xff },
+        "cbor integer key": { 0xa1, 0x01, 0x01 },
+    };
+
+    for name, data := range cases {
+        format := codec.MessagePack;
+        if name[:4] == "cbor" {
+            format = codec.CBOR;
+        }
+        if _, err := format.ToJSON(data); err == nil {
+            t.Errorf("%s was accepted", name);
+        }
+    }
+}
+
+func TestCodecNegotiation(t *testing.T) {
+    cases := map[string]string{
+        "": "application/json",
+        "*/*": "application/json",
+        "text/html": "application/json",
+        "application/msgpack": "application/msgpack",
+        "application/x-msgpack": "application/msgpack",
+        "*/*, application/cbor": "application/cbor",
+        "application/*;q=0.5, application/cbor": "application/cbor",
+        "application/msgpack;q=0.4, application/json": "application/json",
+    };
+
+    for accept, expected := range cases {
+        if got := codec.Negotiate(accept).ContentType; got != expected {
+            t.Errorf("Accept %q gave %s, expected %s", accept, got, expected);
+        }
+    }
+}
+
+func TestRoutesSpeakMessagePack(t *testing.T) {
+    router := newTestRouter(t);
+
+    body, _ := codec.MessagePack.Marshal(map[string]string{
+        "email": "",
+        "username": "bob",
+        "password": "long enough",
+    });
+    request := httptest.NewRequest("POST", "/register", bytes.NewReader(body));
+    request.Header.Set("Content-Type", "application/msgpack");
+    request.Header.Set("Accept", "application/msgpack");
+    recorder := httptest.NewRecorder();
+    router.Mux.ServeHTTP(recorder, request);
+
+    if recorder.Code != http.StatusUnprocessableEntity {
+        t.Fatalf("status is %d, expected %d", recorder.Code, http.StatusUnprocessableEntity);
+    }
+    if got := recorder.Header().Get("Content-Type"); got != "application/msgpack" {
+        t.Fatalf("Content-Type is %q", got);
+    }
+
+    decoded, err := codec.MessagePack.ToJSON(recorder.Body.Bytes());
+    if err != nil {
+        t.Fatal(err);
+    }
+    expected := `{"data":{"data":{"email":"required"},"kind":"validation_errors"},"error":"validation_failed","status":"error"}`;
+    if string(decoded) != expected {
+        t.Errorf("response is %s", decoded);
+    }
+}
+
+// decoding any bytes fails with an error or gives JSON that survives being
+// encoded and decoded again
+func FuzzCodecsToJSON(f *testing.F) {
+    f.Add([]byte{ 0x81, 0xa1, 'a', 0x01 });
+    f.Add([]byte{ 0x92, 0xd0, 0x80, 0xc4, 0x01, 0xff });
+    f.Add([]byte{ 0xdd, 0xff, 0xff, 0xff, 0xff });
+    f.Add([]byte{ 0xa1, 0x61, 'a', 0x01 });
+    f.Add([]byte{ 0x9f, 0xf9, 0x3c, 0x00, 0xc1, 0x1a, 0x51, 0x4b, 0x67, 0xb0, 0xff });
+    f.Add([]byte{ 0x3b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff });
+    f.Add(append(bytes.Repeat([]byte{ 0x91 }, 300), 0xc0));
+
+    f.Fuzz(func(t *testing.T, data []byte) {
+        for _, format := range []codec.Codec{ codec.MessagePack, codec.CBOR } {
+            decoded, err := format.ToJSON(data);
+            if err != nil {
+                continue;
+            }
+            if !json.Valid(decoded) {
+                t.Fatalf("%s of %x gave invalid JSON %s", format.ContentType, data, decoded);
+            }
+
+            // numbers past 64 bits become floats on the first trip
+            first := reencode(t, format, decoded);
+            if second := reencode(t, format, first); !bytes.Equal(first, second) {
+                t.Errorf("%s of %x gave %s, then %s", format.ContentType, data, first, second);
+            }
+        }
+    });
+}
+
+func reencode(t *testing.T, format codec.Codec, data []byte) []byte {
+    t.Helper();
+
+    encoded, err := format.Marshal(json.RawMessage(data));
+    if err != nil {
+        t.Fatalf("%s failed to encode %s: %v", format.ContentType, data, err);
+    }
+    decoded, err := format.ToJSON(encoded);
+    if err != nil {
+        t.Fatalf("%s failed to decode %x from %s: %v", format.ContentType, encoded, data, err);
+    }
+
+    return decoded;
+}
+
+func TestRoutesRejectLargeBodies(t *testing.T) {
+    router := newTestRouter(t);
+
+    body := `{"username":"` + strings.Repeat("a", 2 << 20) + `"}`;
+    request := httptest.NewRequest("POST", "/login", strings.NewReader(body));
+    request.Header.Set("Content-Type", "application/json");
+    recorder := httptest.NewRecorder();
+    router.Mux.ServeHTTP(recorder, request);
+
+    if recorder.Code != http.StatusRequestEntityTooLarge {
+        t.Errorf("status is %d, expected %d", recorder.Code, http.StatusRequestEntityTooLarge);
+    }
+}
@@ -0,0 +1,100 @@
+package test
+
+import (
+	"math/rand/v2"
+	"strings"
+	"testing"
+
+	"github.com/cxcnxl/go-crud/internal/diff"
+)
+
+func TestDiffWords(t *testing.T) {
+    a := "the quick brown fox";
+    b := "the slow brown  fox jumps";
+
+    ops := diff.Words(a, b);
+
+    if got := diff.Apply(ops, diff.OpDelete); got != a {
+        t.Errorf("Old side of diff is %q, expected %q", got, a);
+    }
+    if got := diff.Apply(ops, diff.OpInsert); got != b {
+        t.Errorf("New side of diff is %q, expected %q", got, b);
+    }
+
+    deleted := "";
+    for _, op := range ops {
+        if op.Kind == diff.OpDelete {
+            deleted += op.Text;
+        }
+    }
+    if deleted != "quick " {
+        t.Errorf("Deleted %q, expected %q", deleted, "quick ");
+    }
+}
+
+func TestDiffEqual(t *testing.T) {
+    ops := diff.Words("same text", "same text");
+
+    if len(ops) != 1 || ops[0].Kind != diff.OpEqual {
+        t.Errorf("Expected single equal op, got %v", ops);
+    }
+}
+
+// tokens of one character each, so the equal text is as long as the
+// common subsequence
+func TestDiffFindsLongestCommonSubsequence(t *testing.T) {
+    random := rand.New(rand.NewPCG(1, 2));
+    text := func() string {
+        words := make([]string, random.IntN(30));
+        for i := range words {
+            words[i] = string(rune('a' + random.IntN(3)));
+        }
+        return strings.Join(words, " ");
+    };
+
+    for range 200 {
+        a, b := text(), text();
+        ops := diff.Words(a, b);
+        if diff.Apply(ops, diff.OpDelete) != a || diff.Apply(ops, diff.OpInsert) != b {
+            t.Fatalf("%q to %q gave %v", a, b, ops);
+        }
+
+        equal := 0;
+        for _, op := range ops {
+            if op.Kind == diff.OpEqual {
+                equal += len(op.Text);
+            }
+        }
+        if expected := lcsLength(a, b); equal != expected {
+            t.Errorf("%q to %q keeps %d characters, expected %d", a, b, equal, expected);
+        }
+    }
+}
+
+func lcsLength(a string, b string) int {
+    lengths := make([][]int, len(a) + 1);
+    for i := range lengths {
+        lengths[i] = make([]int, len(b) + 1);
+    }
+    for i := 1; i <= len(a); i++ {
+        for j := 1; j <= len(b); j++ {
+            if a[i - 1] == b[j - 1] {
+                lengths[i][j] = lengths[i - 1][j - 1] + 1;
+            } else {
+                lengths[i][j] = max(lengths[i - 1][j], lengths[i][j - 1]);
+            }
+        }
+    }
+
+    return lengths[len(a)][len(b)];
+}
+
+func TestDiffLongPosts(t *testing.T) {
+    a := strings.Repeat("a ", 2500);
+    b := "b " + strings.Repeat("a ", 2400) + "c";
+
+    ops := diff.Words(a, b);
+    if diff.Apply(ops, diff.OpDelete) != a || diff.Apply(ops, diff.OpInsert) != b {
+        t.Error("long diff does not give back both sides");
+    }
+}
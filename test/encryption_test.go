@@ -0,0 +1,80 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/cxcnxl/go-crud/internal/encryption"
+)
+
+func newTestSealer(t *testing.T, fill byte) *encryption.Sealer {
+    sealer, err := encryption.NewSealer(bytes.Repeat([]byte{fill}, encryption.KeySize));
+    if err != nil {
+        t.Fatal(err);
+    }
+
+    return sealer;
+}
+
+func TestSealerRoundTrip(t *testing.T) {
+    sealer := newTestSealer(t, 1);
+    plaintext := []byte("hello there");
+
+    first, err := sealer.Seal(plaintext, []byte("conversation:1"));
+    if err != nil {
+        t.Fatal(err);
+    }
+    second, _ := sealer.Seal(plaintext, []byte("conversation:1"));
+
+    if bytes.Contains(first, plaintext) {
+        t.Error("sealed value contains the plaintext");
+    }
+    if bytes.Equal(first, second) {
+        t.Error("sealing the same plaintext twice gave the same value");
+    }
+
+    opened, err := sealer.Open(first, []byte("conversation:1"));
+    if err != nil {
+        t.Fatal(err);
+    }
+    if !bytes.Equal(opened, plaintext) {
+        t.Errorf("opened %q, expected %q", opened, plaintext);
+    }
+}
+
+func TestSealerRejectsTampering(t *testing.T) {
+    sealer := newTestSealer(t, 1);
+    sealed, _ := sealer.Seal([]byte("hello there"), []byte("conversation:1"));
+
+    tampered := bytes.Clone(sealed);
+    tampered[len(tampered) - 1] ^= 1;
+
+    cases := map[string]func() ([]byte, error){
+        "tampered": func() ([]byte, error) {
+            return sealer.Open(tampered, []byte("conversation:1"));
+        },
+        "other record": func() ([]byte, error) {
+            return sealer.Open(sealed, []byte("conversation:2"));
+        },
+        "other key": func() ([]byte, error) {
+            return newTestSealer(t, 2).Open(sealed, []byte("conversation:1"));
+        },
+        "truncated": func() ([]byte, error) {
+            return sealer.Open(sealed[:4], []byte("conversation:1"));
+        },
+    };
+
+    for name, open := range cases {
+        if _, err := open(); !errors.Is(err, encryption.InvalidCiphertextError{}) {
+            t.Errorf("%s: got error %v, expected invalid ciphertext", name, err);
+        }
+    }
+}
+
+func TestSealerRejectsShortKeys(t *testing.T) {
+    _, err := encryption.NewSealer([]byte("short"));
+    if !errors.Is(err, encryption.InvalidKeyError{}) {
+        t.Errorf("got error %v, expected invalid key", err);
+    }
+}
@@ -0,0 +1,25 @@
+package test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/cxcnxl/go-crud/internal/entities"
+)
+
+func TestParseEntities(t *testing.T) {
+    parsed := entities.Parse(
+        "#Go is fun, ask @alice or @Bob_2 about #go and #Тест. " +
+        "Mail me at me@example.com, see page#anchor",
+    );
+
+    expectedTags := []string{"go", "тест"};
+    if !slices.Equal(parsed.Tags, expectedTags) {
+        t.Errorf("Parsed tags %v, expected %v", parsed.Tags, expectedTags);
+    }
+
+    expectedMentions := []string{"alice", "Bob_2"};
+    if !slices.Equal(parsed.Mentions, expectedMentions) {
+        t.Errorf("Parsed mentions %v, expected %v", parsed.Mentions, expectedMentions);
+    }
+}
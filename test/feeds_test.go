@@ -0,0 +1,90 @@
+package test
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cxcnxl/go-crud/internal/feeds"
+)
+
+func testFeed() feeds.Feed {
+    published := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC);
+
+    return feeds.Feed{
+        Title: "Posts by alice",
+        HomeUrl: "http://localhost/users/alice",
+        FeedUrl: "http://localhost/users/alice/feed.atom",
+        Author: "alice",
+        Updated: published,
+        Items: []feeds.Item{{
+            Id: "http://localhost/posts/1",
+            Url: "http://localhost/posts/1",
+            Content: "first line <b>\nsecond line",
+            Published: published,
+            Updated: published,
+        }},
+    };
+}
+
+func TestAtomFeed(t *testing.T) {
+    body, err := feeds.Atom(testFeed());
+    if err != nil {
+        t.Fatal(err);
+    }
+
+    var parsed struct {
+        XMLName xml.Name
+        Entries []struct {
+            Title   string `xml:"title"`
+            Content string `xml:"content"`
+        } `xml:"entry"`
+    };
+    if err := xml.Unmarshal(body, &parsed); err != nil {
+        t.Fatalf("Atom feed is not valid XML: %v", err);
+    }
+
+    if parsed.XMLName.Space != "http://www.w3.org/2005/Atom" {
+        t.Errorf("Unexpected namespace %q", parsed.XMLName.Space);
+    }
+    if len(parsed.Entries) != 1 || parsed.Entries[0].Title != "first line <b>" {
+        t.Errorf("Unexpected entries %+v", parsed.Entries);
+    }
+}
+
+func TestRSSFeed(t *testing.T) {
+    body, err := feeds.RSS(testFeed());
+    if err != nil {
+        t.Fatal(err);
+    }
+
+    if !strings.Contains(string(body), "<pubDate>Thu, 02 Jan 2025 03:04:05 +0000</pubDate>") {
+        t.Errorf("RSS item has no RFC 822 pubDate:\n%s", body);
+    }
+}
+
+func TestJSONFeed(t *testing.T) {
+    body, err := feeds.JSONFeed(testFeed());
+    if err != nil {
+        t.Fatal(err);
+    }
+
+    var parsed map[string]any;
+    if err := json.Unmarshal(body, &parsed); err != nil {
+        t.Fatal(err);
+    }
+
+    if parsed["version"] != "https://jsonfeed.org/version/1.1" {
+        t.Errorf("Unexpected version %v", parsed["version"]);
+    }
+    items, _ := parsed["items"].([]any);
+    if len(items) != 1 {
+        t.Fatalf("Expected one item, got %v", parsed["items"]);
+    }
+    item, _ := items[0].(map[string]any);
+    if item["date_published"] != "2025-01-02T03:04:05Z" {
+        t.Errorf("Unexpected date_published %v", item["date_published"]);
+    }
+}
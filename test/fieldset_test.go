@@ -0,0 +1,97 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/cxcnxl/go-crud/internal/fieldset"
+	"github.com/cxcnxl/go-crud/internal/models"
+	"github.com/cxcnxl/go-crud/internal/problems"
+)
+
+func projectPost(t *testing.T, query string) string {
+    t.Helper();
+
+    values, _ := url.ParseQuery(query);
+    spec, err := fieldset.Parse(fieldset.Post, values);
+    if err != nil {
+        t.Fatalf("%s: %v", query, err);
+    }
+
+    post := models.Post{
+        ID: 1,
+        Body: "hi",
+        Status: models.PostStatusPublished,
+        Author: models.User{ ID: 2, Username: "ann", Bio: "bio" },
+    };
+    projected, err := spec.Project([]models.Post{ post });
+    if err != nil {
+        t.Fatalf("%s: %v", query, err);
+    }
+
+    encoded, _ := json.Marshal(projected);
+    return string(encoded);
+}
+
+func TestFieldsetProjectsResponses(t *testing.T) {
+    cases := map[string]string{
+        "fields=id,body": `[{"author":{"avatar_url":"","bio":"bio","display_name":"","followers_count":0,"following_count":0,"id":2,"locale":"","show_email":false,"username":"ann","website":""},"body":"hi","id":1}]`,
+        "fields=id,author.username": `[{"author":{"username":"ann"},"id":1}]`,
+        "fields=id&include=": `[{"id":1}]`,
+        "fields=status&include=author": `[{"author":{"avatar_url":"","bio":"bio","display_name":"","followers_count":0,"following_count":0,"id":2,"locale":"","show_email":false,"username":"ann","website":""},"status":"published"}]`,
+    };
+
+    for query, expected := range cases {
+        if got := projectPost(t, query); got != expected {
+            t.Errorf("%s projected %s, expected %s", query, got, expected);
+        }
+    }
+}
+
+func TestFieldsetRejectsUnknownMembers(t *testing.T) {
+    // models may refer to each other, includes still end at MaxDepth
+    node := &fieldset.Model{ Table: "nodes", Fields: map[string][]string{ "id": { "id" } } };
+    node.Relations = map[string]fieldset.Relation{
+        "parent": { Association: "Parent", ForeignKey: "parent_id", Model: node },
+    };
+
+    cases := []struct {
+        model *fieldset.Model
+        query string
+        err   error
+    }{
+        { fieldset.Post, "fields=id,author_id", fieldset.UnknownFieldError{ Field: "author_id" } },
+        { fieldset.Post, "fields=author.email_hash", fieldset.UnknownFieldError{ Field: "author.email_hash" } },
+        { fieldset.Post, "include=author,comments", fieldset.UnknownRelationError{ Relation: "comments" } },
+        { fieldset.Post, "fields=comments.id", fieldset.UnknownRelationError{ Relation: "comments.id" } },
+        { fieldset.User, "include=posts", fieldset.UnknownRelationError{ Relation: "posts" } },
+        { node, "include=parent.parent.parent", fieldset.IncludeTooDeepError{ Relation: "parent.parent.parent" } },
+        { node, "fields=parent.parent.parent.id", fieldset.IncludeTooDeepError{ Relation: "parent.parent.parent.id" } },
+        { node, "include=parent.parent&fields=parent.parent.id", nil },
+    };
+
+    for _, c := range cases {
+        values, _ := url.ParseQuery(c.query);
+        if _, err := fieldset.Parse(c.model, values); err != c.err {
+            t.Errorf("%s failed with %#v, expected %#v", c.query, err, c.err);
+        }
+    }
+}
+
+func TestFieldsetErrorsAreProblems(t *testing.T) {
+    router := newTestRouter(t);
+
+    request := httptest.NewRequest("GET", "/posts/1?include=comments", nil);
+    request.Header.Set("Accept", problems.ContentType);
+    recorder := httptest.NewRecorder();
+    router.Mux.ServeHTTP(recorder, request);
+
+    var body map[string]any;
+    json.Unmarshal(recorder.Body.Bytes(), &body);
+    if recorder.Code != http.StatusBadRequest || body["code"] != "unknown_relation" || body["relation"] != "comments" {
+        t.Errorf("response is %d %v", recorder.Code, body);
+    }
+}
@@ -0,0 +1,190 @@
+package test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"gorm.io/gorm"
+
+	"github.com/cxcnxl/go-crud/internal/graph"
+	"github.com/cxcnxl/go-crud/internal/models"
+)
+
+type graphResult struct {
+    Data   map[string]any
+    Errors []struct {
+        Message    string
+        Extensions map[string]any
+    }
+}
+
+// posts the body to the GraphQL handler of service as the viewer and
+// returns the status with the decoded result
+func runGraph(t *testing.T, service testService, viewerId uint, body string) (int, graphResult) {
+    t.Helper();
+
+    request := httptest.NewRequest("POST", "/graphql", strings.NewReader(body));
+    request.Header.Set("Content-Type", "application/json");
+    request = request.WithContext(graph.WithViewer(request.Context(), viewerId));
+    recorder := httptest.NewRecorder();
+    graph.NewHandler(service.AppService).ServeHTTP(recorder, request);
+
+    var result graphResult;
+    json.Unmarshal(recorder.Body.Bytes(), &result);
+    return recorder.Code, result;
+}
+
+func graphQuery(query string) string {
+    body, _ := json.Marshal(map[string]string{ "query": query });
+    return string(body);
+}
+
+// counts queries reading each table
+func countQueries(t *testing.T, db *gorm.DB) map[string]int {
+    counts := map[string]int{};
+    mutex := sync.Mutex{};
+    err := db.Callback().Query().After("gorm:query").Register("test:count", func(tx *gorm.DB) {
+        mutex.Lock();
+        counts[tx.Statement.Table]++;
+        mutex.Unlock();
+    });
+    if err != nil {
+        t.Fatal(err);
+    }
+
+    return counts;
+}
+
+func TestGraphQLBatchesLoads(t *testing.T) {
+    service := newTestService(t);
+    ids := []uint{};
+    for i := range 3 {
+        author := createTestUser(t, service, fmt.Sprintf("author%d", i));
+        post := models.Post{ Body: "post", AuthorID: author.ID };
+        service.db.Create(&post);
+        ids = append(ids, post.ID);
+    }
+    counts := countQueries(t, service.db);
+
+    status, result := runGraph(t, service, 0, graphQuery(fmt.Sprintf(`{
+        a: post(id: %d) { author { username posts { id } } }
+        b: post(id: %d) { author { username posts { id } } }
+        c: post(id: %d) { author { username posts { id } } }
+        missing: post(id: 404) { id }
+    }`, ids[0], ids[1], ids[2])));
+    if status != http.StatusOK || len(result.Errors) > 0 {
+        t.Fatalf("answered %d %v", status, result.Errors);
+    }
+
+    for i, alias := range []string{ "a", "b", "c" } {
+        author := result.Data[alias].(map[string]any)["author"].(map[string]any);
+        if author["username"] != fmt.Sprintf("author%d", i) || len(author["posts"].([]any)) != 1 {
+            t.Errorf("%s is %v", alias, author);
+        }
+    }
+    if result.Data["missing"] != nil {
+        t.Errorf("missing post is %v", result.Data["missing"]);
+    }
+    // posts by id, then the latest posts of the authors
+    if counts["users"] != 1 || counts["posts"] != 2 {
+        t.Errorf("queries made: %v", counts);
+    }
+}
+
+func TestGraphQLShowsEmailToOwnerOnly(t *testing.T) {
+    service := newTestService(t);
+    owner := createTestUser(t, service, "owner");
+    other := createTestUser(t, service, "other");
+
+    query := graphQuery(`{ user(username: "owner") { email } }`);
+    cases := []struct {
+        viewerId uint
+        email    any
+    }{
+        { 0, nil },
+        { other.ID, nil },
+        { owner.ID, "owner@example.com" },
+    };
+    for _, c := range cases {
+        _, result := runGraph(t, service, c.viewerId, query);
+        if email := result.Data["user"].(map[string]any)["email"]; email != c.email {
+            t.Errorf("viewer %d sees email %v", c.viewerId, email);
+        }
+    }
+
+    _, result := runGraph(t, service, owner.ID, graphQuery(`{ viewer { username } nobody: user(username: "nobody") { id } }`));
+    if result.Data["viewer"].(map[string]any)["username"] != "owner" || result.Data["nobody"] != nil {
+        t.Errorf("result is %v", result.Data);
+    }
+}
+
+func TestGraphQLRejectsQueries(t *testing.T) {
+    service := newTestService(t);
+
+    deep := `{ viewer { posts { author { posts { author { posts { author { posts { id } } } } } } } } }`;
+    costly := `{ tagPosts(tag: "go", first: 100) { items { author { posts(first: 50) { id } } } } }`;
+    cases := []struct {
+        body   string
+        status int
+        code   string
+    }{
+        { graphQuery(deep), http.StatusOK, "QUERY_TOO_DEEP" },
+        { graphQuery(costly), http.StatusOK, "COMPLEXITY_LIMIT_EXCEEDED" },
+        { graphQuery(`{ viewer { password } }`), http.StatusUnprocessableEntity, "GRAPHQL_VALIDATION_FAILED" },
+        { graphQuery(`{ viewer `), http.StatusUnprocessableEntity, "GRAPHQL_PARSE_FAILED" },
+        { `not json`, http.StatusBadRequest, "" },
+    };
+
+    for _, c := range cases {
+        status, result := runGraph(t, service, 0, c.body);
+        if status != c.status {
+            t.Errorf("%s answered %d, expected %d", c.body, status, c.status);
+        }
+        if c.code == "" {
+            continue;
+        }
+        if result.Data != nil || len(result.Errors) != 1 || result.Errors[0].Extensions["code"] != c.code {
+            t.Errorf("%s answered %v, expected %s", c.body, result.Errors, c.code);
+        }
+    }
+}
+
+func TestGraphQLPersistsQueries(t *testing.T) {
+    service := newTestService(t);
+    createTestUser(t, service, "ann");
+
+    query := `{ user(username: "ann") { username } }`;
+    sum := sha256.Sum256([]byte(query));
+    hash := hex.EncodeToString(sum[:]);
+    persisted := func(query string, hash string) string {
+        body, _ := json.Marshal(map[string]any{
+            "query": query,
+            "extensions": map[string]any{ "persistedQuery": map[string]any{ "version": 1, "sha256Hash": hash } },
+        });
+        return string(body);
+    };
+
+    _, result := runGraph(t, service, 0, persisted("", hash));
+    if len(result.Errors) != 1 || result.Errors[0].Extensions["code"] != "PERSISTED_QUERY_NOT_FOUND" {
+        t.Errorf("unknown hash answered %v", result.Errors);
+    }
+
+    _, result = runGraph(t, service, 0, persisted(query, "00"));
+    if len(result.Errors) != 1 || result.Data != nil {
+        t.Errorf("mismatched hash answered %v", result);
+    }
+
+    for _, body := range []string{ persisted(query, hash), persisted("", hash) } {
+        _, result = runGraph(t, service, 0, body);
+        if len(result.Errors) > 0 || result.Data["user"].(map[string]any)["username"] != "ann" {
+            t.Errorf("%s answered %v", body, result);
+        }
+    }
+}
@@ -0,0 +1,110 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cxcnxl/go-crud/internal/auth_helpers"
+	"github.com/cxcnxl/go-crud/internal/jsonpatch"
+	"github.com/cxcnxl/go-crud/internal/routes"
+)
+
+// cases from the appendix of RFC 6902
+func TestJSONPatchRFCExamples(t *testing.T) {
+    cases := []struct {
+        target string
+        patch  string
+        result string
+    }{
+        {`{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`},
+        {`{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`},
+        {`{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+        {`{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+        {`{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`},
+        {
+            `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+            `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+            `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`,
+        },
+        {`{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`},
+        {
+            `{"baz":"qux","foo":["a",2,"c"]}`,
+            `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2.0}]`,
+            `{"baz":"qux","foo":["a",2,"c"]}`,
+        },
+        {`{"foo":"bar"}`, `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`, `{"foo":"bar","child":{"grandchild":{}}}`},
+        {`{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux","xyz":123}]`, `{"foo":"bar","baz":"qux"}`},
+        {`{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`},
+        {`{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":10}]`, `{"/":9,"~1":10}`},
+        {`{"foo":null}`, `[{"op":"test","path":"/foo","value":null}]`, `{"foo":null}`},
+        {`{"foo":{"bar":1}}`, `[{"op":"copy","from":"/foo","path":"/baz"},{"op":"add","path":"/baz/qux","value":2}]`, `{"foo":{"bar":1},"baz":{"bar":1,"qux":2}}`},
+        {`{"foo":1}`, `[{"op":"replace","path":"","value":{"bar":2}}]`, `{"bar":2}`},
+    };
+
+    for _, c := range cases {
+        got, err := jsonpatch.Apply([]byte(c.target), []byte(c.patch));
+        if err != nil {
+            t.Errorf("%s + %s: %v", c.target, c.patch, err);
+            continue;
+        }
+
+        var gotValue, expectedValue any;
+        json.Unmarshal(got, &gotValue);
+        json.Unmarshal([]byte(c.result), &expectedValue);
+        if !reflect.DeepEqual(gotValue, expectedValue) {
+            t.Errorf("%s + %s = %s, expected %s", c.target, c.patch, got, c.result);
+        }
+    }
+}
+
+func TestJSONPatchFailures(t *testing.T) {
+    cases := []struct {
+        target string
+        patch  string
+        err    error
+    }{
+        {`{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`, jsonpatch.TestFailedError{ Path: "/baz" }},
+        {`{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":"10"}]`, jsonpatch.TestFailedError{ Path: "/~01" }},
+        {`{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`, jsonpatch.ConflictError{ Path: "/baz/bat" }},
+        {`{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/3","value":1}]`, jsonpatch.ConflictError{ Path: "/foo/3" }},
+        {`{"foo":["bar"]}`, `[{"op":"remove","path":"/foo/01"}]`, jsonpatch.ConflictError{ Path: "/foo/01" }},
+        {`{"foo":"bar"}`, `[{"op":"replace","path":"/baz","value":1}]`, jsonpatch.ConflictError{ Path: "/baz" }},
+        {`{"foo":{"bar":1}}`, `[{"op":"move","from":"/foo","path":"/foo/bar/baz"}]`, jsonpatch.ConflictError{ Path: "/foo/bar/baz" }},
+        {`{"foo":"bar"}`, `[{"op":"add","path":"/baz"}]`, jsonpatch.InvalidPatchError{}},
+        {`{"foo":"bar"}`, `[{"op":"invalid","path":"/baz","value":1}]`, jsonpatch.InvalidPatchError{}},
+        {`{"foo":"bar"}`, `[{"op":"add","path":"baz","value":1}]`, jsonpatch.InvalidPatchError{}},
+        {`{"foo":"bar"}`, `{"op":"add","path":"/baz","value":1}`, jsonpatch.InvalidPatchError{}},
+    };
+
+    for _, c := range cases {
+        _, err := jsonpatch.Apply([]byte(c.target), []byte(c.patch));
+        if err != c.err {
+            t.Errorf("%s + %s failed with %#v, expected %#v", c.target, c.patch, err, c.err);
+        }
+    }
+}
+
+func TestPatchRoutesNameAcceptedFormats(t *testing.T) {
+    t.Setenv("JWT_SECRET", "test_secret");
+    service := newTestService(t);
+    router := routes.NewRouter(service.AppService, nil);
+    ann := createTestUser(t, service, "ann");
+
+    request := httptest.NewRequest("PATCH", "/me", strings.NewReader(`bio=x`));
+    request.Header.Set("Content-Type", "application/x-www-form-urlencoded");
+    request.Header.Set("Authorization", "Bearer " + auth_helpers.SignJWT(map[string]any{"id": ann.ID}));
+    recorder := httptest.NewRecorder();
+    router.Mux.ServeHTTP(recorder, request);
+
+    if recorder.Code != http.StatusUnsupportedMediaType {
+        t.Fatalf("form body answered %d", recorder.Code);
+    }
+    accepted := recorder.Header().Get("Accept-Patch");
+    if !strings.Contains(accepted, jsonpatch.ContentType) || !strings.Contains(accepted, "application/merge-patch+json") {
+        t.Errorf("Accept-Patch is %q", accepted);
+    }
+}
@@ -0,0 +1,43 @@
+package test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cxcnxl/go-crud/internal/mailer"
+)
+
+func TestMailerFormatUsesCRLF(t *testing.T) {
+    data, err := mailer.Format("noreply@example.com", mailer.Message{
+        To: "user@example.com",
+        Subject: "Hello",
+        Body: "first\nsecond\r\nthird",
+    }, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC));
+    if err != nil {
+        t.Fatal(err);
+    }
+
+    mail := string(data);
+    if !strings.HasPrefix(mail, "From: noreply@example.com\r\nTo: user@example.com\r\nSubject: Hello\r\n") {
+        t.Fatalf("unexpected headers: %q", mail);
+    }
+    if !strings.HasSuffix(mail, "\r\n\r\nfirst\r\nsecond\r\nthird") {
+        t.Fatalf("body line breaks not normalized: %q", mail);
+    }
+}
+
+func TestMailerFormatRejectsHeaderInjection(t *testing.T) {
+    messages := []mailer.Message{
+        { To: "user@example.com\r\nBcc: other@example.com", Subject: "Hello" },
+        { To: "user@example.com", Subject: "Hello\nBcc: other@example.com" },
+    };
+
+    for _, message := range messages {
+        _, err := mailer.Format("noreply@example.com", message, time.Now());
+        if !errors.Is(err, mailer.InvalidHeaderError{}) {
+            t.Fatalf("expected InvalidHeaderError for %q, got %v", message.To + message.Subject, err);
+        }
+    }
+}
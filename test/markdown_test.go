@@ -0,0 +1,133 @@
+package test
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+
+	"github.com/cxcnxl/go-crud/internal/markdown"
+)
+
+// payloads that must never survive rendering in an executable form
+var xssCorpus = []string{
+    `<script>alert(1)</script>`,
+    `<SCRIPT SRC=//evil.example/x.js></SCRIPT>`,
+    `<img src=x onerror=alert(1)>`,
+    `<svg onload=alert(1)>`,
+    `<iframe src="javascript:alert(1)"></iframe>`,
+    `<a href="javascript:alert(1)">x</a>`,
+    `<style>body{background:url(javascript:alert(1))}</style>`,
+    `<div style="background:url(javascript:alert(1))">x</div>`,
+    `<body onload=alert(1)>`,
+    `<math><mtext><table><mglyph><style><img src=x onerror=alert(1)>`,
+    `[x](javascript:alert(1))`,
+    `[x](JaVaScRiPt:alert(1))`,
+    `[x](javascript&#58;alert(1))`,
+    `[x](  javascript:alert(1))`,
+    `[x](vbscript:msgbox(1))`,
+    `[x](data:text/html;base64,PHNjcmlwdD5hbGVydCgxKTwvc2NyaXB0Pg==)`,
+    `![x](javascript:alert(1))`,
+    `![x](https://evil.example/x.png" onerror="alert(1))`,
+    `[x](https://example.com "title\" onmouseover=\"alert(1)")`,
+    `<http://example.com/"onmouseover="alert(1)>`,
+    "```\"><script>alert(1)</script>\nx\n```",
+    "```js\" onmouseover=\"alert(1)\nx\n```",
+    `#tag"onmouseover="alert(1)`,
+    `@user"><script>alert(1)</script>`,
+    `<!--><script>alert(1)</script>-->`,
+    `<object data="javascript:alert(1)">`,
+    `<form action="javascript:alert(1)"><input type=submit>`,
+    `<meta http-equiv="refresh" content="0;url=javascript:alert(1)">`,
+};
+
+var allowedTags = map[string]bool{
+    "p": true, "br": true, "hr": true, "em": true, "strong": true,
+    "del": true, "blockquote": true, "pre": true, "code": true, "ul": true,
+    "ol": true, "li": true, "h1": true, "h2": true, "h3": true, "h4": true,
+    "h5": true, "h6": true, "a": true,
+};
+
+var allowedAttrs = map[string]bool{
+    "href": true, "rel": true, "target": true, "class": true, "start": true,
+};
+
+// walks rendered html and reports every tag, attribute or link outside of
+// the allowlist
+func checkSanitized(t *testing.T, payload string, rendered string) {
+    tokenizer := html.NewTokenizer(strings.NewReader(rendered));
+
+    for {
+        tokenType := tokenizer.Next();
+        if tokenType == html.ErrorToken {
+            return;
+        }
+        if tokenType != html.StartTagToken && tokenType != html.SelfClosingTagToken {
+            continue;
+        }
+
+        token := tokenizer.Token();
+        if !allowedTags[token.Data] {
+            t.Errorf("Rendered %q contains <%s>:\n%s", payload, token.Data, rendered);
+        }
+
+        for _, attr := range token.Attr {
+            if !allowedAttrs[attr.Key] {
+                t.Errorf("Rendered %q contains %s attribute:\n%s", payload, attr.Key, rendered);
+            }
+
+            href := strings.ToLower(strings.TrimSpace(attr.Val));
+            if attr.Key == "href" &&
+                !strings.HasPrefix(href, "http://") &&
+                !strings.HasPrefix(href, "https://") &&
+                !strings.HasPrefix(href, "mailto:") &&
+                !(strings.HasPrefix(href, "/") && !strings.HasPrefix(href, "//")) {
+                t.Errorf("Rendered %q links to %q:\n%s", payload, attr.Val, rendered);
+            }
+        }
+    }
+}
+
+func TestMarkdownXSSCorpus(t *testing.T) {
+    for _, payload := range xssCorpus {
+        rendered, err := markdown.Render(payload);
+        if err != nil {
+            t.Errorf("Render(%q) throwed an error %v", payload, err);
+            continue;
+        }
+
+        checkSanitized(t, payload, rendered);
+        checkSanitized(t, payload, markdown.RenderPlain(payload));
+    }
+}
+
+func TestMarkdownFeatures(t *testing.T) {
+    rendered, err := markdown.Render(
+        "**hi** #Go @alice https://example.com\n\n```go\nfmt.Println()\n```",
+    );
+    if err != nil {
+        t.Fatal(err);
+    }
+
+    for _, expected := range []string{
+        "<strong>hi</strong>",
+        `<a href="/tags/go" class="hashtag" rel="nofollow">#Go</a>`,
+        `<a href="/users/alice" class="mention" rel="nofollow">@alice</a>`,
+        `<a href="https://example.com" rel="nofollow noopener" target="_blank">`,
+        `<code class="language-go">`,
+    } {
+        if !strings.Contains(rendered, expected) {
+            t.Errorf("Rendered markdown misses %q:\n%s", expected, rendered);
+        }
+    }
+}
+
+func TestRenderPlain(t *testing.T) {
+    rendered := markdown.RenderPlain("<b>x</b> #go\nline");
+
+    expected := `<p>&lt;b&gt;x&lt;/b&gt; <a class="hashtag" href="/tags/go" rel="nofollow">#go</a><br>` +
+        "\nline</p>";
+    if rendered != expected {
+        t.Errorf("RenderPlain returned\n%s\nexpected\n%s", rendered, expected);
+    }
+}
@@ -0,0 +1,59 @@
+package test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/cxcnxl/go-crud/internal/media"
+)
+
+func encodePNG(t *testing.T, width int, height int) []byte {
+    t.Helper();
+
+    src := image.NewRGBA(image.Rect(0, 0, width, height));
+    for y := 0; y < height; y++ {
+        for x := 0; x < width; x++ {
+            src.Set(x, y, color.RGBA{ uint8(x), uint8(y), 128, 255 });
+        }
+    }
+
+    var out bytes.Buffer;
+    if err := png.Encode(&out, src); err != nil {
+        t.Fatal(err);
+    }
+
+    return out.Bytes();
+}
+
+func TestThumbnailFitsTheBox(t *testing.T) {
+    data, err := media.Thumbnail(encodePNG(t, 400, 100), 64);
+    if err != nil {
+        t.Fatal(err);
+    }
+
+    thumbnail, err := jpeg.Decode(bytes.NewReader(data));
+    if err != nil {
+        t.Fatal(err);
+    }
+    if size := thumbnail.Bounds().Size(); size.X != 64 || size.Y != 16 {
+        t.Errorf("thumbnail is %v", size);
+    }
+}
+
+func TestThumbnailRejectsHugeImages(t *testing.T) {
+    // a tiny file whose header claims 30000x30000 pixels
+    data := encodePNG(t, 1, 1);
+    binary.BigEndian.PutUint32(data[16:], 30000);
+    binary.BigEndian.PutUint32(data[20:], 30000);
+    binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]));
+
+    if _, err := media.Thumbnail(data, 64); err != (media.ImageTooLargeError{}) {
+        t.Errorf("huge image failed with %v", err);
+    }
+}
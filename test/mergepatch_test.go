@@ -0,0 +1,56 @@
+package test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/cxcnxl/go-crud/internal/mergepatch"
+)
+
+// cases from the appendix of RFC 7386
+func TestMergePatchRFCExamples(t *testing.T) {
+    cases := []struct {
+        target string
+        patch  string
+        result string
+    }{
+        {`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+        {`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+        {`{"a":"b"}`, `{"a":null}`, `{}`},
+        {`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+        {`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+        {`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+        {`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+        {`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+        {`["a","b"]`, `["c","d"]`, `["c","d"]`},
+        {`{"a":"b"}`, `["c"]`, `["c"]`},
+        {`{"a":"foo"}`, `null`, `null`},
+        {`{"a":"foo"}`, `"bar"`, `"bar"`},
+        {`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+        {`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+        {`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+    };
+
+    for _, c := range cases {
+        got, err := mergepatch.Apply([]byte(c.target), []byte(c.patch));
+        if err != nil {
+            t.Errorf("%s + %s: %v", c.target, c.patch, err);
+            continue;
+        }
+
+        var gotValue, expectedValue any;
+        json.Unmarshal(got, &gotValue);
+        json.Unmarshal([]byte(c.result), &expectedValue);
+        if !reflect.DeepEqual(gotValue, expectedValue) {
+            t.Errorf("%s + %s = %s, expected %s", c.target, c.patch, got, c.result);
+        }
+    }
+}
+
+func TestMergePatchRejectsInvalidJson(t *testing.T) {
+    _, err := mergepatch.Apply([]byte(`{}`), []byte(`{"a":`));
+    if _, ok := err.(mergepatch.InvalidPatchError); !ok {
+        t.Errorf("got error %v, expected invalid patch", err);
+    }
+}
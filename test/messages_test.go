@@ -0,0 +1,208 @@
+package test
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/cxcnxl/go-crud/internal/app_service"
+	"github.com/cxcnxl/go-crud/internal/dto"
+	"github.com/cxcnxl/go-crud/internal/models"
+)
+
+func TestDirectConversationsAreShared(t *testing.T) {
+    service := newTestService(t);
+    ann := createTestUser(t, service, "ann");
+    bob := createTestUser(t, service, "bob");
+
+    first, err := service.CreateConversation(ann.ID, []uint{ bob.ID });
+    if err != nil {
+        t.Fatal(err);
+    }
+    again, err := service.CreateConversation(bob.ID, []uint{ ann.ID, bob.ID });
+    if err != nil || again.ID != first.ID {
+        t.Errorf("second conversation is %d, %v, expected %d", again.ID, err, first.ID);
+    }
+
+    // requests racing to start the first conversation of a pair
+    carl := createTestUser(t, service, "carl");
+    ids := make([]uint, 8);
+    var wg sync.WaitGroup;
+    for i := range ids {
+        wg.Add(1);
+        go func() {
+            defer wg.Done();
+            conversation, err := service.CreateConversation(carl.ID, []uint{ ann.ID });
+            if err != nil {
+                t.Error(err);
+            }
+            ids[i] = conversation.ID;
+        }();
+    }
+    wg.Wait();
+
+    var count int64;
+    service.db.Model(&models.Conversation{}).Where("direct_key IS NOT NULL").Count(&count);
+    if count != 2 || len(slices.Compact(slices.Clone(ids))) != 1 {
+        t.Errorf("racing requests got conversations %v, %d in total", ids, count);
+    }
+}
+
+func TestConversationParticipants(t *testing.T) {
+    service := newTestService(t);
+    ann := createTestUser(t, service, "ann");
+    bob := createTestUser(t, service, "bob");
+    carl := createTestUser(t, service, "carl");
+    dave := createTestUser(t, service, "dave");
+
+    invalid := [][]uint{ {}, { ann.ID }, { 0, bob.ID }, { bob.ID, 999 } };
+    for _, participantIds := range invalid {
+        _, err := service.CreateConversation(ann.ID, participantIds);
+        if !errors.Is(err, appservice.InvalidParticipantsError{}) {
+            t.Errorf("conversation with %v failed with %v", participantIds, err);
+        }
+    }
+
+    group, err := service.CreateConversation(ann.ID, []uint{ bob.ID, carl.ID });
+    if err != nil {
+        t.Fatal(err);
+    }
+    members := []uint{};
+    for _, participant := range group.Participants {
+        members = append(members, participant.User.ID);
+    }
+    slices.Sort(members);
+    if !slices.Equal(members, []uint{ ann.ID, bob.ID, carl.ID }) {
+        t.Errorf("participants are %v", members);
+    }
+
+    if _, err := service.GetConversation(dave.ID, group.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+        t.Errorf("outsider reading the conversation failed with %v", err);
+    }
+    if _, err := service.SendMessage(dave.ID, group.ID, "hi"); !errors.Is(err, gorm.ErrRecordNotFound) {
+        t.Errorf("outsider sending a message failed with %v", err);
+    }
+    if _, err := service.GetMessages(dave.ID, group.ID, 0, 10); !errors.Is(err, gorm.ErrRecordNotFound) {
+        t.Errorf("outsider reading messages failed with %v", err);
+    }
+}
+
+func unreadIn(t *testing.T, service testService, userId uint, conversationId uint) int {
+    t.Helper();
+
+    conversation, err := service.GetConversation(userId, conversationId);
+    if err != nil {
+        t.Fatal(err);
+    }
+
+    return conversation.Unread;
+}
+
+func receiptOf(conversation dto.ConversationViewDto, userId uint) uint {
+    for _, participant := range conversation.Participants {
+        if participant.UserID == userId {
+            return participant.LastReadMessageID;
+        }
+    }
+
+    return 0;
+}
+
+func TestReadReceiptsAndUnreadCounts(t *testing.T) {
+    service := newTestService(t);
+    ann := createTestUser(t, service, "ann");
+    bob := createTestUser(t, service, "bob");
+    carl := createTestUser(t, service, "carl");
+
+    conversation, err := service.CreateConversation(ann.ID, []uint{ bob.ID, carl.ID });
+    if err != nil {
+        t.Fatal(err);
+    }
+
+    first, _ := service.SendMessage(ann.ID, conversation.ID, "one");
+    second, _ := service.SendMessage(ann.ID, conversation.ID, "two");
+
+    if unread := unreadIn(t, service, ann.ID, conversation.ID); unread != 0 {
+        t.Errorf("sender has %d unread", unread);
+    }
+    if unread := unreadIn(t, service, bob.ID, conversation.ID); unread != 2 {
+        t.Errorf("bob has %d unread, expected 2", unread);
+    }
+
+    if err := service.MarkConversationRead(bob.ID, conversation.ID, first.ID); err != nil {
+        t.Fatal(err);
+    }
+    if unread := unreadIn(t, service, bob.ID, conversation.ID); unread != 1 {
+        t.Errorf("bob has %d unread after reading the first, expected 1", unread);
+    }
+
+    // 0 reads everything, receipts do not move back
+    service.MarkConversationRead(bob.ID, conversation.ID, 0);
+    service.MarkConversationRead(bob.ID, conversation.ID, first.ID);
+    view, _ := service.GetConversation(carl.ID, conversation.ID);
+    if receipt := receiptOf(view, bob.ID); receipt != second.ID {
+        t.Errorf("receipt of bob is %d, expected %d", receipt, second.ID);
+    }
+    if receipt := receiptOf(view, ann.ID); receipt != second.ID {
+        t.Errorf("receipt of the sender is %d, expected %d", receipt, second.ID);
+    }
+
+    other, _ := service.CreateConversation(bob.ID, []uint{ carl.ID });
+    service.SendMessage(bob.ID, other.ID, "three");
+    service.SendMessage(bob.ID, conversation.ID, "four");
+
+    if total, _ := service.GetUnreadMessagesCount(carl.ID); total != 4 {
+        t.Errorf("carl has %d unread in total, expected 4", total);
+    }
+    if total, _ := service.GetUnreadMessagesCount(ann.ID); total != 1 {
+        t.Errorf("ann has %d unread in total, expected 1", total);
+    }
+
+    err = service.MarkConversationRead(carl.ID, conversation.ID, 999);
+    if !errors.Is(err, gorm.ErrRecordNotFound) {
+        t.Errorf("reading up to a missing message failed with %v", err);
+    }
+}
+
+func TestConversationsPageThroughEqualTimes(t *testing.T) {
+    service := newTestService(t);
+    ann := createTestUser(t, service, "ann");
+
+    expected := []uint{};
+    for _, name := range []string{ "bob", "carl", "dave", "erin", "fred" } {
+        other := createTestUser(t, service, name);
+        conversation, err := service.CreateConversation(ann.ID, []uint{ other.ID });
+        if err != nil {
+            t.Fatal(err);
+        }
+        expected = append([]uint{ conversation.ID }, expected...);
+    }
+    at := time.UnixMilli(time.Now().UnixMilli());
+    service.db.Model(&models.Conversation{}).Where("1 = 1").Update("last_message_at", at);
+
+    for _, limit := range []int{ 1, 2, 3, 10 } {
+        ids := []uint{};
+        before := dto.Keyset{};
+        for range 10 {
+            page, err := service.GetConversations(ann.ID, before, limit);
+            if err != nil {
+                t.Fatal(err);
+            }
+            for _, conversation := range page.Items {
+                ids = append(ids, conversation.ID);
+            }
+            if page.NextCursor == nil {
+                break;
+            }
+            before = *page.NextCursor;
+        }
+
+        if !slices.Equal(ids, expected) {
+            t.Errorf("pages of %d read %v, expected %v", limit, ids, expected);
+        }
+    }
+}
@@ -0,0 +1,104 @@
+package test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cxcnxl/go-crud/internal/app_service"
+	"github.com/cxcnxl/go-crud/internal/auth_helpers"
+	"github.com/cxcnxl/go-crud/internal/middleware"
+)
+
+func TestJSONResponserDefaultsContentType(t *testing.T) {
+    handler := middleware.JSONResponserMiddleware(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte("{}"));
+    });
+
+    recorder := httptest.NewRecorder();
+    handler(recorder, httptest.NewRequest("GET", "/", nil));
+
+    if got := recorder.Header().Values("Content-Type"); len(got) != 1 || got[0] != "application/json" {
+        t.Errorf("Content-Type is %v, expected application/json", got);
+    }
+}
+
+func TestJSONResponserKeepsHandlerContentType(t *testing.T) {
+    handler := middleware.JSONResponserMiddleware(func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "application/atom+xml");
+        w.WriteHeader(http.StatusOK);
+    });
+
+    recorder := httptest.NewRecorder();
+    handler(recorder, httptest.NewRequest("GET", "/", nil));
+
+    if got := recorder.Header().Get("Content-Type"); got != "application/atom+xml" {
+        t.Errorf("Content-Type is %q, expected application/atom+xml", got);
+    }
+}
+
+func TestJWTAutherRejectsSuspendedUsers(t *testing.T) {
+    t.Setenv("JWT_SECRET", "test_secret");
+
+    check := func(_ context.Context, userId uint) error {
+        if userId == 2 {
+            return appservice.SuspendedUserError{};
+        }
+        return nil;
+    };
+
+    handler := middleware.JWTAutherMiddleware(check)(func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusOK);
+    });
+
+    for userId, expected := range map[uint]int{1: http.StatusOK, 2: http.StatusForbidden} {
+        token := auth_helpers.SignJWT(map[string]any{"id": userId});
+        request := httptest.NewRequest("GET", "/", nil);
+        request.Header.Set("Authorization", "Bearer " + token);
+
+        recorder := httptest.NewRecorder();
+        handler(recorder, request);
+
+        if recorder.Code != expected {
+            t.Errorf("user %d got status %d, expected %d", userId, recorder.Code, expected);
+        }
+        if expected == http.StatusForbidden && !strings.Contains(recorder.Body.String(), `"user_suspended"`) {
+            t.Errorf("suspended user got %s, expected the user_suspended problem", recorder.Body.String());
+        }
+    }
+}
+
+func TestOptionalJWTAutherLetsAnonymousThrough(t *testing.T) {
+    t.Setenv("JWT_SECRET", "test_secret");
+
+    var claims any;
+    check := func(context.Context, uint) error { return nil; };
+    handler := middleware.OptionalJWTAutherMiddleware(check)(func(w http.ResponseWriter, r *http.Request) {
+        claims = r.Context().Value("auth");
+        w.WriteHeader(http.StatusOK);
+    });
+
+    recorder := httptest.NewRecorder();
+    handler(recorder, httptest.NewRequest("GET", "/", nil));
+    if recorder.Code != http.StatusOK || claims != nil {
+        t.Errorf("anonymous request got status %d and claims %v", recorder.Code, claims);
+    }
+
+    request := httptest.NewRequest("GET", "/", nil);
+    request.Header.Set("Authorization", "Bearer " + auth_helpers.SignJWT(map[string]any{"id": 1}));
+    recorder = httptest.NewRecorder();
+    handler(recorder, request);
+    if recorder.Code != http.StatusOK || claims == nil {
+        t.Errorf("authenticated request got status %d and claims %v", recorder.Code, claims);
+    }
+
+    request = httptest.NewRequest("GET", "/", nil);
+    request.Header.Set("Authorization", "Bearer not.a.token");
+    recorder = httptest.NewRecorder();
+    handler(recorder, request);
+    if recorder.Code != http.StatusUnauthorized {
+        t.Errorf("request with bad token got status %d, expected 401", recorder.Code);
+    }
+}
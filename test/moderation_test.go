@@ -0,0 +1,247 @@
+package test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cxcnxl/go-crud/internal/app_service"
+	"github.com/cxcnxl/go-crud/internal/auth_helpers"
+	"github.com/cxcnxl/go-crud/internal/dto"
+	"github.com/cxcnxl/go-crud/internal/models"
+	"github.com/cxcnxl/go-crud/internal/routes"
+)
+
+// inserts user with the moderator role
+func createTestModerator(t *testing.T, service testService, username string) models.User {
+    t.Helper();
+
+    moderator := createTestUser(t, service, username);
+    if err := service.db.Model(&moderator).Update("role", models.RoleAdmin).Error; err != nil {
+        t.Fatal(err);
+    }
+
+    return moderator;
+}
+
+func TestResolveReportAppliesActions(t *testing.T) {
+    cases := []struct {
+        action string
+        status models.PostStatus
+        check  func(t *testing.T, service testService, post models.Post, author models.User)
+    }{
+        { appservice.ModerationDismiss, models.PostStatusPublished, nil },
+        { appservice.ModerationApprove, models.PostStatusHeld, func(t *testing.T, service testService, post models.Post, _ models.User) {
+            service.db.First(&post, post.ID);
+            if post.Status != models.PostStatusPublished || post.PublishedAt == nil {
+                t.Errorf("approved post is %s", post.Status);
+            }
+        } },
+        { appservice.ModerationHidePost, models.PostStatusPublished, func(t *testing.T, service testService, post models.Post, _ models.User) {
+            service.db.First(&post, post.ID);
+            if post.Status != models.PostStatusHidden {
+                t.Errorf("hidden post is %s", post.Status);
+            }
+        } },
+        { appservice.ModerationDeletePost, models.PostStatusPublished, func(t *testing.T, service testService, post models.Post, _ models.User) {
+            service.db.Unscoped().First(&post, post.ID);
+            if !post.DeletedAt.Valid || post.Status != models.PostStatusHidden {
+                t.Errorf("deleted post is %s, deleted at %v", post.Status, post.DeletedAt);
+            }
+        } },
+        { appservice.ModerationSuspendAuthor, models.PostStatusPublished, func(t *testing.T, service testService, _ models.Post, author models.User) {
+            service.db.First(&author, author.ID);
+            if author.SuspendedAt == nil {
+                t.Error("author is not suspended");
+            }
+        } },
+    };
+
+    for _, c := range cases {
+        t.Run(c.action, func(t *testing.T) {
+            service := newTestService(t);
+            moderator := createTestModerator(t, service, "moderator");
+            author := createTestUser(t, service, "author");
+            reporter := createTestUser(t, service, "reporter");
+            other := createTestUser(t, service, "other");
+
+            post := createTestPost(t, service, author.ID, time.Now());
+            service.db.Model(&post).Update("status", c.status);
+            reports := []models.Report{};
+            for _, reporterId := range []uint{ reporter.ID, other.ID } {
+                report := models.Report{ ReporterID: &reporterId, PostID: &post.ID, UserID: author.ID, Reason: "spam", Status: models.ReportOpen };
+                service.db.Create(&report);
+                reports = append(reports, report);
+            }
+
+            report, err := service.ResolveReport(moderator.ID, reports[0].ID, dto.ModerationActionDto{ Action: c.action, Note: "checked" });
+            if err != nil {
+                t.Fatal(err);
+            }
+            if report.Status != models.ReportResolved || report.Resolution != c.action {
+                t.Errorf("resolved report is %s with %s", report.Status, report.Resolution);
+            }
+            if c.check != nil {
+                c.check(t, service, post, author);
+            }
+
+            // reports on the same post are resolved with it
+            var open int64;
+            service.db.Model(&models.Report{}).Where("status = ?", models.ReportOpen).Count(&open);
+            if open != 0 {
+                t.Errorf("%d reports left open", open);
+            }
+
+            var actions []models.ModerationAction;
+            service.db.Find(&actions);
+            if len(actions) != 1 {
+                t.Fatalf("%d audit rows written", len(actions));
+            }
+            action := actions[0];
+            if action.Action != c.action || action.ModeratorID != moderator.ID || action.Note != "checked" ||
+                action.ReportID == nil || *action.ReportID != reports[0].ID ||
+                action.PostID == nil || *action.PostID != post.ID ||
+                action.UserID == nil || *action.UserID != author.ID {
+                t.Errorf("audit row is %+v", action);
+            }
+
+            _, err = service.ResolveReport(moderator.ID, reports[0].ID, dto.ModerationActionDto{ Action: appservice.ModerationDismiss });
+            if !errors.As(err, &appservice.ReportClosedError{}) {
+                t.Errorf("second resolve returned %v", err);
+            }
+        });
+    }
+}
+
+func TestResolveReportRefusesInvalidActions(t *testing.T) {
+    service := newTestService(t);
+    moderator := createTestModerator(t, service, "moderator");
+    author := createTestUser(t, service, "author");
+    reporter := createTestUser(t, service, "reporter");
+
+    report, err := service.ReportUser(reporter.ID, author.ID, "spam");
+    if err != nil {
+        t.Fatal(err);
+    }
+
+    _, err = service.ResolveReport(reporter.ID, report.ID, dto.ModerationActionDto{ Action: appservice.ModerationDismiss });
+    if !errors.As(err, &appservice.NotAdminError{}) {
+        t.Errorf("resolve by reporter returned %v", err);
+    }
+    for _, action := range []string{ appservice.ModerationHidePost, "ban" } {
+        _, err = service.ResolveReport(moderator.ID, report.ID, dto.ModerationActionDto{ Action: action });
+        if !errors.As(err, &appservice.InvalidModerationActionError{}) {
+            t.Errorf("%s returned %v", action, err);
+        }
+    }
+
+    var count int64;
+    service.db.Model(&models.ModerationAction{}).Count(&count);
+    if count != 0 {
+        t.Errorf("%d audit rows written for refused actions", count);
+    }
+}
+
+func TestKeywordFilterHoldsNewPosts(t *testing.T) {
+    service := newTestService(t);
+    moderator := createTestModerator(t, service, "moderator");
+    ann := createTestUser(t, service, "ann");
+    bob := createTestUser(t, service, "bob");
+    followTestUser(t, service, ann.ID, bob.ID);
+
+    filter, err := appservice.NewModerationFilter(moderator.ID, dto.ModerationFilterDto{ Pattern: "Spam" });
+    if err != nil {
+        t.Fatal(err);
+    }
+    service.db.Create(&filter);
+
+    clean, err := service.CreatePost(bob.ID, dto.CreatePostDto{ Body: "spammy looking but fine" });
+    if err != nil {
+        t.Fatal(err);
+    }
+    held, err := service.CreatePost(bob.ID, dto.CreatePostDto{ Body: "buy SPAM now" });
+    if err != nil {
+        t.Fatal(err);
+    }
+
+    if clean.Status != models.PostStatusPublished {
+        t.Errorf("post without the keyword is %s", clean.Status);
+    }
+    if held.Status != models.PostStatusHeld || held.PublishedAt != nil {
+        t.Errorf("post with the keyword is %s, published at %v", held.Status, held.PublishedAt);
+    }
+
+    var report models.Report;
+    if err := service.db.Where("post_id = ?", held.ID).First(&report).Error; err != nil {
+        t.Fatalf("held post was not queued for review: %v", err);
+    }
+    if report.Status != models.ReportOpen || report.ReporterID != nil {
+        t.Errorf("review report is %+v", report);
+    }
+
+    for _, cold := range []bool{ false, true } {
+        ids := readTimeline(t, service, ann.ID, 10, cold);
+        if slices.Contains(ids, held.ID) || !slices.Contains(ids, clean.ID) {
+            t.Errorf("timeline is %v", ids);
+        }
+    }
+}
+
+func TestSuspendedUserIsRefused(t *testing.T) {
+    t.Setenv("JWT_SECRET", "test_secret");
+    service := newTestService(t);
+    router := routes.NewRouter(service.AppService, nil);
+    moderator := createTestModerator(t, service, "moderator");
+    ann := createTestUser(t, service, "ann");
+    service.db.Model(&ann).Update("password_hashed", auth_helpers.HashPassword("password1", auth_helpers.GenerateRandomSalt()));
+
+    // signed before the suspension
+    token := auth_helpers.SignJWT(map[string]any{ "id": ann.ID });
+    getMe := func() *httptest.ResponseRecorder {
+        request := httptest.NewRequest("GET", "/me", nil);
+        request.Header.Set("Authorization", "Bearer " + token);
+        recorder := httptest.NewRecorder();
+        router.Mux.ServeHTTP(recorder, request);
+        return recorder;
+    };
+    login := dto.PostLoginDto{ Username: "ann", Password: "password1" };
+
+    if recorder := getMe(); recorder.Code != http.StatusOK {
+        t.Fatalf("active user answered %d", recorder.Code);
+    }
+
+    if err := service.SuspendUser(moderator.ID, ann.ID, "spam"); err != nil {
+        t.Fatal(err);
+    }
+    if _, err := service.LoginUser(login); !errors.As(err, &appservice.SuspendedUserError{}) {
+        t.Errorf("login of suspended user returned %v", err);
+    }
+    recorder := getMe();
+    if recorder.Code != http.StatusForbidden || !strings.Contains(recorder.Body.String(), `"user_suspended"`) {
+        t.Errorf("token of suspended user answered %d %s", recorder.Code, recorder.Body.String());
+    }
+
+    var action models.ModerationAction;
+    service.db.First(&action);
+    if action.Action != "suspend_user" || action.UserID == nil || *action.UserID != ann.ID || action.Note != "spam" {
+        t.Errorf("audit row is %+v", action);
+    }
+
+    if err := service.UnsuspendUser(moderator.ID, ann.ID, ""); err != nil {
+        t.Fatal(err);
+    }
+    if _, err := service.LoginUser(login); err != nil {
+        t.Errorf("login after suspension was lifted returned %v", err);
+    }
+    if recorder := getMe(); recorder.Code != http.StatusOK {
+        t.Errorf("token after suspension was lifted answered %d", recorder.Code);
+    }
+
+    if err := service.SuspendUser(moderator.ID, moderator.ID, ""); !errors.As(err, &appservice.InvalidModerationActionError{}) {
+        t.Errorf("moderator suspending themselves returned %v", err);
+    }
+}
@@ -0,0 +1,113 @@
+package test
+
+import (
+	"testing"
+)
+
+// returns the unread count of the user after checking that it matches the
+// unread notifications listed, with the counter cached and not
+func unreadNotifications(t *testing.T, service testService, userId uint) int {
+    t.Helper();
+
+    page, err := service.GetNotifications(userId, true, 0, 100);
+    if err != nil {
+        t.Fatal(err);
+    }
+
+    counts := []int{};
+    for range 2 {
+        count, err := service.GetUnreadNotificationsCount(userId);
+        if err != nil {
+            t.Fatal(err);
+        }
+        counts = append(counts, count);
+    }
+    service.redis.FlushAll();
+    count, err := service.GetUnreadNotificationsCount(userId);
+    if err != nil {
+        t.Fatal(err);
+    }
+    counts = append(counts, count);
+
+    for _, count := range counts {
+        if count != len(page.Items) {
+            t.Errorf("unread counts are %v, %d notifications listed", counts, len(page.Items));
+            break;
+        }
+    }
+
+    return len(page.Items);
+}
+
+func TestUnreadCountMatchesNotifications(t *testing.T) {
+    service := newTestService(t);
+    ann := createTestUser(t, service, "ann");
+    bob := createTestUser(t, service, "bob");
+    carol := createTestUser(t, service, "carol");
+    dave := createTestUser(t, service, "dave");
+
+    follow := func(followerId uint) {
+        t.Helper();
+        if err := service.FollowUser(followerId, ann.ID); err != nil {
+            t.Fatal(err);
+        }
+    };
+    steps := []struct {
+        name  string
+        run   func()
+        count int
+    }{
+        { "followed by three", func() { follow(bob.ID); follow(carol.ID); follow(dave.ID); }, 3 },
+        { "muted carol", func() { service.MuteUser(ann.ID, carol.ID); }, 2 },
+        { "followed again by muted carol", func() { service.UnfollowUser(carol.ID, ann.ID); follow(carol.ID); }, 2 },
+        { "blocked dave", func() { service.BlockUser(ann.ID, dave.ID); }, 1 },
+        { "unmuted carol", func() { service.UnmuteUser(ann.ID, carol.ID); }, 3 },
+    };
+
+    // the counter is cached between the steps
+    unreadNotifications(t, service, ann.ID);
+    for _, step := range steps {
+        step.run();
+        if count := unreadNotifications(t, service, ann.ID); count != step.count {
+            t.Errorf("%s: %d unread notifications, expected %d", step.name, count, step.count);
+        }
+    }
+}
+
+func TestMarkAllNotificationsRead(t *testing.T) {
+    service := newTestService(t);
+    ann := createTestUser(t, service, "ann");
+    bob := createTestUser(t, service, "bob");
+    carol := createTestUser(t, service, "carol");
+
+    for _, pair := range [][2]uint{ { bob.ID, ann.ID }, { carol.ID, ann.ID }, { ann.ID, bob.ID } } {
+        if err := service.FollowUser(pair[0], pair[1]); err != nil {
+            t.Fatal(err);
+        }
+    }
+    if count := unreadNotifications(t, service, ann.ID); count != 2 {
+        t.Fatalf("%d unread notifications", count);
+    }
+
+    if err := service.MarkAllNotificationsRead(ann.ID); err != nil {
+        t.Fatal(err);
+    }
+    if count := unreadNotifications(t, service, ann.ID); count != 0 {
+        t.Errorf("%d unread notifications after marking all read", count);
+    }
+
+    page, err := service.GetNotifications(ann.ID, false, 0, 100);
+    if err != nil || len(page.Items) != 2 {
+        t.Fatalf("notifications are %v %v", page.Items, err);
+    }
+    for _, notification := range page.Items {
+        if notification.ReadAt == nil {
+            t.Errorf("notification %d is unread", notification.ID);
+        }
+    }
+
+    // notifications of others stay unread
+    if count := unreadNotifications(t, service, bob.ID); count != 1 {
+        t.Errorf("%d unread notifications of bob", count);
+    }
+}
@@ -0,0 +1,80 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cxcnxl/go-crud/internal/app_service"
+	"github.com/cxcnxl/go-crud/internal/openapi"
+	"github.com/cxcnxl/go-crud/internal/routes"
+)
+
+func newTestRouter(t *testing.T) *routes.MethodHandler {
+    return routes.NewRouter(&appservice.AppService{}, nil);
+}
+
+func TestOpenAPIDocumentsEveryRoute(t *testing.T) {
+    router := newTestRouter(t);
+    spec := router.OpenAPI();
+
+    for _, route := range router.Routes() {
+        operation := spec.Paths[openapi.PathOf(route.Path)][strings.ToLower(route.Method)];
+        if operation == nil {
+            t.Errorf("%s %s is missing from the spec, pass an openapi.Doc when registering it", route.Method, route.Path);
+        }
+    }
+}
+
+func TestOpenAPIReferencesResolve(t *testing.T) {
+    router := newTestRouter(t);
+
+    recorder := httptest.NewRecorder();
+    router.Mux.ServeHTTP(recorder, httptest.NewRequest("GET", "/openapi.json", nil));
+
+    var spec map[string]any;
+    if err := json.Unmarshal(recorder.Body.Bytes(), &spec); err != nil {
+        t.Fatalf("spec is not JSON: %v", err);
+    }
+    if spec["openapi"] != openapi.Version {
+        t.Errorf("openapi is %v, expected %s", spec["openapi"], openapi.Version);
+    }
+
+    schemas := spec["components"].(map[string]any)["schemas"].(map[string]any);
+    var walk func(value any);
+    walk = func(value any) {
+        switch value := value.(type) {
+        case map[string]any:
+            if ref, ok := value["$ref"].(string); ok {
+                name := strings.TrimPrefix(ref, "#/components/schemas/");
+                if _, ok := schemas[name]; !ok {
+                    t.Errorf("reference %s does not resolve", ref);
+                }
+            }
+            for _, child := range value {
+                walk(child);
+            }
+        case []any:
+            for _, child := range value {
+                walk(child);
+            }
+        }
+    };
+    walk(spec);
+}
+
+func TestOpenAPISchemaFollowsJSONEncoding(t *testing.T) {
+    spec := newTestRouter(t).OpenAPI();
+
+    user := spec.Components.Schemas["UserViewDto"]["properties"].(openapi.Schema);
+    if _, ok := user["username"]; !ok {
+        t.Error("fields of embedded models.User are not promoted");
+    }
+    if _, ok := user["email"]; !ok {
+        t.Error("email of UserViewDto is missing");
+    }
+    if _, ok := user["PasswordHashed"]; ok {
+        t.Error(`fields tagged json:"-" are listed`);
+    }
+}
@@ -0,0 +1,112 @@
+package test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cxcnxl/go-crud/internal/app_service"
+	"github.com/cxcnxl/go-crud/internal/problems"
+)
+
+func writeProblem(err error, header http.Header) (*httptest.ResponseRecorder, map[string]any) {
+    request := httptest.NewRequest("GET", "/posts/1", nil);
+    for name, values := range header {
+        request.Header[name] = values;
+    }
+
+    recorder := httptest.NewRecorder();
+    problems.Write(recorder, request, err);
+
+    var body map[string]any;
+    json.Unmarshal(recorder.Body.Bytes(), &body);
+
+    return recorder, body;
+}
+
+func TestProblemsNegotiatePerRequest(t *testing.T) {
+    err := fmt.Errorf("creating user: %w", appservice.DuplicateUserEmailError{});
+
+    recorder, body := writeProblem(err, http.Header{ "Accept": { "application/json" } });
+    if recorder.Code != http.StatusConflict || body["error"] != "duplicate_user_email" {
+        t.Errorf("envelope is %d %v", recorder.Code, body);
+    }
+    if got := recorder.Header().Get("Content-Type"); got != "application/json" {
+        t.Errorf("envelope Content-Type is %q", got);
+    }
+
+    recorder, body = writeProblem(err, http.Header{
+        "Accept": { "application/json;q=0.5, application/problem+json" },
+    });
+    if got := recorder.Header().Get("Content-Type"); got != problems.ContentType {
+        t.Fatalf("problem Content-Type is %q", got);
+    }
+    expected := map[string]any{
+        "type": problems.TypePrefix + "duplicate_user_email",
+        "title": "Email is already registered",
+        "status": float64(http.StatusConflict),
+        "code": "duplicate_user_email",
+        "instance": "/posts/1",
+    };
+    for name, value := range expected {
+        if body[name] != value {
+            t.Errorf("%s is %v, expected %v", name, body[name], value);
+        }
+    }
+}
+
+func TestProblemsLocalizeTitles(t *testing.T) {
+    recorder, body := writeProblem(appservice.NotAdminError{}, http.Header{
+        "Accept": { problems.ContentType },
+        "Accept-Language": { "fr, de-AT;q=0.8" },
+    });
+
+    if body["title"] != "Nur für Administratoren" {
+        t.Errorf("title is %v", body["title"]);
+    }
+    if got := recorder.Header().Get("Content-Language"); got != "de" {
+        t.Errorf("Content-Language is %q", got);
+    }
+}
+
+func TestProblemsCarryExtensions(t *testing.T) {
+    _, body := writeProblem(appservice.InvalidProfileError{ Field: "bio" }, http.Header{
+        "Accept": { problems.ContentType },
+    });
+
+    if body["code"] != "invalid_profile" || body["field"] != "bio" || body["detail"] != "invalid_bio" {
+        t.Errorf("problem is %v", body);
+    }
+}
+
+func TestProblemsHideUnknownErrors(t *testing.T) {
+    recorder, body := writeProblem(errors.New("dial tcp: connection refused"), http.Header{
+        "Accept": { problems.ContentType },
+    });
+
+    if recorder.Code != http.StatusInternalServerError || body["type"] != "about:blank" {
+        t.Errorf("problem is %d %v", recorder.Code, body);
+    }
+    if strings.Contains(recorder.Body.String(), "refused") {
+        t.Errorf("problem leaks the error: %s", recorder.Body.String());
+    }
+}
+
+func TestProblemsForUnauthorizedRequests(t *testing.T) {
+    router := newTestRouter(t);
+
+    request := httptest.NewRequest("GET", "/me", nil);
+    request.Header.Set("Accept", problems.ContentType);
+    recorder := httptest.NewRecorder();
+    router.Mux.ServeHTTP(recorder, request);
+
+    var body map[string]any;
+    json.Unmarshal(recorder.Body.Bytes(), &body);
+    if recorder.Code != http.StatusUnauthorized || body["code"] != "unauthorized" {
+        t.Errorf("response is %d %v", recorder.Code, body);
+    }
+}
@@ -0,0 +1,116 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cxcnxl/go-crud/internal/auth_helpers"
+	"github.com/cxcnxl/go-crud/internal/dto"
+	"github.com/cxcnxl/go-crud/internal/mergepatch"
+	"github.com/cxcnxl/go-crud/internal/models"
+	"github.com/cxcnxl/go-crud/internal/routes"
+)
+
+func TestProfileShowsEmailWhenAllowed(t *testing.T) {
+    service := newTestService(t);
+    ann := createTestUser(t, service, "ann");
+    bob := createTestUser(t, service, "bob");
+
+    for _, showEmail := range []bool{ false, true } {
+        service.db.Model(&ann).Update("show_email", showEmail);
+
+        cases := []struct {
+            viewerId uint
+            visible  bool
+        }{
+            { 0, showEmail },
+            { bob.ID, showEmail },
+            { ann.ID, true },
+        };
+        for _, c := range cases {
+            profile, err := service.GetProfile("ann", c.viewerId);
+            if err != nil {
+                t.Fatal(err);
+            }
+            if visible := profile.Email == "ann@example.com"; visible != c.visible || (!visible && profile.Email != "") {
+                t.Errorf("with show_email %v viewer %d sees email %q", showEmail, c.viewerId, profile.Email);
+            }
+        }
+    }
+
+    me, err := service.GetMe(ann.ID);
+    if err != nil || me.Email != "ann@example.com" {
+        t.Errorf("owner sees %q %v", me.Email, err);
+    }
+}
+
+func TestMergePatchUpdatesGivenProfileFields(t *testing.T) {
+    t.Setenv("JWT_SECRET", "test_secret");
+    service := newTestService(t);
+    router := routes.NewRouter(service.AppService, nil);
+    ann := createTestUser(t, service, "ann");
+    service.db.Model(&ann).Updates(models.User{
+        DisplayName: "Ann",
+        Bio: "hello",
+        Website: "https://ann.example.com",
+    });
+    token := auth_helpers.SignJWT(map[string]any{ "id": ann.ID });
+
+    patchMe := func(patch string) (int, dto.ProfileDto) {
+        request := httptest.NewRequest("PATCH", "/me", strings.NewReader(patch));
+        request.Header.Set("Content-Type", mergepatch.ContentType);
+        request.Header.Set("Authorization", "Bearer " + token);
+        recorder := httptest.NewRecorder();
+        router.Mux.ServeHTTP(recorder, request);
+
+        var user models.User;
+        service.db.First(&user, ann.ID);
+        return recorder.Code, dto.ProfileDto{
+            DisplayName: user.DisplayName,
+            Bio: user.Bio,
+            AvatarUrl: user.AvatarUrl,
+            Website: user.Website,
+            Locale: user.Locale,
+            ShowEmail: user.ShowEmail,
+        };
+    };
+
+    cases := []struct {
+        patch   string
+        status  int
+        profile dto.ProfileDto
+    }{
+        {
+            `{"bio":"  new bio  ","locale":"en-us"}`,
+            http.StatusOK,
+            dto.ProfileDto{ DisplayName: "Ann", Bio: "new bio", Website: "https://ann.example.com", Locale: "en-US" },
+        },
+        {
+            `{"display_name":null,"website":null,"show_email":true}`,
+            http.StatusOK,
+            dto.ProfileDto{ Bio: "new bio", Locale: "en-US", ShowEmail: true },
+        },
+        // refused patches change nothing
+        {
+            `{"bio":"kept?","website":"ftp://ann.example.com"}`,
+            http.StatusBadRequest,
+            dto.ProfileDto{ Bio: "new bio", Locale: "en-US", ShowEmail: true },
+        },
+        {
+            `{}`,
+            http.StatusOK,
+            dto.ProfileDto{ Bio: "new bio", Locale: "en-US", ShowEmail: true },
+        },
+    };
+
+    for _, c := range cases {
+        status, profile := patchMe(c.patch);
+        if status != c.status || profile != c.profile {
+            got, _ := json.Marshal(profile);
+            t.Errorf("%s answered %d with %s", c.patch, status, got);
+        }
+    }
+}
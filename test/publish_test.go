@@ -0,0 +1,151 @@
+package test
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/cxcnxl/go-crud/internal/app_service"
+	"github.com/cxcnxl/go-crud/internal/dto"
+	"github.com/cxcnxl/go-crud/internal/models"
+)
+
+// creates draft of the author through the service
+func createTestDraft(t *testing.T, service testService, authorId uint, body string) models.Post {
+    t.Helper();
+
+    post, err := service.CreatePost(authorId, dto.CreatePostDto{ Body: body, Status: string(models.PostStatusDraft) });
+    if err != nil {
+        t.Fatal(err);
+    }
+
+    return post;
+}
+
+func TestPublishPostSchedulesAndPublishes(t *testing.T) {
+    service := newTestService(t);
+    ann := createTestUser(t, service, "ann");
+    bob := createTestUser(t, service, "bob");
+    followTestUser(t, service, bob.ID, ann.ID);
+    draft := createTestDraft(t, service, ann.ID, "soon");
+
+    publishAt := time.Now().Add(time.Hour);
+    if _, err := service.PublishPost(draft.ID, bob.ID, &publishAt); !errors.As(err, &appservice.NotPostAuthorError{}) {
+        t.Errorf("publish by another user returned %v", err);
+    }
+    scheduled, err := service.PublishPost(draft.ID, ann.ID, &publishAt);
+    if err != nil || scheduled.Status != models.PostStatusScheduled || scheduled.PublishAt == nil || scheduled.PublishedAt != nil {
+        t.Fatalf("scheduling returned %v %v", scheduled, err);
+    }
+
+    // due posts only
+    if err := service.PublishDuePosts(); err != nil {
+        t.Fatal(err);
+    }
+    if post, _ := service.GetPostById(draft.ID); post.Status != models.PostStatusScheduled {
+        t.Errorf("post scheduled for later is %s", post.Status);
+    }
+    if ids := readTimeline(t, service, bob.ID, 10, true); len(ids) != 0 {
+        t.Errorf("timeline shows scheduled posts %v", ids);
+    }
+
+    service.db.Model(&draft).Update("publish_at", time.Now().Add(-time.Second));
+    if err := service.PublishDuePosts(); err != nil {
+        t.Fatal(err);
+    }
+    post, _ := service.GetPostById(draft.ID);
+    if post.Status != models.PostStatusPublished || post.PublishedAt == nil {
+        t.Errorf("due post is %s", post.Status);
+    }
+    if ids := readTimeline(t, service, bob.ID, 10, false); !slices.Equal(ids, []uint{ draft.ID }) {
+        t.Errorf("timeline is %v", ids);
+    }
+
+    if _, err := service.PublishPost(draft.ID, ann.ID, nil); !errors.As(err, &appservice.PostAlreadyPublishedError{}) {
+        t.Errorf("publishing again returned %v", err);
+    }
+}
+
+func TestPublishPostHoldsFilteredPost(t *testing.T) {
+    service := newTestService(t);
+    moderator := createTestModerator(t, service, "moderator");
+    ann := createTestUser(t, service, "ann");
+    bob := createTestUser(t, service, "bob");
+    followTestUser(t, service, bob.ID, ann.ID);
+
+    // drafts are checked once they get published
+    draft := createTestDraft(t, service, ann.ID, "buy spam now");
+    filter, _ := appservice.NewModerationFilter(moderator.ID, dto.ModerationFilterDto{ Pattern: "spam" });
+    service.db.Create(&filter);
+    if draft.Status != models.PostStatusDraft {
+        t.Fatalf("draft is %s", draft.Status);
+    }
+
+    later := time.Now().Add(time.Hour);
+    for _, publishAt := range []*time.Time{ nil, &later } {
+        service.db.Model(&draft).Update("status", models.PostStatusDraft);
+
+        held, err := service.PublishPost(draft.ID, ann.ID, publishAt);
+        if err != nil || held.Status != models.PostStatusHeld || held.PublishedAt != nil {
+            t.Errorf("publish at %v returned %s %v", publishAt, held.Status, err);
+        }
+        if post, _ := service.GetPostById(draft.ID); post.Status != models.PostStatusHeld {
+            t.Errorf("held post is stored as %s", post.Status);
+        }
+    }
+
+    var reports int64;
+    service.db.Model(&models.Report{}).Where("post_id = ?", draft.ID).Count(&reports);
+    if reports != 2 {
+        t.Errorf("%d review reports", reports);
+    }
+    if _, err := service.PublishPost(draft.ID, ann.ID, nil); !errors.As(err, &appservice.PostUnderReviewError{}) {
+        t.Errorf("publishing a held post returned %v", err);
+    }
+    if ids := readTimeline(t, service, bob.ID, 10, true); len(ids) != 0 {
+        t.Errorf("timeline shows held posts %v", ids);
+    }
+}
+
+func TestUserFeedListsPublicPostsOfTheUser(t *testing.T) {
+    service := newTestService(t);
+    ann := createTestUser(t, service, "ann");
+    bob := createTestUser(t, service, "bob");
+
+    start := time.Now().Add(-time.Hour);
+    expected := []uint{};
+    for i := range 52 {
+        post := createTestPost(t, service, ann.ID, start.Add(time.Duration(i) * time.Second));
+        expected = append([]uint{ post.ID }, expected...);
+    }
+    expected = expected[:50];
+
+    hidden := []models.Post{
+        createTestPost(t, service, ann.ID, time.Now()),
+        createTestPost(t, service, ann.ID, time.Now()),
+        createTestPost(t, service, ann.ID, time.Now()),
+        createTestPost(t, service, ann.ID, time.Now()),
+    };
+    service.db.Model(&hidden[0]).Update("visibility", models.VisibilityFollowers);
+    service.db.Model(&hidden[1]).Update("status", models.PostStatusHeld);
+    service.db.Model(&hidden[2]).Update("status", models.PostStatusDraft);
+    service.db.Delete(&hidden[3]);
+    createTestPost(t, service, bob.ID, time.Now());
+
+    user, posts, err := service.GetUserFeed("ann");
+    if err != nil || user.ID != ann.ID {
+        t.Fatalf("feed returned %v %v", user, err);
+    }
+    ids := []uint{};
+    for _, post := range posts {
+        ids = append(ids, post.ID);
+    }
+    if !slices.Equal(ids, expected) {
+        t.Errorf("feed is %v, expected %v", ids, expected);
+    }
+
+    if _, _, err := service.GetUserFeed("nobody"); err == nil {
+        t.Error("feed of an unknown user returned no error");
+    }
+}
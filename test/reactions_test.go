@@ -0,0 +1,86 @@
+package test
+
+import (
+	"fmt"
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/cxcnxl/go-crud/internal/models"
+)
+
+func expectReactionCounts(t *testing.T, service testService, postId uint, expected map[string]int) {
+    t.Helper();
+
+    counts, err := service.GetReactionCounts(postId);
+    if err != nil {
+        t.Fatal(err);
+    }
+    if !maps.Equal(counts, expected) {
+        t.Errorf("counts are %v, expected %v", counts, expected);
+    }
+}
+
+func TestReactionCountsFollowReactions(t *testing.T) {
+    service := newTestService(t);
+    ann := createTestUser(t, service, "ann");
+    bob := createTestUser(t, service, "bob");
+    post := createTestPost(t, service, ann.ID, time.Now());
+
+    // the first reaction fills the cache from the database, later ones
+    // count in redis
+    service.AddReaction(post.ID, ann.ID, "like");
+    service.AddReaction(post.ID, bob.ID, "like");
+    service.AddReaction(post.ID, bob.ID, "like");
+    service.AddReaction(post.ID, bob.ID, "wow");
+    service.RemoveReaction(post.ID, ann.ID, "like");
+    expectReactionCounts(t, service, post.ID, map[string]int{ "like": 1, "wow": 1 });
+
+    if err := service.ReconcileReactionCounts(); err != nil {
+        t.Fatal(err);
+    }
+    var rows []models.ReactionCount;
+    service.db.Where("post_id = ?", post.ID).Order("emoji").Find(&rows);
+    if len(rows) != 2 || rows[0].Emoji != "like" || rows[0].Count != 1 || rows[1].Count != 1 {
+        t.Errorf("reconciled counts are %+v", rows);
+    }
+}
+
+func TestReactionCountsWithoutMarkerAreMissing(t *testing.T) {
+    service := newTestService(t);
+    ann := createTestUser(t, service, "ann");
+    bob := createTestUser(t, service, "bob");
+    post := createTestPost(t, service, ann.ID, time.Now());
+    service.AddReaction(post.ID, bob.ID, "love");
+
+    // what an increment racing the expiry of the counters leaves behind
+    key := fmt.Sprintf("posts:reactions:%d", post.ID);
+    service.redis.Del(key);
+    service.redis.HSet(key, "love", "7");
+
+    expectReactionCounts(t, service, post.ID, map[string]int{ "love": 1 });
+    if !service.redis.Exists(key) || service.redis.HGet(key, "_") == "" {
+        t.Errorf("counts are not cached again");
+    }
+}
+
+func TestReactionIncrementSkipsMissingCounts(t *testing.T) {
+    service := newTestService(t);
+    ann := createTestUser(t, service, "ann");
+    bob := createTestUser(t, service, "bob");
+    post := createTestPost(t, service, ann.ID, time.Now());
+    service.AddReaction(post.ID, bob.ID, "sad");
+
+    key := fmt.Sprintf("posts:reactions:%d", post.ID);
+    service.redis.Del(key);
+    service.redis.Del("posts:reactions:dirty");
+
+    // counters are rebuilt from the database, which has both reactions
+    service.AddReaction(post.ID, ann.ID, "sad");
+    if count := service.redis.HGet(key, "sad"); count != "2" {
+        t.Errorf("rebuilt counter is %q", count);
+    }
+    if ok, _ := service.redis.SIsMember("posts:reactions:dirty", fmt.Sprint(post.ID)); !ok {
+        t.Errorf("post is not marked for reconciliation");
+    }
+}
@@ -0,0 +1,48 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/cxcnxl/go-crud/internal/realtime"
+	"github.com/cxcnxl/go-crud/internal/redis"
+)
+
+func TestHubDropsSlowSubscriber(t *testing.T) {
+    hub := realtime.NewHub();
+    subscriber := hub.Subscribe(1);
+    other := hub.Subscribe(2);
+    defer hub.Unsubscribe(subscriber);
+    defer hub.Unsubscribe(other);
+
+    for i := 0; i <= realtime.SubscriberBuffer; i++ {
+        hub.Dispatch(redis.StreamEvent{ UserID: 1, Type: "post" });
+    }
+
+    select {
+    case <-subscriber.Dropped:
+    default:
+        t.Error("Subscriber with full buffer was not dropped");
+    }
+
+    if len(subscriber.Events) != realtime.SubscriberBuffer {
+        t.Errorf("Buffered %d events, expected %d", len(subscriber.Events), realtime.SubscriberBuffer);
+    }
+    if len(other.Events) != 0 {
+        t.Error("Event delivered to another user");
+    }
+}
+
+func TestStreamIdOrder(t *testing.T) {
+    if !realtime.IdAfter("1700000000000-1", "1700000000000-0") {
+        t.Error("Expected later sequence to come after");
+    }
+    if !realtime.IdAfter("1700000000001-0", "1700000000000-5") {
+        t.Error("Expected later timestamp to come after");
+    }
+    if realtime.IdAfter("1700000000000-0", "1700000000000-0") {
+        t.Error("Equal ids are not after each other");
+    }
+    if !realtime.IdAfter("1-0", "") {
+        t.Error("Every id comes after empty one");
+    }
+}
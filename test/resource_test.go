@@ -0,0 +1,171 @@
+package test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cxcnxl/go-crud/internal/app_service"
+	"github.com/cxcnxl/go-crud/internal/auth_helpers"
+	"github.com/cxcnxl/go-crud/internal/middleware"
+	"github.com/cxcnxl/go-crud/internal/routes"
+)
+
+type noteModel struct {
+    ID      uint
+    OwnerID uint
+    Text    string
+}
+
+type noteDto struct {
+    Text string `json:"text"`
+}
+
+func (self noteDto) Validate() error {
+    if self.Text == "" {
+        return errors.New("invalid_text");
+    }
+
+    return nil;
+}
+
+// every case is refused before the resource touches the database, so the
+// service needs none
+func newNoteRouter(resource routes.Resource[noteModel, noteDto, noteDto]) *routes.MethodHandler {
+    methodHandler := routes.NewMethodHandler(http.NewServeMux());
+    routes.RegisterResource(methodHandler, &appservice.AppService{}, resource);
+
+    return methodHandler;
+}
+
+func noteResource() routes.Resource[noteModel, noteDto, noteDto] {
+    return routes.Resource[noteModel, noteDto, noteDto]{
+        Name: "note",
+        Path: "/notes",
+        Operations: map[routes.ResourceOperation]middleware.MiddlewareSet{
+            routes.ResourceList: middleware.UtilMiddleware,
+            routes.ResourceCreate: middleware.UtilMiddleware,
+        },
+        Create: func(req routes.ResourceRequest, data noteDto) (noteModel, error) {
+            return noteModel{ OwnerID: req.UserID, Text: data.Text }, nil;
+        },
+    };
+}
+
+func TestResourceRoutesOnlyListedOperations(t *testing.T) {
+    router := newNoteRouter(noteResource());
+
+    recorder := httptest.NewRecorder();
+    router.Mux.ServeHTTP(recorder, httptest.NewRequest("PATCH", "/notes", nil));
+    if recorder.Code != http.StatusMethodNotAllowed {
+        t.Errorf("status is %d, expected %d", recorder.Code, http.StatusMethodNotAllowed);
+    }
+
+    recorder = httptest.NewRecorder();
+    router.Mux.ServeHTTP(recorder, httptest.NewRequest("DELETE", "/notes/1", nil));
+    if recorder.Code != http.StatusNotFound {
+        t.Errorf("status is %d, expected %d", recorder.Code, http.StatusNotFound);
+    }
+}
+
+func TestResourceValidatesBody(t *testing.T) {
+    router := newNoteRouter(noteResource());
+
+    recorder := httptest.NewRecorder();
+    request := httptest.NewRequest("POST", "/notes", strings.NewReader(`{"text":""}`));
+    router.Mux.ServeHTTP(recorder, request);
+
+    if recorder.Code != http.StatusBadRequest {
+        t.Errorf("status is %d, expected %d", recorder.Code, http.StatusBadRequest);
+    }
+    if !strings.Contains(recorder.Body.String(), "invalid_text") {
+        t.Errorf("body %q does not name the validation error", recorder.Body.String());
+    }
+}
+
+func TestResourcePolicyRunsBeforeReads(t *testing.T) {
+    resource := noteResource();
+    resource.Policy = func(
+        _ routes.ResourceRequest,
+        _ routes.ResourceOperation,
+        _ *noteModel,
+    ) error {
+        return routes.ResourceForbiddenError{};
+    };
+    router := newNoteRouter(resource);
+
+    recorder := httptest.NewRecorder();
+    router.Mux.ServeHTTP(recorder, httptest.NewRequest("GET", "/notes", nil));
+
+    if recorder.Code != http.StatusForbidden {
+        t.Errorf("status is %d, expected %d", recorder.Code, http.StatusForbidden);
+    }
+}
+
+func TestOwnedResourceNeedsAuthentication(t *testing.T) {
+    resource := noteResource();
+    resource.OwnerColumn = "owner_id";
+    router := newNoteRouter(resource);
+
+    recorder := httptest.NewRecorder();
+    router.Mux.ServeHTTP(recorder, httptest.NewRequest("GET", "/notes", nil));
+
+    if recorder.Code != http.StatusUnauthorized {
+        t.Errorf("status is %d, expected %d", recorder.Code, http.StatusUnauthorized);
+    }
+}
+
+// rows of owned resources belong to the caller whatever the hooks set
+func TestOwnedResourceSetsOwner(t *testing.T) {
+    t.Setenv("JWT_SECRET", "test_secret");
+    service := newTestService(t);
+    if err := service.db.AutoMigrate(&noteModel{}); err != nil {
+        t.Fatal(err);
+    }
+
+    owner := createTestUser(t, service, "owner");
+
+    auth := middleware.NewAuthSets(service.CheckActiveUser);
+    resource := noteResource();
+    resource.OwnerColumn = "owner_id";
+    resource.Operations = map[routes.ResourceOperation]middleware.MiddlewareSet{
+        routes.ResourceCreate: auth.Required,
+        routes.ResourceUpdate: auth.Required,
+    };
+    resource.Create = func(_ routes.ResourceRequest, data noteDto) (noteModel, error) {
+        return noteModel{ OwnerID: 99, Text: data.Text }, nil;
+    };
+    resource.Update = func(_ routes.ResourceRequest, item *noteModel, data noteDto) error {
+        item.OwnerID = 99;
+        item.Text = data.Text;
+        return nil;
+    };
+    methodHandler := routes.NewMethodHandler(http.NewServeMux());
+    routes.RegisterResource(methodHandler, service.AppService, resource);
+    token := "Bearer " + auth_helpers.SignJWT(map[string]any{ "id": owner.ID });
+
+    for _, call := range []struct {
+        method string
+        path   string
+        text   string
+    }{
+        { "POST", "/notes", "first" },
+        { "PATCH", "/notes/1", "second" },
+    } {
+        request := httptest.NewRequest(call.method, call.path, strings.NewReader(`{"text":"` + call.text + `"}`));
+        request.Header.Set("Authorization", token);
+        recorder := httptest.NewRecorder();
+        methodHandler.Mux.ServeHTTP(recorder, request);
+        if recorder.Code >= 300 {
+            t.Fatalf("%s %s answered %d %s", call.method, call.path, recorder.Code, recorder.Body.String());
+        }
+
+        var note noteModel;
+        service.db.First(&note, 1);
+        if note.OwnerID != owner.ID || note.Text != call.text {
+            t.Errorf("%s %s stored %+v", call.method, call.path, note);
+        }
+    }
+}
@@ -0,0 +1,290 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"connectrpc.com/connect"
+
+	"github.com/cxcnxl/go-crud/internal/routes"
+	"github.com/cxcnxl/go-crud/internal/rpc"
+	"github.com/cxcnxl/go-crud/internal/rpcapi/gocrudv1"
+	"github.com/cxcnxl/go-crud/internal/rpcapi/gocrudv1/gocrudv1connect"
+)
+
+// RPC transports, Connect with both encodings and gRPC
+var rpcTransports = map[string][]connect.ClientOption{
+    "connect+json": { connect.WithProtoJSON() },
+    "connect+proto": {},
+    "grpc": { connect.WithGRPC() },
+};
+
+// serves the routes over TLS so gRPC clients get HTTP/2
+func newRPCServer(t *testing.T) *httptest.Server {
+    t.Helper();
+
+    // bad tokens are only rejected once there's a secret to check them with
+    t.Setenv("JWT_SECRET", "test_secret");
+    service := newTestService(t);
+    router := routes.NewRouter(service.AppService, nil);
+
+    server := httptest.NewUnstartedServer(router.Mux);
+    server.EnableHTTP2 = true;
+    server.StartTLS();
+    t.Cleanup(server.Close);
+
+    return server;
+}
+
+// register, login, me, create and get post over REST and every RPC
+// transport end the same way
+func TestRPCMatchesRESTOnSuccess(t *testing.T) {
+    server := newRPCServer(t);
+    ctx := context.Background();
+
+    status, user := callREST(t, server, "POST", "/register", `{"email":"rest@example.com","username":"rest_user","password":"password1"}`, "");
+    if status != http.StatusOK || user["username"] != "rest_user" || user["email"] != "rest@example.com" {
+        t.Fatalf("REST register answered %d %v", status, user);
+    }
+    _, auth := callREST(t, server, "POST", "/login", `{"username":"rest_user","password":"password1"}`, "");
+    token, _ := auth["auth_token"].(string);
+    if token == "" {
+        t.Fatalf("REST login answered %v", auth);
+    }
+    status, me := callREST(t, server, "GET", "/me", "", "Bearer " + token);
+    if status != http.StatusOK || me["username"] != "rest_user" {
+        t.Fatalf("REST me answered %d %v", status, me);
+    }
+    status, post := callREST(t, server, "POST", "/posts", `{"body":"hello from rest"}`, "Bearer " + token);
+    if status != http.StatusCreated || post["body"] != "hello from rest" || post["status"] != "published" {
+        t.Fatalf("REST create post answered %d %v", status, post);
+    }
+    id, _ := post["id"].(float64);
+    status, post = callREST(t, server, "GET", fmt.Sprintf("/posts/%d", uint(id)), "", "");
+    if status != http.StatusOK || post["body"] != "hello from rest" {
+        t.Fatalf("REST get post answered %d %v", status, post);
+    }
+
+    for name, options := range rpcTransports {
+        client := gocrudv1connect.NewApiServiceClient(server.Client(), server.URL, options...);
+        username := strings.NewReplacer("+", "_").Replace(name);
+
+        registered, err := client.Register(ctx, connect.NewRequest(&gocrudv1.RegisterRequest{
+            Email: username + "@example.com",
+            Username: username,
+            Password: "password1",
+        }));
+        if err != nil {
+            t.Fatalf("%s register: %v", name, err);
+        }
+        if registered.Msg.User.Username != username || registered.Msg.User.Email != username + "@example.com" {
+            t.Errorf("%s register answered %v", name, registered.Msg.User);
+        }
+
+        login, err := client.Login(ctx, connect.NewRequest(&gocrudv1.LoginRequest{
+            Username: username,
+            Password: "password1",
+        }));
+        if err != nil || login.Msg.AuthToken == "" {
+            t.Fatalf("%s login: %v", name, err);
+        }
+        token := "Bearer " + login.Msg.AuthToken;
+
+        me, err := client.Me(ctx, withToken(connect.NewRequest(&gocrudv1.Empty{}), token));
+        if err != nil {
+            t.Fatalf("%s me: %v", name, err);
+        }
+        if me.Msg.User.Username != username || me.Msg.User.Id != registered.Msg.User.Id {
+            t.Errorf("%s me answered %v", name, me.Msg.User);
+        }
+
+        created, err := client.CreatePost(ctx, withToken(connect.NewRequest(&gocrudv1.CreatePostRequest{
+            Body: "hello from " + name,
+        }), token));
+        if err != nil {
+            t.Fatalf("%s create post: %v", name, err);
+        }
+        if created.Msg.Post.Body != "hello from " + name || created.Msg.Post.Status != "published" {
+            t.Errorf("%s create post answered %v", name, created.Msg.Post);
+        }
+
+        fetched, err := client.GetPost(ctx, connect.NewRequest(&gocrudv1.PostIdRequest{ Id: created.Msg.Post.Id }));
+        if err != nil {
+            t.Fatalf("%s get post: %v", name, err);
+        }
+        if fetched.Msg.Post.Body != "hello from " + name || fetched.Msg.Post.Author.GetUsername() != username {
+            t.Errorf("%s get post answered %v", name, fetched.Msg.Post);
+        }
+    }
+}
+
+// the same calls made over REST and every RPC transport fail with the
+// REST status and its code
+func TestRPCMatchesREST(t *testing.T) {
+    server := newRPCServer(t);
+
+    cases := []struct {
+        name   string
+        method string
+        path   string
+        body   string
+        call   func(ctx context.Context, client gocrudv1connect.ApiServiceClient, token string) error
+        token  string
+        status int
+    }{
+        {
+            "register with invalid email",
+            "POST", "/register", `{"email":"nope","username":"ann","password":"password1"}`,
+            func(ctx context.Context, client gocrudv1connect.ApiServiceClient, token string) error {
+                _, err := client.Register(ctx, connect.NewRequest(&gocrudv1.RegisterRequest{ Email: "nope", Username: "ann", Password: "password1" }));
+                return err;
+            },
+            "", http.StatusUnprocessableEntity,
+        },
+        {
+            "login without password",
+            "POST", "/login", `{"username":"ann"}`,
+            func(ctx context.Context, client gocrudv1connect.ApiServiceClient, token string) error {
+                _, err := client.Login(ctx, connect.NewRequest(&gocrudv1.LoginRequest{ Username: "ann" }));
+                return err;
+            },
+            "", http.StatusUnprocessableEntity,
+        },
+        {
+            "me without token",
+            "GET", "/me", "",
+            func(ctx context.Context, client gocrudv1connect.ApiServiceClient, token string) error {
+                _, err := client.Me(ctx, withToken(connect.NewRequest(&gocrudv1.Empty{}), token));
+                return err;
+            },
+            "", http.StatusUnauthorized,
+        },
+        {
+            "me with garbage token",
+            "GET", "/me", "",
+            func(ctx context.Context, client gocrudv1connect.ApiServiceClient, token string) error {
+                _, err := client.Me(ctx, withToken(connect.NewRequest(&gocrudv1.Empty{}), token));
+                return err;
+            },
+            "Bearer garbage", http.StatusUnauthorized,
+        },
+        {
+            "create post without token",
+            "POST", "/posts", `{"body":"hi"}`,
+            func(ctx context.Context, client gocrudv1connect.ApiServiceClient, token string) error {
+                _, err := client.CreatePost(ctx, withToken(connect.NewRequest(&gocrudv1.CreatePostRequest{ Body: "hi" }), token));
+                return err;
+            },
+            "", http.StatusUnauthorized,
+        },
+        {
+            "get post 0",
+            "GET", "/posts/0", "",
+            func(ctx context.Context, client gocrudv1connect.ApiServiceClient, token string) error {
+                _, err := client.GetPost(ctx, connect.NewRequest(&gocrudv1.PostIdRequest{}));
+                return err;
+            },
+            "", http.StatusBadRequest,
+        },
+        {
+            "get missing post",
+            "GET", "/posts/404", "",
+            func(ctx context.Context, client gocrudv1connect.ApiServiceClient, token string) error {
+                _, err := client.GetPost(ctx, connect.NewRequest(&gocrudv1.PostIdRequest{ Id: 404 }));
+                return err;
+            },
+            "", http.StatusNotFound,
+        },
+        {
+            "update post without token",
+            "PATCH", "/posts/3", `{"body":"hi"}`,
+            func(ctx context.Context, client gocrudv1connect.ApiServiceClient, token string) error {
+                _, err := client.UpdatePost(ctx, withToken(connect.NewRequest(&gocrudv1.UpdatePostRequest{ Id: 3, Body: "hi" }), token));
+                return err;
+            },
+            "", http.StatusUnauthorized,
+        },
+    };
+
+    for _, c := range cases {
+        status, _ := callREST(t, server, c.method, c.path, c.body, c.token);
+        if status != c.status {
+            t.Errorf("%s: REST answered %d, expected %d", c.name, status, c.status);
+        }
+
+        code := rpc.CodeOfStatus(c.status);
+        for name, options := range rpcTransports {
+            client := gocrudv1connect.NewApiServiceClient(server.Client(), server.URL, options...);
+            err := c.call(context.Background(), client, c.token);
+            if got := connect.CodeOf(err); err == nil || got != code {
+                t.Errorf("%s: %s answered %v, expected %v", c.name, name, err, code);
+            }
+        }
+    }
+}
+
+func TestRPCRejectsUnknownCalls(t *testing.T) {
+    server := newRPCServer(t);
+
+    for name, options := range rpcTransports {
+        client := connect.NewClient[gocrudv1.Empty, gocrudv1.Empty](
+            server.Client(),
+            server.URL + "/" + gocrudv1connect.ApiServiceName + "/Nope",
+            options...,
+        );
+        _, err := client.CallUnary(context.Background(), connect.NewRequest(&gocrudv1.Empty{}));
+        if connect.CodeOf(err) != connect.CodeUnimplemented {
+            t.Errorf("%s: unknown procedure answered %v", name, err);
+        }
+    }
+
+    request, _ := http.NewRequest("POST", server.URL + gocrudv1connect.ApiServiceMeProcedure, strings.NewReader("{}"));
+    request.Header.Set("Content-Type", "text/plain");
+    response, err := server.Client().Do(request);
+    if err != nil {
+        t.Fatal(err);
+    }
+    response.Body.Close();
+    if response.StatusCode != http.StatusUnsupportedMediaType {
+        t.Errorf("text/plain call answered %d", response.StatusCode);
+    }
+}
+
+func withToken[T any](request *connect.Request[T], token string) *connect.Request[T] {
+    if token != "" {
+        request.Header().Set("Authorization", token);
+    }
+
+    return request;
+}
+
+// makes a JSON request and returns the status with data of the response
+func callREST(t *testing.T, server *httptest.Server, method string, path string, body string, token string) (int, map[string]any) {
+    t.Helper();
+
+    request, _ := http.NewRequest(method, server.URL + path, bytes.NewReader([]byte(body)));
+    request.Header.Set("Content-Type", "application/json");
+    if token != "" {
+        request.Header.Set("Authorization", token);
+    }
+    response, err := server.Client().Do(request);
+    if err != nil {
+        t.Fatal(err);
+    }
+    defer response.Body.Close();
+
+    var envelope struct {
+        Data struct {
+            Data map[string]any
+        }
+    }
+    json.NewDecoder(response.Body).Decode(&envelope);
+
+    return response.StatusCode, envelope.Data.Data;
+}
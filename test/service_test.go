@@ -0,0 +1,129 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/glebarez/sqlite"
+	rdb "github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/cxcnxl/go-crud/internal/app_service"
+	"github.com/cxcnxl/go-crud/internal/blobstore"
+	"github.com/cxcnxl/go-crud/internal/encryption"
+	"github.com/cxcnxl/go-crud/internal/mailer"
+	"github.com/cxcnxl/go-crud/internal/models"
+	"github.com/cxcnxl/go-crud/internal/redis"
+)
+
+// mails sent through the service, in order
+type recordingMailer struct {
+    messages []mailer.Message
+}
+
+func (self *recordingMailer) Send(_ context.Context, message mailer.Message) error {
+    self.messages = append(self.messages, message);
+    return nil;
+}
+
+// how long deleted posts of test services stay in the trash
+const testRetention = 30 * 24 * time.Hour;
+
+// key signing download links of test services
+const testSigningKey = "test-signing-key";
+
+// service over an in-memory sqlite database, a miniredis server and blobs
+// in a temporary directory, all dropped when the test ends. SQLite ignores
+// row locks
+type testService struct {
+    *appservice.AppService
+    db     *gorm.DB
+    redis  *miniredis.Miniredis
+    mailer *recordingMailer
+}
+
+func newTestService(t *testing.T) testService {
+    t.Helper();
+
+    db, err := gorm.Open(
+        sqlite.Open("file::memory:"),
+        &gorm.Config{ TranslateError: true, Logger: logger.Discard },
+    );
+    if err != nil {
+        t.Fatal(err);
+    }
+    // one connection, every connection to :memory: has a database of its own
+    sqlDb, _ := db.DB();
+    sqlDb.SetMaxOpenConns(1);
+    t.Cleanup(func() { sqlDb.Close(); });
+
+    err = db.AutoMigrate(
+        &models.User{},
+        &models.UsernameChange{},
+        &models.EmailChange{},
+        &models.Post{},
+        &models.PostRevision{},
+        &models.Attachment{},
+        &models.Follow{},
+        &models.Block{},
+        &models.Mute{},
+        &models.Reaction{},
+        &models.ReactionCount{},
+        &models.Tag{},
+        &models.PostTag{},
+        &models.PostMention{},
+        &models.Notification{},
+        &models.NotificationPreference{},
+        &models.Conversation{},
+        &models.Participant{},
+        &models.Message{},
+        &models.Report{},
+        &models.ModerationFilter{},
+        &models.ModerationAction{},
+    );
+    if err != nil {
+        t.Fatal(err);
+    }
+
+    server := miniredis.RunT(t);
+    client := rdb.NewClient(&rdb.Options{ Addr: server.Addr() });
+    t.Cleanup(func() { client.Close(); });
+
+    sealer, err := encryption.NewSealer(make([]byte, encryption.KeySize));
+    if err != nil {
+        t.Fatal(err);
+    }
+
+    blobs, err := blobstore.NewLocalStore(t.TempDir());
+    if err != nil {
+        t.Fatal(err);
+    }
+
+    mails := &recordingMailer{};
+    service := appservice.NewAppService(
+        db,
+        redis.NewRedisWrapper(client),
+        blobs,
+        blobstore.NewUrlSigner(testSigningKey),
+        sealer,
+        mails,
+        testRetention,
+    );
+
+    return testService{ service, db, server, mails };
+}
+
+// inserts user with the given username and returns it
+func createTestUser(t *testing.T, service testService, username string) models.User {
+    t.Helper();
+
+    user := models.User{ Username: username, Email: username + "@example.com" };
+    if err := service.db.Create(&user).Error; err != nil {
+        t.Fatal(err);
+    }
+
+    return user;
+}
@@ -0,0 +1,41 @@
+package test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/cxcnxl/go-crud/internal/dto"
+	"github.com/cxcnxl/go-crud/internal/models"
+)
+
+func TestPostsSharingANewTag(t *testing.T) {
+    service := newTestService(t);
+    ann := createTestUser(t, service, "ann");
+
+    // posts bringing the same new tag at once
+    var wg sync.WaitGroup;
+    for range 6 {
+        wg.Add(1);
+        go func() {
+            defer wg.Done();
+            if _, err := service.CreatePost(ann.ID, dto.CreatePostDto{ Body: "hello #fresh" }); err != nil {
+                t.Error(err);
+            }
+        }();
+    }
+    wg.Wait();
+
+    var tags int64;
+    service.db.Model(&models.Tag{}).Where("name = ?", "fresh").Count(&tags);
+    if tags != 1 {
+        t.Errorf("tag is stored %d times", tags);
+    }
+
+    page, err := service.GetTagPosts("fresh", 0, 0, 20);
+    if err != nil {
+        t.Fatal(err);
+    }
+    if len(page.Items) != 6 {
+        t.Errorf("tag has %d posts, expected 6", len(page.Items));
+    }
+}
@@ -0,0 +1,287 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cxcnxl/go-crud/internal/app_service"
+	"github.com/cxcnxl/go-crud/internal/dto"
+	"github.com/cxcnxl/go-crud/internal/models"
+	"github.com/cxcnxl/go-crud/internal/redis"
+)
+
+// inserts published post of the author
+func createTestPost(t *testing.T, service testService, authorId uint, publishedAt time.Time) models.Post {
+    t.Helper();
+
+    post := models.Post{
+        AuthorID: authorId,
+        Body: "post",
+        Status: models.PostStatusPublished,
+        Visibility: models.VisibilityPublic,
+        PublishedAt: &publishedAt,
+    };
+    if err := service.db.Create(&post).Error; err != nil {
+        t.Fatal(err);
+    }
+
+    return post;
+}
+
+func followTestUser(t *testing.T, service testService, followerId uint, followeeId uint) {
+    t.Helper();
+
+    err := service.db.Create(&models.Follow{ FollowerID: followerId, FolloweeID: followeeId }).Error;
+    if err != nil {
+        t.Fatal(err);
+    }
+}
+
+// ids of every timeline page of the given size, read until the last one.
+// cold drops the cache before every page
+func readTimeline(t *testing.T, service testService, userId uint, limit int, cold bool) []uint {
+    t.Helper();
+
+    ids := []uint{};
+    before := dto.Keyset{};
+    for range 20 {
+        if cold {
+            service.redis.FlushAll();
+        }
+
+        page, err := service.GetTimeline(userId, before, limit);
+        if err != nil {
+            t.Fatal(err);
+        }
+        for _, post := range page.Items {
+            ids = append(ids, post.ID);
+        }
+        if page.NextCursor == nil {
+            return ids;
+        }
+        before = *page.NextCursor;
+    }
+
+    t.Fatal("timeline does not end");
+    return nil;
+}
+
+func TestTimelinePagesThroughPostsOfTheSameMillisecond(t *testing.T) {
+    service := newTestService(t);
+    ann := createTestUser(t, service, "ann");
+    bob := createTestUser(t, service, "bob");
+    followTestUser(t, service, ann.ID, bob.ID);
+
+    at := time.UnixMilli(time.Now().UnixMilli());
+    expected := []uint{};
+    for i := range 5 {
+        author := ann.ID;
+        if i % 2 == 0 {
+            author = bob.ID;
+        }
+        expected = append(expected, createTestPost(t, service, author, at).ID);
+    }
+    older := createTestPost(t, service, bob.ID, at.Add(-time.Second));
+    slices.Reverse(expected);
+    expected = append(expected, older.ID);
+
+    for _, cold := range []bool{ false, true } {
+        for _, limit := range []int{ 1, 2, 3, 10 } {
+            if ids := readTimeline(t, service, ann.ID, limit, cold); !slices.Equal(ids, expected) {
+                t.Errorf("pages of %d (cold %v) read %v, expected %v", limit, cold, ids, expected);
+            }
+        }
+    }
+
+    // posts of celebrities are merged in on read, straight from the database
+    service.db.Model(&bob).Update("followers_count", appservice.CelebrityFollowersThreshold);
+    service.redis.FlushAll();
+    for _, limit := range []int{ 1, 2, 4 } {
+        if ids := readTimeline(t, service, ann.ID, limit, false); !slices.Equal(ids, expected) {
+            t.Errorf("pages of %d with a celebrity read %v, expected %v", limit, ids, expected);
+        }
+    }
+}
+
+func TestTimelineCursorRoundTrips(t *testing.T) {
+    cursor := dto.Keyset{ At: 1729350000123, ID: 42 };
+    text, _ := cursor.MarshalText();
+    if string(text) != "1729350000123_42" {
+        t.Errorf("cursor is written as %s", text);
+    }
+
+    var parsed dto.Keyset;
+    if err := parsed.UnmarshalText(text); err != nil || parsed != cursor {
+        t.Errorf("cursor is read as %v, %v", parsed, err);
+    }
+
+    for _, invalid := range []string{ "", "42", "x_1", "1_-1", "1_2_3" } {
+        if err := parsed.UnmarshalText([]byte(invalid)); err == nil {
+            t.Errorf("%q is read as a cursor", invalid);
+        }
+    }
+}
+
+// post events in the stream of the user
+func countPostEvents(t *testing.T, service testService, userId uint) int {
+    t.Helper();
+
+    entries, err := service.redis.Stream(fmt.Sprintf("events:user:%d", userId));
+    if err != nil {
+        return 0;
+    }
+
+    count := 0;
+    for _, entry := range entries {
+        if slices.Contains(entry.Values, appservice.EventPost) {
+            count++;
+        }
+    }
+
+    return count;
+}
+
+func TestPublishDuePostsClaimsEveryPostOnce(t *testing.T) {
+    service := newTestService(t);
+    ann := createTestUser(t, service, "ann");
+    bob := createTestUser(t, service, "bob");
+    followTestUser(t, service, ann.ID, bob.ID);
+
+    due := time.Now().Add(-time.Minute);
+    for range 5 {
+        post := models.Post{
+            AuthorID: bob.ID,
+            Body: "scheduled",
+            Status: models.PostStatusScheduled,
+            Visibility: models.VisibilityPublic,
+            PublishAt: &due,
+        };
+        if err := service.db.Create(&post).Error; err != nil {
+            t.Fatal(err);
+        }
+    }
+
+    // instances of the job running at once
+    var wg sync.WaitGroup;
+    for range 4 {
+        wg.Add(1);
+        go func() {
+            defer wg.Done();
+            if err := service.PublishDuePosts(); err != nil {
+                t.Error(err);
+            }
+        }();
+    }
+    wg.Wait();
+
+    var published int64;
+    service.db.Model(&models.Post{}).Where("status = ?", models.PostStatusPublished).Count(&published);
+    if published != 5 {
+        t.Errorf("%d posts are published", published);
+    }
+    if count := countPostEvents(t, service, ann.ID); count != 5 {
+        t.Errorf("follower got %d post events, expected 5", count);
+    }
+}
+
+func TestPublishPostPublishesOnce(t *testing.T) {
+    service := newTestService(t);
+    ann := createTestUser(t, service, "ann");
+    bob := createTestUser(t, service, "bob");
+    followTestUser(t, service, ann.ID, bob.ID);
+
+    draft, err := service.CreatePost(bob.ID, dto.CreatePostDto{ Body: "draft", Status: string(models.PostStatusDraft) });
+    if err != nil {
+        t.Fatal(err);
+    }
+
+    if _, err := service.PublishPost(draft.ID, bob.ID, nil); err != nil {
+        t.Fatal(err);
+    }
+    _, err = service.PublishPost(draft.ID, bob.ID, nil);
+    if !errors.Is(err, appservice.PostAlreadyPublishedError{}) {
+        t.Errorf("publishing again failed with %v", err);
+    }
+    if count := countPostEvents(t, service, ann.ID); count != 1 {
+        t.Errorf("follower got %d post events, expected 1", count);
+    }
+}
+
+func TestTimelineReadsPastTheCachedWindow(t *testing.T) {
+    service := newTestService(t);
+    ann := createTestUser(t, service, "ann");
+    bob := createTestUser(t, service, "bob");
+    followTestUser(t, service, ann.ID, bob.ID);
+
+    start := time.UnixMilli(time.Now().UnixMilli());
+    posts := []models.Post{};
+    for i := range redis.TimelineSize + 30 {
+        publishedAt := start.Add(-time.Duration(i) * time.Second);
+        posts = append(posts, models.Post{
+            AuthorID: bob.ID,
+            Body: "post",
+            Status: models.PostStatusPublished,
+            Visibility: models.VisibilityPublic,
+            PublishedAt: &publishedAt,
+        });
+    }
+    if err := service.db.CreateInBatches(&posts, 100).Error; err != nil {
+        t.Fatal(err);
+    }
+
+    // warm pages cross the end of the cached window
+    ids := readTimeline(t, service, ann.ID, 70, false);
+    if len(ids) != len(posts) || ids[0] != posts[0].ID || ids[len(ids) - 1] != posts[len(posts) - 1].ID {
+        t.Errorf("read %d posts from %v to %v", len(ids), ids[0], ids[len(ids) - 1]);
+    }
+
+    // so do cursors older than all of it
+    oldest := posts[len(posts) - 5];
+    page, err := service.GetTimeline(ann.ID, dto.Keyset{ At: oldest.PublishedAt.UnixMilli(), ID: oldest.ID }, 10);
+    if err != nil || len(page.Items) != 4 {
+        t.Errorf("page past the window has %d posts, %v", len(page.Items), err);
+    }
+}
+
+func TestMergeTimelineEntries(t *testing.T) {
+    entry := func(postId uint, score int64) redis.TimelineEntry {
+        return redis.TimelineEntry{ PostID: postId, Score: score };
+    };
+
+    cases := []struct {
+        a        []redis.TimelineEntry
+        b        []redis.TimelineEntry
+        expected []redis.TimelineEntry
+    }{
+        { nil, nil, []redis.TimelineEntry{} },
+        { []redis.TimelineEntry{ entry(1, 10) }, nil, []redis.TimelineEntry{ entry(1, 10) } },
+        { nil, []redis.TimelineEntry{ entry(1, 10) }, []redis.TimelineEntry{ entry(1, 10) } },
+        {
+            []redis.TimelineEntry{ entry(5, 50), entry(3, 30) },
+            []redis.TimelineEntry{ entry(4, 40), entry(2, 20), entry(1, 10) },
+            []redis.TimelineEntry{ entry(5, 50), entry(4, 40), entry(3, 30), entry(2, 20), entry(1, 10) },
+        },
+        // same millisecond, higher ids first
+        {
+            []redis.TimelineEntry{ entry(7, 10), entry(2, 10) },
+            []redis.TimelineEntry{ entry(9, 10), entry(4, 10) },
+            []redis.TimelineEntry{ entry(9, 10), entry(7, 10), entry(4, 10), entry(2, 10) },
+        },
+        // posts of an account that just crossed the celebrity threshold
+        {
+            []redis.TimelineEntry{ entry(3, 30), entry(1, 10) },
+            []redis.TimelineEntry{ entry(3, 30), entry(2, 20), entry(1, 10) },
+            []redis.TimelineEntry{ entry(3, 30), entry(2, 20), entry(1, 10) },
+        },
+    };
+
+    for _, c := range cases {
+        if merged := redis.MergeTimelineEntries(c.a, c.b); !slices.Equal(merged, c.expected) {
+            t.Errorf("%v and %v merged into %v, expected %v", c.a, c.b, merged, c.expected);
+        }
+    }
+}
@@ -0,0 +1,186 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/cxcnxl/go-crud/internal/app_service"
+	"github.com/cxcnxl/go-crud/internal/models"
+)
+
+// inserts post of the author deleted the given time ago, with a revision,
+// reaction, tag, mention of the mentioned user and an unread notification
+// about it
+func createDeletedTestPost(
+    t *testing.T,
+    service testService,
+    authorId uint,
+    mentionedId uint,
+    deletedAgo time.Duration,
+) models.Post {
+    t.Helper();
+
+    post := createTestPost(t, service, authorId, time.Now().Add(-deletedAgo - time.Hour));
+    tag := models.Tag{ Name: fmt.Sprintf("tag%d", post.ID) };
+    for _, row := range []any{
+        &models.PostRevision{ PostID: post.ID, Body: "old" },
+        &models.Reaction{ PostID: post.ID, UserID: mentionedId, Emoji: "👍" },
+        &models.ReactionCount{ PostID: post.ID, Emoji: "👍", Count: 1 },
+        &tag,
+        &models.PostMention{ PostID: post.ID, UserID: mentionedId },
+        &models.Notification{ UserID: mentionedId, Type: models.NotificationMention, ActorID: authorId, PostID: &post.ID },
+    } {
+        if err := service.db.Omit("Actor").Create(row).Error; err != nil {
+            t.Fatal(err);
+        }
+    }
+    service.db.Create(&models.PostTag{ PostID: post.ID, TagID: tag.ID });
+
+    service.db.Delete(&post);
+    service.db.Unscoped().Model(&post).Update("deleted_at", time.Now().Add(-deletedAgo));
+
+    return post;
+}
+
+// number of rows of each dependent table referring to the post
+func countPostRows(service testService, postId uint) map[string]int64 {
+    counts := map[string]int64{};
+    for name, model := range map[string]any{
+        "posts": &models.Post{},
+        "revisions": &models.PostRevision{},
+        "reactions": &models.Reaction{},
+        "reaction_counts": &models.ReactionCount{},
+        "post_tags": &models.PostTag{},
+        "post_mentions": &models.PostMention{},
+        "notifications": &models.Notification{},
+    } {
+        column := "post_id";
+        if name == "posts" {
+            column = "id";
+        }
+
+        var count int64;
+        service.db.Unscoped().Model(model).Where(column + " = ?", postId).Count(&count);
+        counts[name] = count;
+    }
+
+    return counts;
+}
+
+func TestPurgeDeletesExpiredPostsWithDependentRows(t *testing.T) {
+    service := newTestService(t);
+    ann := createTestUser(t, service, "ann");
+    bob := createTestUser(t, service, "bob");
+
+    expired := createDeletedTestPost(t, service, ann.ID, bob.ID, testRetention + time.Hour);
+    trashed := createDeletedTestPost(t, service, ann.ID, bob.ID, testRetention - time.Hour);
+    live := createTestPost(t, service, ann.ID, time.Now().Add(-2 * testRetention));
+    service.redis.Set(fmt.Sprintf("notifications:unread:%d", bob.ID), "2");
+
+    if err := service.PurgeDeletedPosts(); err != nil {
+        t.Fatal(err);
+    }
+
+    for name, count := range countPostRows(service, expired.ID) {
+        if count != 0 {
+            t.Errorf("%d %s left of the purged post", count, name);
+        }
+    }
+    for name, count := range countPostRows(service, trashed.ID) {
+        if count != 1 {
+            t.Errorf("%d %s left of the post inside retention", count, name);
+        }
+    }
+    if count := countPostRows(service, live.ID)["posts"]; count != 1 {
+        t.Error("live post was purged");
+    }
+
+    // the tags stay for other posts
+    var tags int64;
+    service.db.Model(&models.Tag{}).Count(&tags);
+    if tags != 2 {
+        t.Errorf("%d tags left", tags);
+    }
+
+    count, err := service.GetUnreadNotificationsCount(bob.ID);
+    if err != nil || count != 1 {
+        t.Errorf("unread notifications count is %d %v", count, err);
+    }
+}
+
+func TestRestorePostInsideRetentionByOwner(t *testing.T) {
+    service := newTestService(t);
+    ann := createTestUser(t, service, "ann");
+    bob := createTestUser(t, service, "bob");
+
+    post := createTestPost(t, service, ann.ID, time.Now());
+    if err := service.DeletePost(post.ID, bob.ID); !errors.As(err, &appservice.NotPostAuthorError{}) {
+        t.Errorf("delete by another user returned %v", err);
+    }
+    if err := service.DeletePost(post.ID, ann.ID); err != nil {
+        t.Fatal(err);
+    }
+    if _, err := service.GetPostById(post.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+        t.Errorf("deleted post is read with %v", err);
+    }
+
+    if _, err := service.RestorePost(post.ID, bob.ID); !errors.As(err, &appservice.NotPostAuthorError{}) {
+        t.Errorf("restore by another user returned %v", err);
+    }
+    restored, err := service.RestorePost(post.ID, ann.ID);
+    if err != nil || restored.ID != post.ID || restored.DeletedAt.Valid {
+        t.Fatalf("restore returned %v %v", restored, err);
+    }
+    if _, err := service.GetPostById(post.ID); err != nil {
+        t.Errorf("restored post is read with %v", err);
+    }
+
+    // live posts and posts past the retention are not in the trash
+    if _, err := service.RestorePost(post.ID, ann.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+        t.Errorf("restoring a live post returned %v", err);
+    }
+    expired := createDeletedTestPost(t, service, ann.ID, bob.ID, testRetention + time.Hour);
+    if _, err := service.RestorePost(expired.ID, ann.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+        t.Errorf("restoring a post past the retention returned %v", err);
+    }
+}
+
+func TestTrashListsOwnDeletedPosts(t *testing.T) {
+    service := newTestService(t);
+    ann := createTestUser(t, service, "ann");
+    bob := createTestUser(t, service, "bob");
+
+    expected := []uint{};
+    for range 3 {
+        post := createDeletedTestPost(t, service, ann.ID, bob.ID, time.Hour);
+        expected = append([]uint{ post.ID }, expected...);
+    }
+    createDeletedTestPost(t, service, ann.ID, bob.ID, testRetention + time.Hour);
+    createDeletedTestPost(t, service, bob.ID, ann.ID, time.Hour);
+    createTestPost(t, service, ann.ID, time.Now());
+
+    ids := []uint{};
+    before := uint(0);
+    for range 5 {
+        page, err := service.GetTrash(ann.ID, before, 2);
+        if err != nil {
+            t.Fatal(err);
+        }
+        for _, post := range page.Items {
+            ids = append(ids, post.ID);
+        }
+        if page.NextCursor == 0 {
+            break;
+        }
+        before = page.NextCursor;
+    }
+
+    if !slices.Equal(ids, expected) {
+        t.Errorf("trash is %v, expected %v", ids, expected);
+    }
+}
@@ -0,0 +1,109 @@
+package test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cxcnxl/go-crud/internal/validation"
+)
+
+type signupForm struct {
+    Name    string   `json:"name" validate:"required,min=2,max=5"`
+    Email   string   `json:"email" validate:"email"`
+    Code    string   `json:"code" pattern:"^[A-Z]{3}$"`
+    Tags    []string `json:"tags" validate:"max=2"`
+    Nick    string   `json:"nick" validate:"even"`
+}
+
+func init() {
+    validation.Register("even", func(value reflect.Value, _ string) bool {
+        return len(value.String()) % 2 == 0;
+    });
+}
+
+func TestValidationReportsFirstFailingRulePerField(t *testing.T) {
+    err := validation.Struct(signupForm{
+        Name: "a",
+        Email: "not an email",
+        Code: "abc",
+        Tags: []string{ "a", "b", "c" },
+        Nick: "odd",
+    });
+
+    expected := validation.Errors{
+        "name": "min",
+        "email": "email",
+        "code": "pattern",
+        "tags": "max",
+        "nick": "even",
+    };
+    if !reflect.DeepEqual(err, expected) {
+        t.Errorf("errors are %v, expected %v", err, expected);
+    }
+}
+
+func TestValidationSkipsEmptyOptionalFields(t *testing.T) {
+    if err := validation.Struct(signupForm{ Name: "bob" }); err != nil {
+        t.Errorf("valid form failed with %v", err);
+    }
+
+    err := validation.Struct(signupForm{ Name: "   " });
+    if !reflect.DeepEqual(err, validation.Errors{ "name": "required" }) {
+        t.Errorf("blank required field gave %v", err);
+    }
+}
+
+func TestValidationDecodeIsStrict(t *testing.T) {
+    var form signupForm;
+
+    err := validation.Decode([]byte(`{"name":"bob","admin":true}`), &form);
+    if !reflect.DeepEqual(err, validation.Errors{ "admin": "unknown" }) {
+        t.Errorf("unknown field gave %v", err);
+    }
+
+    err = validation.Decode([]byte(`{"name":1}`), &form);
+    if !reflect.DeepEqual(err, validation.Errors{ "name": "type" }) {
+        t.Errorf("wrong type gave %v", err);
+    }
+
+    err = validation.Decode([]byte(`{"name":"bob"} {"name":"eve"}`), &form);
+    if !errors.Is(err, validation.InvalidJSONError{}) {
+        t.Errorf("trailing data gave %v", err);
+    }
+}
+
+func TestRegisterRejectsInvalidBodyWith422(t *testing.T) {
+    router := newTestRouter(t);
+
+    recorder := httptest.NewRecorder();
+    body := strings.NewReader(`{"email":"","username":"a b","password":"short"}`);
+    router.Mux.ServeHTTP(recorder, httptest.NewRequest("POST", "/register", body));
+
+    if recorder.Code != http.StatusUnprocessableEntity {
+        t.Fatalf("status is %d, expected %d", recorder.Code, http.StatusUnprocessableEntity);
+    }
+
+    var response struct {
+        Error string `json:"error"`
+        Data  struct {
+            Data map[string]string `json:"data"`
+        } `json:"data"`
+    };
+    if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+        t.Fatal(err);
+    }
+
+    expected := map[string]string{
+        "email": "required",
+        "username": "username",
+        "password": "min",
+    };
+    if response.Error != "validation_failed" || !reflect.DeepEqual(response.Data.Data, expected) {
+        t.Errorf("response is %s", recorder.Body.String());
+    }
+}
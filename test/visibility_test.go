@@ -0,0 +1,69 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cxcnxl/go-crud/internal/app_service"
+	"github.com/cxcnxl/go-crud/internal/dto"
+	"github.com/cxcnxl/go-crud/internal/models"
+)
+
+func TestAnonymousPostAudience(t *testing.T) {
+    service := &appservice.AppService{};
+
+    cases := []struct {
+        status     models.PostStatus
+        visibility models.PostVisibility
+        visible    bool
+    }{
+        {models.PostStatusPublished, models.VisibilityPublic, true},
+        {models.PostStatusPublished, models.VisibilityUnlisted, true},
+        {models.PostStatusPublished, models.VisibilityFollowers, false},
+        {models.PostStatusPublished, models.VisibilityPrivate, false},
+        {models.PostStatusDraft, models.VisibilityPublic, false},
+        {models.PostStatusHeld, models.VisibilityPublic, false},
+    };
+
+    for _, c := range cases {
+        post := models.Post{AuthorID: 1, Status: c.status, Visibility: c.visibility};
+
+        visible, err := service.CanViewPost(post, 0);
+        if err != nil {
+            t.Fatal(err);
+        }
+        if visible != c.visible {
+            t.Errorf("%s %s post visible: %v, expected %v", c.status, c.visibility, visible, c.visible);
+        }
+    }
+}
+
+func TestFollowersOnlyPostsReachFollowers(t *testing.T) {
+    service := newTestService(t);
+    ann := createTestUser(t, service, "ann");
+    bob := createTestUser(t, service, "bob");
+    carl := createTestUser(t, service, "carl");
+    followTestUser(t, service, ann.ID, bob.ID);
+
+    for _, author := range []models.User{ bob, carl } {
+        post := createTestPost(t, service, author.ID, time.Now());
+        service.db.Model(&post).Update("visibility", models.VisibilityFollowers);
+
+        visible, err := service.CanViewPost(post, ann.ID);
+        if err != nil {
+            t.Fatal(err);
+        }
+        if following := author.ID == bob.ID; visible != following {
+            t.Errorf("post of %s is visible %v", author.Username, visible);
+        }
+    }
+
+    // both on one page
+    page, err := service.GetTimeline(ann.ID, dto.Keyset{}, 10);
+    if err != nil {
+        t.Fatal(err);
+    }
+    if len(page.Items) != 1 || page.Items[0].AuthorID != bob.ID {
+        t.Errorf("timeline has %d posts", len(page.Items));
+    }
+}